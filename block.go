@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	zg "zerocash_gnark/zerocash_gnark"
+	zn "zerocash_gnark/zerocash_network"
+
+	"github.com/rs/zerolog"
+)
+
+// maxBlockRequest bounds the number of blocks returned for one request.
+const maxBlockRequest = 64
+
+// maxBlockEntries bounds the number of entries of a block.
+const maxBlockEntries = 256
+
+// maxClockDrift bounds how far ahead of the local clock a proposed block may
+// be timestamped: block timestamps are the chain time round deadlines are
+// checked against (see Ledger.Now).
+const maxClockDrift = 5 * time.Second
+
+// TxLifetime is the number of blocks during which a transaction created now
+// can be included, after which it expires.
+const TxLifetime = 64
+
+// blockEntry returns the entry as listed in a block.
+func (pe pendingEntry) blockEntry() zn.BlockEntry {
+	return zn.BlockEntry{
+		ID:         pe.id,
+		Sns:        pe.entry.Sns,
+		Cms:        pe.entry.Cms,
+		RevokedSns: pe.entry.RevokedSns,
+		RevokedCms: pe.entry.RevokedCms,
+		Fee:        pe.entry.Fee,
+		Expiry:     pe.entry.Expiry,
+	}
+}
+
+// headerLocked returns the header of the block made of entries and of the fee
+// note feeCm (nil if the fees are burned) on top of the last committed block,
+// and the block entries. It fails if entries do not apply to the committed
+// state or have expired. l.mu must be held.
+func (l *Ledger) headerLocked(entries []pendingEntry, feeCm []byte, now time.Time) (zn.BlockHeader, []zn.BlockEntry, error) {
+	height := uint64(len(l.blocks))
+	state := l.clone()
+	blockEntries := make([]zn.BlockEntry, len(entries))
+	for i, pe := range entries {
+		if pe.entry.expired(height) {
+			return zn.BlockHeader{}, nil, fmt.Errorf("entry %d of block: %w", i, ErrExpired)
+		}
+		if err := state.checkEntry(pe.entry); err != nil {
+			return zn.BlockHeader{}, nil, fmt.Errorf("entry %d of block: %w", i, err)
+		}
+		state.apply(pe.entry)
+		blockEntries[i] = pe.blockEntry()
+	}
+	fees, err := zn.BlockFees(blockEntries)
+	if err != nil {
+		return zn.BlockHeader{}, nil, err
+	}
+	if len(feeCm) > 0 {
+		if fees == 0 || state.HasCommitment(feeCm) {
+			return zn.BlockHeader{}, nil, ErrBadFeeNote
+		}
+		state.apply(LedgerEntry{Cms: [][]byte{feeCm}})
+	}
+	h := zn.BlockHeader{
+		Height:          height,
+		CmRoot:          zn.MerkleRoot(state.Commitments()),
+		NullifierDigest: zn.NullifierDigest(state.Nullifiers()),
+		TxRoot:          zn.TxRoot(blockEntries),
+		Fees:            fees,
+		FeeCm:           feeCm,
+		Timestamp:       now,
+	}
+	if n := len(l.blocks); n > 0 {
+		prev := l.blocks[n-1].Header
+		h.PrevHash = prev.Hash()
+		if now.Before(prev.Timestamp) {
+			h.Timestamp = prev.Timestamp
+		}
+	}
+	return h, blockEntries, nil
+}
+
+// checkHeaderLocked checks that h is the header of the block made of entries
+// on top of the last committed block. l.mu must be held.
+func (l *Ledger) checkHeaderLocked(h zn.BlockHeader, entries []pendingEntry) error {
+	var prev *zn.BlockHeader
+	if n := len(l.blocks); n > 0 {
+		prev = &l.blocks[n-1].Header
+	}
+	if err := zn.VerifyHeader(prev, h); err != nil {
+		return err
+	}
+	want, _, err := l.headerLocked(entries, h.FeeCm, h.Timestamp)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want.Hash(), h.Hash()) {
+		return fmt.Errorf("block %d does not match the ledger state", h.Height)
+	}
+	return nil
+}
+
+// sealLocked logs that entries were committed with header h and applies them
+// to the committed state, along with the fee note proved by feeProof. l.mu
+// must be held.
+func (l *Ledger) sealLocked(h zn.BlockHeader, entries []pendingEntry, feeProof []byte) error {
+	ids := make([][]byte, len(entries))
+	for i, pe := range entries {
+		ids[i] = pe.id
+	}
+	payload, err := encodeEntry(LedgerEntry{Seal: &h, Sealed: ids, FeeProof: feeProof})
+	if err != nil {
+		return err
+	}
+	if err := l.writeLocked(payload); err != nil {
+		return err
+	}
+	l.commitLocked(h, entries, feeProof)
+	return nil
+}
+
+// commitLocked applies entries and the fee note of h to the committed state,
+// records the block and removes the entries from the mempool, along with the
+// pending entries that now conflict with a committed spend. l.mu must be
+// held.
+func (l *Ledger) commitLocked(h zn.BlockHeader, entries []pendingEntry, feeProof []byte) {
+	ids := make([][]byte, len(entries))
+	blockEntries := make([]zn.BlockEntry, len(entries))
+	for i, pe := range entries {
+		l.apply(pe.entry)
+		l.known[string(pe.id)] = true
+		ids[i] = pe.id
+		blockEntries[i] = pe.blockEntry()
+	}
+	if len(h.FeeCm) > 0 {
+		l.apply(LedgerEntry{Cms: [][]byte{h.FeeCm}})
+	}
+	l.blocks = append(l.blocks, zn.Block{Header: h, Entries: blockEntries, FeeProof: feeProof})
+	l.pool.remove(ids)
+	l.evictLocked(l.pool.conflicting(l.NoteStore))
+}
+
+// selectLocked returns at most max mempool entries by decreasing fee that can
+// be committed together. An entry revoking notes not committed yet (the
+// settlement it reverts is still pending) waits for a later block, as does an
+// entry whose fee would overflow the fees of the block; an expired entry is
+// left for eviction. l.mu must be held.
+func (l *Ledger) selectLocked(max int) []pendingEntry {
+	height := uint64(len(l.blocks))
+	state := l.clone()
+	var entries []pendingEntry
+	var fees uint64
+	for _, pe := range l.pool.ordered() {
+		if len(entries) == max {
+			break
+		}
+		if pe.entry.expired(height) || fees+pe.entry.Fee < fees || !revokesCommitted(state, pe.entry) || state.checkEntry(pe.entry) != nil {
+			continue
+		}
+		state.apply(pe.entry)
+		fees += pe.entry.Fee
+		entries = append(entries, pe)
+	}
+	return entries
+}
+
+// collectFeesLocked mints the fee note of the block made of entries with
+// l.Fees. It returns a nil note if the block pays no fee or the ledger does
+// not collect fees. l.mu must be held.
+func (l *Ledger) collectFeesLocked(entries []pendingEntry) (cm, proof []byte, err error) {
+	if l.Fees == nil {
+		return nil, nil, nil
+	}
+	var fees uint64
+	for _, pe := range entries {
+		fees += pe.entry.Fee
+	}
+	if fees == 0 {
+		return nil, nil, nil
+	}
+	return l.Fees.CollectFees(fees)
+}
+
+// revokesCommitted reports whether the notes e revokes are in state.
+func revokesCommitted(state *NoteStore, e LedgerEntry) bool {
+	for _, sn := range e.RevokedSns {
+		if !state.HasNullifier(sn) {
+			return false
+		}
+	}
+	for _, cm := range e.RevokedCms {
+		if !state.HasCommitment(cm) {
+			return false
+		}
+	}
+	return true
+}
+
+// Seal commits mempool entries (at most maxBlockEntries, by decreasing fee)
+// into a new block and returns it. It returns nil if there is nothing to
+// seal and no heartbeat is due. A single validator seals blocks on its own;
+// validators running consensus use ProposeBlock and CommitBlock instead.
+func (l *Ledger) Seal() (*zn.Block, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.selectLocked(maxBlockEntries)
+	if len(entries) == 0 && !l.heartbeatDueLocked(time.Now()) {
+		return nil, nil
+	}
+	feeCm, feeProof, err := l.collectFeesLocked(entries)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := l.headerLocked(entries, feeCm, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := l.sealLocked(h, entries, feeProof); err != nil {
+		return nil, err
+	}
+	b := l.blocks[len(l.blocks)-1]
+	return &b, nil
+}
+
+// replaySeal commits the mempool entries ids with header h and fee note proof
+// feeProof, checking that h matches the replayed state.
+func (l *Ledger) replaySeal(h zn.BlockHeader, ids [][]byte, feeProof []byte) error {
+	entries := make([]pendingEntry, len(ids))
+	for i, id := range ids {
+		pe, ok := l.pool.get(id)
+		if !ok {
+			return fmt.Errorf("block %d seals unknown entry %x", h.Height, id)
+		}
+		entries[i] = pe
+	}
+	if err := l.checkHeaderLocked(h, entries); err != nil {
+		return err
+	}
+	l.commitLocked(h, entries, feeProof)
+	return nil
+}
+
+// ProposeBlock returns the header, the encoded entries and the fee note proof
+// of a block made of at most max mempool entries, without committing it. ok
+// is false if there is nothing to propose and no heartbeat is due.
+func (l *Ledger) ProposeBlock(max int) (h zn.BlockHeader, payloads [][]byte, feeProof []byte, ok bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.selectLocked(max)
+	if len(entries) == 0 && !l.heartbeatDueLocked(time.Now()) {
+		return zn.BlockHeader{}, nil, nil, false, nil
+	}
+	feeCm, feeProof, err := l.collectFeesLocked(entries)
+	if err != nil {
+		return zn.BlockHeader{}, nil, nil, false, err
+	}
+	h, _, err = l.headerLocked(entries, feeCm, time.Now().UTC())
+	if err != nil {
+		return zn.BlockHeader{}, nil, nil, false, err
+	}
+	payloads = make([][]byte, len(entries))
+	for i, pe := range entries {
+		payloads[i] = pe.payload
+	}
+	return h, payloads, feeProof, true, nil
+}
+
+// decodeBlock decodes the entries of a proposed block.
+func decodeBlock(payloads [][]byte) ([]pendingEntry, error) {
+	entries := make([]pendingEntry, len(payloads))
+	for i, payload := range payloads {
+		e, err := decodeEntry(payload)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d of block: %w", i, err)
+		}
+		entries[i] = pendingEntry{id: []byte(entryID(payload)), entry: e, payload: payload}
+	}
+	return entries, nil
+}
+
+// CheckBlock checks that the block with header h and encoded entries payloads
+// extends the committed chain, is not timestamped more than maxClockDrift
+// ahead of the local clock, that h commits to the state it leads to and
+// that feeProof proves its fee note. The entries this ledger does not know yet
+// must pass l.Verify; the others passed it, or were admitted here, already.
+func (l *Ledger) CheckBlock(h zn.BlockHeader, payloads [][]byte, feeProof []byte) error {
+	if h.Timestamp.After(time.Now().Add(maxClockDrift)) {
+		return fmt.Errorf("block %d is timestamped ahead of the local clock", h.Height)
+	}
+	entries, err := decodeBlock(payloads)
+	if err != nil {
+		return err
+	}
+	if err := checkFeeNote(h, feeProof); err != nil {
+		return err
+	}
+	if l.Verify != nil {
+		for i, pe := range entries {
+			if l.isKnown(pe.payload) {
+				continue
+			}
+			if err := l.Verify(pe.entry); err != nil {
+				return fmt.Errorf("entry %d of block: %w", i, err)
+			}
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.checkHeaderLocked(h, entries)
+}
+
+// CommitBlock commits a block agreed on by consensus, whether or not its
+// entries were relayed to this ledger's mempool.
+func (l *Ledger) CommitBlock(h zn.BlockHeader, payloads [][]byte, feeProof []byte) error {
+	entries, err := decodeBlock(payloads)
+	if err != nil {
+		return err
+	}
+	if err := checkFeeNote(h, feeProof); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.checkHeaderLocked(h, entries); err != nil {
+		return err
+	}
+	for _, pe := range entries {
+		if _, ok := l.pool.get(pe.id); ok {
+			continue
+		}
+		// Log the entry so that replaying the seal finds it.
+		if err := l.writeLocked(pe.payload); err != nil {
+			return err
+		}
+		l.admitLocked(pe.entry, pe.payload)
+	}
+	return l.sealLocked(h, entries, feeProof)
+}
+
+// Height returns the number of committed blocks.
+func (l *Ledger) Height() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return uint64(len(l.blocks))
+}
+
+// Blocks returns at most count blocks starting at height from, without their
+// entries if headersOnly is set.
+func (l *Ledger) Blocks(from uint64, count int, headersOnly bool) []zn.Block {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if from >= uint64(len(l.blocks)) || count <= 0 {
+		return nil
+	}
+	end := from + uint64(count)
+	if end > uint64(len(l.blocks)) {
+		end = uint64(len(l.blocks))
+	}
+	blocks := append([]zn.Block(nil), l.blocks[from:end]...)
+	if headersOnly {
+		for i := range blocks {
+			blocks[i].Entries = nil
+		}
+	}
+	return blocks
+}
+
+// ProduceBlocks evicts expired mempool entries and seals a block every
+// interval, as long as entries are pending, and an empty one once the last
+// block is older than l.Heartbeat. It never returns.
+func (l *Ledger) ProduceBlocks(interval time.Duration, logger zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if n, err := l.EvictExpired(now); err != nil {
+			logger.Error().Err(err).Msg("Failed to evict expired entries")
+		} else if n > 0 {
+			logger.Info().Msgf("%d expired entries evicted from the mempool", n)
+		}
+		b, err := l.Seal()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to seal block")
+			continue
+		}
+		if b != nil {
+			logger.Info().Msgf("Block %d sealed: %d entries, %d fees, hash %x", b.Header.Height, len(b.Entries), b.Header.Fees, b.Header.Hash()[:8])
+		}
+	}
+}
+
+// -------------------------------
+// BlockHandler
+// -------------------------------
+
+// BlockHandler serves "block_get" requests from the node's ledger.
+type BlockHandler struct {
+	Node *Node
+}
+
+func NewBlockHandler(node *Node) *BlockHandler {
+	return &BlockHandler{Node: node}
+}
+
+func (bh *BlockHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+
+	req, ok := msg.Payload.(zn.BlockRequestPayload)
+	if !ok {
+		fmt.Println("BlockHandler: invalid payload")
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a BlockRequestPayload")
+		return
+	}
+	count := req.Count
+	if count > maxBlockRequest {
+		count = maxBlockRequest
+	}
+	ledger := bh.Node.ledger()
+	resp := zn.BlockListPayload{
+		Height: ledger.Height(),
+		Blocks: ledger.Blocks(req.From, count, req.HeadersOnly),
+	}
+	if err := zn.Reply(conn, msg, zn.BlocksMsg, resp); err != nil {
+		logger.Error().Err(err).Msgf("%s[Node %d] [Block] Error sending blocks\033[0m", getNodeColor(bh.Node.ID), bh.Node.ID)
+	}
+}
+
+// FetchBlocks asks the validator for at most count blocks starting at height
+// from, and checks the entries of full blocks against their header.
+func (n *Node) FetchBlocks(validatorAddress string, from uint64, count int, headersOnly bool) (zn.BlockListPayload, error) {
+	req := zn.BlockRequestPayload{From: from, Count: count, HeadersOnly: headersOnly}
+	list, err := zn.Call[zn.BlockListPayload](context.Background(), n.RPC, validatorAddress, zn.BlockGetMsg, req)
+	if err != nil {
+		return zn.BlockListPayload{}, err
+	}
+	if !headersOnly {
+		for _, b := range list.Blocks {
+			if err := zn.VerifyBlock(b); err != nil {
+				return zn.BlockListPayload{}, err
+			}
+		}
+	}
+	return list, nil
+}
+
+// HeaderChain is the chain of block headers followed by a light client.
+type HeaderChain struct {
+	mu      sync.Mutex
+	headers []zn.BlockHeader
+}
+
+// Tip returns the last verified header, or nil if the chain is empty.
+func (c *HeaderChain) Tip() *zn.BlockHeader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.headers) == 0 {
+		return nil
+	}
+	h := c.headers[len(c.headers)-1]
+	return &h
+}
+
+// Height returns the height of the block following the tip.
+func (c *HeaderChain) Height() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint64(len(c.headers))
+}
+
+// Extend appends headers once checked to chain onto the tip.
+func (c *HeaderChain) Extend(headers []zn.BlockHeader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var tip *zn.BlockHeader
+	if len(c.headers) > 0 {
+		tip = &c.headers[len(c.headers)-1]
+	}
+	if err := zn.VerifyHeaderChain(tip, headers); err != nil {
+		return err
+	}
+	c.headers = append(c.headers, headers...)
+	return nil
+}
+
+// SyncHeaders fetches the headers following the node's tip from the validator
+// and extends its header chain with them. It returns the new height.
+func (n *Node) SyncHeaders(validatorAddress string) (uint64, error) {
+	for {
+		var from uint64
+		if tip := n.Headers.Tip(); tip != nil {
+			from = tip.Height + 1
+		}
+		list, err := n.FetchBlocks(validatorAddress, from, maxBlockRequest, true)
+		if err != nil {
+			return from, err
+		}
+		headers := make([]zn.BlockHeader, len(list.Blocks))
+		for i, b := range list.Blocks {
+			headers[i] = b.Header
+		}
+		if err := n.Headers.Extend(headers); err != nil {
+			return from, err
+		}
+		from += uint64(len(headers))
+		if len(headers) == 0 || from >= list.Height {
+			return from, nil
+		}
+	}
+}
+
+// TxContext returns the context in which the node checks the transactions it
+// receives: the configured chain and the height following its header chain.
+func (n *Node) TxContext() zg.TxContext {
+	return zg.TxContext{ChainID: ChainID, Height: n.Headers.Height()}
+}
+
+// TxExpiry returns the expiry height of a transaction created now, TxLifetime
+// blocks after the next block. The header chain is synced from the validator
+// first; if it cannot be reached, the last synced height is used.
+func (n *Node) TxExpiry(validatorAddress string) uint64 {
+	height, err := n.SyncHeaders(validatorAddress)
+	if err != nil {
+		n.logger.Warn().Err(err).Msgf("%s[Node %d] [Block] Header sync failed\033[0m", getNodeColor(n.ID), n.ID)
+	}
+	return height + TxLifetime
+}
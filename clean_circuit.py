@@ -1,8 +1,12 @@
 import os
 import shutil
 
+# Supprime les circuits compilés et les clés (cf. LoadOrGenerateKeys), à
+# régénérer après toute modification d'un circuit. Les bundles de preuves
+# exportés auparavant ne se vérifient qu'avec l'ancienne zk_vk.
+
 # Chemin des dossiers à nettoyer
-folder_paths = ["./_run_default", "./_run_register", "./_run_oneCoin", "./_run_F1", "./_run_2coin", "./_run_F2", "./_run_3coin", "./_run_F3", "./_run_draw"]
+folder_paths = ["./_run_default", "./_run_register", "./_run_oneCoin", "./_run_F1", "./_run_2coin", "./_run_F2", "./_run_3coin", "./_run_F3", "./_run_draw", "./_run_sellerRegister", "./_run_settle2", "./_run_settle3"]
 
 # Vérifie si le dossier existe avant de tenter de supprimer son contenu
 for folder_path in folder_paths:
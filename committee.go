@@ -0,0 +1,701 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	zg "zerocash_gnark/zerocash_gnark"
+	zn "zerocash_gnark/zerocash_network"
+
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	bls12377_fr "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	"github.com/rs/zerolog"
+)
+
+// -------------------------------
+// Committee
+// -------------------------------
+
+var (
+	ErrCommitteeNotReady = errors.New("committee key generation is not complete")
+	ErrNotCommittee      = errors.New("node is not a committee member")
+	ErrNotEnoughShares   = errors.New("not enough valid decryption shares")
+)
+
+// Committee is a node's view of the bid decryption committee (see section 10
+// of zerocash_gnark): the Feldman commitments of every dealer and, on a
+// member, the shares it received. Observers (validator, auctioneer) only hold
+// the commitments, which is enough to compute the committee key and to check
+// partial decryptions.
+//
+// A dealing is accepted once a quorum of members (see echoQuorum) has echoed
+// the hash of the commitments it received from the dealer, signed by the
+// dealer (see zn.DKGEchoPayload), so that all nodes accept the same
+// commitments without waiting for members that stay silent. A member whose
+// share does not verify complains in its echo, and the dealer must then
+// reveal that share to the whole committee (zn.DKGJustifyPayload); a dealing
+// with an unanswered complaint is not accepted. A dealer that sent different
+// commitments or revealed an invalid share is disqualified, and so is, on
+// Close, a dealer that has not qualified by then. The committee key is that
+// of the qualified dealers.
+type Committee struct {
+	Config zn.CommitteeConfig
+	index  int // DKG index of the node, 0 for an observer
+
+	mu       sync.Mutex
+	dealings map[int]*dealing
+	coeffs   []bls12377_fr.Element // polynomial of the node's own dealing
+}
+
+// dealing is what a node knows about the dealing of one member.
+type dealing struct {
+	commitments []bls12377.G1Affine         // nil until received from the dealer
+	share       *bls12377_fr.Element        // the node's verified share, on a member
+	echoes      map[int][]byte              // commitment hash echoed by each member
+	complaints  map[int]bool                // whether each member's echo complained
+	revealed    map[int]bls12377_fr.Element // shares revealed by the dealer
+	qualified   bool
+	rejected    error // why the dealer was disqualified
+}
+
+func (d *dealing) decided() bool {
+	return d.qualified || d.rejected != nil
+}
+
+// NewCommittee returns the view of node nodeID on the committee cfg.
+func NewCommittee(cfg zn.CommitteeConfig, nodeID int) *Committee {
+	c := &Committee{Config: cfg, dealings: make(map[int]*dealing)}
+	c.index = c.memberIndex(nodeID)
+	return c
+}
+
+// memberIndex returns the DKG index of node id, 0 if it is not a member.
+func (c *Committee) memberIndex(id int) int {
+	for k, m := range c.Config.Members {
+		if m == id {
+			return k + 1
+		}
+	}
+	return 0
+}
+
+// IsMember reports whether the node holds a share.
+func (c *Committee) IsMember() bool {
+	return c.index > 0
+}
+
+// IsRequester reports whether node id may ask members for decryptions.
+func (c *Committee) IsRequester(id int) bool {
+	for _, r := range c.Config.Requesters {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dealing returns the record of dealer, created on first use. It must be
+// called with c.mu held.
+func (c *Committee) dealing(dealer int) *dealing {
+	d, ok := c.dealings[dealer]
+	if !ok {
+		d = &dealing{
+			echoes:     make(map[int][]byte),
+			complaints: make(map[int]bool),
+			revealed:   make(map[int]bls12377_fr.Element),
+		}
+		c.dealings[dealer] = d
+	}
+	return d
+}
+
+// AddDealing records the dealing of dealer, whose signature the caller has
+// checked. On a member, share must be the evaluation of the committed
+// polynomial at the member's index; the member records its own echo,
+// complaining if the share does not verify, and returns it to be broadcast to
+// the rest of the committee.
+func (c *Committee) AddDealing(G bls12377.G1Affine, dealer int, commitments []bls12377.G1Affine, share, signature []byte) (zn.DKGEchoPayload, error) {
+	if c.memberIndex(dealer) == 0 {
+		return zn.DKGEchoPayload{}, fmt.Errorf("dealer %d is not a committee member", dealer)
+	}
+	if len(commitments) != c.Config.Threshold {
+		return zn.DKGEchoPayload{}, fmt.Errorf("dealer %d committed to %d coefficients, want %d", dealer, len(commitments), c.Config.Threshold)
+	}
+	echo := zn.DKGEchoPayload{Dealer: dealer, Hash: zn.DKGCommitmentHash(dealer, commitments), Signature: signature}
+	var s *bls12377_fr.Element
+	if c.IsMember() {
+		echo.Echoer = c.Config.Members[c.index-1]
+		var v bls12377_fr.Element
+		v.SetBytes(share)
+		if len(share) != 0 && zg.VerifyDKGShare(G, commitments, c.index, v) {
+			s = &v
+		} else {
+			echo.Complaint = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.dealing(dealer)
+	if d.commitments != nil {
+		return zn.DKGEchoPayload{}, fmt.Errorf("duplicate dealing from dealer %d", dealer)
+	}
+	d.commitments = commitments
+	d.share = s
+	if c.IsMember() {
+		d.echoes[echo.Echoer] = echo.Hash
+		d.complaints[echo.Echoer] = echo.Complaint
+	}
+	c.update(G, dealer, d)
+	return echo, nil
+}
+
+// AddEcho records the echo of a member for a dealing, whose dealer signature
+// the caller has checked.
+func (c *Committee) AddEcho(G bls12377.G1Affine, echo zn.DKGEchoPayload) error {
+	if c.memberIndex(echo.Echoer) == 0 {
+		return fmt.Errorf("echo from node %d, not a committee member", echo.Echoer)
+	}
+	if c.memberIndex(echo.Dealer) == 0 {
+		return fmt.Errorf("dealer %d is not a committee member", echo.Dealer)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.dealing(echo.Dealer)
+	if _, ok := d.echoes[echo.Echoer]; ok {
+		return fmt.Errorf("duplicate echo from member %d for dealer %d", echo.Echoer, echo.Dealer)
+	}
+	d.echoes[echo.Echoer] = echo.Hash
+	d.complaints[echo.Echoer] = echo.Complaint
+	c.update(G, echo.Dealer, d)
+	return nil
+}
+
+// Justify returns the node's answer to the complaint of member accuser
+// against its dealing: the share of accuser, revealed to the whole committee.
+func (c *Committee) Justify(accuser int) (zn.DKGJustifyPayload, error) {
+	k := c.memberIndex(accuser)
+	if k == 0 {
+		return zn.DKGJustifyPayload{}, fmt.Errorf("complaint from node %d, not a committee member", accuser)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.coeffs == nil {
+		return zn.DKGJustifyPayload{}, errors.New("node has not dealt")
+	}
+	share := zg.DKGShare(c.coeffs, k)
+	b := share.Bytes()
+	return zn.DKGJustifyPayload{Dealer: c.Config.Members[c.index-1], Accuser: accuser, Share: b[:]}, nil
+}
+
+// AddJustification records the share a dealer revealed for an accuser. The
+// accuser adopts it if it verifies; the dealer is disqualified otherwise.
+func (c *Committee) AddJustification(G bls12377.G1Affine, j zn.DKGJustifyPayload) error {
+	if c.memberIndex(j.Dealer) == 0 {
+		return fmt.Errorf("dealer %d is not a committee member", j.Dealer)
+	}
+	if c.memberIndex(j.Accuser) == 0 {
+		return fmt.Errorf("justification for node %d, not a committee member", j.Accuser)
+	}
+	var s bls12377_fr.Element
+	s.SetBytes(j.Share)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.dealing(j.Dealer)
+	if _, ok := d.revealed[j.Accuser]; ok {
+		return fmt.Errorf("duplicate justification from dealer %d for member %d", j.Dealer, j.Accuser)
+	}
+	d.revealed[j.Accuser] = s
+	c.update(G, j.Dealer, d)
+	return nil
+}
+
+// echoQuorum is the number of matching echoes that qualifies a dealing: all
+// members but Threshold-1, the most that may be faulty without learning the
+// key, and at least Threshold.
+func (c *Committee) echoQuorum() int {
+	q := len(c.Config.Members) - c.Config.Threshold + 1
+	if q < c.Config.Threshold {
+		q = c.Config.Threshold
+	}
+	return q
+}
+
+// update qualifies or disqualifies the dealing d of dealer from what the node
+// received so far. A share revealed after the dealing qualified is still
+// adopted by its accuser. It must be called with c.mu held.
+func (c *Committee) update(G bls12377.G1Affine, dealer int, d *dealing) {
+	if d.rejected != nil || d.commitments == nil {
+		return
+	}
+	hash := zn.DKGCommitmentHash(dealer, d.commitments)
+	for m, h := range d.echoes {
+		if !d.qualified && !bytes.Equal(h, hash) {
+			d.rejected = fmt.Errorf("dealer %d sent other commitments to member %d", dealer, m)
+			return
+		}
+	}
+	for m, s := range d.revealed {
+		k := c.memberIndex(m)
+		if !zg.VerifyDKGShare(G, d.commitments, k, s) {
+			if !d.qualified {
+				d.rejected = fmt.Errorf("dealer %d revealed an invalid share for member %d", dealer, m)
+				return
+			}
+			continue
+		}
+		if k == c.index && d.share == nil {
+			share := s
+			d.share = &share
+		}
+	}
+	if d.qualified || len(d.echoes) < c.echoQuorum() {
+		return
+	}
+	for m, complained := range d.complaints {
+		if _, ok := d.revealed[m]; complained && !ok {
+			return
+		}
+	}
+	d.qualified = true
+}
+
+// Close ends the key generation: the dealers that have not qualified yet are
+// disqualified.
+func (c *Committee) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.Config.Members {
+		if d := c.dealing(m); !d.decided() {
+			d.rejected = fmt.Errorf("dealer %d did not qualify in time", m)
+		}
+	}
+}
+
+// Disqualified returns the disqualified dealers and why.
+func (c *Committee) Disqualified() map[int]error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[int]error)
+	for m, d := range c.dealings {
+		if d.rejected != nil {
+			out[m] = d.rejected
+		}
+	}
+	return out
+}
+
+// Ready reports whether the key generation is complete: every dealer is
+// qualified or disqualified, and at least one qualified.
+func (c *Committee) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready()
+}
+
+// ready implements Ready. It must be called with c.mu held.
+func (c *Committee) ready() bool {
+	qualified := 0
+	for _, m := range c.Config.Members {
+		d, ok := c.dealings[m]
+		if !ok || !d.decided() {
+			return false
+		}
+		if d.qualified {
+			qualified++
+		}
+	}
+	return qualified > 0
+}
+
+// qualifiedList returns the qualified dealings in member order, or
+// ErrCommitteeNotReady. It must be called with c.mu held.
+func (c *Committee) qualifiedList() ([]*dealing, error) {
+	if !c.ready() {
+		return nil, ErrCommitteeNotReady
+	}
+	var list []*dealing
+	for _, m := range c.Config.Members {
+		if d := c.dealings[m]; d.qualified {
+			list = append(list, d)
+		}
+	}
+	return list, nil
+}
+
+// dealingList returns the commitments of the qualified dealers in member
+// order, or ErrCommitteeNotReady.
+func (c *Committee) dealingList() ([][]bls12377.G1Affine, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	qualified, err := c.qualifiedList()
+	if err != nil {
+		return nil, err
+	}
+	list := make([][]bls12377.G1Affine, len(qualified))
+	for i, d := range qualified {
+		list[i] = d.commitments
+	}
+	return list, nil
+}
+
+// PublicKey returns the committee key Y bids are encrypted to.
+func (c *Committee) PublicKey() (bls12377.G1Affine, error) {
+	dealings, err := c.dealingList()
+	if err != nil {
+		return bls12377.G1Affine{}, err
+	}
+	return zg.DKGPublicKey(dealings), nil
+}
+
+// PartialDecrypt returns the member's share of x·U with its proof. The
+// member's key share is the sum of the shares of the qualified dealers.
+func (c *Committee) PartialDecrypt(G, U bls12377.G1Affine) (zg.PartialDecryption, error) {
+	if !c.IsMember() {
+		return zg.PartialDecryption{}, ErrNotCommittee
+	}
+	c.mu.Lock()
+	qualified, err := c.qualifiedList()
+	var share bls12377_fr.Element
+	for _, d := range qualified {
+		share.Add(&share, d.share)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return zg.PartialDecryption{}, err
+	}
+	return zg.PartialDecrypt(G, U, c.index, share)
+}
+
+// VerifyParts checks that parts are the partial decryptions of Us, in order,
+// by the member of DKG index k.
+func (c *Committee) VerifyParts(G bls12377.G1Affine, Us []bls12377.G1Affine, k int, parts []zg.PartialDecryption) error {
+	if len(parts) != len(Us) {
+		return fmt.Errorf("%d shares for %d bids", len(parts), len(Us))
+	}
+	dealings, err := c.dealingList()
+	if err != nil {
+		return err
+	}
+	vk := zg.DKGVerificationKey(dealings, k)
+	for i, pd := range parts {
+		if pd.Index != k || !zg.VerifyPartialDecryption(G, Us[i], vk, pd) {
+			return fmt.Errorf("invalid share for bid %d", i)
+		}
+	}
+	return nil
+}
+
+// Combine checks the partial decryptions of U against the members'
+// verification keys and interpolates the first Threshold valid ones from
+// distinct members. It returns x·U and the shares used.
+func (c *Committee) Combine(G, U bls12377.G1Affine, parts []zg.PartialDecryption) (bls12377.G1Affine, []zg.PartialDecryption, error) {
+	dealings, err := c.dealingList()
+	if err != nil {
+		return bls12377.G1Affine{}, nil, err
+	}
+	var used []zg.PartialDecryption
+	seen := make(map[int]bool)
+	for _, pd := range parts {
+		if len(used) == c.Config.Threshold {
+			break
+		}
+		if pd.Index < 1 || pd.Index > len(c.Config.Members) || seen[pd.Index] {
+			continue
+		}
+		if !zg.VerifyPartialDecryption(G, U, zg.DKGVerificationKey(dealings, pd.Index), pd) {
+			continue
+		}
+		seen[pd.Index] = true
+		used = append(used, pd)
+	}
+	if len(used) < c.Config.Threshold {
+		return bls12377.G1Affine{}, nil, ErrNotEnoughShares
+	}
+	key, err := zg.CombinePartialDecryptions(used)
+	return key, used, err
+}
+
+// JoinCommittee sets the node's view of the committee cfg. It must be called
+// on every member and observer before the dealings are sent.
+func (n *Node) JoinCommittee(cfg zn.CommitteeConfig) {
+	n.Committee = NewCommittee(cfg, n.ID)
+}
+
+// DealDKG sends the node's dealing: a share to every member, itself included,
+// and the commitments alone to every observer.
+func (n *Node) DealDKG() error {
+	c := n.Committee
+	if c == nil || !c.IsMember() {
+		return ErrNotCommittee
+	}
+	coeffs, err := zg.DKGPolynomial(c.Config.Threshold)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.coeffs = coeffs
+	c.mu.Unlock()
+	commitments := zg.DKGCommit(n.G, coeffs)
+	signature := n.Identity.Sign(zn.DKGCommitmentHash(n.ID, commitments))
+
+	deal := func(share []byte) zn.DKGDealPayload {
+		return zn.DKGDealPayload{
+			Dealer:      n.ID,
+			Config:      c.Config,
+			Commitments: commitments,
+			Share:       share,
+			Signature:   signature,
+		}
+	}
+	for k, address := range c.Config.Addresses {
+		share := zg.DKGShare(coeffs, k+1)
+		shareBytes := share.Bytes()
+		if err := n.sendDKG(address, zn.DKGDealMsg, deal(shareBytes[:])); err != nil {
+			return fmt.Errorf("deal to member %d: %w", c.Config.Members[k], err)
+		}
+	}
+	for _, address := range c.Config.Observers {
+		if err := n.sendDKG(address, zn.DKGDealMsg, deal(nil)); err != nil {
+			return fmt.Errorf("deal to observer %s: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// broadcastDKG sends a DKG message to every member but n and to every
+// observer.
+func (n *Node) broadcastDKG(msgType string, payload interface{}) error {
+	c := n.Committee
+	var errs []error
+	for k, address := range c.Config.Addresses {
+		if c.Config.Members[k] == n.ID {
+			continue
+		}
+		if err := n.sendDKG(address, msgType, payload); err != nil {
+			errs = append(errs, fmt.Errorf("member %d: %w", c.Config.Members[k], err))
+		}
+	}
+	for _, address := range c.Config.Observers {
+		if err := n.sendDKG(address, msgType, payload); err != nil {
+			errs = append(errs, fmt.Errorf("observer %s: %w", address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendDKG sends a DKG message to address. DKG messages get no reply.
+func (n *Node) sendDKG(address, msgType string, payload interface{}) error {
+	conn, err := n.Identity.Dial(address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return zn.SendMessage(conn, zn.PackMessage(msgType, payload))
+}
+
+// RequestBidKeys asks the committee members for their partial decryptions of
+// the bids of a closed round and combines them. Members are queried in turn
+// until Threshold of them have sent valid shares for every bid. keys[i] is
+// the encryption key of round.Bids[i] and parts[i] the shares it was combined
+// from.
+func (n *Node) RequestBidKeys(round zn.RoundInfo) (keys []bls12377.G1Affine, parts [][]zg.PartialDecryption, err error) {
+	c := n.Committee
+	if c == nil {
+		return nil, nil, ErrCommitteeNotReady
+	}
+	Us := make([]bls12377.G1Affine, len(round.Bids))
+	for i, t := range round.Bids {
+		reg, ok := t.Tx.(zn.TxRegister)
+		if !ok {
+			return nil, nil, fmt.Errorf("bid %d is not a TxRegister", i)
+		}
+		Us[i] = reg.Ip.G_r
+	}
+
+	received := make([][]zg.PartialDecryption, len(round.Bids))
+	verified := 0
+	for k, address := range c.Config.Addresses {
+		if verified == c.Config.Threshold {
+			break
+		}
+		member := c.Config.Members[k]
+		resp, err := n.requestDecryption(address, member, round.ID)
+		if err == nil {
+			err = c.VerifyParts(n.G, Us, k+1, resp.Parts)
+		}
+		if err != nil {
+			n.logger.Warn().Msgf("%s[Node %d] [Committee] No valid decryption shares from member %d: %v\033[0m",
+				getNodeColor(n.ID), n.ID, member, err)
+			continue
+		}
+		for i := range round.Bids {
+			received[i] = append(received[i], resp.Parts[i])
+		}
+		verified++
+	}
+	if verified < c.Config.Threshold {
+		return nil, nil, fmt.Errorf("%w: %d of %d members", ErrNotEnoughShares, verified, c.Config.Threshold)
+	}
+
+	keys = make([]bls12377.G1Affine, len(round.Bids))
+	parts = make([][]zg.PartialDecryption, len(round.Bids))
+	for i := range round.Bids {
+		keys[i], parts[i], err = c.Combine(n.G, Us[i], received[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("bid %d: %w", i, err)
+		}
+	}
+	return keys, parts, nil
+}
+
+// requestDecryption sends a decryption request to member, at address, and
+// waits for its shares. The connection must be authenticated as member.
+func (n *Node) requestDecryption(address string, member, roundID int) (zn.DecryptSharePayload, error) {
+	ctx := context.Background()
+	peer, err := n.RPC.Peer(ctx, address)
+	if err != nil {
+		return zn.DecryptSharePayload{}, err
+	}
+	if peer != member {
+		return zn.DecryptSharePayload{}, fmt.Errorf("%s is node %d, not member %d", address, peer, member)
+	}
+	return zn.Call[zn.DecryptSharePayload](ctx, n.RPC, address, "decrypt_request", zn.DecryptRequestPayload{RoundID: roundID})
+}
+
+// -------------------------------
+// CommitteeHandler
+// -------------------------------
+
+// CommitteeHandler handles the DKG messages ("dkg_deal", "dkg_echo" and
+// "dkg_justify") on every node of the committee and "decrypt_request"
+// messages on its members.
+type CommitteeHandler struct {
+	Node *Node
+}
+
+func NewCommitteeHandler(node *Node) *CommitteeHandler {
+	return &CommitteeHandler{Node: node}
+}
+
+func (ch *CommitteeHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+	c := ch.Node.Committee
+	if c == nil {
+		logger.Warn().Msgf("%s[Node %d] [Committee] Not part of a committee\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "node %d is not part of a committee", ch.Node.ID)
+		return
+	}
+
+	switch req := msg.Payload.(type) {
+	case zn.DKGDealPayload:
+		if !reflect.DeepEqual(req.Config, c.Config) {
+			logger.Warn().Msgf("%s[Node %d] [Committee] Dealing from %d is for another committee\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.Dealer)
+			return
+		}
+		if err := ch.Node.Identity.Verify(req.Dealer, zn.DKGCommitmentHash(req.Dealer, req.Commitments), req.Signature); err != nil {
+			logger.Warn().Err(err).Msgf("%s[Node %d] [Committee] Dealing from %d is not signed by it\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.Dealer)
+			return
+		}
+		echo, err := c.AddDealing(ch.Node.G, req.Dealer, req.Commitments, req.Share, req.Signature)
+		if err != nil {
+			logger.Warn().Msgf("%s[Node %d] [Committee] %v\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID, err)
+			return
+		}
+		logger.Info().Msgf("%s[Node %d] [Committee] Dealing from node %d received\033[0m",
+			getNodeColor(ch.Node.ID), ch.Node.ID, req.Dealer)
+		if !c.IsMember() {
+			return
+		}
+		if echo.Complaint {
+			logger.Warn().Msgf("%s[Node %d] [Committee] Share from dealer %d does not verify, complaining\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.Dealer)
+		}
+		if err := ch.Node.broadcastDKG(zn.DKGEchoMsg, echo); err != nil {
+			logger.Warn().Err(err).Msgf("%s[Node %d] [Committee] Error echoing the dealing of node %d\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.Dealer)
+		}
+
+	case zn.DKGEchoPayload:
+		// Only commitments the dealer signed count.
+		if err := ch.Node.Identity.Verify(req.Dealer, req.Hash, req.Signature); err != nil {
+			logger.Warn().Err(err).Msgf("%s[Node %d] [Committee] Echo from %d does not carry a signature of dealer %d\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.Echoer, req.Dealer)
+			return
+		}
+		if err := c.AddEcho(ch.Node.G, req); err != nil {
+			logger.Warn().Msgf("%s[Node %d] [Committee] %v\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID, err)
+			return
+		}
+		if !req.Complaint || req.Dealer != ch.Node.ID {
+			return
+		}
+		// Complaint against this node's dealing: its share for the accuser is
+		// revealed to the whole committee, itself included.
+		j, err := c.Justify(req.Echoer)
+		if err == nil {
+			err = c.AddJustification(ch.Node.G, j)
+		}
+		if err == nil {
+			err = ch.Node.broadcastDKG(zn.DKGJustifyMsg, j)
+		}
+		if err != nil {
+			logger.Warn().Err(err).Msgf("%s[Node %d] [Committee] Error answering the complaint of member %d\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.Echoer)
+		}
+
+	case zn.DKGJustifyPayload:
+		if err := c.AddJustification(ch.Node.G, req); err != nil {
+			logger.Warn().Msgf("%s[Node %d] [Committee] %v\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID, err)
+		}
+
+	case zn.DecryptRequestPayload:
+		parts, err := ch.decryptRound(req.RoundID)
+		if err != nil {
+			logger.Warn().Msgf("%s[Node %d] [Committee] Round %d: decryption refused: %v\033[0m",
+				getNodeColor(ch.Node.ID), ch.Node.ID, req.RoundID, err)
+			zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+			return
+		}
+		resp := zn.DecryptSharePayload{Member: ch.Node.ID, Parts: parts}
+		if err := zn.Reply(conn, msg, "decrypt_share", resp); err != nil {
+			logger.Error().Err(err).Msgf("%s[Node %d] [Committee] Error sending decryption shares\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID)
+		}
+
+	default:
+		fmt.Println("CommitteeHandler: invalid payload")
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a committee message")
+	}
+}
+
+// decryptRound computes the member's shares for the bids of a round. The
+// round is fetched from the validator: bids are only decrypted once the
+// registration window is closed, and only those the validator accepted.
+func (ch *CommitteeHandler) decryptRound(roundID int) ([]zg.PartialDecryption, error) {
+	c := ch.Node.Committee
+	round, err := ch.Node.GetRound(c.Config.Validator, roundID)
+	if err != nil {
+		return nil, err
+	}
+	if round.State == zn.RoundOpen {
+		return nil, errors.New("round is still open")
+	}
+	parts := make([]zg.PartialDecryption, len(round.Bids))
+	for i, t := range round.Bids {
+		reg, ok := t.Tx.(zn.TxRegister)
+		if !ok {
+			return nil, fmt.Errorf("bid %d is not a TxRegister", i)
+		}
+		if parts[i], err = c.PartialDecrypt(ch.Node.G, reg.Ip.G_r); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	zg "zerocash_gnark/zerocash_gnark"
+	zn "zerocash_gnark/zerocash_network"
+
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+)
+
+// dkgFaults describes the misbehaviour of some committee members during a
+// test DKG.
+type dkgFaults struct {
+	badShare   map[int]int // dealer -> member sent a share that does not verify
+	equivocate int         // dealer sending other commitments to half the nodes
+	silent     int         // member that echoes no dealing
+}
+
+// newTestCommittee returns the views of the n members of a committee (nodes
+// 1..n, in order) and of an observer.
+func newTestCommittee(n, threshold int) []*Committee {
+	cfg := zn.CommitteeConfig{Threshold: threshold}
+	for id := 1; id <= n; id++ {
+		cfg.Members = append(cfg.Members, id)
+		cfg.Addresses = append(cfg.Addresses, fmt.Sprintf("local:%d", id))
+	}
+	views := make([]*Committee, 0, n+1)
+	for id := 1; id <= n; id++ {
+		views = append(views, NewCommittee(cfg, id))
+	}
+	return append(views, NewCommittee(cfg, 0))
+}
+
+// runDKG delivers, in turn, every dealing, every echo and every
+// justification to all views, as CommitteeHandler does, then closes the key
+// generation.
+func runDKG(t *testing.T, G bls12377.G1Affine, views []*Committee, f dkgFaults) {
+	t.Helper()
+	cfg := views[0].Config
+	var echoes []zn.DKGEchoPayload
+	for _, dealer := range cfg.Members {
+		coeffs, err := zg.DKGPolynomial(cfg.Threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := zg.DKGPolynomial(cfg.Threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		views[dealer-1].coeffs = coeffs
+		for i, v := range views {
+			poly := coeffs
+			if dealer == f.equivocate && i >= len(views)/2 {
+				poly = other
+			}
+			var share []byte
+			if v.IsMember() {
+				s := zg.DKGShare(poly, v.index)
+				if f.badShare[dealer] == cfg.Members[i] {
+					var one = s
+					one.SetOne()
+					s.Add(&s, &one)
+				}
+				b := s.Bytes()
+				share = b[:]
+			}
+			echo, err := v.AddDealing(G, dealer, zg.DKGCommit(G, poly), share, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.IsMember() && cfg.Members[i] != f.silent {
+				echoes = append(echoes, echo)
+			}
+		}
+	}
+
+	var justifications []zn.DKGJustifyPayload
+	for _, e := range echoes {
+		for i, v := range views {
+			if v.IsMember() && cfg.Members[i] == e.Echoer {
+				continue
+			}
+			if err := v.AddEcho(G, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if e.Complaint {
+			j, err := views[e.Dealer-1].Justify(e.Echoer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			justifications = append(justifications, j)
+		}
+	}
+	for _, j := range justifications {
+		for _, v := range views {
+			if err := v.AddJustification(G, j); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, v := range views {
+		v.Close()
+	}
+}
+
+func TestCommitteeDKG(t *testing.T) {
+	_, _, G, _ := bls12377.Generators()
+	tests := []struct {
+		name         string
+		n, threshold int
+		faults       dkgFaults
+		disqualified []int
+	}{
+		{"honest", 3, 2, dkgFaults{}, nil},
+		{"bad share justified", 4, 2, dkgFaults{badShare: map[int]int{2: 3}}, nil},
+		{"silent member", 4, 2, dkgFaults{silent: 4}, nil},
+		{"equivocating dealer", 4, 3, dkgFaults{equivocate: 2}, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			views := newTestCommittee(tt.n, tt.threshold)
+			runDKG(t, G, views, tt.faults)
+
+			// Every view agrees on the qualified dealers and the key.
+			var Y bls12377.G1Affine
+			for i, v := range views {
+				if !v.Ready() {
+					t.Fatalf("view %d not ready", i)
+				}
+				dq := v.Disqualified()
+				if len(dq) != len(tt.disqualified) {
+					t.Fatalf("view %d disqualified %v, want %v", i, dq, tt.disqualified)
+				}
+				for _, m := range tt.disqualified {
+					if dq[m] == nil {
+						t.Fatalf("view %d did not disqualify dealer %d", i, m)
+					}
+				}
+				key, err := v.PublicKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					Y = key
+				} else if !key.Equal(&Y) {
+					t.Fatalf("view %d computed another committee key", i)
+				}
+			}
+
+			// A bid key encrypted to Y is recovered from Threshold shares.
+			encKey, U, _, err := zg.NewCommitteeEncKey(G, Y)
+			if err != nil {
+				t.Fatal(err)
+			}
+			observer := views[len(views)-1]
+			var parts []zg.PartialDecryption
+			for _, v := range views[:tt.n] {
+				pd, err := v.PartialDecrypt(G, U)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := observer.VerifyParts(G, []bls12377.G1Affine{U}, v.index, []zg.PartialDecryption{pd}); err != nil {
+					t.Fatalf("member %d: %v", v.index, err)
+				}
+				parts = append(parts, pd)
+			}
+			key, used, err := observer.Combine(G, U, parts[len(parts)-tt.threshold:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Equal(&encKey) || len(used) != tt.threshold {
+				t.Fatalf("combined key differs from the encryption key (%d shares used)", len(used))
+			}
+
+			// A share that does not match the member's verification key is
+			// rejected, and does not count towards the threshold.
+			bad := parts[0]
+			bad.D = G
+			if err := observer.VerifyParts(G, []bls12377.G1Affine{U}, bad.Index, []zg.PartialDecryption{bad}); err == nil {
+				t.Fatal("bad share verified")
+			}
+			few := append([]zg.PartialDecryption{bad}, parts[1:tt.threshold]...)
+			if _, _, err := observer.Combine(G, U, few); !errors.Is(err, ErrNotEnoughShares) {
+				t.Fatalf("combined with a bad share: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptRequestAuthorization(t *testing.T) {
+	cfg := newTestCommittee(3, 2)[0].Config
+	cfg.Requesters = []int{0, 4} // validator and auctioneer
+	n := &Node{ID: 1, Committee: NewCommittee(cfg, 1)}
+	msg := zn.PackMessage("decrypt_request", zn.DecryptRequestPayload{RoundID: 1})
+	tests := []struct {
+		peer int
+		ok   bool
+	}{
+		{0, true},
+		{4, true},
+		{2, false}, // another member
+		{5, false}, // a bidder
+	}
+	for _, tt := range tests {
+		err := n.authorize(tt.peer, msg)
+		if (err == nil) != tt.ok {
+			t.Errorf("request from node %d: err = %v, want allowed %v", tt.peer, err, tt.ok)
+		}
+	}
+}
+
+func TestRoundOpenAuthorization(t *testing.T) {
+	n := &Node{ID: 0, Auctioneers: []int{4}}
+	msg := zn.PackMessage("round_open", zn.RoundOpenPayload{})
+	tests := []struct {
+		peer int
+		ok   bool
+	}{
+		{4, true},
+		{0, false}, // the validator itself
+		{5, false}, // a bidder
+	}
+	for _, tt := range tests {
+		err := n.authorize(tt.peer, msg)
+		if (err == nil) != tt.ok {
+			t.Errorf("opening from node %d: err = %v, want allowed %v", tt.peer, err, tt.ok)
+		}
+	}
+}
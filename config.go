@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+
+	zn "zerocash_gnark/zerocash_network"
+)
+
+// Node roles. The first validator admits transactions, the other validators
+// replay them through consensus; the auctioneer opens and settles rounds;
+// participants trade, and the first three form the decryption committee.
+const (
+	RoleValidator   = "validator"
+	RoleAuctioneer  = "auctioneer"
+	RoleParticipant = "participant"
+)
+
+// NetworkConfig describes the nodes run by this process, as read from the
+// -config file:
+//
+//	{
+//	  "chain_id": 1,
+//	  "nodes": [
+//	    {"id": 0, "role": "validator", "listen": "127.0.0.1:9000", "key": "_keys/node0.key", "peers": [1, 2]},
+//	    {"id": 1, "role": "participant", "listen": "127.0.0.1:9001", "peers": [0]},
+//	    ...
+//	  ]
+//	}
+//
+// Peers are the bootstrap peers of a node, whose identity keys it pins; the
+// relation is made symmetric, so that both ends accept the connection. Nodes
+// learn about the rest of the network by exchanging peers at runtime, taking
+// only the entries each node signed for itself (see Node.ExchangePeers).
+type NetworkConfig struct {
+	ChainID uint64       `json:"chain_id,omitempty"`
+	Nodes   []NodeConfig `json:"nodes"`
+}
+
+// NodeConfig describes one node. The node listens on the port of Listen, on
+// every interface, and advertises Listen to its peers. Key is the file holding
+// its identity seed (see zn.LoadIdentity); without it, the node gets a new
+// identity at every start.
+type NodeConfig struct {
+	ID     int    `json:"id"`
+	Role   string `json:"role"`
+	Listen string `json:"listen"`
+	Key    string `json:"key,omitempty"`
+	Peers  []int  `json:"peers,omitempty"`
+}
+
+// LoadConfig reads and checks the network configuration at path.
+func LoadConfig(path string) (*NetworkConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg NetworkConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// DefaultConfig is the network run without -config: numNodes nodes listening
+// on consecutive ports from basePort, the first numValidators of them
+// validators, node 4 the auctioneer and all nodes peers of each other.
+func DefaultConfig(numNodes, basePort, numValidators int) *NetworkConfig {
+	cfg := &NetworkConfig{Nodes: make([]NodeConfig, numNodes)}
+	for i := range cfg.Nodes {
+		role := RoleParticipant
+		switch {
+		case i == 0 || i < numValidators:
+			role = RoleValidator
+		case i == 4:
+			role = RoleAuctioneer
+		}
+		cfg.Nodes[i] = NodeConfig{ID: i, Role: role, Listen: "127.0.0.1:" + strconv.Itoa(basePort+i)}
+		for j := 0; j < numNodes; j++ {
+			if j != i {
+				cfg.Nodes[i].Peers = append(cfg.Nodes[i].Peers, j)
+			}
+		}
+	}
+	return cfg
+}
+
+// Validate checks that IDs and listen addresses are unique, that roles and
+// peers are known and that there is a validator.
+func (c *NetworkConfig) Validate() error {
+	ids := make(map[int]bool)
+	listens := make(map[string]bool)
+	validators := 0
+	for _, n := range c.Nodes {
+		if ids[n.ID] {
+			return fmt.Errorf("duplicate node id %d", n.ID)
+		}
+		ids[n.ID] = true
+		if _, err := n.Port(); err != nil {
+			return fmt.Errorf("node %d: %w", n.ID, err)
+		}
+		if listens[n.Listen] {
+			return fmt.Errorf("node %d: listen address %s already used", n.ID, n.Listen)
+		}
+		listens[n.Listen] = true
+		switch n.Role {
+		case RoleValidator:
+			validators++
+		case RoleAuctioneer, RoleParticipant:
+		default:
+			return fmt.Errorf("node %d: unknown role %q", n.ID, n.Role)
+		}
+	}
+	for _, n := range c.Nodes {
+		for _, p := range n.Peers {
+			if !ids[p] || p == n.ID {
+				return fmt.Errorf("node %d: invalid peer %d", n.ID, p)
+			}
+		}
+	}
+	if validators == 0 {
+		return fmt.Errorf("no validator")
+	}
+	return nil
+}
+
+// PeersOf returns the bootstrap peers of node id: those it lists and those
+// listing it.
+func (c *NetworkConfig) PeersOf(id int) []int {
+	seen := make(map[int]bool)
+	var peers []int
+	add := func(p int) {
+		if !seen[p] {
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+	for _, n := range c.Nodes {
+		if n.ID == id {
+			for _, p := range n.Peers {
+				add(p)
+			}
+		} else {
+			for _, p := range n.Peers {
+				if p == id {
+					add(n.ID)
+				}
+			}
+		}
+	}
+	return peers
+}
+
+// NewNodeFromConfig creates the node described by cfg. admitsTxs selects the
+// validator that admits transactions (see NewNode).
+func NewNodeFromConfig(cfg NodeConfig, commonG bls12377.G1Affine, admitsTxs bool) (*Node, error) {
+	port, err := cfg.Port()
+	if err != nil {
+		return nil, err
+	}
+	node := NewNode(port, cfg.ID, commonG, admitsTxs)
+	node.Address = cfg.Listen
+	if cfg.Key != "" {
+		if node.Identity, err = zn.LoadIdentity(cfg.ID, cfg.Key); err != nil {
+			return nil, err
+		}
+		node.RPC = newRPCClient(node.Identity)
+	}
+	node.Peers = NewPeerTable(selfInfo(node.Identity, cfg.Listen, cfg.Role))
+	return node, nil
+}
+
+// Port returns the port of the listen address.
+func (n NodeConfig) Port() (int, error) {
+	_, port, err := net.SplitHostPort(n.Listen)
+	if err != nil {
+		return 0, fmt.Errorf("listen address %q: %w", n.Listen, err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, fmt.Errorf("listen address %q: invalid port", n.Listen)
+	}
+	return p, nil
+}
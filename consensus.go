@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	zn "zerocash_gnark/zerocash_network"
+
+	"github.com/rs/zerolog"
+)
+
+// Consensus timeouts; each grows with the round so that a round eventually
+// lasts long enough for the honest validators to hear from each other.
+const (
+	timeoutPropose   = 1 * time.Second
+	timeoutPrevote   = 500 * time.Millisecond
+	timeoutPrecommit = 500 * time.Millisecond
+	timeoutDelta     = 500 * time.Millisecond
+
+	// maxFutureMessages bounds the messages kept for heights not reached yet.
+	maxFutureMessages = 4096
+
+	// evictInterval is the period at which expired mempool entries are
+	// evicted.
+	evictInterval = 10 * time.Second
+)
+
+var errUnknownValidator = errors.New("unknown validator")
+
+// Transport delivers consensus messages to a validator.
+type Transport interface {
+	Send(to zn.ValidatorInfo, msg zn.Message) error
+}
+
+// TCPTransport sends each message on a new connection to the validator's
+// address, like Node.SendMessage, without logging the (large) message. The
+// connection is authenticated with Identity, which JoinConsensus sets to the
+// identity of each validator's node, and must reach the validator to.ID.
+type TCPTransport struct {
+	Identity *zn.Identity
+}
+
+func (t TCPTransport) Send(to zn.ValidatorInfo, msg zn.Message) error {
+	conn, err := t.Identity.DialTimeout(to.Address, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if conn.Peer != to.ID {
+		return fmt.Errorf("%s is node %d, not validator %d", to.Address, conn.Peer, to.ID)
+	}
+	return zn.SendMessage(conn, msg)
+}
+
+// LocalTransport delivers messages to replicas of the same process without
+// going through the network, so that a whole validator set can run in one
+// process.
+type LocalTransport struct {
+	mu       sync.RWMutex
+	replicas map[int]*Replica
+}
+
+func NewLocalTransport() *LocalTransport {
+	return &LocalTransport{replicas: make(map[int]*Replica)}
+}
+
+// Add makes r reachable through t.
+func (t *LocalTransport) Add(r *Replica) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.replicas[r.ID] = r
+}
+
+func (t *LocalTransport) Send(to zn.ValidatorInfo, msg zn.Message) error {
+	t.mu.RLock()
+	r := t.replicas[to.ID]
+	t.mu.RUnlock()
+	if r == nil {
+		return fmt.Errorf("%w %d", errUnknownValidator, to.ID)
+	}
+	r.Deliver(msg)
+	return nil
+}
+
+// step is the step of a replica within a round.
+type step int
+
+const (
+	stepPropose step = iota
+	stepPrevote
+	stepPrecommit
+)
+
+// timeoutEvent fires when the step of a round lasted too long.
+type timeoutEvent struct {
+	height uint64
+	round  int
+	step   step
+}
+
+// entryEvent signals that entries were appended to the ledger.
+type entryEvent struct{}
+
+// Replica is a validator running a Tendermint-style BFT consensus on the
+// blocks of its ledger. In each round of a height, the proposer broadcasts a
+// block built from its pending entries; validators prevote for it if it
+// extends their ledger and does not conflict with the block they are locked
+// on, precommit it once 2n/3+1 prevotes agree, and commit it once 2n/3+1
+// precommits agree. A round that does not reach agreement times out and the
+// next validator proposes. Safety holds with f < n/3 faulty validators:
+// proposals and votes are signed, and a validator only precommits a block it
+// is then locked on.
+//
+// Entries admitted by a validator are relayed to the others, which check
+// their proofs again (see Ledger.Verify), so that any proposer can include
+// them. A replica that missed a whole block cannot catch
+// up yet: messages for later heights are kept until it gets there.
+type Replica struct {
+	ID         int
+	validators []zn.ValidatorInfo
+	identity   *zn.Identity // signs proposals and votes
+	ledger     *Ledger
+	transport  Transport
+	logger     zerolog.Logger
+	inbox      chan interface{}
+
+	// State of the current height, owned by run.
+	height      uint64
+	round       int
+	step        step
+	started     bool
+	lockedRound int
+	lockedBlock *zn.Proposal
+	validRound  int
+	validBlock  *zn.Proposal
+	proposals   map[int]*zn.Proposal
+	votes       map[zn.VoteKind]map[int]map[int][]byte // kind -> round -> validator -> block hash
+	senders     map[int]map[int]bool                   // round -> validators heard from
+	valid       map[string]bool                        // block hash -> CheckBlock succeeded
+	fired       map[string]bool                        // rules that fire once per round
+	future      []interface{}
+}
+
+// NewReplica returns the replica of the validator with identity id, whose
+// state is ledger. The validator set must be the same for all replicas, and
+// list the identity key of each validator.
+func NewReplica(id *zn.Identity, validators []zn.ValidatorInfo, ledger *Ledger, transport Transport) *Replica {
+	r := &Replica{
+		ID:         id.ID,
+		validators: validators,
+		identity:   id,
+		ledger:     ledger,
+		transport:  transport,
+		logger:     zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger(),
+		inbox:      make(chan interface{}, 1024),
+	}
+	ledger.OnAppend = r.relay
+	r.resetHeight()
+	return r
+}
+
+// Start runs the replica until the process exits.
+func (r *Replica) Start() {
+	go r.run()
+}
+
+// Deliver hands a consensus message to the replica.
+func (r *Replica) Deliver(msg zn.Message) {
+	switch p := msg.Payload.(type) {
+	case zn.Proposal, zn.Vote:
+		r.inbox <- p
+	case zn.EntryPayload:
+		if err := r.ledger.AppendEncoded(p.Entry); err != nil {
+			r.logf(r.logger.Warn(), "Relayed entry rejected: %v", err)
+			return
+		}
+		r.inbox <- entryEvent{}
+	default:
+		fmt.Println("Replica: invalid payload")
+	}
+}
+
+// HandleMessage serves the "bft_*" messages of a node.
+func (r *Replica) HandleMessage(msg zn.Message, conn net.Conn) {
+	r.Deliver(msg)
+}
+
+// relay sends an entry appended locally to the other validators.
+func (r *Replica) relay(payload []byte) {
+	msg := zn.PackMessage(zn.BFTEntryMsg, zn.EntryPayload{Entry: payload})
+	for _, v := range r.validators {
+		if v.ID != r.ID {
+			r.send(v, msg)
+		}
+	}
+	r.inbox <- entryEvent{}
+}
+
+func (r *Replica) send(to zn.ValidatorInfo, msg zn.Message) {
+	go func() {
+		if err := r.transport.Send(to, msg); err != nil {
+			r.logf(r.logger.Warn(), "Cannot reach validator %d: %v", to.ID, err)
+		}
+	}()
+}
+
+func (r *Replica) logf(ev *zerolog.Event, format string, args ...interface{}) {
+	ev.Msgf("%s[Node %d] [BFT] %s\033[0m", getNodeColor(r.ID), r.ID, fmt.Sprintf(format, args...))
+}
+
+func (r *Replica) run() {
+	evict := time.NewTicker(evictInterval)
+	defer evict.Stop()
+	var beat <-chan time.Time // wakes the replica up when a heartbeat may be due
+	if r.ledger.Heartbeat > 0 {
+		t := time.NewTicker(r.ledger.Heartbeat)
+		defer t.Stop()
+		beat = t.C
+	}
+	r.process()
+	for {
+		select {
+		case ev := <-r.inbox:
+			switch ev := ev.(type) {
+			case zn.Proposal:
+				r.onProposal(ev)
+			case zn.Vote:
+				r.onVote(ev)
+			case timeoutEvent:
+				r.onTimeout(ev)
+			}
+		case now := <-evict.C:
+			if n, err := r.ledger.EvictExpired(now); err != nil {
+				r.logf(r.logger.Error(), "Cannot evict expired entries: %v", err)
+			} else if n > 0 {
+				r.logf(r.logger.Info(), "%d expired entries evicted from the mempool", n)
+			}
+		case <-beat:
+		}
+		r.process()
+	}
+}
+
+// resetHeight clears the state of the height after a commit.
+func (r *Replica) resetHeight() {
+	r.height = r.ledger.Height()
+	r.round = 0
+	r.step = stepPropose
+	r.started = false
+	r.lockedRound, r.lockedBlock = -1, nil
+	r.validRound, r.validBlock = -1, nil
+	r.proposals = make(map[int]*zn.Proposal)
+	r.votes = map[zn.VoteKind]map[int]map[int][]byte{zn.Prevote: {}, zn.Precommit: {}}
+	r.senders = make(map[int]map[int]bool)
+	r.valid = make(map[string]bool)
+	r.fired = make(map[string]bool)
+}
+
+func (r *Replica) validator(id int) (zn.ValidatorInfo, bool) {
+	for _, v := range r.validators {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return zn.ValidatorInfo{}, false
+}
+
+// proposer returns the proposer of round of height, in round-robin order.
+func (r *Replica) proposer(height uint64, round int) zn.ValidatorInfo {
+	n := uint64(len(r.validators))
+	return r.validators[(height+uint64(round))%n]
+}
+
+// faulty returns f, the number of faulty validators tolerated.
+func (r *Replica) faulty() int {
+	return (len(r.validators) - 1) / 3
+}
+
+// quorum returns the number of votes any two sets of which share an honest
+// validator.
+func (r *Replica) quorum() int {
+	return 2*len(r.validators)/3 + 1
+}
+
+// later reports whether ev is for a height not reached yet, and keeps it; ev
+// is dropped if it is for a past height.
+func (r *Replica) later(height uint64, ev interface{}) bool {
+	if height < r.height {
+		return true
+	}
+	if height > r.height {
+		if len(r.future) < maxFutureMessages {
+			r.future = append(r.future, ev)
+		}
+		return true
+	}
+	return false
+}
+
+func (r *Replica) heardFrom(round, validator int) {
+	if r.senders[round] == nil {
+		r.senders[round] = make(map[int]bool)
+	}
+	r.senders[round][validator] = true
+}
+
+func (r *Replica) onProposal(p zn.Proposal) {
+	if r.later(p.Height, p) {
+		return
+	}
+	if r.proposer(p.Height, p.Round).ID != p.Proposer {
+		r.logf(r.logger.Warn(), "Proposal of height %d round %d from non-proposer %d", p.Height, p.Round, p.Proposer)
+		return
+	}
+	v, _ := r.validator(p.Proposer)
+	if !ed25519.Verify(v.PubKey, p.SignBytes(), p.Signature) {
+		r.logf(r.logger.Warn(), "Bad proposal signature from validator %d", p.Proposer)
+		return
+	}
+	if _, ok := r.proposals[p.Round]; ok {
+		return
+	}
+	r.proposals[p.Round] = &p
+	r.heardFrom(p.Round, p.Proposer)
+}
+
+func (r *Replica) onVote(v zn.Vote) {
+	if r.later(v.Height, v) {
+		return
+	}
+	val, ok := r.validator(v.Validator)
+	if !ok || !ed25519.Verify(val.PubKey, v.SignBytes(), v.Signature) {
+		r.logf(r.logger.Warn(), "Bad %s from validator %d", v.Kind, v.Validator)
+		return
+	}
+	byRound := r.votes[v.Kind]
+	if byRound[v.Round] == nil {
+		byRound[v.Round] = make(map[int][]byte)
+	}
+	if prev, ok := byRound[v.Round][v.Validator]; ok {
+		if !bytes.Equal(prev, v.BlockHash) {
+			r.logf(r.logger.Warn(), "Validator %d equivocates: two %ss in round %d", v.Validator, v.Kind, v.Round)
+		}
+		return
+	}
+	byRound[v.Round][v.Validator] = v.BlockHash
+	r.heardFrom(v.Round, v.Validator)
+}
+
+func (r *Replica) onTimeout(t timeoutEvent) {
+	if t.height != r.height || t.round != r.round || t.step != r.step {
+		return
+	}
+	switch t.step {
+	case stepPropose:
+		r.vote(zn.Prevote, nil)
+		r.step = stepPrevote
+	case stepPrevote:
+		r.vote(zn.Precommit, nil)
+		r.step = stepPrecommit
+	case stepPrecommit:
+		r.startRound(r.round + 1)
+	}
+}
+
+func (r *Replica) schedule(s step, d time.Duration) {
+	t := timeoutEvent{height: r.height, round: r.round, step: s}
+	time.AfterFunc(d+time.Duration(r.round)*timeoutDelta, func() { r.inbox <- t })
+}
+
+// count returns the number of kind votes of round for hash (nil: nil votes).
+func (r *Replica) count(kind zn.VoteKind, round int, hash []byte) int {
+	n := 0
+	for _, h := range r.votes[kind][round] {
+		if bytes.Equal(h, hash) {
+			n++
+		}
+	}
+	return n
+}
+
+// isValid reports whether p extends the ledger, caching the answer.
+func (r *Replica) isValid(p *zn.Proposal) bool {
+	key := string(p.Header.Hash())
+	if ok, seen := r.valid[key]; seen {
+		return ok
+	}
+	err := r.ledger.CheckBlock(p.Header, p.Entries, p.FeeProof)
+	if err != nil {
+		r.logf(r.logger.Warn(), "Invalid block proposed at height %d: %v", p.Height, err)
+	}
+	r.valid[key] = err == nil
+	return err == nil
+}
+
+// once reports whether the rule name has not fired yet in the current round,
+// and marks it fired.
+func (r *Replica) once(name string) bool {
+	key := fmt.Sprintf("%s/%d", name, r.round)
+	if r.fired[key] {
+		return false
+	}
+	r.fired[key] = true
+	return true
+}
+
+func (r *Replica) startRound(round int) {
+	r.round = round
+	r.step = stepPropose
+	r.started = true
+	if r.proposer(r.height, round).ID == r.ID {
+		r.propose()
+	}
+	r.schedule(stepPropose, timeoutPropose)
+}
+
+func (r *Replica) propose() {
+	p := zn.Proposal{Height: r.height, Round: r.round, ValidRound: -1, Proposer: r.ID}
+	if r.validBlock != nil {
+		p.ValidRound = r.validRound
+		p.Header, p.Entries, p.FeeProof = r.validBlock.Header, r.validBlock.Entries, r.validBlock.FeeProof
+	} else {
+		h, entries, feeProof, ok, err := r.ledger.ProposeBlock(maxBlockEntries)
+		if err != nil {
+			r.logf(r.logger.Error(), "Cannot build block: %v", err)
+			return
+		}
+		if !ok {
+			return
+		}
+		p.Header, p.Entries, p.FeeProof = h, entries, feeProof
+	}
+	p.Signature = r.identity.Sign(p.SignBytes())
+	r.logf(r.logger.Info(), "Proposing block %d (%d entries) in round %d", p.Height, len(p.Entries), p.Round)
+	r.onProposal(p)
+	r.broadcast(zn.PackMessage(zn.BFTProposalMsg, p))
+}
+
+// vote signs and broadcasts a vote for hash in the current round.
+func (r *Replica) vote(kind zn.VoteKind, hash []byte) {
+	v := zn.Vote{Kind: kind, Height: r.height, Round: r.round, BlockHash: hash, Validator: r.ID}
+	v.Signature = r.identity.Sign(v.SignBytes())
+	r.onVote(v)
+	r.broadcast(zn.PackMessage(zn.BFTVoteMsg, v))
+}
+
+func (r *Replica) broadcast(msg zn.Message) {
+	for _, v := range r.validators {
+		if v.ID != r.ID {
+			r.send(v, msg)
+		}
+	}
+}
+
+// process applies the consensus rules until none fires.
+func (r *Replica) process() {
+	if !r.started {
+		if !r.ledger.HasPending() && !r.ledger.HeartbeatDue() && len(r.senders) == 0 {
+			return // nothing to agree on
+		}
+		r.startRound(0)
+	}
+
+	// Commit: a block precommitted by a quorum in any round.
+	for round, p := range r.proposals {
+		hash := p.Header.Hash()
+		if r.count(zn.Precommit, round, hash) >= r.quorum() && r.isValid(p) {
+			r.commit(p)
+			return
+		}
+	}
+
+	// Skip to a later round that f+1 validators (one of them honest) reached.
+	for round, from := range r.senders {
+		if round > r.round && len(from) > r.faulty() {
+			r.startRound(round)
+			r.process()
+			return
+		}
+	}
+
+	p := r.proposals[r.round]
+	if r.step == stepPropose && p != nil {
+		hash := p.Header.Hash()
+		switch {
+		case p.ValidRound == -1:
+			if r.isValid(p) && (r.lockedRound == -1 || bytes.Equal(r.lockedBlock.Header.Hash(), hash)) {
+				r.vote(zn.Prevote, hash)
+			} else {
+				r.vote(zn.Prevote, nil)
+			}
+			r.step = stepPrevote
+		case p.ValidRound < r.round && r.count(zn.Prevote, p.ValidRound, hash) >= r.quorum():
+			if r.isValid(p) && (r.lockedRound <= p.ValidRound || bytes.Equal(r.lockedBlock.Header.Hash(), hash)) {
+				r.vote(zn.Prevote, hash)
+			} else {
+				r.vote(zn.Prevote, nil)
+			}
+			r.step = stepPrevote
+		}
+	}
+
+	if r.step == stepPrevote && len(r.votes[zn.Prevote][r.round]) >= r.quorum() && r.once("prevote-timeout") {
+		r.schedule(stepPrevote, timeoutPrevote)
+	}
+
+	if r.step >= stepPrevote && p != nil {
+		hash := p.Header.Hash()
+		if r.count(zn.Prevote, r.round, hash) >= r.quorum() && r.isValid(p) && r.once("polka") {
+			if r.step == stepPrevote {
+				r.lockedRound, r.lockedBlock = r.round, p
+				r.vote(zn.Precommit, hash)
+				r.step = stepPrecommit
+			}
+			r.validRound, r.validBlock = r.round, p
+		}
+	}
+
+	if r.step == stepPrevote && r.count(zn.Prevote, r.round, nil) >= r.quorum() {
+		r.vote(zn.Precommit, nil)
+		r.step = stepPrecommit
+	}
+
+	if len(r.votes[zn.Precommit][r.round]) >= r.quorum() && r.once("precommit-timeout") {
+		r.schedule(stepPrecommit, timeoutPrecommit)
+	}
+}
+
+func (r *Replica) commit(p *zn.Proposal) {
+	if err := r.ledger.CommitBlock(p.Header, p.Entries, p.FeeProof); err != nil {
+		// A quorum committed a block this ledger rejects: the ledger has
+		// diverged and the replica no longer takes part in this height.
+		r.logf(r.logger.Error(), "Cannot commit block %d: %v", p.Height, err)
+		r.valid[string(p.Header.Hash())] = false
+		return
+	}
+	r.logf(r.logger.Info(), "Block %d committed (%d entries, round %d, hash %x)", p.Height, len(p.Entries), p.Round, p.Header.Hash()[:8])
+	r.resetHeight()
+	future := r.future
+	r.future = nil
+	for _, ev := range future {
+		switch ev := ev.(type) {
+		case zn.Proposal:
+			r.onProposal(ev)
+		case zn.Vote:
+			r.onVote(ev)
+		}
+	}
+	r.process()
+}
+
+// JoinConsensus makes nodes the validators of a consensus over transport and
+// starts their replicas; ledgers[i] is the state of nodes[i]. The first node
+// admits transactions in its ledger (normally LedgerDB); the others check
+// again the entries it relays and the blocks proposed to them (see
+// verifyEntry). Each replica signs with the identity key of its node, which
+// the other nodes pin, and collects the fees of the blocks it proposes.
+func JoinConsensus(nodes []*Node, ledgers []*Ledger, transport Transport) error {
+	if len(ledgers) != len(nodes) {
+		return fmt.Errorf("%d ledgers for %d validators", len(ledgers), len(nodes))
+	}
+	validators := make([]zn.ValidatorInfo, len(nodes))
+	for i, n := range nodes {
+		validators[i] = zn.ValidatorInfo{ID: n.ID, Address: n.Address, PubKey: n.Identity.Public()}
+	}
+	for i, n := range nodes {
+		ledger := ledgers[i]
+		ledger.Fees = n
+		ledger.Verify = verifyEntry
+		t := transport
+		if tcp, ok := transport.(TCPTransport); ok {
+			tcp.Identity = n.Identity
+			t = tcp
+		}
+		n.Replica = NewReplica(n.Identity, validators, ledger, t)
+		if local, ok := transport.(*LocalTransport); ok {
+			local.Add(n.Replica)
+		}
+	}
+	for _, n := range nodes {
+		n.Replica.Start()
+	}
+	return nil
+}
+
+// ledger returns the ledger the node serves blocks from.
+func (n *Node) ledger() *Ledger {
+	if n.Replica != nil {
+		return n.Replica.ledger
+	}
+	return LedgerDB
+}
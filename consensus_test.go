@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	zn "zerocash_gnark/zerocash_network"
+)
+
+// newTestValidators returns n nodes with their own identity and in-memory
+// ledger, running consensus over a LocalTransport.
+func newTestValidators(t *testing.T, n int) ([]*Node, []*Ledger) {
+	t.Helper()
+	nodes := make([]*Node, n)
+	ledgers := make([]*Ledger, n)
+	for i := range nodes {
+		id, err := zn.NewIdentity(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = &Node{ID: i, Address: fmt.Sprintf("local:%d", i), Identity: id}
+		ledgers[i] = NewMemoryLedger()
+	}
+	if err := JoinConsensus(nodes, ledgers, NewLocalTransport()); err != nil {
+		t.Fatal(err)
+	}
+	return nodes, ledgers
+}
+
+func TestReplicasCommitSameBlocks(t *testing.T) {
+	nodes, ledgers := newTestValidators(t, 4)
+	for i, n := range nodes {
+		if !bytes.Equal(n.Replica.validators[i].PubKey, n.Identity.Public()) {
+			t.Fatalf("validator %d does not sign with its node identity", i)
+		}
+	}
+
+	// Entries without notes carry no proof to check.
+	for id := 1; id <= 3; id++ {
+		ev := &RoundEvent{Kind: RoundEventOpened, ID: id, At: time.Now()}
+		if err := ledgers[0].Append(LedgerEntry{Round: ev}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		committed := 0
+		for _, l := range ledgers {
+			if l.Height() > 0 && !l.HasPending() {
+				committed++
+			}
+		}
+		if committed == len(ledgers) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d replicas committed the entries", committed, len(ledgers))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	want := ledgers[0].Blocks(0, int(ledgers[0].Height()), true)
+	for i, l := range ledgers[1:] {
+		got := l.Blocks(0, int(l.Height()), true)
+		if len(got) != len(want) {
+			t.Fatalf("replica %d has %d blocks, want %d", i+1, len(got), len(want))
+		}
+		for h := range want {
+			if !bytes.Equal(got[h].Header.Hash(), want[h].Header.Hash()) {
+				t.Fatalf("replica %d: block %d differs", i+1, h)
+			}
+		}
+	}
+}
+
+func TestReplicaRejectsUnprovenEntries(t *testing.T) {
+	// A faulty validator admits an entry spending a note without any proof.
+	faulty := NewMemoryLedger()
+	forged := LedgerEntry{Sns: [][]byte{{1, 2, 3}}, Cms: [][]byte{{4, 5, 6}}, Expiry: 10}
+	if err := faulty.Append(forged); err != nil {
+		t.Fatal(err)
+	}
+	h, payloads, feeProof, ok, err := faulty.ProposeBlock(maxBlockEntries)
+	if err != nil || !ok {
+		t.Fatalf("ProposeBlock: ok=%v err=%v", ok, err)
+	}
+
+	replica := NewMemoryLedger()
+	replica.Verify = verifyEntry
+	if err := replica.AppendEncoded(payloads[0]); err == nil {
+		t.Error("relayed unproven entry accepted")
+	}
+	if err := replica.CheckBlock(h, payloads, feeProof); err == nil {
+		t.Error("block with an unproven entry accepted")
+	}
+	if replica.HasNullifier(forged.Sns[0]) {
+		t.Error("unproven nullifier admitted")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+
+	zn "zerocash_gnark/zerocash_network"
+)
+
+// DefaultDHSessionTTL is the lifetime of a DH session unless -dh-ttl says
+// otherwise. It must outlast an auction round, whose registrations, settlement
+// and draws all use the keys negotiated at registration.
+const DefaultDHSessionTTL = time.Hour
+
+// DHSession is the Diffie-Hellman exchange a node shares with Peer for
+// auction round Round, usable until Expires. Round 0 is the standing session
+// of ordinary transactions and refunds.
+type DHSession struct {
+	Peer    int
+	Round   int
+	Params  zn.DHParams
+	Created time.Time
+	Expires time.Time
+}
+
+// dhKey identifies a session: each round is registered under its own keys.
+type dhKey struct {
+	peer, round int
+}
+
+// DHStore holds the current DH sessions of a node with each of its peers, one
+// per auction round. Sessions are rotated by running a new exchange (see
+// Node.DiffieHellmanKeyExchange and Node.RoundKeyExchange): the new session
+// replaces the previous one for the same peer and round, whose secrets are
+// erased. EndRound erases the sessions of a round once it is over; expired
+// sessions are ignored by Get and erased by Expire. A DHStore is safe for
+// concurrent use. If it was opened with OpenDHStore, every change is written
+// to its file so the sessions survive a restart.
+type DHStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[dhKey]*DHSession
+	path     string // empty: in-memory only
+
+	// RoundOver, if set, reports the rounds whose sessions Expire erases
+	// although they have not expired (see Node.roundOver).
+	RoundOver func(round int) bool
+}
+
+// NewDHStore returns an empty in-memory store whose sessions last ttl.
+func NewDHStore(ttl time.Duration) *DHStore {
+	return &DHStore{ttl: ttl, sessions: make(map[dhKey]*DHSession)}
+}
+
+// OpenDHStore opens (or creates) the store persisted at path and loads the
+// sessions that have not expired yet. The file holds session secrets in the
+// clear and is only readable by its owner.
+func OpenDHStore(path string, ttl time.Duration) (*DHStore, error) {
+	s := NewDHStore(ttl)
+	s.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessions []DHSession
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	now := time.Now()
+	for i := range sessions {
+		if now.Before(sessions[i].Expires) {
+			s.sessions[dhKey{sessions[i].Peer, sessions[i].Round}] = &sessions[i]
+		} else {
+			eraseDHParams(&sessions[i].Params)
+		}
+	}
+	return s, nil
+}
+
+// Put makes p the current session with peer for round and erases the session
+// it replaces.
+func (s *DHStore) Put(peer, round int, p zn.DHParams) error {
+	now := time.Now()
+	k := dhKey{peer, round}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.sessions[k]; ok {
+		eraseDHParams(&old.Params)
+	}
+	p.Secret = append([]byte(nil), p.Secret...)
+	s.sessions[k] = &DHSession{Peer: peer, Round: round, Params: p, Created: now, Expires: now.Add(s.ttl)}
+	return s.save()
+}
+
+// Get returns a copy of the current session with peer for round, unless there
+// is none or it has expired. The copy is not erased when the session is.
+func (s *DHStore) Get(peer, round int) (zn.DHParams, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[dhKey{peer, round}]
+	if !ok || !time.Now().Before(sess.Expires) {
+		return zn.DHParams{}, false
+	}
+	p := sess.Params
+	p.Secret = append([]byte(nil), p.Secret...)
+	return p, true
+}
+
+// Len returns the number of live sessions.
+func (s *DHStore) Len() int {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, sess := range s.sessions {
+		if now.Before(sess.Expires) {
+			n++
+		}
+	}
+	return n
+}
+
+// Delete erases the session with peer for round.
+func (s *DHStore) Delete(peer, round int) error {
+	k := dhKey{peer, round}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[k]
+	if !ok {
+		return nil
+	}
+	eraseDHParams(&sess.Params)
+	delete(s.sessions, k)
+	return s.save()
+}
+
+// EndRound erases the sessions of round, with every peer, and returns how
+// many there were. The standing sessions (round 0) are never ended.
+func (s *DHStore) EndRound(round int) (int, error) {
+	if round == 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eraseLocked(func(sess *DHSession) bool { return sess.Round == round })
+}
+
+// Expire erases the sessions that have expired at now, and those of the
+// rounds reported over by s.RoundOver, and returns how many there were.
+func (s *DHStore) Expire(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.eraseLocked(func(sess *DHSession) bool {
+		if !now.Before(sess.Expires) {
+			return true
+		}
+		return sess.Round != 0 && s.RoundOver != nil && s.RoundOver(sess.Round)
+	})
+}
+
+// eraseLocked erases the sessions matching drop and saves the store if there
+// were any. s.mu must be held.
+func (s *DHStore) eraseLocked(drop func(sess *DHSession) bool) (int, error) {
+	n := 0
+	for k, sess := range s.sessions {
+		if drop(sess) {
+			eraseDHParams(&sess.Params)
+			delete(s.sessions, k)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.save()
+}
+
+// ExpireEvery calls Expire every interval. It never returns.
+func (s *DHStore) ExpireEvery(interval time.Duration, logf func(format string, args ...interface{})) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if n, err := s.Expire(now); err != nil {
+			logf("Failed to persist expired DH sessions: %v", err)
+		} else if n > 0 {
+			logf("%d expired DH sessions erased", n)
+		}
+	}
+}
+
+// EndRound erases the DH sessions of round once the node's part in it is over:
+// after the auction for the auctioneer, after its draw or refund for a bidder.
+// The validator erases its own when the round is settled or expires (see
+// roundOver).
+func (n *Node) EndRound(round int) {
+	k, err := n.DH.EndRound(round)
+	if err != nil {
+		n.logger.Error().Err(err).Msgf("%s[Node %d] [Diffie-Hellman] Failed to persist the end of round %d\033[0m", getNodeColor(n.ID), n.ID, round)
+	} else if k > 0 {
+		n.logger.Info().Msgf("%s[Node %d] [Diffie-Hellman] %d sessions of round %d erased\033[0m", getNodeColor(n.ID), n.ID, k, round)
+	}
+}
+
+// roundOver reports whether the validator needs no more DH keys for round: its
+// settlement was accepted, or it ended without one. The seller's keys are only
+// used to check the settlement.
+func roundOver(round int) bool {
+	r, err := Rounds.Get(round)
+	if err != nil {
+		return false
+	}
+	switch r.State() {
+	case zn.RoundSettled, zn.RoundExpired, zn.RoundDisputed:
+		return true
+	}
+	return false
+}
+
+// save writes the sessions to s.path, through a temporary file renamed over
+// the previous one. It must be called with s.mu held.
+func (s *DHStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	sessions := make([]DHSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, *sess)
+	}
+	var buf bytes.Buffer
+	defer func() { clear(buf.Bytes()) }()
+	if err := gob.NewEncoder(&buf).Encode(sessions); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// eraseDHParams overwrites the secrets of p: the ephemeral scalar and the shared point.
+func eraseDHParams(p *zn.DHParams) {
+	for i := range p.Secret {
+		p.Secret[i] = 0
+	}
+	p.Secret = nil
+	p.SharedSecret = bls12377.G1Affine{}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	zn "zerocash_gnark/zerocash_network"
+)
+
+// testDHParams returns DH parameters whose secret is filled with b.
+func testDHParams(b byte) zn.DHParams {
+	return zn.DHParams{Secret: bytes.Repeat([]byte{b}, 32)}
+}
+
+// erased reports whether secret has been overwritten with zeros.
+func erased(secret []byte) bool {
+	return bytes.Equal(secret, make([]byte, len(secret)))
+}
+
+func TestDHStoreRounds(t *testing.T) {
+	s := NewDHStore(time.Hour)
+	for _, p := range []struct {
+		peer, round int
+		b           byte
+	}{{1, 0, 10}, {1, 7, 17}, {2, 7, 27}, {1, 8, 18}} {
+		if err := s.Put(p.peer, p.round, testDHParams(p.b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		peer, round int
+		b           byte // 0 if there is no session
+	}{
+		{1, 0, 10},
+		{1, 7, 17},
+		{2, 7, 27},
+		{2, 0, 0},
+		{1, 9, 0},
+	}
+	for _, tt := range tests {
+		p, ok := s.Get(tt.peer, tt.round)
+		if ok != (tt.b != 0) || ok && !bytes.Equal(p.Secret, testDHParams(tt.b).Secret) {
+			t.Errorf("session (%d, %d): got %v, %x", tt.peer, tt.round, ok, p.Secret)
+		}
+	}
+
+	// Rotating a session erases the one it replaces.
+	old := s.sessions[dhKey{1, 7}].Params.Secret
+	copied, _ := s.Get(1, 7)
+	if err := s.Put(1, 7, testDHParams(71)); err != nil {
+		t.Fatal(err)
+	}
+	if !erased(old) {
+		t.Fatal("rotated session not erased")
+	}
+	if erased(copied.Secret) {
+		t.Fatal("copy returned by Get erased with the session")
+	}
+
+	// EndRound erases the sessions of the round with every peer, and only them.
+	old = s.sessions[dhKey{2, 7}].Params.Secret
+	if n, err := s.EndRound(7); err != nil || n != 2 {
+		t.Fatalf("EndRound(7) = %d, %v, want 2 sessions", n, err)
+	}
+	if !erased(old) {
+		t.Fatal("ended session not erased")
+	}
+	if _, ok := s.Get(1, 7); ok {
+		t.Fatal("session of an ended round still served")
+	}
+	if _, ok := s.Get(1, 8); !ok {
+		t.Fatal("session of another round ended")
+	}
+	if n, _ := s.EndRound(0); n != 0 {
+		t.Fatal("standing sessions ended")
+	}
+}
+
+func TestDHStoreExpiry(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		at       time.Time
+		over     map[int]bool // rounds reported over
+		remained int
+	}{
+		{"live", now, nil, 3},
+		{"expired", now.Add(2 * time.Hour), nil, 0},
+		{"round over", now, map[int]bool{5: true}, 2},
+		{"standing session never over", now, map[int]bool{0: true}, 3},
+	}
+	for _, tt := range tests {
+		s := NewDHStore(time.Hour)
+		s.RoundOver = func(round int) bool { return tt.over[round] }
+		s.Put(1, 0, testDHParams(1))
+		s.Put(1, 5, testDHParams(2))
+		s.Put(1, 6, testDHParams(3))
+		n, err := s.Expire(tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3-tt.remained || s.Len() != tt.remained {
+			t.Errorf("%s: %d sessions erased, %d left, want %d left", tt.name, n, s.Len(), tt.remained)
+		}
+	}
+
+	// A session past its TTL is not served, even before Expire erases it.
+	s := NewDHStore(0)
+	s.Put(1, 0, testDHParams(1))
+	if _, ok := s.Get(1, 0); ok || s.Len() != 0 {
+		t.Fatal("expired session served")
+	}
+}
+
+func TestDHStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dh", "sessions")
+	s, err := OpenDHStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(1, 0, testDHParams(1))
+	s.Put(1, 4, testDHParams(2))
+	s.Put(2, 4, testDHParams(3))
+	if _, err := s.EndRound(4); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(2, 5, testDHParams(4))
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("session file mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	s, err = OpenDHStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 {
+		t.Fatalf("%d sessions reloaded, want 2", s.Len())
+	}
+	if p, ok := s.Get(2, 5); !ok || !bytes.Equal(p.Secret, testDHParams(4).Secret) {
+		t.Fatal("session not reloaded")
+	}
+	if _, ok := s.Get(1, 4); ok {
+		t.Fatal("ended session reloaded")
+	}
+
+	// Sessions that expired while the node was down are not loaded.
+	expired, err := OpenDHStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.Put(3, 0, testDHParams(5))
+	s, err = OpenDHStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get(3, 0); ok || len(s.sessions) != 2 {
+		t.Fatalf("expired session loaded (%d sessions)", len(s.sessions))
+	}
+}
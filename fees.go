@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	zg "zerocash_gnark/zerocash_gnark"
+	zn "zerocash_gnark/zerocash_network"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+)
+
+var (
+	// ErrBadFee is returned for a transaction fee that is negative or does
+	// not fit in 64 bits.
+	ErrBadFee = errors.New("invalid transaction fee")
+	// ErrBadFeeNote is returned for a block whose fee note is not proved to
+	// hold the fees of its entries.
+	ErrBadFeeNote = errors.New("invalid block fee note")
+)
+
+// FeeCollector mints the note collecting the fees of a block proposed by a
+// validator.
+type FeeCollector interface {
+	// CollectFees returns the commitment of a new note of fees coins owned
+	// by the validator and the proof, for the "fee" circuit, that it holds
+	// fees coins.
+	CollectFees(fees uint64) (cm, proof []byte, err error)
+}
+
+// txFee returns the fee proved by a transaction, nil meaning no fee.
+func txFee(fee *big.Int) (uint64, error) {
+	if fee == nil {
+		return 0, nil
+	}
+	if !fee.IsUint64() {
+		return 0, ErrBadFee
+	}
+	return fee.Uint64(), nil
+}
+
+// checkFeeNote checks that feeProof proves that the fee note of h holds the
+// fees of the block. A block without a fee note burns its fees.
+func checkFeeNote(h zn.BlockHeader, feeProof []byte) error {
+	if len(h.FeeCm) == 0 {
+		return nil
+	}
+	if globalVKFee == nil {
+		return errors.New("fee verifying key not loaded")
+	}
+	if !zg.ValidateFeeNote(feeProof, new(big.Int).SetUint64(h.Fees), h.FeeCm, globalVKFee) {
+		return ErrBadFeeNote
+	}
+	return nil
+}
+
+// CollectFees implements FeeCollector with a note owned by the node's fee key.
+// The node keeps the opening of the note; it can spend it once the block is
+// committed.
+func (n *Node) CollectFees(fees uint64) (cm, proof []byte, err error) {
+	if globalPKFee == nil {
+		return nil, nil, errors.New("fee proving key not loaded")
+	}
+	note := GenerateNote(zg.Gamma{Coins: new(big.Int).SetUint64(fees), Energy: big.NewInt(0)},
+		GeneratePk(n.FeeSk), GenerateSk(), GenerateSk())
+
+	ip := zg.InputFeeNote{
+		Fees: note.Value.Coins,
+		Cm:   note.Cm,
+		Rho:  new(big.Int).SetBytes(note.Rho),
+		Rand: new(big.Int).SetBytes(note.Rand),
+	}
+	c, _ := ip.BuildWitness()
+	w, err := frontend.NewWitness(c, ecc.BW6_761.ScalarField())
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := groth16.Prove(globalCCSFee, globalPKFee, w)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		return nil, nil, err
+	}
+
+	n.feeMu.Lock()
+	n.feeNotes = append(n.feeNotes, note)
+	n.feeMu.Unlock()
+	return note.Cm, buf.Bytes(), nil
+}
+
+// FeeNotes returns the fee notes minted by the node.
+func (n *Node) FeeNotes() []zg.Note {
+	n.feeMu.Lock()
+	defer n.feeMu.Unlock()
+	return append([]zg.Note(nil), n.feeNotes...)
+}
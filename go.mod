@@ -6,6 +6,7 @@ require (
 	github.com/HamzaZF/Network-Zerocash v0.0.0-20250212154641-c9547d75697a
 	github.com/consensys/gnark v0.12.0
 	github.com/consensys/gnark-crypto v0.16.0
+	github.com/manifoldco/promptui v0.9.0
 	github.com/rs/zerolog v1.33.0
 )
 
@@ -17,7 +18,6 @@ require (
 	github.com/fxamacker/cbor/v2 v2.7.0 // indirect
 	github.com/google/pprof v0.0.0-20250208200701-d0013a598941 // indirect
 	github.com/ingonyama-zk/icicle/v3 v3.4.0 // indirect
-	github.com/manifoldco/promptui v0.9.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mmcloughlin/addchain v0.4.0 // indirect
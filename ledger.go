@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	zg "zerocash_gnark/zerocash_gnark"
+	zn "zerocash_gnark/zerocash_network"
+)
+
+// ErrCorruptLedger is returned when a checksummed ledger record cannot be
+// decoded, or when a damaged record is followed by valid ones.
+var ErrCorruptLedger = errors.New("corrupt ledger record")
+
+// ErrNoteLocked is returned for an entry spending a settlement output whose
+// challenge window is still open.
+var ErrNoteLocked = errors.New("note is locked until the end of the challenge window")
+
+// ErrExpired is returned for a transaction whose expiry height is below the
+// height of the next block.
+var ErrExpired = errors.New("transaction expired")
+
+// LedgerEntry is one atomic change of the validator state. Handlers build one
+// entry per accepted transaction and append it with Ledger.Append, which logs
+// it and admits it to the mempool until it is committed in a block; on replay,
+// its round event is applied to Rounds. An entry with a Seal only records
+// that the entries Sealed were committed in a block with that header and fee
+// note proof (see Ledger.Seal and Ledger.CommitBlock), and an entry with
+// Evicted that these entries left the mempool uncommitted.
+type LedgerEntry struct {
+	Sns [][]byte // nullifiers published
+	Cms [][]byte // commitments added
+	// Spent holds the commitments of the notes spent, when the proof makes
+	// them public; Append rejects the entry if one of them is locked.
+	Spent [][]byte
+	// LockedUntil, if set, locks the notes Cms until then: the outputs of a
+	// settlement cannot be spent while the settlement can still be reverted.
+	LockedUntil time.Time
+	// RevokedSns and RevokedCms undo a settlement reverted on challenge.
+	RevokedSns [][]byte
+	RevokedCms [][]byte
+	Tx         *zg.TxResult
+	TxOneCoin  *zg.TxResultDefaultOneCoin
+	Round      *RoundEvent
+	Fee        uint64    // paid to the validator (public input of the proof); orders the mempool
+	Expiry     uint64    // last height of a block that may include the entry (public input of the proof)
+	Admitted   time.Time // set by Append; the entry expires TTL later
+	// Proofs are the proofs the admitting validator checked, with their
+	// public inputs, so that the other validators can check them again (see
+	// verifyEntry).
+	Proofs   []*zg.ProofBundle
+	Seal     *zn.BlockHeader
+	Sealed   [][]byte // IDs of the entries of the Seal block, in order
+	FeeProof []byte   // proof of the fee note of the Seal block
+	Evicted  [][]byte // IDs of the entries evicted from the mempool
+}
+
+// RoundEventKind identifies a change of an auction round.
+type RoundEventKind int
+
+const (
+	RoundEventOpened RoundEventKind = iota
+	RoundEventBid
+	RoundEventSeller
+	RoundEventSettled
+	RoundEventDisputed
+	RoundEventRefunded
+	RoundEventDrawn
+)
+
+// RoundEvent records a change of the round ID, so that Rounds can be rebuilt
+// from the ledger. At is the time the change was accepted.
+type RoundEvent struct {
+	Kind RoundEventKind
+	ID   int
+	At   time.Time
+
+	// RoundEventOpened
+	Deadline        time.Time
+	SettleBy        time.Time
+	ChallengeWindow time.Duration
+
+	// RoundEventBid, RoundEventSeller
+	Tx   zn.Transaction
+	Aux  zn.AuxList
+	Info zn.InfoBid
+	Cm   []byte
+
+	// RoundEventSettled
+	Settlement zn.TxSettlePayload
+
+	// RoundEventRefunded, RoundEventDrawn
+	CmIn []byte
+}
+
+// Ledger is the validator state together with its write-ahead log, its
+// mempool and the blocks committed from it. The embedded NoteStore is the
+// committed state, which block headers commit to; an appended entry waits in
+// the mempool until it is committed in a block. HasNullifier also reports
+// pending spends, while HasCommitment only reports committed notes.
+//
+// Each entry is written as a record [length uint32][crc32 uint32][gob
+// payload] and fsync'd before it is applied; the ID of an entry is the
+// SHA-256 of its payload. OpenLedger replays the log; a torn record at the end
+// of the file (crash during a write) is truncated away, but a damaged record
+// followed by valid ones fails with ErrCorruptLedger.
+type Ledger struct {
+	*NoteStore
+	mu     sync.Mutex // serializes changes
+	f      *os.File   // nil for an in-memory ledger
+	blocks []zn.Block
+	pool   *Mempool
+	known  map[string]bool      // IDs of the pending and committed entries
+	locked map[string]time.Time // commitments that cannot be spent before then
+
+	// OnAppend, if set, is called with the payload of each entry accepted by
+	// Append (not by AppendEncoded), e.g. to relay it to the other validators.
+	OnAppend func(payload []byte)
+
+	// Fees, if set, collects the fees of the blocks sealed or proposed by
+	// this ledger; otherwise they are burned.
+	Fees FeeCollector
+
+	// Heartbeat, if set, is the age of the last block past which an empty
+	// block is sealed or proposed, so that the chain time (see Now) keeps up
+	// with the wall clock when no transaction comes in.
+	Heartbeat time.Duration
+
+	// Verify, if set, checks the entries this ledger did not admit itself:
+	// those relayed by another validator (AppendEncoded) and those of the
+	// blocks it is asked to vote for (CheckBlock).
+	Verify func(e LedgerEntry) error
+
+	rounds *RoundRegistry // replays the round events of the log; nil for a replica
+}
+
+// pendingEntry is an entry of the mempool or of a proposed block.
+type pendingEntry struct {
+	id      []byte
+	entry   LedgerEntry
+	payload []byte
+}
+
+func newLedger(f *os.File) *Ledger {
+	return &Ledger{
+		NoteStore: NewNoteStore(),
+		f:         f,
+		pool:      NewMempool(),
+		known:     make(map[string]bool),
+		locked:    make(map[string]time.Time),
+	}
+}
+
+// NewMemoryLedger returns a ledger that is not persisted.
+func NewMemoryLedger() *Ledger {
+	return newLedger(nil)
+}
+
+// LedgerDB is the ledger of the validator; main replaces it with a persistent
+// one unless -ledger is empty.
+var LedgerDB = NewMemoryLedger()
+
+// recordHeaderSize is the size of the length and checksum prefix of a record.
+const recordHeaderSize = 8
+
+// OpenLedger opens (or creates) the ledger log at path and replays it into
+// the validator state and Rounds.
+func OpenLedger(path string) (*Ledger, error) {
+	return openLedger(path, Rounds)
+}
+
+// OpenReplicaLedger is OpenLedger for a validator that only replays the
+// entries admitted by another one: their round events are not applied,
+// since Rounds is the state of the admitting validator.
+func OpenReplicaLedger(path string) (*Ledger, error) {
+	return openLedger(path, nil)
+}
+
+func openLedger(path string, rounds *RoundRegistry) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := newLedger(f)
+	l.rounds = rounds
+	end, err := l.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// replay applies every complete record of f to the ledger and l.rounds and
+// returns the offset following the last one.
+func (l *Ledger) replay(f *os.File) (int64, error) {
+	r := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			// EOF: clean end; ErrUnexpectedEOF: torn header.
+			return offset, nil
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil || crc32.ChecksumIEEE(payload) != sum {
+			// Torn or partially written payload: the record was never
+			// acknowledged, unless valid records follow it.
+			torn, err := tornTail(f, offset)
+			if err != nil {
+				return offset, err
+			}
+			if !torn {
+				return offset, fmt.Errorf("%w at offset %d: valid records follow a damaged record", ErrCorruptLedger, offset)
+			}
+			return offset, nil
+		}
+		var e LedgerEntry
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&e); err != nil {
+			return offset, fmt.Errorf("%w at offset %d: %v", ErrCorruptLedger, offset, err)
+		}
+		if e.Seal != nil {
+			if err := l.replaySeal(*e.Seal, e.Sealed, e.FeeProof); err != nil {
+				return offset, fmt.Errorf("%w at offset %d: %v", ErrCorruptLedger, offset, err)
+			}
+			offset += recordHeaderSize + int64(size)
+			continue
+		}
+		if e.Evicted != nil {
+			l.evictLocked(e.Evicted)
+			offset += recordHeaderSize + int64(size)
+			continue
+		}
+		l.admitLocked(e, payload)
+		if e.Round != nil && l.rounds != nil {
+			if err := l.rounds.replay(*e.Round); err != nil {
+				return offset, fmt.Errorf("replay round %d at offset %d: %w", e.Round.ID, offset, err)
+			}
+		}
+		offset += recordHeaderSize + int64(size)
+	}
+}
+
+// tornTail reports whether the bytes of f from offset, where replay stopped on
+// a damaged record, are only the remains of an interrupted write: no complete
+// record with a valid checksum starts after offset.
+func tornTail(f *os.File, offset int64) (bool, error) {
+	tail, err := io.ReadAll(io.NewSectionReader(f, offset, math.MaxInt64-offset))
+	if err != nil {
+		return false, err
+	}
+	for i := 1; i+recordHeaderSize < len(tail); i++ {
+		size := int(binary.BigEndian.Uint32(tail[i : i+4]))
+		end := i + recordHeaderSize + size
+		if size == 0 || end < 0 || end > len(tail) {
+			continue
+		}
+		if crc32.ChecksumIEEE(tail[i+recordHeaderSize:end]) == binary.BigEndian.Uint32(tail[i+4:i+8]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Append checks that e has not expired, spends no nullifier spent by a
+// committed or pending entry and no locked note, logs it and admits it to the
+// mempool, atomically: of two concurrent entries spending the same note, the
+// second fails with ErrDoubleSpend. If the mempool is full, the entry paying
+// the lowest fee is evicted for e, or e is rejected with ErrMempoolFull. The round event of e, if any, must be
+// applied by the caller (the AuctionRound methods do so once their apply
+// function returns).
+func (l *Ledger) Append(e LedgerEntry) error {
+	if e.Admitted.IsZero() {
+		e.Admitted = time.Now().UTC()
+	}
+	payload, err := encodeEntry(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	err = l.appendLocked(e, payload)
+	l.mu.Unlock()
+	if err == nil && l.OnAppend != nil {
+		l.OnAppend(payload)
+	}
+	return err
+}
+
+// AppendEncoded appends the entry encoded in payload, as relayed by another
+// validator, once l.Verify accepts it. An entry already known is ignored.
+func (l *Ledger) AppendEncoded(payload []byte) error {
+	e, err := decodeEntry(payload)
+	if err != nil {
+		return err
+	}
+	if l.isKnown(payload) {
+		return nil
+	}
+	// Proofs are checked without holding l.mu.
+	if l.Verify != nil {
+		if err := l.Verify(e); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.known[entryID(payload)] {
+		return nil
+	}
+	return l.appendLocked(e, payload)
+}
+
+// isKnown reports whether the entry encoded in payload is pending or
+// committed.
+func (l *Ledger) isKnown(payload []byte) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.known[entryID(payload)]
+}
+
+func (l *Ledger) appendLocked(e LedgerEntry, payload []byte) error {
+	if e.expired(uint64(len(l.blocks))) {
+		return ErrExpired
+	}
+	if err := l.checkEntry(e); err != nil {
+		return err
+	}
+	if err := l.checkLocksLocked(e, l.nowLocked()); err != nil {
+		return err
+	}
+	victim, err := l.pool.check(pendingEntry{entry: e})
+	if err != nil {
+		return err
+	}
+	if victim != nil {
+		if err := l.logEvictionLocked([][]byte{victim}); err != nil {
+			return err
+		}
+	}
+	if err := l.writeLocked(payload); err != nil {
+		return err
+	}
+	l.admitLocked(e, payload)
+	return nil
+}
+
+// checkLocksLocked returns ErrNoteLocked if e spends a note locked at now.
+// Expired locks are dropped. l.mu must be held.
+func (l *Ledger) checkLocksLocked(e LedgerEntry, now time.Time) error {
+	for _, cm := range e.Spent {
+		until, ok := l.locked[string(cm)]
+		if !ok {
+			continue
+		}
+		if now.Before(until) {
+			return ErrNoteLocked
+		}
+		delete(l.locked, string(cm))
+	}
+	return nil
+}
+
+// Now returns the chain time: the timestamp of the last committed block, set
+// by the validator that proposed it and agreed on with the block. Round
+// deadlines and note locks are checked against it rather than against the
+// local clock, so that every validator reaches the same decisions. It is the
+// zero time before the first block.
+func (l *Ledger) Now() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nowLocked()
+}
+
+// nowLocked is Now with l.mu held.
+func (l *Ledger) nowLocked() time.Time {
+	if n := len(l.blocks); n > 0 {
+		return l.blocks[n-1].Header.Timestamp
+	}
+	return time.Time{}
+}
+
+// HeartbeatDue reports whether an empty block is due (see Heartbeat).
+func (l *Ledger) HeartbeatDue() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.heartbeatDueLocked(time.Now())
+}
+
+// heartbeatDueLocked is HeartbeatDue at now. l.mu must be held.
+func (l *Ledger) heartbeatDueLocked(now time.Time) bool {
+	return l.Heartbeat > 0 && now.Sub(l.nowLocked()) >= l.Heartbeat
+}
+
+// TxContext returns the context in which the validator accepts transactions:
+// the configured chain and the height of the next block.
+func (l *Ledger) TxContext() zg.TxContext {
+	return zg.TxContext{ChainID: ChainID, Height: l.Height()}
+}
+
+// expired reports whether e can no longer be included in the block at height.
+// Entries carrying a round event never expire: the round state already
+// reflects them.
+func (e LedgerEntry) expired(height uint64) bool {
+	return e.Round == nil && e.Expiry < height
+}
+
+// HasNullifier reports whether sn is spent by a committed or pending entry.
+func (l *Ledger) HasNullifier(sn []byte) bool {
+	return l.NoteStore.HasNullifier(sn) || l.pool.HasNullifier(sn)
+}
+
+// HasPending reports whether entries wait in the mempool.
+func (l *Ledger) HasPending() bool {
+	return l.pool.Len() > 0
+}
+
+// EvictExpired evicts the mempool entries older than its TTL or past their
+// expiry height and returns their number.
+func (l *Ledger) EvictExpired(now time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := l.pool.expired(now, uint64(len(l.blocks)))
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return len(ids), l.logEvictionLocked(ids)
+}
+
+// logEvictionLocked logs that the entries ids leave the mempool and evicts
+// them. l.mu must be held.
+func (l *Ledger) logEvictionLocked(ids [][]byte) error {
+	payload, err := encodeEntry(LedgerEntry{Evicted: ids})
+	if err != nil {
+		return err
+	}
+	if err := l.writeLocked(payload); err != nil {
+		return err
+	}
+	l.evictLocked(ids)
+	return nil
+}
+
+// evictLocked removes the entries ids from the mempool; they may be admitted
+// again. l.mu must be held.
+func (l *Ledger) evictLocked(ids [][]byte) {
+	l.pool.remove(ids)
+	for _, id := range ids {
+		delete(l.known, string(id))
+	}
+}
+
+func encodeEntry(e LedgerEntry) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(e); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+func decodeEntry(payload []byte) (LedgerEntry, error) {
+	var e LedgerEntry
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&e)
+	return e, err
+}
+
+// entryID returns the ID of the entry encoded in payload, as a map key.
+func entryID(payload []byte) string {
+	id := sha256.Sum256(payload)
+	return string(id[:])
+}
+
+// writeLocked logs payload, if the ledger is persistent. l.mu must be held.
+func (l *Ledger) writeLocked(payload []byte) error {
+	if l.f == nil {
+		return nil
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	if _, err := l.f.Write(record); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// admitLocked adds e to the mempool. l.mu must be held (or the ledger not yet
+// shared, during replay).
+func (l *Ledger) admitLocked(e LedgerEntry, payload []byte) {
+	id := entryID(payload)
+	l.known[id] = true
+	if !e.LockedUntil.IsZero() {
+		for _, cm := range e.Cms {
+			l.locked[string(cm)] = e.LockedUntil
+		}
+	}
+	l.pool.add(pendingEntry{id: []byte(id), entry: e, payload: payload})
+}
+
+// Close closes the ledger log.
+func (l *Ledger) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
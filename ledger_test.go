@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestLedger logs three entries, the first two committed in a block, to
+// a new ledger and returns its path and the offsets at which its records end.
+func writeTestLedger(t *testing.T) (string, []int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ledger.log")
+	l, err := OpenReplicaLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range []LedgerEntry{
+		{Sns: [][]byte{[]byte("sn1")}, Cms: [][]byte{[]byte("cm1")}, Expiry: 10},
+		{Sns: [][]byte{[]byte("sn2")}, Cms: [][]byte{[]byte("cm2")}, Expiry: 10},
+	} {
+		if err := l.Append(e); err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+	}
+	if _, err := l.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(LedgerEntry{Sns: [][]byte{[]byte("sn3")}, Cms: [][]byte{[]byte("cm3")}, Expiry: 10}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ends []int
+	for off := 0; off < len(data); {
+		off += recordHeaderSize + int(binary.BigEndian.Uint32(data[off:off+4]))
+		ends = append(ends, off)
+	}
+	return path, ends
+}
+
+func TestOpenLedgerReplay(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the log, whose records end at ends, and returns
+		// the size the log must be truncated to on replay.
+		damage  func(data []byte, ends []int) ([]byte, int)
+		pending int // entries back in the mempool
+		err     error
+	}{
+		{"intact", func(data []byte, ends []int) ([]byte, int) {
+			return data, len(data)
+		}, 1, nil},
+		{"torn header", func(data []byte, ends []int) ([]byte, int) {
+			return append(data, 0, 0, 1), len(data)
+		}, 1, nil},
+		{"torn payload", func(data []byte, ends []int) ([]byte, int) {
+			last := ends[len(ends)-2]
+			return data[:last+recordHeaderSize+3], last
+		}, 0, nil},
+		{"damaged last record", func(data []byte, ends []int) ([]byte, int) {
+			data[len(data)-1] ^= 1
+			return data, ends[len(ends)-2]
+		}, 0, nil},
+		{"damaged record before valid ones", func(data []byte, ends []int) ([]byte, int) {
+			data[ends[0]-1] ^= 1
+			return data, len(data)
+		}, 0, ErrCorruptLedger},
+	}
+	for _, tt := range tests {
+		path, ends := writeTestLedger(t)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, size := tt.damage(data, ends)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := OpenReplicaLedger(path)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if l.Height() != 1 || !l.HasCommitment([]byte("cm2")) || !l.HasNullifier([]byte("sn1")) {
+			t.Errorf("%s: committed block not replayed", tt.name)
+		}
+		if l.pool.Len() != tt.pending || l.HasNullifier([]byte("sn3")) != (tt.pending == 1) {
+			t.Errorf("%s: %d pending entries, want %d", tt.name, l.pool.Len(), tt.pending)
+		}
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if fi.Size() != int64(size) {
+			t.Errorf("%s: log truncated to %d bytes, want %d", tt.name, fi.Size(), size)
+		}
+
+		// The log accepts new records after the truncation point.
+		if err := l.Append(LedgerEntry{Sns: [][]byte{[]byte("sn4")}, Expiry: 10}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		l.Close()
+		if l, err = OpenReplicaLedger(path); err != nil {
+			t.Fatalf("%s: reopen: %v", tt.name, err)
+		}
+		if !l.HasNullifier([]byte("sn4")) {
+			t.Errorf("%s: entry appended after replay lost", tt.name)
+		}
+		l.Close()
+	}
+}
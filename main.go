@@ -2,13 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 	zg "zerocash_gnark/zerocash_gnark"
@@ -66,20 +73,45 @@ func getNodeColor(id int) string {
 // Node structure and its fields
 // -------------------------------
 type Node struct {
-	ID          int // Unique identifier for the node
-	Port        int
-	Address     string
-	logger      zerolog.Logger
-	G           bls12377.G1Affine     // Common G (same for all nodes)
-	DHExchanges map[int]*zn.DHParams  // Stores the DH exchange for each peer (key = peer's ID)
-	DHHandler   *DiffieHellmanHandler // Dedicated handler for DH exchanges (verifier role)
+	ID        int // Unique identifier for the node
+	Port      int
+	Address   string
+	Identity  *zn.Identity // identité longue durée, authentifie les connexions
+	RPC       *zn.Client   // appels requête/réponse vers les autres nœuds, sous Identity
+	logger    zerolog.Logger
+	G         bls12377.G1Affine     // Common G (same for all nodes)
+	DH        *DHStore              // Current DH session with each peer (key = peer's ID)
+	DHHandler *DiffieHellmanHandler // Dedicated handler for DH exchanges (verifier role)
 	//TxHandler      *TransactionHandler   // Dedicated handler for transactions
 	TxHandler TxHandlerInterface
 	//TxDefaultOneCoinHandler *TransactionDefaultOneCoinHandler
-	DHRequestHandler  *DHRequestHandler
-	RegisterHandler   *RegisterHandler
-	AuctionHandler    *AuctionHandler
-	TxDrawCoinHandler *TxDrawCoinHandler
+	DHRequestHandler      *DHRequestHandler
+	RegisterHandler       *RegisterHandler
+	AuctionHandler        *AuctionHandler
+	TxDrawCoinHandler     *TxDrawCoinHandler
+	SellerRegisterHandler *SellerRegisterHandler
+	RoundHandler          *RoundHandler
+	RefundHandler         *RefundHandler
+	CommitteeHandler      *CommitteeHandler
+	ChallengeHandler      *ChallengeHandler
+	BlockHandler          *BlockHandler
+	PeerHandler           *PeerHandler
+	Router                *zn.Router   // aiguille les messages reçus vers les handlers ci-dessus
+	Peers                 *PeerTable   // nœuds connus, lui compris (cf. ExchangePeers)
+	Replica               *Replica     // nil si le nœud ne participe pas au consensus
+	Headers               *HeaderChain // en-têtes vérifiés (client léger)
+	Committee             *Committee   // nil tant que JoinCommittee n'a pas été appelé
+	// Auctioneers liste les nœuds de rôle auctioneer, seuls autorisés à
+	// ouvrir des rounds (cf. authorize).
+	Auctioneers []int
+	// Registrations conserve, par round, l'entrée de la preuve d'enregistrement
+	// du bidder, rejouée par SendChallenge.
+	Registrations map[int]zg.TxProverInputHighLevelRegister
+	// FeeSk est la clé du validateur propriétaire des notes de frais qu'il
+	// crée pour les blocs qu'il propose (cf. CollectFees).
+	FeeSk    []byte
+	feeMu    sync.Mutex
+	feeNotes []zg.Note
 }
 
 // Draw simule un retrait (withdraw) pour ce noeud (non-validateur).
@@ -125,28 +157,125 @@ func NewNode(port int, id int, commonG bls12377.G1Affine, isValidator bool) *Nod
 	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
 
 	address := "127.0.0.1:" + strconv.Itoa(port)
+	identity, err := zn.NewIdentity(id)
+	if err != nil {
+		logger.Fatal().Err(err).Msgf("[Node %d] Failed to generate the node identity", id)
+	}
+	dhStore := NewDHStore(DHSessionTTL)
+	if DHStoreDir != "" {
+		path := filepath.Join(DHStoreDir, fmt.Sprintf("node%d.dh", id))
+		if dhStore, err = OpenDHStore(path, DHSessionTTL); err != nil {
+			logger.Fatal().Err(err).Msgf("[Node %d] Failed to open the DH session store", id)
+		}
+	}
 	node := &Node{
-		ID:          id,
-		Port:        port,
-		Address:     address,
-		logger:      logger,
-		G:           commonG,
-		DHExchanges: make(map[int]*zn.DHParams),
+		ID:       id,
+		Port:     port,
+		Address:  address,
+		Identity: identity,
+		RPC:      newRPCClient(identity),
+		logger:   logger,
+		G:        commonG,
+		DH:       dhStore,
+
+		Registrations: make(map[int]zg.TxProverInputHighLevelRegister),
+		Headers:       &HeaderChain{},
+		FeeSk:         GenerateSk(),
 	}
 	node.DHHandler = NewDiffieHellmanHandler(node)
 	node.DHRequestHandler = NewDHRequestHandler(node)
 	node.RegisterHandler = NewRegisterHandler(node)
 	node.AuctionHandler = NewAuctionHandler(node)
 	node.TxDrawCoinHandler = NewTxDrawCoinHandler(node)
+	node.SellerRegisterHandler = NewSellerRegisterHandler(node)
+	node.RoundHandler = NewRoundHandler(node)
+	node.RefundHandler = NewRefundHandler(node)
+	node.CommitteeHandler = NewCommitteeHandler(node)
+	node.ChallengeHandler = NewChallengeHandler(node)
+	node.BlockHandler = NewBlockHandler(node)
+	node.PeerHandler = NewPeerHandler(node)
+	role := RoleParticipant
+	if isValidator {
+		role = RoleValidator
+	}
+	node.Peers = NewPeerTable(selfInfo(identity, address, role))
 	//node.TxHandler = NewTransactionHandler(node)
 	if isValidator {
+		node.DH.RoundOver = roundOver
 		node.TxHandler = NewTransactionValidatorHandler(node)
 	} else {
 		node.TxHandler = NewTransactionHandler(node)
 	}
+	node.Router = node.routes()
 	return node
 }
 
+// routes returns the router of the node: every message type it serves, behind
+// panic recovery, logging, rate limiting and sender authorization (see
+// authorize). A new message type only needs a route here. Requests the node
+// cannot serve are answered with a structured error (see zn.ReplyError).
+func (n *Node) routes() *zn.Router {
+	r := zn.NewRouter()
+	r.Use(
+		zn.Recover(func(msg zn.Message, conn net.Conn, v interface{}) {
+			n.logger.Error().Msgf("%s[Node %d] [Router] Handler for %s panicked: %v\n%s\033[0m", getNodeColor(n.ID), n.ID, msg.Type, v, debug.Stack())
+			zn.ReplyError(conn, msg, zn.CodeInternal, "handler for %s failed", msg.Type)
+		}),
+		zn.Logging(func(msg zn.Message, conn net.Conn) {
+			n.logger.Info().Msgf("%s[Node %d] [Message] %s received\033[0m", getNodeColor(n.ID), n.ID, msg.Type)
+		}),
+		zn.RateLimit(MessageRate, MessageBurst, func(msg zn.Message, conn net.Conn) {
+			n.logger.Warn().Msgf("%s[Node %d] [Router] Rate limit exceeded by %s, %s dropped\033[0m", getNodeColor(n.ID), n.ID, conn.RemoteAddr(), msg.Type)
+			zn.ReplyError(conn, msg, zn.CodeRateLimited, "too many messages")
+		}),
+		zn.Authorize(n.authorize, func(msg zn.Message, conn net.Conn, err error) {
+			peer, _ := zn.PeerID(conn)
+			n.logger.Warn().Err(err).Msgf("%s[Node %d] [Connection] %s from node %d refused\033[0m", getNodeColor(n.ID), n.ID, msg.Type, peer)
+			zn.ReplyError(conn, msg, zn.CodeUnauthorized, "%v", err)
+		}),
+	)
+	r.NotFound(zn.HandlerFunc(func(msg zn.Message, conn net.Conn) {
+		n.logger.Warn().Msgf("%s[Node %d] [Router] No handler for %q\033[0m", getNodeColor(n.ID), n.ID, msg.Type)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "no handler for %q", msg.Type)
+	}))
+	r.Handle("DiffieHellman", n.DHHandler)
+	r.Handle("tx", n.TxHandler)
+	r.Handle("dh_request", n.DHRequestHandler)
+	r.Handle("register", n.RegisterHandler)
+	r.Handle("register_seller", n.SellerRegisterHandler)
+	r.Handle("round_open", n.RoundHandler)
+	r.Handle("round_get", n.RoundHandler)
+	r.Handle("refund", n.RefundHandler)
+	r.Handle("dkg_deal", n.CommitteeHandler)
+	r.Handle("dkg_echo", n.CommitteeHandler)
+	r.Handle("dkg_justify", n.CommitteeHandler)
+	r.Handle("decrypt_request", n.CommitteeHandler)
+	r.Handle("challenge", n.ChallengeHandler)
+	r.Handle("auction", n.AuctionHandler)
+	r.Handle("tx_draw_one_coin", n.TxDrawCoinHandler)
+	r.Handle("block_get", n.BlockHandler)
+	r.Handle(zn.PeersGetMsg, n.PeerHandler)
+	for _, t := range []string{"bft_proposal", "bft_vote", "bft_entry"} {
+		r.HandleFunc(t, func(msg zn.Message, conn net.Conn) {
+			if n.Replica == nil {
+				n.logger.Warn().Msgf("%s[Node %d] [BFT] Not a validator, %s ignored\033[0m", getNodeColor(n.ID), n.ID, msg.Type)
+				zn.ReplyError(conn, msg, zn.CodeRefused, "node %d is not a validator", n.ID)
+				return
+			}
+			n.Replica.HandleMessage(msg, conn)
+		})
+	}
+	return r
+}
+
+// newRPCClient returns the client a node calls its peers with, bounded by
+// RPCTimeout.
+func newRPCClient(identity *zn.Identity) *zn.Client {
+	c := zn.NewClient(identity)
+	c.Timeout = RPCTimeout
+	return c
+}
+
 // -------------------------------
 // Node methods
 // -------------------------------
@@ -160,6 +289,9 @@ func (n *Node) Run(wg *sync.WaitGroup) {
 		return
 	}
 	n.logger.Info().Msgf("%s[Node %d] [TCP Server] Server started on %s\033[0m", getNodeColor(n.ID), n.ID, n.Address)
+	go n.DH.ExpireEvery(time.Minute, func(format string, args ...interface{}) {
+		n.logger.Info().Msgf("%s[Node %d] [Diffie-Hellman] %s\033[0m", getNodeColor(n.ID), n.ID, fmt.Sprintf(format, args...))
+	})
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -170,9 +302,15 @@ func (n *Node) Run(wg *sync.WaitGroup) {
 	}
 }
 
-// handleConnection continuously receives gob messages and dispatches them to the appropriate handler.
-func (n *Node) handleConnection(conn net.Conn) {
-	defer conn.Close()
+// handleConnection negotiates the protocol version and authenticates the peer,
+// then continuously receives encrypted messages and hands them to n.Router.
+func (n *Node) handleConnection(raw net.Conn) {
+	defer raw.Close()
+	conn, err := n.Identity.Accept(raw)
+	if err != nil {
+		n.logger.Warn().Err(err).Msgf("%s[Node %d] [Connection] Handshake with %s failed\033[0m", getNodeColor(n.ID), n.ID, raw.RemoteAddr())
+		return
+	}
 	for {
 		var msg zn.Message
 		err := zn.ReceiveMessage(conn, &msg)
@@ -180,36 +318,61 @@ func (n *Node) handleConnection(conn net.Conn) {
 			if err == io.EOF {
 				n.logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Connection] Client closed connection (EOF)\033[0m", getNodeColor(n.ID), n.ID))
 			} else {
-				n.logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Connection] Error receiving message via gob\033[0m", getNodeColor(n.ID), n.ID))
+				n.logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Connection] Error receiving message\033[0m", getNodeColor(n.ID), n.ID))
 			}
 			return
 		}
-		//n.logger.Info().Msgf("Message reçu : %+v", msg)
-		n.logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Message] Message received\033[0m", getNodeColor(n.ID), n.ID))
-		switch msg.Type {
-		case "DiffieHellman":
-			n.DHHandler.HandleMessage(msg, conn)
-		case "tx":
-			n.TxHandler.HandleMessage(msg, conn)
-		//case "txDefaultOneCoin":
-		//	n.TxDefaultOneCoinHandler.HandleMessage(msg, conn)
-		case "dh_request":
-			n.DHRequestHandler.HandleMessage(msg, conn)
-		case "register":
-			n.RegisterHandler.HandleMessage(msg, conn)
-		case "auction":
-			n.AuctionHandler.HandleMessage(msg, conn)
-		case "tx_draw_one_coin":
-			n.TxDrawCoinHandler.HandleMessage(msg, conn)
-		default:
-			zn.RouteMessage(msg, conn)
+		n.Router.HandleMessage(msg, conn)
+	}
+}
+
+// authorize checks that peer, the authenticated sender of msg, may send it:
+// consensus messages must come from a validator, decryption requests from a
+// requester of the committee, round openings from an auctioneer, and messages naming their sender must name
+// peer. Transactions are not checked since the validator forwards them on
+// behalf of their author.
+func (n *Node) authorize(peer int, msg zn.Message) error {
+	claimed := peer
+	switch p := msg.Payload.(type) {
+	case zn.Proposal, zn.Vote, zn.EntryPayload:
+		if n.Replica == nil {
+			return nil
+		}
+		if _, ok := n.Replica.validator(peer); !ok {
+			return fmt.Errorf("node %d is not a validator", peer)
+		}
+	case zn.DHPayload:
+		claimed = p.ID
+	case zn.DecryptRequestPayload:
+		if n.Committee == nil || !n.Committee.IsRequester(peer) {
+			return fmt.Errorf("node %d may not request decryptions", peer)
+		}
+	case zn.RoundOpenPayload:
+		if !slices.Contains(n.Auctioneers, peer) {
+			return fmt.Errorf("node %d is not an auctioneer", peer)
 		}
+	case zn.DKGDealPayload:
+		claimed = p.Dealer
+	case zn.DKGEchoPayload:
+		claimed = p.Echoer
+	case zn.DKGJustifyPayload:
+		claimed = p.Dealer
+	case zn.TxSellerRegister:
+		claimed = p.ID
+	case zn.TxChallenge:
+		claimed = p.ID
+	case zn.TxDrawPayload:
+		claimed = p.ID
+	}
+	if claimed != peer {
+		return fmt.Errorf("message claims to come from node %d", claimed)
 	}
+	return nil
 }
 
 // SendMessage establishes a connection to a target address and sends the message.
 func (n *Node) SendMessage(targetAddress string, msg zn.Message) error {
-	conn, err := net.Dial("tcp", targetAddress)
+	conn, err := n.Identity.Dial(targetAddress)
 	if err != nil {
 		n.logger.Error().Err(err).Msgf("%s[Node %d] [SendMessage] Error dialing %s\033[0m", getNodeColor(n.ID), n.ID, targetAddress)
 		return err
@@ -223,72 +386,83 @@ func (n *Node) SendMessage(targetAddress string, msg zn.Message) error {
 	return nil
 }
 
-// DiffieHellmanKeyExchange executes the key exchange protocol in the initiator role.
-// The node initiates the exchange with a peer at targetAddress.
-// It generates its ephemeral secret r, computes A = G^r, sends A, waits for B = G^b, computes the shared secret S = B^r,
-// then stores the exchange in DHExchanges with the key corresponding to the peer's ID.
+// DiffieHellmanKeyExchange runs RoundKeyExchange for the standing session
+// (round 0) with the peer at targetAddress, used by ordinary transactions and
+// refunds.
 func (n *Node) DiffieHellmanKeyExchange(targetAddress string) error {
+	return n.RoundKeyExchange(targetAddress, 0)
+}
+
+// RoundKeyExchange executes the key exchange protocol in the initiator role.
+// The node initiates the exchange with a peer at targetAddress for the auction
+// round round.
+// It generates its ephemeral secret r, computes A = G^r, sends A, waits for B = G^b, computes the shared secret S = B^r,
+// then makes the exchange the current session with the peer for that round in
+// n.DH, which erases the previous one: running an exchange rotates the keys.
+// A and B are signed with the identity keys of their senders, together with
+// the round, and B must be signed by the authenticated peer over A: any other
+// response is rejected.
+func (n *Node) RoundKeyExchange(targetAddress string, round int) error {
 	var r_bytes [32]byte
 	var shared bls12377.G1Affine
 
-	conn, err := net.Dial("tcp", targetAddress)
+	ctx := context.Background()
+	peer, err := n.RPC.Peer(ctx, targetAddress)
 	if err != nil {
 		n.logger.Error().Err(err).Msgf("%s[Node %d] [Diffie-Hellman] Error dialing %s\033[0m", getNodeColor(n.ID), n.ID, targetAddress)
 		return err
 	}
-	defer conn.Close()
 
 	G := n.G
 
 	// Generate the ephemeral secret r and compute A = G^r.
 	r, _ := zg.GenerateBls12377_frElement()
 	r_bytes = r.Bytes()
+	defer clear(r_bytes[:])
 	A := *new(bls12377.G1Affine).ScalarMultiplication(&G, new(big.Int).SetBytes(r_bytes[:]))
-	// Temporary storage with key -1.
-	n.DHExchanges[-1] = &zn.DHParams{
-		EphemeralPublic: A,
-		Secret:          r_bytes[:],
-	}
 
-	// Send "DH_G_r" containing A.
+	// Send "DH_G_r" containing A, signed for the peer, and wait for the
+	// response "DH_G_b".
 	dhPayload := zn.DHPayload{
-		ID:      n.ID,
+		Peer:    peer,
+		Round:   round,
 		SubType: "DH_G_r",
 		Value:   A,
 	}
-	msg := zn.PackMessage("DiffieHellman", dhPayload)
-	if err := zn.SendMessage(conn, msg); err != nil {
-		n.logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Error sending DH_G_r\033[0m", getNodeColor(n.ID), n.ID))
-		return err
-	}
-	n.logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] DH_G_r sent successfully\033[0m", getNodeColor(n.ID), n.ID))
-
-	// Wait for the response "DH_G_b" from the peer.
-	var response zn.Message
-	if err := zn.ReceiveMessage(conn, &response); err != nil {
-		n.logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Error receiving DH_G_b\033[0m", getNodeColor(n.ID), n.ID))
+	dhPayload.Sign(n.Identity)
+	respPayload, err := zn.Call[zn.DHPayload](ctx, n.RPC, targetAddress, "DiffieHellman", dhPayload)
+	if err != nil {
+		n.logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] DH_G_r/DH_G_b exchange failed\033[0m", getNodeColor(n.ID), n.ID))
 		return err
 	}
-	//n.logger.Info().Msgf("Réponse reçue : %+v", response)
 	n.logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Response received\033[0m", getNodeColor(n.ID), n.ID))
-	respPayload, ok := response.Payload.(zn.DHPayload)
-	if !ok || respPayload.SubType != "DH_G_b" {
+	if respPayload.SubType != "DH_G_b" {
 		n.logger.Error().Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Received payload not conforming to DH_G_b\033[0m", getNodeColor(n.ID), n.ID))
 		return fmt.Errorf("non conforming payload")
 	}
+	err = respPayload.Check(n.Identity, peer)
+	if err == nil && (!respPayload.Partner.Equal(&A) || respPayload.Round != round) {
+		err = fmt.Errorf("DH_G_b answers another exchange")
+	}
+	if err != nil {
+		n.logger.Error().Err(err).Msgf("%s[Node %d] [Diffie-Hellman] Unauthenticated DH_G_b rejected\033[0m", getNodeColor(n.ID), n.ID)
+		return err
+	}
 	// Retrieve B sent by the peer (verifier).
 	B := respPayload.Value
 
 	// Compute the shared secret S = B^r.
 	shared = *new(bls12377.G1Affine).ScalarMultiplication(&B, new(big.Int).SetBytes(r_bytes[:]))
 
-	// Update the map: remove the temporary entry (-1) and use the peer's ID.
-	delete(n.DHExchanges, -1)
-	n.DHExchanges[respPayload.ID] = &zn.DHParams{
+	// Replace the session with the peer; the previous one is erased.
+	if err := n.DH.Put(respPayload.ID, round, zn.DHParams{
 		EphemeralPublic: A,
 		PartnerPublic:   B, // Store the received key B (via respPayload.Value)
 		Secret:          r_bytes[:],
 		SharedSecret:    shared,
+	}); err != nil {
+		n.logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Error storing the session\033[0m", getNodeColor(n.ID), n.ID))
+		return err
 	}
 
 	//n.logger.Info().Msgf("Secret partagé calculé: %+v", shared)
@@ -299,7 +473,7 @@ func (n *Node) DiffieHellmanKeyExchange(targetAddress string) error {
 // SendTransactionDummyImproved sends a dummy transaction for validation.
 func (n *Node) SendTransactionDummyImproved(validatorAddress string, targetAddress string, targetID int, globalCCS constraint.ConstraintSystem, globalPK groth16.ProvingKey, globalVK groth16.VerifyingKey) error {
 
-	conn, err := net.Dial("tcp", validatorAddress)
+	conn, err := n.Identity.Dial(validatorAddress)
 	if err != nil {
 		n.logger.Error().Err(err).Msgf("%s[Node %d] [Transaction] Error dialing %s\033[0m", getNodeColor(n.ID), n.ID, validatorAddress)
 		return err
@@ -346,18 +520,25 @@ func (n *Node) SendTransactionDummyImproved(validatorAddress string, targetAddre
 	pkNew1 := []byte("pkNew1_XXXXXXXXXXXX")
 	pkNew2 := []byte("pkNew2_XXXXXXXXXXXX")
 
+	dh, ok := n.DH.Get(targetID, 0)
+	if !ok {
+		return fmt.Errorf("no DH session with node %d", targetID)
+	}
+
 	// 3) Build TxProverInputHighLevel
 	inp := zg.TxProverInputHighLevel{
 		OldNotes: [2]zg.Note{old1, old2},
 		OldSk:    [2][]byte{skOld1, skOld2},
 		NewVals:  [2]zg.Gamma{new1, new2},
 		NewPk:    [2][]byte{pkNew1, pkNew2},
-		EncKey:   n.DHExchanges[targetID].SharedSecret,
-		R:        n.DHExchanges[targetID].Secret,
+		EncKey:   dh.SharedSecret,
+		R:        dh.Secret,
 		//B:        b_bytes[:],
-		G:   n.G,
-		G_b: n.DHExchanges[targetID].PartnerPublic,
-		G_r: n.DHExchanges[targetID].EphemeralPublic,
+		G:       n.G,
+		G_b:     dh.PartnerPublic,
+		G_r:     dh.EphemeralPublic,
+		ChainID: ChainID,
+		Expiry:  n.TxExpiry(validatorAddress),
 	}
 
 	/*
@@ -670,6 +851,7 @@ func (n *Node) SendTransactionRegisterN(
 	validatorAddress string,
 	targetAddress string,
 	targetID int,
+	roundID int,
 	// Paramètres existants pour les preuves et circuits
 	globalCCSOneCoin constraint.ConstraintSystem,
 	globalPKOneCoin groth16.ProvingKey,
@@ -693,32 +875,53 @@ func (n *Node) SendTransactionRegisterN(
 	kind bool,
 ) error {
 
-	// Établir la connexion TCP
-	conn, err := net.Dial("tcp", validatorAddress)
-	if err != nil {
-		n.logger.Error().Err(err).Msgf("%s[Node %d] [Transaction] Error dialing %s\033[0m", getNodeColor(n.ID), n.ID, validatorAddress)
+	// Chaque round est enregistré sous des clés DH propres au round,
+	// partagées avec la cible jusqu'au tirage (cf. Node.EndRound).
+	if err := n.RoundKeyExchange(targetAddress, roundID); err != nil {
 		return err
 	}
-	defer conn.Close()
+	dh, ok := n.DH.Get(targetID, roundID)
+	if !ok {
+		return fmt.Errorf("no DH session with node %d for round %d", targetID, roundID)
+	}
 
 	//c.PkOut, c.SkIn, c.Bid, c.GammaInCoins, c.GammaInEnergy, c.EncKey)
 
+	// La transaction et la preuve d'enregistrement expirent ensemble.
+	expiry := n.TxExpiry(validatorAddress)
+
 	// Construction de l'input de la preuve pour une transaction one coin
 	inp := zg.TxProverInputHighLevelDefaultOneCoin{
 		OldNote: nBase,
 		OldSk:   skBase,
 		NewVal:  gammaIn,
 		NewPk:   pkIn,
-		EncKey:  n.DHExchanges[targetID].SharedSecret,
-		R:       n.DHExchanges[targetID].Secret,
+		EncKey:  dh.SharedSecret,
+		R:       dh.Secret,
 		// B:      b_bytes[:], // à décommenter et définir si nécessaire
-		G:   n.G,
-		G_b: n.DHExchanges[targetID].PartnerPublic,
-		G_r: n.DHExchanges[targetID].EphemeralPublic,
+		G:       n.G,
+		G_b:     dh.PartnerPublic,
+		G_r:     dh.EphemeralPublic,
+		ChainID: ChainID,
+		Expiry:  expiry,
+	}
+
+	// Le bid est chiffré sous la clé du comité, et non sous la clé DH partagée
+	// avec l'auctioneer : il ne pourra être déchiffré qu'avec Threshold parts.
+	if n.Committee == nil {
+		return ErrCommitteeNotReady
+	}
+	committeeKey, err := n.Committee.PublicKey()
+	if err != nil {
+		return err
+	}
+	regEncKey, regG_r, regR, err := zg.NewCommitteeEncKey(n.G, committeeKey)
+	if err != nil {
+		return err
 	}
 
 	// Appel de la fonction de chiffrement avec les paramètres requis
-	encVal := zg.BuildEncRegMimc(inp.EncKey, gammaIn, pkOut, skIn, bid)
+	encVal := zg.BuildEncRegMimc(regEncKey, gammaIn, pkOut, skIn, bid)
 
 	//decVal, _ := zg.BuildDecRegMimc(inp.EncKey, encVal)
 
@@ -756,12 +959,13 @@ func (n *Node) SendTransactionRegisterN(
 		RhoIn:    big.NewInt(1111).Bytes(),
 		RandIn:   big.NewInt(2222).Bytes(),
 		InVal:    gammaIn,
-		EncKey:   n.DHExchanges[targetID].SharedSecret,
-		R:        n.DHExchanges[targetID].Secret,
-		// B:      b_bytes[:], // à décommenter et définir si nécessaire
-		G:   n.G,
-		G_b: n.DHExchanges[targetID].PartnerPublic,
-		G_r: n.DHExchanges[targetID].EphemeralPublic,
+		EncKey:   regEncKey,
+		R:        regR,
+		G:        n.G,
+		G_b:      committeeKey,
+		G_r:      regG_r,
+		ChainID:  ChainID,
+		Expiry:   expiry,
 	}
 
 	/*
@@ -773,7 +977,7 @@ func (n *Node) SendTransactionRegisterN(
 	randNew := zg.RandBigInt()
 
 	// Construction de la transaction one coin
-	tx := TransactionOneCoin(inp, globalCCSOneCoin, globalPKOneCoin, conn, n.ID, targetAddress, targetID, rhoNew, randNew)
+	tx := TransactionOneCoin(inp, globalCCSOneCoin, globalPKOneCoin, n.ID, targetAddress, targetID, rhoNew, randNew)
 
 	// Génération de la preuve d'enregistrement
 	piReg, pubReg, Ip, err := ProofRegister(inp_reg, globalCCSRegister, globalPKRegister)
@@ -781,6 +985,7 @@ func (n *Node) SendTransactionRegisterN(
 		fmt.Printf("Error generating register proof: %v\n", err)
 		return err
 	}
+	n.Registrations[roundID] = inp_reg
 
 	// Encapsulation de la transaction
 	tx_encapsulated := zn.TxEncapsulated{
@@ -793,21 +998,229 @@ func (n *Node) SendTransactionRegisterN(
 		CmIn:      inp_reg.CmIn,
 		PiReg:     piReg,
 		PubW:      pubReg,
-		Ip:        Ip,
+		Ip:        Ip.Public(), // le bid ne quitte le nœud que chiffré
 		AuxCipher: [5][]byte{pk_enc_bytes, skIn_enc_bytes, bid_enc_bytes, coins_enc_bytes, energy_enc_bytes},
 		EncVal:    encVal, //FALSE, TO REMOVE
 		Kind:      kind,
+		RoundID:   roundID,
 	}
 
-	// Packager et envoyer le message
-	msg := zn.PackMessage("register", txReg)
-	if err := zn.SendMessage(conn, msg); err != nil {
-		fmt.Printf("%s[Node %d] [Transaction] Error sending transaction: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
-		return nil
-	} else {
-		fmt.Printf("%s[Node %d] [Transaction] Transaction sent successfully for validation\033[0m\n", getNodeColor(n.ID), n.ID)
+	// Le validateur répond par l'état du round une fois le bid enregistré.
+	if _, err := zn.Call[zn.RoundInfo](context.Background(), n.RPC, validatorAddress, zn.RegisterMsg, txReg); err != nil {
+		fmt.Printf("%s[Node %d] [Transaction] Registration rejected: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+		return err
+	}
+	fmt.Printf("%s[Node %d] [Transaction] Registration accepted in round %d\033[0m\n", getNodeColor(n.ID), n.ID, roundID)
+
+	return nil
+}
+
+// SendTransactionSellerRegister enregistre le nœud comme vendeur : il verrouille
+// la note d'énergie nIn (via une transaction one coin) et chiffre, sous la clé DH
+// partagée avec targetID, (pkOut, skIn, reserve, coins, energy). Le prix de
+// réserve ne quitte jamais le nœud en clair.
+func (n *Node) SendTransactionSellerRegister(
+	validatorAddress string,
+	targetID int,
+	roundID int,
+	globalCCSOneCoin constraint.ConstraintSystem,
+	globalPKOneCoin groth16.ProvingKey,
+	globalCCSSellerRegister constraint.ConstraintSystem,
+	globalPKSellerRegister groth16.ProvingKey,
+	nBase zg.Note, // note dépensée par la transaction one coin
+	skBase []byte,
+	pkIn []byte,
+	gammaIn zg.Gamma, // énergie mise en vente
+	pkOut []byte, // reçoit le paiement et l'énergie invendue
+	skIn []byte,
+	reserve *big.Int, // prix de réserve par unité d'énergie
+	nIn zg.Note, // note d'énergie verrouillée
+) error {
+
+	validatorID, err := n.RPC.Peer(context.Background(), validatorAddress)
+	if err != nil {
+		n.logger.Error().Err(err).Msgf("%s[Node %d] [SellerRegister] Error dialing %s\033[0m", getNodeColor(n.ID), n.ID, validatorAddress)
+		return err
+	}
+
+	// Comme pour les bidders, le round est enregistré sous des clés DH propres
+	// au round.
+	if validatorID == targetID {
+		if err := n.RoundKeyExchange(validatorAddress, roundID); err != nil {
+			return err
+		}
+	}
+	dh, ok := n.DH.Get(targetID, roundID)
+	if !ok {
+		return fmt.Errorf("no DH session with node %d for round %d", targetID, roundID)
+	}
+
+	expiry := n.TxExpiry(validatorAddress)
+	inp := zg.TxProverInputHighLevelDefaultOneCoin{
+		OldNote: nBase,
+		OldSk:   skBase,
+		NewVal:  gammaIn,
+		NewPk:   pkIn,
+		EncKey:  dh.SharedSecret,
+		R:       dh.Secret,
+		G:       n.G,
+		G_b:     dh.PartnerPublic,
+		G_r:     dh.EphemeralPublic,
+		ChainID: ChainID,
+		Expiry:  expiry,
+	}
+
+	// Même schéma que pour un bid : le prix de réserve occupe la place du bid.
+	encVal := zg.BuildEncRegMimc(dh.SharedSecret, gammaIn, pkOut, skIn, reserve)
+	var cAux [5][]byte
+	for i := 0; i < 5; i++ {
+		b := encVal[i].Bytes()
+		cAux[i] = append([]byte{}, b[:]...)
+	}
+
+	ip := zg.InputProverSellerRegister{
+		CmIn:          nIn.Cm,
+		CAux:          cAux,
+		GammaInCoins:  gammaIn.Coins,
+		GammaInEnergy: gammaIn.Energy,
+		ChainID:       ChainID,
+		Expiry:        expiry,
+		G:             n.G,
+		G_b:           dh.PartnerPublic,
+		G_r:           dh.EphemeralPublic,
+		InCoin:        gammaIn.Coins,
+		InEnergy:      gammaIn.Energy,
+		RhoIn:         new(big.Int).SetBytes(nIn.Rho),
+		RandIn:        new(big.Int).SetBytes(nIn.Rand),
+		SkIn:          new(big.Int).SetBytes(skIn),
+		PkIn:          new(big.Int).SetBytes(pkIn),
+		PkOut:         new(big.Int).SetBytes(pkOut),
+		Reserve:       reserve,
+		EncKey:        dh.SharedSecret,
+		R:             new(big.Int).SetBytes(dh.Secret),
+	}
+
+	// La transaction one coin crée la note verrouillée nIn elle-même : le
+	// validateur vérifie que CmIn est bien sa sortie.
+	rhoNew := new(big.Int).SetBytes(nIn.Rho)
+	randNew := new(big.Int).SetBytes(nIn.Rand)
+	tx := TransactionOneCoin(inp, globalCCSOneCoin, globalPKOneCoin, n.ID, validatorAddress, targetID, rhoNew, randNew)
+
+	piReg, err := ProofSellerRegister(ip, globalCCSSellerRegister, globalPKSellerRegister)
+	if err != nil {
+		return fmt.Errorf("seller register proof: %w", err)
+	}
+
+	txSeller := zn.TxSellerRegister{
+		TxIn: zn.TxEncapsulated{
+			Kind:    1,
+			Payload: tx,
+		},
+		CmIn:      nIn.Cm,
+		PiReg:     piReg,
+		AuxCipher: cAux,
+		EncVal:    encVal,
+		GammaIn:   gammaIn,
+		Expiry:    expiry,
+		ID:        n.ID,
+		TargetID:  targetID,
+		RoundID:   roundID,
+	}
+
+	if _, err := zn.Call[zn.RoundInfo](context.Background(), n.RPC, validatorAddress, zn.SellerRegisterMsg, txSeller); err != nil {
+		fmt.Printf("%s[Node %d] [SellerRegister] Seller registration rejected: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+		return err
+	}
+	fmt.Printf("%s[Node %d] [SellerRegister] Seller registration accepted in round %d\033[0m\n", getNodeColor(n.ID), n.ID, roundID)
+
+	return nil
+}
+
+// SendTransactionRefund récupère la note nIn verrouillée dans le round roundID
+// lorsque celui-ci a expiré sans règlement, ou que le règlement accepté ne l'a
+// pas dépensée. La note est dépensée par une
+// transaction one coin vers une nouvelle note de même valeur appartenant à
+// pkNew, chiffrée sous la clé DH partagée avec le validateur.
+func (n *Node) SendTransactionRefund(
+	validatorAddress string,
+	validatorID int,
+	roundID int,
+	globalCCSOneCoin constraint.ConstraintSystem,
+	globalPKOneCoin groth16.ProvingKey,
+	nIn zg.Note, // note verrouillée lors de l'enregistrement
+	skIn []byte,
+	pkNew []byte,
+) error {
+	dh, ok := n.DH.Get(validatorID, 0)
+	if !ok {
+		return fmt.Errorf("no DH session with validator %d", validatorID)
+	}
+
+	inp := zg.TxProverInputHighLevelDefaultOneCoin{
+		OldNote: nIn,
+		OldSk:   skIn,
+		NewVal:  nIn.Value,
+		NewPk:   pkNew,
+		EncKey:  dh.SharedSecret,
+		R:       dh.Secret,
+		G:       n.G,
+		G_b:     dh.PartnerPublic,
+		G_r:     dh.EphemeralPublic,
+		ChainID: ChainID,
+		Expiry:  n.TxExpiry(validatorAddress),
+	}
+	tx := TransactionOneCoin(inp, globalCCSOneCoin, globalPKOneCoin, n.ID, validatorAddress, validatorID, zg.RandBigInt(), zg.RandBigInt())
+
+	if _, err := zn.Call[zn.RoundInfo](context.Background(), n.RPC, validatorAddress, zn.RefundMsg, zn.TxRefund{TxIn: tx, RoundID: roundID}); err != nil {
+		fmt.Printf("%s[Node %d] [Refund] Refund rejected: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+		return err
+	}
+	fmt.Printf("%s[Node %d] [Refund] Refund accepted\033[0m\n", getNodeColor(n.ID), n.ID)
+
+	// La note a quitté le round : ses clés DH ne servent plus.
+	n.EndRound(roundID)
+	return nil
+}
+
+// SendChallenge conteste le règlement du round roundID : le bidder révèle
+// l'ouverture (coins, energy, bid) de son enregistrement et la prouve avec
+// CircuitBidOpening, sous la clé du comité et le G_r utilisés à
+// l'enregistrement. Le validateur annule le règlement s'il est incompatible
+// avec ce bid.
+func (n *Node) SendChallenge(
+	validatorAddress string,
+	roundID int,
+	globalCCSBidOpening constraint.ConstraintSystem,
+	globalPKBidOpening groth16.ProvingKey,
+) error {
+	inp, ok := n.Registrations[roundID]
+	if !ok {
+		return fmt.Errorf("no registration in round %d", roundID)
+	}
+	// La preuve est refaite : elle reçoit une nouvelle expiration.
+	inp.ChainID = ChainID
+	inp.Expiry = n.TxExpiry(validatorAddress)
+	proof, ip, err := ProofBidOpening(inp, globalCCSBidOpening, globalPKBidOpening)
+	if err != nil {
+		return err
 	}
 
+	_, err = zn.Call[zn.RoundInfo](context.Background(), n.RPC, validatorAddress, zn.ChallengeMsg, zn.TxChallenge{
+		RoundID: roundID,
+		ID:      n.ID,
+		CmIn:    ip.CmIn,
+		Coins:   ip.GammaInCoins,
+		Energy:  ip.GammaInEnergy,
+		Bid:     ip.Bid,
+		Proof:   proof,
+		Expiry:  ip.Expiry,
+	})
+	if err != nil {
+		fmt.Printf("%s[Node %d] [Challenge] Challenge rejected: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+		return err
+	}
+	fmt.Printf("%s[Node %d] [Challenge] Round %d settlement reverted\033[0m\n", getNodeColor(n.ID), n.ID, roundID)
+
 	return nil
 }
 
@@ -924,6 +1337,9 @@ func Transaction(inp zg.TxProverInputHighLevel, globalCCS constraint.ConstraintS
 	ip.G_b = inp.G_b
 	ip.G_r = inp.G_r
 	ip.EncKey = inp.EncKey
+	ip.Fee = inp.Fee
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
 
 	wc, _ := ip.BuildWitness()
 	w, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField())
@@ -939,10 +1355,12 @@ func Transaction(inp zg.TxProverInputHighLevel, globalCCS constraint.ConstraintS
 	proof.WriteTo(&buf)
 
 	txResult := zg.TxResult{
-		SnOld: snOld,
-		CmNew: cmNew,
-		CNew:  [2]zg.Note{cNew[0], cNew[1]},
-		Proof: buf.Bytes(),
+		SnOld:  snOld,
+		CmNew:  cmNew,
+		CNew:   [2]zg.Note{cNew[0], cNew[1]},
+		Proof:  buf.Bytes(),
+		Fee:    inp.Fee,
+		Expiry: inp.Expiry,
 
 		RhoNew:  rhoNew,
 		RandNew: randNew,
@@ -971,7 +1389,7 @@ func Transaction(inp zg.TxProverInputHighLevel, globalCCS constraint.ConstraintS
 	}
 }
 
-func TransactionOneCoin(inp zg.TxProverInputHighLevelDefaultOneCoin, globalCCSOneCoin constraint.ConstraintSystem, globalPKOneCoin groth16.ProvingKey, conn net.Conn, ID int, targetAddress string, targetID int, rhoNew *big.Int, randNew *big.Int) zn.TxDefaultOneCoinPayload {
+func TransactionOneCoin(inp zg.TxProverInputHighLevelDefaultOneCoin, globalCCSOneCoin constraint.ConstraintSystem, globalPKOneCoin groth16.ProvingKey, ID int, targetAddress string, targetID int, rhoNew *big.Int, randNew *big.Int) zn.TxDefaultOneCoinPayload {
 	// 1) snOld[i] = MiMC(skOld[i], RhoOld[i]) off-circuit
 	var snOld []byte
 	sn := zg.CalcSerialMimc(inp.OldSk, inp.OldNote.Rho)
@@ -1078,6 +1496,9 @@ func TransactionOneCoin(inp zg.TxProverInputHighLevelDefaultOneCoin, globalCCSOn
 	ip.G_b = inp.G_b
 	ip.G_r = inp.G_r
 	ip.EncKey = inp.EncKey
+	ip.Fee = inp.Fee
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
 
 	wc, _ := ip.BuildWitness()
 	w, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField())
@@ -1099,10 +1520,12 @@ func TransactionOneCoin(inp zg.TxProverInputHighLevelDefaultOneCoin, globalCCSOn
 	proof.WriteTo(&buf)
 
 	txResult := zg.TxResultDefaultOneCoin{
-		SnOld: snOld,
-		CmNew: cmNew,
-		CNew:  cNew, //[2]zg.Note{[0], cNew[1]},
-		Proof: buf.Bytes(),
+		SnOld:  snOld,
+		CmNew:  cmNew,
+		CNew:   cNew, //[2]zg.Note{[0], cNew[1]},
+		Proof:  buf.Bytes(),
+		Fee:    inp.Fee,
+		Expiry: inp.Expiry,
 
 		RhoNew:  rhoNew,
 		RandNew: randNew,
@@ -1130,7 +1553,6 @@ func TransactionNCoin(
 	inp zg.TxProverInputHighLevelDefaultNCoin, // type adapté pour N coins
 	globalCCSN []constraint.ConstraintSystem,
 	globalPKN []groth16.ProvingKey,
-	conn net.Conn,
 	ID int,
 	targetAddress string,
 	targetID int,
@@ -1271,6 +1693,9 @@ func TransactionNCoin(
 	}
 	ip.RhoNew = rhoNewList
 	ip.RandNew = randNewList
+	ip.Fee = inp.Fee
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
 
 	// //error if N!=2
 	// if N != 2 {
@@ -1318,6 +1743,8 @@ func TransactionNCoin(
 		CmNew:   cmNewList,
 		CNew:    cNewList,
 		Proof:   buf.Bytes(),
+		Fee:     inp.Fee,
+		Expiry:  inp.Expiry,
 		RhoNew:  rhoNewList,
 		RandNew: randNewList,
 		SkOld:   inp.OldSk, // supposé être déjà une slice
@@ -1589,7 +2016,7 @@ func TransactionF1(inp zg.TxProverInputHighLevelF1, globalCCSF1 constraint.Const
 	}
 }
 
-func TransactionFN(inp zg.TxProverInputHighLevelFN, globalCCSFN []constraint.ConstraintSystem, globalPKFN []groth16.ProvingKey, conn net.Conn, ID int, targetAddress string, targetID int) zn.TxFNPayload {
+func TransactionFN(inp zg.TxProverInputHighLevelFN, globalCCSFN []constraint.ConstraintSystem, globalPKFN []groth16.ProvingKey, ID int, targetAddress string, targetID int) zn.TxFNPayload {
 
 	// Conversion des champs coin‑spécifiques de [][]byte en []frontend.Variable pour chaque coin.
 	coinCount := len(inp.InCoin)
@@ -1703,12 +2130,14 @@ func TransactionFN(inp zg.TxProverInputHighLevelFN, globalCCSFN []constraint.Con
 		C:      res,        //[][5][]byte{C0, C1},
 		DecVal: inp.DecVal, // On suppose que inp.DecVal est déjà un slice avec 2 éléments.
 		// Paramètres globaux
-		SkT:    inp.SkT,
-		EncKey: inp.EncKey,
-		R:      RConv, //new(big.Int).SetBytes(inp.R), // On suppose que inp.R est un slice (ex. avec la valeur globale en première position).
-		G:      inp.G,
-		G_b:    inp.G_b,
-		G_r:    inp.G_r,
+		SkT:     inp.SkT,
+		EncKey:  inp.EncKey,
+		R:       RConv, //new(big.Int).SetBytes(inp.R), // On suppose que inp.R est un slice (ex. avec la valeur globale en première position).
+		G:       inp.G,
+		G_b:     inp.G_b,
+		G_r:     inp.G_r,
+		ChainID: inp.ChainID,
+		Expiry:  inp.Expiry,
 	}
 
 	var err error
@@ -1750,14 +2179,8 @@ func TransactionFN(inp zg.TxProverInputHighLevelFN, globalCCSFN []constraint.Con
 	}
 }
 
-func ProofRegister(
-	inp zg.TxProverInputHighLevelRegister,
-	ccsRegister constraint.ConstraintSystem,
-	pkRegister groth16.ProvingKey,
-) (proofBytes []byte, publicWitnessBytes []byte, ipr zg.InputProverRegister, err error) {
-
-	// ========== 1) Construire InputProverRegister ==========
-	//    (cette struct aura les champs en big.Int, bytes, etc.)
+// registerInput convertit inp en InputProverRegister (champs en big.Int).
+func registerInput(inp zg.TxProverInputHighLevelRegister) zg.InputProverRegister {
 	var ip zg.InputProverRegister
 
 	// Remplir ip à partir de inp
@@ -1786,6 +2209,46 @@ func ProofRegister(
 	ip.G_b = inp.G_b
 	ip.G_r = inp.G_r
 	ip.EncKey = inp.EncKey
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
+	return ip
+}
+
+// ProofBidOpening prouve à nouveau l'enregistrement inp avec
+// CircuitBidOpening, le bid étant révélé (cf. SendChallenge).
+func ProofBidOpening(
+	inp zg.TxProverInputHighLevelRegister,
+	ccsOpening constraint.ConstraintSystem,
+	pkOpening groth16.ProvingKey,
+) ([]byte, zg.BidOpening, error) {
+	opening := zg.BidOpening(registerInput(inp))
+	circuitFull, err := opening.BuildWitness()
+	if err != nil {
+		return nil, zg.BidOpening{}, fmt.Errorf("build witness: %w", err)
+	}
+	w, err := frontend.NewWitness(circuitFull, ecc.BW6_761.ScalarField())
+	if err != nil {
+		return nil, zg.BidOpening{}, fmt.Errorf("NewWitness: %w", err)
+	}
+	proof, err := groth16.Prove(ccsOpening, pkOpening, w)
+	if err != nil {
+		return nil, zg.BidOpening{}, fmt.Errorf("groth16.Prove: %w", err)
+	}
+	var proofBuf bytes.Buffer
+	if _, err := proof.WriteTo(&proofBuf); err != nil {
+		return nil, zg.BidOpening{}, fmt.Errorf("proof.WriteTo: %w", err)
+	}
+	return proofBuf.Bytes(), opening, nil
+}
+
+func ProofRegister(
+	inp zg.TxProverInputHighLevelRegister,
+	ccsRegister constraint.ConstraintSystem,
+	pkRegister groth16.ProvingKey,
+) (proofBytes []byte, publicWitnessBytes []byte, ipr zg.InputProverRegister, err error) {
+
+	// ========== 1) Construire InputProverRegister ==========
+	ip := registerInput(inp)
 
 	// ========== 2) On construit le Circuit complet ==========
 	circuitFull, err := ip.BuildWitness()
@@ -1823,6 +2286,31 @@ func ProofRegister(
 	return proofBuf.Bytes(), pubBuf.Bytes(), ip, nil
 }
 
+// ProofSellerRegister génère la preuve de CircuitTxSellerRegister.
+func ProofSellerRegister(
+	ip zg.InputProverSellerRegister,
+	ccsSellerRegister constraint.ConstraintSystem,
+	pkSellerRegister groth16.ProvingKey,
+) ([]byte, error) {
+	circuitFull, err := ip.BuildWitness()
+	if err != nil {
+		return nil, fmt.Errorf("build witness: %w", err)
+	}
+	w, err := frontend.NewWitness(circuitFull, ecc.BW6_761.ScalarField())
+	if err != nil {
+		return nil, fmt.Errorf("NewWitness: %w", err)
+	}
+	proof, err := groth16.Prove(ccsSellerRegister, pkSellerRegister, w)
+	if err != nil {
+		return nil, fmt.Errorf("groth16.Prove: %w", err)
+	}
+	var proofBuf bytes.Buffer
+	if _, err := proof.WriteTo(&proofBuf); err != nil {
+		return nil, fmt.Errorf("proof.WriteTo: %w", err)
+	}
+	return proofBuf.Bytes(), nil
+}
+
 // func ProofRegister(
 // 	inp zg.TxProverInputHighLevelRegister,
 // 	ccsRegister constraint.ConstraintSystem,
@@ -1979,6 +2467,8 @@ func ProofRegisterOld(inp zg.TxProverInputHighLevelRegister, globalCCSRegister c
 	ip.G_b = inp.G_b
 	ip.G_r = inp.G_r
 	ip.EncKey = inp.EncKey
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
 
 	wc, _ := ip.BuildWitness()
 	w, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField())
@@ -2101,37 +2591,49 @@ func NewDiffieHellmanHandler(node *Node) *DiffieHellmanHandler {
 // HandleMessage processes a "DiffieHellman" type message received by the verifier.
 // When it receives "DH_G_r", it stores A (the initiator's ephemeral key),
 // generates its ephemeral secret b, computes B = G^b, computes the shared secret S = A^b,
-// and sends back a "DH_G_b" message containing B.
+// and sends back a "DH_G_b" message containing B. A must be signed for this node
+// by the authenticated peer, and B is signed together with A.
 func (dh *DiffieHellmanHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
 	remoteAddr := conn.RemoteAddr().String()
 	payload, ok := msg.Payload.(zn.DHPayload)
 	if !ok {
 		fmt.Printf("DiffieHellman Handler (node %d): Non-conforming payload from %s", dh.Node.ID, remoteAddr)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a DHPayload")
+		return
+	}
+
+	// Only the authenticated peer may open an exchange under its own ID, with
+	// a point it signed for this node.
+	peer, _ := zn.PeerID(conn)
+	if err := payload.Check(dh.Node.Identity, peer); err != nil {
+		logger.Warn().Err(err).Msgf("%s[Node %d] [Diffie-Hellman] Unauthenticated %s from %s rejected\033[0m", getNodeColor(dh.Node.ID), dh.Node.ID, payload.SubType, remoteAddr)
+		zn.ReplyError(conn, msg, zn.CodeUnauthorized, "%v", err)
 		return
 	}
 
 	if payload.SubType == "DH_G_r" {
-		// Store A received from the initiator in DHExchanges with key = payload.ID.
-		dh.Node.DHExchanges[payload.ID] = &zn.DHParams{
-			EphemeralPublic: payload.Value,
-		}
 		logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Received DH_G_r from node %d\033[0m", getNodeColor(dh.Node.ID), dh.Node.ID, payload.ID))
 		//fmt.Printf("DiffieHellman Handler (node %d): Received DH_G_r from node %d : %+v\n", dh.Node.ID, payload.ID, payload.Value)
 
 		// Generate ephemeral secret b and compute B = G^b.
 		b, _ := zg.GenerateBls12377_frElement()
 		secret := b.Bytes() // verifier's secret
+		defer clear(secret[:])
 		B := *new(bls12377.G1Affine).ScalarMultiplication(&dh.Node.G, new(big.Int).SetBytes(secret[:]))
 		// Compute the shared secret S = A^b.
-		A := dh.Node.DHExchanges[payload.ID].EphemeralPublic
+		A := payload.Value
 		shared := *new(bls12377.G1Affine).ScalarMultiplication(&A, new(big.Int).SetBytes(secret[:]))
-		// Store these values in DHExchanges for this peer.
-		dh.Node.DHExchanges[payload.ID] = &zn.DHParams{
+		// Replace the session with this peer; the previous one is erased.
+		if err := dh.Node.DH.Put(payload.ID, payload.Round, zn.DHParams{
 			EphemeralPublic: B,             //payload.Value, // A received from the initiator
 			PartnerPublic:   payload.Value, //B,             // B computed by the verifier
 			Secret:          secret[:],
 			SharedSecret:    shared,
+		}); err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Error storing the session with node %d\033[0m", getNodeColor(dh.Node.ID), dh.Node.ID, payload.ID))
+			zn.ReplyError(conn, msg, zn.CodeInternal, "session not stored")
+			return
 		}
 
 		//fmt.Printf("DiffieHellman Handler (node %d): Shared secret computed: %+v\n", dh.Node.ID, shared)
@@ -2139,12 +2641,14 @@ func (dh *DiffieHellmanHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 
 		// Send the "DH_G_b" message containing B.
 		respPayload := zn.DHPayload{
-			ID:      dh.Node.ID,
+			Peer:    payload.ID,
+			Round:   payload.Round,
 			SubType: "DH_G_b",
 			Value:   B,
+			Partner: payload.Value,
 		}
-		respMsg := zn.PackMessage("DiffieHellman", respPayload)
-		if err := zn.SendMessage(conn, respMsg); err != nil {
+		respPayload.Sign(dh.Node.Identity)
+		if err := zn.Reply(conn, msg, "DiffieHellman", respPayload); err != nil {
 			logger.Error().Err(err).Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Error sending DH_G_b to node %d\033[0m", getNodeColor(dh.Node.ID), dh.Node.ID, payload.ID))
 			//fmt.Printf("DiffieHellman Handler (node %d): Error sending DH_G_b to node %d: %v\n", dh.Node.ID, payload.ID, err)
 		} else {
@@ -2153,6 +2657,7 @@ func (dh *DiffieHellmanHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 		}
 	} else {
 		logger.Error().Msg(fmt.Sprintf("%s[Node %d] [Diffie-Hellman] Unknown subtype '%s' from node %d\033[0m", getNodeColor(dh.Node.ID), dh.Node.ID, payload.SubType, payload.ID))
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "unknown subtype %q", payload.SubType)
 		//fmt.Printf("DiffieHellman Handler (node %d): Unknown subtype '%s' from node %d\n", dh.Node.ID, payload.SubType, payload.ID)
 	}
 }
@@ -2224,14 +2729,19 @@ func (th *TransactionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 			return
 		}
 		//tx = txPayload
-		ID := txPayload.ID
+		dh, found := th.Node.DH.Get(txPayload.ID, 0)
+		if !found {
+			fmt.Printf("TransactionHandler: no DH session with node %d\n", txPayload.ID)
+			return
+		}
 
 		ok = zg.ValidateTx(txPayload.TxResult,
 			txPayload.Old,
 			txPayload.NewVal,
 			th.Node.G,
-			th.Node.DHExchanges[ID].PartnerPublic,
-			th.Node.DHExchanges[ID].EphemeralPublic,
+			dh.PartnerPublic,
+			dh.EphemeralPublic,
+			th.Node.TxContext(),
 			globalVK)
 	} else {
 		//tx = tx.payload.(zn.TxDefaultOneCoinPayload)
@@ -2240,14 +2750,19 @@ func (th *TransactionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 			fmt.Println("TransactionHandler: invalid payload")
 			return
 		}
-		ID := txPayload.ID
+		dh, found := th.Node.DH.Get(txPayload.ID, 0)
+		if !found {
+			fmt.Printf("TransactionHandler: no DH session with node %d\n", txPayload.ID)
+			return
+		}
 
 		ok = zg.ValidateTxDefaultCoin(txPayload.TxResult,
 			txPayload.Old,
 			txPayload.NewVal,
 			th.Node.G,
-			th.Node.DHExchanges[ID].PartnerPublic,
-			th.Node.DHExchanges[ID].EphemeralPublic,
+			dh.PartnerPublic,
+			dh.EphemeralPublic,
+			th.Node.TxContext(),
 			globalVKOneCoin)
 	}
 
@@ -2290,98 +2805,90 @@ func (tvh *TransactionValidatorHandler) HandleMessage(msg zn.Message, conn net.C
 
 	if tx.Kind == 0 {
 		txPayload, _ := tx.Payload.(zn.Tx)
-		// Open a connection to the recipient to retrieve its DH parameters
-		destConn, err := net.Dial("tcp", txPayload.TargetAddress)
-		if err != nil {
-			fmt.Printf("%s[Node %d] [Validator] Error dialing destination %s: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, txPayload.TargetAddress, err)
-			return
-		}
-		defer destConn.Close()
-
-		// Send a DH request
+		// Ask the recipient for its DH parameters
 		reqPayload := zn.DHRequestPayload{SenderID: txPayload.ID}
-		reqMsg := zn.PackMessage("dh_request", reqPayload)
-		if err := zn.SendMessage(destConn, reqMsg); err != nil {
-			fmt.Printf("%s[Node %d] [Validator] Error sending DH request: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, err)
-			return
-		}
-
-		// Wait for the DH response
-		var respMsg zn.Message
-		if err := zn.ReceiveMessage(destConn, &respMsg); err != nil {
-			fmt.Printf("%s[Node %d] [Validator] Error receiving DH response: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, err)
-			return
-		}
-		respPayload, ok := respMsg.Payload.(zn.DHResponsePayload)
-		if !ok {
-			fmt.Println("DH response not conforming")
+		respPayload, err := zn.Call[zn.DHResponsePayload](context.Background(), tvh.Node.RPC, txPayload.TargetAddress, "dh_request", reqPayload)
+		if err != nil {
+			fmt.Printf("%s[Node %d] [Validator] DH request to %s failed: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, txPayload.TargetAddress, err)
 			return
 		}
 
 		// Validate the proof using the parameters retrieved from the recipient
-		valid_0 := zg.ValidateTx(txPayload.TxResult, txPayload.Old, txPayload.NewVal, tvh.Node.G, respPayload.DestPartnerPublic, respPayload.DestEphemeralPublic, globalVK)
+		ctx := LedgerDB.TxContext()
+		valid_0 := zg.ValidateTx(txPayload.TxResult, txPayload.Old, txPayload.NewVal, tvh.Node.G, respPayload.DestPartnerPublic, respPayload.DestEphemeralPublic, ctx, globalVK)
 
 		//Ensure spending numbers are not already in SnList
-		valid_1 := !containsByteSlice(SnList, txPayload.TxResult.SnOld[0]) || containsByteSlice(SnList, txPayload.TxResult.SnOld[1])
+		valid_1 := !LedgerDB.HasNullifier(txPayload.TxResult.SnOld[0]) && !LedgerDB.HasNullifier(txPayload.TxResult.SnOld[1])
 
-		if valid_0 && valid_1 {
-			logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Validator] Transaction validated.\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID))
-			// Add the serial numbers to the list
-			SnList = append(SnList, txPayload.TxResult.SnOld[0])
-			SnList = append(SnList, txPayload.TxResult.SnOld[1])
-			// Add the transaction to the list
-			TxList = append(TxList, txPayload.TxResult)
-			// Add committments to the list
-			CmList = append(CmList, txPayload.TxResult.CmNew[0])
-			CmList = append(CmList, txPayload.TxResult.CmNew[1])
+		fee, feeErr := txFee(txPayload.TxResult.Fee)
 
+		if valid_0 && valid_1 && feeErr == nil {
+			// The proof travels with the entry, for the other validators.
+			bundle, err := zg.BundleOf("default", globalVK, txPayload.TxResult.Proof,
+				zg.TxStatement(txPayload.TxResult, txPayload.Old, txPayload.NewVal, tvh.Node.G, respPayload.DestPartnerPublic, respPayload.DestEphemeralPublic, ctx))
+			if err != nil {
+				logger.Error().Err(err).Msgf("%s[Node %d] [Validator] Cannot export the transaction proof\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID)
+				return
+			}
+			// Add the serial numbers, the transaction and the commitments to the ledger
+			err = LedgerDB.Append(LedgerEntry{
+				Sns:    [][]byte{txPayload.TxResult.SnOld[0], txPayload.TxResult.SnOld[1]},
+				Cms:    [][]byte{txPayload.TxResult.CmNew[0], txPayload.TxResult.CmNew[1]},
+				Spent:  [][]byte{txPayload.Old[0].Cm, txPayload.Old[1].Cm},
+				Tx:     &txPayload.TxResult,
+				Fee:    fee,
+				Expiry: txPayload.TxResult.Expiry,
+				Proofs: []*zg.ProofBundle{bundle},
+			})
+			if err != nil {
+				logger.Warn().Err(err).Msgf("%s[Node %d] [Validator] Transaction rejected by the ledger\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID)
+				return
+			}
+			logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Validator] Transaction validated.\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID))
 		} else {
 			logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Validator] Transaction invalid.\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID))
 		}
 	} else {
 		txPayload, _ := tx.Payload.(zn.TxDefaultOneCoinPayload)
-		// Open a connection to the recipient to retrieve its DH parameters
-		destConn, err := net.Dial("tcp", txPayload.TargetAddress)
-		if err != nil {
-			fmt.Printf("%s[Node %d] [Validator] Error dialing destination %s: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, txPayload.TargetAddress, err)
-			return
-		}
-		defer destConn.Close()
-
-		// Send a DH request
+		// Ask the recipient for its DH parameters
 		reqPayload := zn.DHRequestPayload{SenderID: txPayload.ID}
-		reqMsg := zn.PackMessage("dh_request", reqPayload)
-		if err := zn.SendMessage(destConn, reqMsg); err != nil {
-			fmt.Printf("%s[Node %d] [Validator] Error sending DH request: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, err)
-			return
-		}
-
-		// Wait for the DH response
-		var respMsg zn.Message
-		if err := zn.ReceiveMessage(destConn, &respMsg); err != nil {
-			fmt.Printf("%s[Node %d] [Validator] Error receiving DH response: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, err)
-			return
-		}
-		respPayload, ok := respMsg.Payload.(zn.DHResponsePayload)
-		if !ok {
-			fmt.Println("DH response not conforming")
+		respPayload, err := zn.Call[zn.DHResponsePayload](context.Background(), tvh.Node.RPC, txPayload.TargetAddress, "dh_request", reqPayload)
+		if err != nil {
+			fmt.Printf("%s[Node %d] [Validator] DH request to %s failed: %v\033[0m\n", getNodeColor(tvh.Node.ID), tvh.Node.ID, txPayload.TargetAddress, err)
 			return
 		}
 
 		// Validate the transaction using the parameters retrieved from the recipient
-		valid_0 := zg.ValidateTxDefaultCoin(txPayload.TxResult, txPayload.Old, txPayload.NewVal, tvh.Node.G, respPayload.DestPartnerPublic, respPayload.DestEphemeralPublic, globalVKOneCoin)
+		ctx := LedgerDB.TxContext()
+		valid_0 := zg.ValidateTxDefaultCoin(txPayload.TxResult, txPayload.Old, txPayload.NewVal, tvh.Node.G, respPayload.DestPartnerPublic, respPayload.DestEphemeralPublic, ctx, globalVKOneCoin)
 
 		//Ensure spending numbers are not already in SnList
-		valid_1 := !containsByteSlice(SnList, txPayload.TxResult.SnOld)
+		valid_1 := !LedgerDB.HasNullifier(txPayload.TxResult.SnOld)
+
+		fee, feeErr := txFee(txPayload.TxResult.Fee)
 
-		if valid_0 && valid_1 {
+		if valid_0 && valid_1 && feeErr == nil {
+			bundle, err := zg.BundleOf("oneCoin", globalVKOneCoin, txPayload.TxResult.Proof,
+				zg.TxDefaultCoinStatement(txPayload.TxResult, txPayload.Old, txPayload.NewVal, tvh.Node.G, respPayload.DestPartnerPublic, respPayload.DestEphemeralPublic, ctx))
+			if err != nil {
+				logger.Error().Err(err).Msgf("%s[Node %d] [Validator] Cannot export the transaction proof\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID)
+				return
+			}
+			// Add the serial number, the transaction and the commitment to the ledger
+			err = LedgerDB.Append(LedgerEntry{
+				Sns:       [][]byte{txPayload.TxResult.SnOld},
+				Cms:       [][]byte{txPayload.TxResult.CmNew},
+				Spent:     [][]byte{txPayload.Old.Cm},
+				TxOneCoin: &txPayload.TxResult,
+				Fee:       fee,
+				Expiry:    txPayload.TxResult.Expiry,
+				Proofs:    []*zg.ProofBundle{bundle},
+			})
+			if err != nil {
+				logger.Warn().Err(err).Msgf("%s[Node %d] [Validator] Transaction rejected by the ledger\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID)
+				return
+			}
 			logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Validator] Transaction validated.\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID))
-			// Add the serial numbers to the list
-			SnList = append(SnList, txPayload.TxResult.SnOld)
-			// Add the transaction to the list
-			TxListDefaultOneCoin = append(TxListDefaultOneCoin, txPayload.TxResult)
-			// Add committments to the list
-			CmList = append(CmList, txPayload.TxResult.CmNew)
 		} else {
 			logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Validator] Transaction invalid.\033[0m", getNodeColor(tvh.Node.ID), tvh.Node.ID))
 		}
@@ -2413,35 +2920,79 @@ func NewTxDrawCoinHandler(node *Node) *TxDrawCoinHandler {
 	return &TxDrawCoinHandler{Node: node}
 }
 
+// HandleMessage validates a draw on the validator with draw and sends the
+// sender its DrawReceipt.
 func (drh *TxDrawCoinHandler) HandleMessage(msg zn.Message, conn net.Conn) {
-	//logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
-	// Example implementation:
-	// Extract the payload of type DHRequestPayload (which you must define)
-	req, ok := msg.Payload.(zn.TxEncapsulated)
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+
+	req, ok := msg.Payload.(zn.TxDrawPayload)
 	if !ok {
-		fmt.Println("AuctionHandler: invalid payload")
+		logger.Warn().Msgf("%s[Node %d] [Draw] Payload is not TxDrawPayload\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a TxDrawPayload")
 		return
 	}
-	//logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Auction] Received an auction result from sender %d\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, req.SenderID))
-
-	// Construction du witness
-	Inp := req.Payload.(zn.TxDefaultOneCoinPayload).Inp
-	wc, _ := Inp.BuildWitness()
-	w, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
 
-	wPub, _ := w.Public()
-	var pubBuf bytes.Buffer
-	if _, err := wPub.WriteTo(&pubBuf); err != nil {
-		panic(err)
+	receipt := drh.draw(req)
+	if receipt.Accepted {
+		logger.Info().Msgf("%s[Node %d] [Draw] Draw from node %d accepted\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, req.ID)
+	} else {
+		logger.Warn().Msgf("%s[Node %d] [Draw] Draw from node %d rejected: %s\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, req.ID, receipt.Reason)
 	}
-
-	buf := bytes.NewReader(req.Payload.(zn.TxDefaultOneCoinPayload).TxResult.Proof)
-	p := groth16.NewProof(ecc.BW6_761)
-	_, err := p.ReadFrom(buf)
-	if err != nil {
-		fmt.Println("invalid proof =>", err)
+	if err := zn.Reply(conn, msg, "draw_receipt", receipt); err != nil {
+		logger.Error().Err(err).Msgf("%s[Node %d] [Draw] Error sending receipt\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
 	}
+}
 
+// draw applies the draw req if it is valid: the proof must verify against
+// globalVKDraw, its serial number must be unspent and it must not have
+// expired. CmIn must be a committed note of the ledger, the OutCm the
+// settlement of the round delivers to a bidder, and the fill must be the one
+// it carries: the draw moves the allocation to a fresh note and allocates
+// nothing itself.
+func (drh *TxDrawCoinHandler) draw(req zn.TxDrawPayload) zn.DrawReceipt {
+	receipt := zn.DrawReceipt{CmOut: req.Ip.CmOut}
+	fee, feeErr := txFee(new(big.Int).SetBytes(req.Ip.Fee))
+	round, roundErr := Rounds.Get(req.RoundID)
+	switch {
+	case feeErr != nil:
+		receipt.Reason = feeErr.Error()
+	case roundErr != nil:
+		receipt.Reason = roundErr.Error()
+	case !LedgerDB.HasCommitment(req.Ip.CmIn):
+		receipt.Reason = ErrUnknownNote.Error()
+	case LedgerDB.HasNullifier(req.Ip.SnIn):
+		receipt.Reason = ErrDoubleSpend.Error()
+	case LedgerDB.TxContext().Expired(req.Ip.Expiry):
+		receipt.Reason = ErrExpired.Error()
+	default:
+		fill := new(big.Int).SetBytes(req.Ip.Fill)
+		err := round.Draw(req.Ip.CmIn, fill, func() error {
+			if !zg.ValidateTxDraw(req.Proof, req.Ip.Public(), LedgerDB.TxContext(), globalVKDraw) {
+				return errors.New("invalid draw proof")
+			}
+			pub := req.Ip.Public()
+			pub.ChainID = ChainID
+			bundle, err := zg.BundleOf("draw", globalVKDraw, req.Proof, &pub)
+			if err != nil {
+				return err
+			}
+			return LedgerDB.Append(LedgerEntry{
+				Sns:    [][]byte{req.Ip.SnIn},
+				Cms:    [][]byte{req.Ip.CmOut},
+				Spent:  [][]byte{req.Ip.CmIn},
+				Fee:    fee,
+				Expiry: req.Ip.Expiry,
+				Round:  &RoundEvent{Kind: RoundEventDrawn, ID: req.RoundID, At: LedgerDB.Now(), CmIn: req.Ip.CmIn},
+				Proofs: []*zg.ProofBundle{bundle},
+			})
+		})
+		if err != nil {
+			receipt.Reason = err.Error()
+			break
+		}
+		receipt.Accepted = true
+	}
+	return receipt
 }
 
 // -------------------------------
@@ -2471,25 +3022,155 @@ func ExtractEncryptedCoins(inp_ *zg.TxProverInputHighLevelFN, coinCount int) [][
 	return coinsEnc
 }
 
-func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+// verifySettle vérifie la preuve de règlement du round : chaque note et chaque
+// ciphertext doivent être ceux d'un enregistrement validé (bidder ou vendeur),
+// chaque bid enregistré doit être réglé, les clés DH du vendeur celles qu'il
+// partage avec le validateur, et aucune note ne doit avoir déjà été dépensée.
+// Un règlement sans preuve est refusé. Les notes de sortie sont alors ajoutées
+// à CmList, verrouillées jusqu'à lockedUntil (fin de la fenêtre de
+// contestation) ; at est l'instant du règlement.
+func (drh *AuctionHandler) verifySettle(round zn.RoundInfo, txS zn.TxSettlePayload, at, lockedUntil time.Time) bool {
 	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
-	// Example implementation:
-	// Extract the payload of type DHRequestPayload (which you must define)
-	req, ok := msg.Payload.(zn.AuctionResultN)
-	if !ok {
-		fmt.Println("AuctionHandler: invalid payload")
-		return
-	}
-	logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Auction] Received an auction result from sender %d\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, req.SenderID))
+	ip := txS.Ip
 
-	/////////////
-
-	N := len(req.InpDOC.OldSk)
-	coinCount := N
+	if txS.Proof == nil {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Settlement has no proof\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
 
-	inp := req.InpDOC
-	rhoNewList := req.RhoNew
-	randNewList := req.RandNew
+	// 1) Vendeur
+	var txSeller zn.TxSellerRegister
+	found := false
+	for _, t := range round.Sellers {
+		reg, ok := t.Tx.(zn.TxSellerRegister)
+		if ok && bytes.Equal(reg.CmIn, ip.Seller.InCm) && equalCipher(reg.AuxCipher, ip.Seller.C) {
+			txSeller, found = reg, true
+			break
+		}
+	}
+	if !found {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Seller note is not registered\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+	dh, ok := drh.Node.DH.Get(txSeller.ID, round.ID)
+	if !ok || !ip.Seller.G_b.Equal(&dh.EphemeralPublic) || !ip.Seller.G_r.Equal(&dh.PartnerPublic) {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Seller DH keys do not match\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+
+	// 2) Bidders : le ciphertext est lié à la note enregistrée (InCm, InSn), et
+	//    EncKey doit être la combinaison des déchiffrements partiels du comité
+	//    pour le G_r de l'enregistrement. Il y a autant de bidders que de bids
+	//    enregistrés ; les nullifiers étant deux à deux distincts (3), chaque
+	//    bid est réglé exactement une fois.
+	if len(ip.Bidders) != len(round.Bids) {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Settlement covers %d of %d registered bids\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, len(ip.Bidders), len(round.Bids))
+		return false
+	}
+	if drh.Node.Committee == nil || len(txS.Decryptions) != len(ip.Bidders) {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Missing committee decryptions\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+	for i, b := range ip.Bidders {
+		var txReg zn.TxRegister
+		registered := false
+		for _, t := range round.Bids {
+			reg, ok := t.Tx.(zn.TxRegister)
+			if ok && bytes.Equal(reg.CmIn, b.InCm) && equalCipher(reg.AuxCipher, b.C) {
+				txReg, registered = reg, true
+				break
+			}
+		}
+		if !registered {
+			logger.Warn().Msgf("%s[Node %d] [Auction] Bidder %d note is not registered\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, i)
+			return false
+		}
+		key, _, err := drh.Node.Committee.Combine(drh.Node.G, txReg.Ip.G_r, txS.Decryptions[i])
+		if err != nil || !key.Equal(&b.EncKey) {
+			logger.Warn().Msgf("%s[Node %d] [Auction] Bidder %d decryption key does not match the committee shares\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, i)
+			return false
+		}
+	}
+
+	// 3) Nullifiers : inédits et deux à deux distincts
+	sns, cms := settlementNotes(ip)
+	for i, sn := range sns {
+		if LedgerDB.HasNullifier(sn) || containsByteSlice(sns[:i], sn) {
+			logger.Warn().Msgf("%s[Node %d] [Auction] Settlement spends an already spent note\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+			return false
+		}
+	}
+
+	// 4) Preuve
+	var vk groth16.VerifyingKey
+	var circuit string
+	switch len(ip.Bidders) {
+	case 2:
+		vk, circuit = globalVKSettle2, "settle2"
+	case 3:
+		vk, circuit = globalVKSettle3, "settle3"
+	default:
+		logger.Warn().Msgf("%s[Node %d] [Auction] No settlement circuit for %d bidders\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, len(ip.Bidders))
+		return false
+	}
+	ip.G = drh.Node.G
+	if !zg.ValidateTxSettle(txS.Proof, ip, LedgerDB.TxContext(), vk) {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Settlement proof invalid\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+	fee, err := txFee(ip.Fee)
+	if err != nil {
+		logger.Warn().Err(err).Msgf("%s[Node %d] [Auction] Settlement fee rejected\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+	ip.ChainID = ChainID
+	pub := ip.Public()
+	bundle, err := zg.BundleOf(circuit, vk, txS.Proof, &pub)
+	if err != nil {
+		logger.Error().Err(err).Msgf("%s[Node %d] [Auction] Cannot export the settlement proof\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+
+	err = LedgerDB.Append(LedgerEntry{
+		Sns:         sns,
+		Cms:         cms,
+		Spent:       settlementInputs(ip),
+		LockedUntil: lockedUntil,
+		Fee:         fee,
+		Round:       &RoundEvent{Kind: RoundEventSettled, ID: round.ID, At: at, Settlement: txS},
+		Proofs:      []*zg.ProofBundle{bundle},
+	})
+	if err != nil {
+		logger.Warn().Err(err).Msgf("%s[Node %d] [Auction] Settlement rejected by the ledger\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
+		return false
+	}
+	logger.Info().Msgf("%s[Node %d] [Auction] Settlement validated: sold=%v price=%v\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, ip.Seller.Sold, ip.Price)
+	return true
+}
+
+// equalCipher compare deux ciphertexts d'enregistrement.
+func equalCipher(a, b [5][]byte) bool {
+	for i := 0; i < 5; i++ {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyOutputs vérifie les preuves du résultat d'enchère : la transaction
+// à N coins qui produit les notes de sortie (2coin/3coin) et la preuve
+// d'allocation F2/F3. ctx fournit le ChainID du validateur.
+func (drh *AuctionHandler) verifyOutputs(req zn.AuctionResultN, ctx zg.TxContext) error {
+	N := len(req.InpDOC.OldSk)
+	coinCount := N
+	if N != 2 && N != 3 {
+		return fmt.Errorf("no circuit for %d coins", N)
+	}
+
+	inp := req.InpDOC
+	rhoNewList := req.RhoNew
+	randNewList := req.RandNew
 
 	// 1) Calculer snOld pour chaque coin
 	var snOldList [][]byte
@@ -2623,6 +3304,9 @@ func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 	}
 	ip.RhoNew = rhoNewList
 	ip.RandNew = randNewList
+	ip.Fee = inp.Fee
+	ip.ChainID = ctx.ChainID
+	ip.Expiry = inp.Expiry
 
 	// //error if N!=2
 	// if N != 2 {
@@ -2631,16 +3315,23 @@ func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 
 	var wc frontend.Circuit
 
+	var err error
 	switch N {
 	case 2:
-		wc, _ = ip.BuildWitness2()
+		wc, err = ip.BuildWitness2()
 	case 3:
-		wc, _ = ip.BuildWitness3()
+		wc, err = ip.BuildWitness3()
+	}
+	if err != nil {
+		return fmt.Errorf("output transaction witness: %w", err)
 	}
 
 	// Construction du witness
 	//wc, _ := ip.BuildWitness2()
-	w, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	w, err := frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return fmt.Errorf("output transaction witness: %w", err)
+	}
 
 	wPub, _ := w.Public()
 	var pubBuf bytes.Buffer
@@ -2650,21 +3341,17 @@ func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 
 	buf := bytes.NewReader(req.TxOut.TxResult.Proof)
 	p := groth16.NewProof(ecc.BW6_761)
-	_, err := p.ReadFrom(buf)
-	if err != nil {
-		fmt.Println("invalid proof =>", err)
+	if _, err = p.ReadFrom(buf); err != nil {
+		return fmt.Errorf("invalid output transaction proof: %w", err)
 	}
 	switch N {
 	case 2:
 		err = groth16.Verify(p, globalVK2Coin, w)
-		if err != nil {
-			fmt.Println("Verify fail =>", err)
-		}
 	case 3:
 		err = groth16.Verify(p, globalVK3Coin, w)
-		if err != nil {
-			fmt.Println("Verify fail =>", err)
-		}
+	}
+	if err != nil {
+		return fmt.Errorf("output transaction proof rejected: %w", err)
 	}
 
 	/////////////
@@ -2780,12 +3467,14 @@ func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 		C:      coinsEnc,    //[][5][]byte{C0, C1},
 		DecVal: inp_.DecVal, // On suppose que inp_.DecVal est déjà un slice avec 2 éléments.
 		// Paramètres globaux
-		SkT:    inp_.SkT,
-		EncKey: inp_.EncKey,
-		R:      RConv, //new(big.Int).SetBytes(inp_.R), // On suppose que inp_.R est un slice (ex. avec la valeur globale en première position).
-		G:      inp_.G,
-		G_b:    inp_.G_b,
-		G_r:    inp_.G_r,
+		SkT:     inp_.SkT,
+		EncKey:  inp_.EncKey,
+		R:       RConv, //new(big.Int).SetBytes(inp_.R), // On suppose que inp_.R est un slice (ex. avec la valeur globale en première position).
+		G:       inp_.G,
+		G_b:     inp_.G_b,
+		G_r:     inp_.G_r,
+		ChainID: ctx.ChainID,
+		Expiry:  inp_.Expiry,
 	}
 
 	// Construction du witness via la méthode BuildWitness de InputTxFN.
@@ -2794,17 +3483,17 @@ func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 	switch coinCount {
 	case 2:
 		c, e = ip_.BuildWitness2()
-		if e != nil {
-			panic(err)
-		}
 	case 3:
 		c, e = ip_.BuildWitness3()
-		if e != nil {
-			panic(err)
-		}
+	}
+	if e != nil {
+		return fmt.Errorf("allocation witness: %w", e)
 	}
 
-	w, _ = frontend.NewWitness(c, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	w, err = frontend.NewWitness(c, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return fmt.Errorf("allocation witness: %w", err)
+	}
 
 	wPub, _ = w.Public()
 	var pubBuf_ bytes.Buffer
@@ -2814,21 +3503,75 @@ func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 
 	buf = bytes.NewReader(req.TxFN.Proof)
 	p = groth16.NewProof(ecc.BW6_761)
-	_, err = p.ReadFrom(buf)
-	if err != nil {
-		fmt.Println("invalid proof =>", err)
+	if _, err = p.ReadFrom(buf); err != nil {
+		return fmt.Errorf("invalid allocation proof: %w", err)
 	}
 	switch coinCount {
 	case 2:
 		err = groth16.Verify(p, globalVKF2, w)
-		if err != nil {
-			fmt.Println("Verify fail =>", err)
-		}
 	case 3:
 		err = groth16.Verify(p, globalVKF3, w)
-		if err != nil {
-			fmt.Println("Verify fail =>", err)
+	}
+	if err != nil {
+		return fmt.Errorf("allocation proof rejected: %w", err)
+	}
+	return nil
+}
+
+func (drh *AuctionHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+	// Example implementation:
+	// Extract the payload of type DHRequestPayload (which you must define)
+	req, ok := msg.Payload.(zn.AuctionResultN)
+	if !ok {
+		fmt.Println("AuctionHandler: invalid payload")
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not an AuctionResultN")
+		return
+	}
+	logger.Info().Msg(fmt.Sprintf("%s[Node %d] [Auction] Received an auction result from sender %d\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, req.SenderID))
+
+	round, err := Rounds.Get(req.RoundID)
+	if err != nil {
+		logger.Warn().Msgf("%s[Node %d] [Auction] %v\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeNotFound, "%v", err)
+		return
+	}
+
+	// Les preuves du résultat sont vérifiées avec le ChainID du validateur et
+	// ne doivent pas avoir expiré.
+	ctx := LedgerDB.TxContext()
+	if ctx.Expired(req.InpDOC.Expiry) || ctx.Expired(req.InpF.Expiry) {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Round %d result rejected: %v\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, round.ID, ErrExpired)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", ErrExpired)
+		return
+	}
+
+	/////////////
+
+	if err := drh.verifyOutputs(req, ctx); err != nil {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Round %d result rejected: %v\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, round.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+		return
+	}
+
+	// Le snapshot est pris avant Settle, qui garde le round verrouillé.
+	info := round.Info()
+	err = round.Settle(req.TxSettle, func(at time.Time) error {
+		if !drh.verifySettle(info, req.TxSettle, at, at.Add(round.ChallengeWindow)) {
+			return errors.New("invalid settlement")
 		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn().Msgf("%s[Node %d] [Auction] Round %d rejected: %v\033[0m",
+			getNodeColor(drh.Node.ID), drh.Node.ID, round.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+		return
+	}
+	logger.Info().Msgf("%s[Node %d] [Auction] Round %d settled\033[0m",
+		getNodeColor(drh.Node.ID), drh.Node.ID, round.ID)
+	if err := zn.Reply(conn, msg, "round_info", round.Info()); err != nil {
+		logger.Error().Err(err).Msgf("%s[Node %d] [Auction] Error sending round info\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID)
 	}
 
 	/////////////
@@ -3094,14 +3837,16 @@ func (drh *DHRequestHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 	req, ok := msg.Payload.(zn.DHRequestPayload)
 	if !ok {
 		fmt.Println("DHRequestHandler: invalid payload")
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a DHRequestPayload")
 		return
 	}
 	logger.Info().Msg(fmt.Sprintf("%s[Node %d] [DH Request] Received a DH request from sender %d\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, req.SenderID))
 
 	// Retrieve the DH parameters of the recipient (here assumed to be stored in DHExchanges)
-	exchange, exists := drh.Node.DHExchanges[req.SenderID]
+	exchange, exists := drh.Node.DH.Get(req.SenderID, req.RoundID)
 	if !exists {
-		fmt.Printf("%s[Node %d] [DH Request] No exchange found for sender %d\033[0m\n", getNodeColor(drh.Node.ID), drh.Node.ID, req.SenderID)
+		fmt.Printf("%s[Node %d] [DH Request] No exchange found for sender %d in round %d\033[0m\n", getNodeColor(drh.Node.ID), drh.Node.ID, req.SenderID, req.RoundID)
+		zn.ReplyError(conn, msg, zn.CodeNotFound, "no DH session with node %d for round %d", req.SenderID, req.RoundID)
 		return
 	}
 
@@ -3110,8 +3855,7 @@ func (drh *DHRequestHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 		DestPartnerPublic:   exchange.PartnerPublic,
 		DestEphemeralPublic: exchange.EphemeralPublic,
 	}
-	respMsg := zn.PackMessage("dh_response", resp)
-	if err := zn.SendMessage(conn, respMsg); err != nil {
+	if err := zn.Reply(conn, msg, "dh_response", resp); err != nil {
 		logger.Info().Msg(fmt.Sprintf("%s[Node %d] [DH Request] Error sending DH response: %v\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID, err))
 	} else {
 		logger.Info().Msg(fmt.Sprintf("%s[Node %d] [DH Request] DH response sent\033[0m", getNodeColor(drh.Node.ID), drh.Node.ID))
@@ -3147,6 +3891,7 @@ func NewRegisterHandler(node *Node) *RegisterHandler {
  */
 
 func (rh *RegisterHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	received := LedgerDB.Now()
 	rh.Node.logger.Info().Msgf("%s[Node %d] [RegisterHandler] 'register' message from %v\033[0m",
 		getNodeColor(rh.Node.ID), rh.Node.ID, conn.RemoteAddr())
 
@@ -3155,101 +3900,578 @@ func (rh *RegisterHandler) HandleMessage(msg zn.Message, conn net.Conn) {
 	if !ok {
 		rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] Payload is not TxRegister\033[0m",
 			getNodeColor(rh.Node.ID), rh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a TxRegister")
 		return
 	}
 
 	if txReg.TxIn.Kind != 1 {
 		rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] TxIn.Kind != 1 (expected one-coin)\033[0m",
 			getNodeColor(rh.Node.ID), rh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "registration does not lock a one-coin transaction")
 		return
 	}
 	txOneCoin, ok := txReg.TxIn.Payload.(zn.TxDefaultOneCoinPayload)
 	if !ok {
 		rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] TxIn.Payload not TxDefaultOneCoinPayload\033[0m",
 			getNodeColor(rh.Node.ID), rh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "registration does not lock a one-coin transaction")
 		return
 	}
 
-	// 2) Retrieve ephemeral keys from the transaction's target
-	destConn, err := net.Dial("tcp", txOneCoin.TargetAddress)
-	if err != nil {
-		fmt.Printf("Error dialing target %s: %v\n", txOneCoin.TargetAddress, err)
+	// Late registrations are rejected before any proof is checked.
+	round, err := Rounds.Get(txReg.RoundID)
+	if err != nil || !round.AcceptsAt(received) {
+		rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] Round %d is not open for registrations\033[0m",
+			getNodeColor(rh.Node.ID), rh.Node.ID, txReg.RoundID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "round %d is not open for registrations", txReg.RoundID)
 		return
 	}
-	defer destConn.Close()
 
-	reqMsg := zn.PackMessage("dh_request", zn.DHRequestPayload{SenderID: txOneCoin.ID})
-	if err := zn.SendMessage(destConn, reqMsg); err != nil {
-		fmt.Printf("Error sending DH request: %v\n", err)
-		return
-	}
-	var respMsg zn.Message
-	if err := zn.ReceiveMessage(destConn, &respMsg); err != nil {
-		fmt.Printf("Error receiving DH response: %v\n", err)
+	// 2) The bid must be encrypted to the committee key
+	if rh.Node.Committee == nil {
+		rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] %v\033[0m",
+			getNodeColor(rh.Node.ID), rh.Node.ID, ErrCommitteeNotReady)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", ErrCommitteeNotReady)
 		return
 	}
-	respPayload, ok := respMsg.Payload.(zn.DHResponsePayload)
-	if !ok {
-		fmt.Println("DH response payload not conforming.")
+	committeeKey, err := rh.Node.Committee.PublicKey()
+	if err != nil || !txReg.Ip.G_b.Equal(&committeeKey) {
+		rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] Bid is not encrypted to the committee key\033[0m",
+			getNodeColor(rh.Node.ID), rh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "bid is not encrypted to the committee key")
 		return
 	}
 
-	// 3) Prepare the arguments to ValidateTxRegister
-	//    These must match EXACTLY how you built them in SendTransactionRegister.
-	cmIn := /* e.g. */ txReg.CmIn     // if you stored nIn.Cm in txReg
-	coinsIn := txOneCoin.NewVal.Coins //big.NewInt(12)                        // if you used 12 in your TxProverInputHighLevelRegister
-	//fmt.Println("txOneCoin.NewVal.Coins =", txOneCoin.NewVal.Coins)
-	energyIn := txOneCoin.NewVal.Energy //big.NewInt(5)                        // if you used 5
-	//fmt.Println("txOneCoin.NewVal.Energy =", txOneCoin.NewVal.Energy)
-	bid := new(big.Int).SetBytes(txReg.AuxCipher[2]) // if the 3rd slot is the plain bid
-
-	/*
-		proofBytes []byte, // la preuve
-		pubWitnessBytes []byte, // éventuellement le witness public
-		// ou alors, si on doit le reconstruire:
-		cmIn []byte,
-		cAux [5][]byte,
-		gammaInCoins, gammaInEnergy, bid *big.Int,
-		G, G_b, G_r bls12377.G1Affine,
-		vk groth16.VerifyingKey,
-	*/
+	// 3) The statement is rebuilt from the validator's view: the amounts are
+	//    those TxIn locks, the bid stays a private witness of the proof.
+	ip := txReg.Ip.Public()
+	ip.CmIn = txReg.CmIn
+	ip.CAux = txReg.AuxCipher
+	ip.GammaInCoins = txOneCoin.NewVal.Coins
+	ip.GammaInEnergy = txOneCoin.NewVal.Energy
+	ip.G = rh.Node.G
+	ip.G_b = committeeKey
 
 	// 4) Actually call your function
 	valid_0 := zg.ValidateTxRegister(
 		txReg.PiReg,
 		txReg.PubW,
-		txReg.Ip,
-		cmIn,
-		txReg.AuxCipher,
-		coinsIn,
-		energyIn,
-		bid,
-		rh.Node.G,
-		respPayload.DestPartnerPublic,
-		respPayload.DestEphemeralPublic,
+		ip,
+		ip.CmIn,
+		ip.CAux,
+		ip.GammaInCoins,
+		ip.GammaInEnergy,
+		ip.G,
+		ip.G_b,
+		ip.G_r,
+		LedgerDB.TxContext(),
 		globalVKRegister,
 	)
 
-	notDoubleSpent := !containsByteSlice(SnList, txOneCoin.TxResult.SnOld)
+	// 5) TxIn locks the note: its proof is checked against the DH keys of its
+	//    recipient, like any one-coin transaction (see
+	//    TransactionValidatorHandler).
+	validIn := false
+	var bundles []*zg.ProofBundle
+	if valid_0 {
+		dh, err := zn.Call[zn.DHResponsePayload](context.Background(), rh.Node.RPC, txOneCoin.TargetAddress, "dh_request", zn.DHRequestPayload{SenderID: txOneCoin.ID, RoundID: txReg.RoundID})
+		if err != nil {
+			rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] DH request to %s failed: %v\033[0m",
+				getNodeColor(rh.Node.ID), rh.Node.ID, txOneCoin.TargetAddress, err)
+			zn.ReplyError(conn, msg, zn.CodeInternal, "DH request to the recipient failed")
+			return
+		}
+		ctx := LedgerDB.TxContext()
+		validIn = zg.ValidateTxDefaultCoin(txOneCoin.TxResult, txOneCoin.Old, txOneCoin.NewVal, rh.Node.G, dh.DestPartnerPublic, dh.DestEphemeralPublic, ctx, globalVKOneCoin)
+		if validIn {
+			ip.ChainID = ctx.ChainID
+			in, errIn := zg.BundleOf("oneCoin", globalVKOneCoin, txOneCoin.TxResult.Proof,
+				zg.TxDefaultCoinStatement(txOneCoin.TxResult, txOneCoin.Old, txOneCoin.NewVal, rh.Node.G, dh.DestPartnerPublic, dh.DestEphemeralPublic, ctx))
+			reg, errReg := zg.BundleOf("register", globalVKRegister, txReg.PiReg, &ip)
+			if errIn != nil || errReg != nil {
+				rh.Node.logger.Error().Msgf("%s[Node %d] [RegisterHandler] Cannot export the registration proofs: %v\033[0m",
+					getNodeColor(rh.Node.ID), rh.Node.ID, errors.Join(errIn, errReg))
+				zn.ReplyError(conn, msg, zn.CodeInternal, "cannot export the registration proofs")
+				return
+			}
+			bundles = []*zg.ProofBundle{in, reg}
+		}
+	}
+
+	notDoubleSpent := !LedgerDB.HasNullifier(txOneCoin.TxResult.SnOld)
 
-	if valid_0 && notDoubleSpent {
+	if valid_0 && validIn && notDoubleSpent {
+		// The round, which round_get exposes, only keeps the public statement.
+		txReg.Ip = ip
+		ev := RoundEvent{
+			Kind: RoundEventBid,
+			ID:   txReg.RoundID,
+			At:   received,
+			Tx:   zn.Transaction{Tx: txReg, Id: txOneCoin.ID},
+			Aux:  zn.AuxList{C: txOneCoin.EncVal, Proof: txReg.PiReg, Id: txOneCoin.ID},
+			Info: zn.InfoBid{Gamma: zg.Gamma{Coins: ip.GammaInCoins, Energy: ip.GammaInEnergy}, Kind: txReg.Kind},
+			Cm:   txOneCoin.TxResult.CmNew,
+		}
+		err := round.AddBid(ev.At, ev.Tx, ev.Aux, ev.Info, ev.Cm, func() error {
+			return LedgerDB.Append(LedgerEntry{
+				Sns:       [][]byte{txOneCoin.TxResult.SnOld},
+				Spent:     [][]byte{txOneCoin.Old.Cm},
+				TxOneCoin: &txOneCoin.TxResult,
+				Round:     &ev,
+				Proofs:    bundles,
+			})
+		})
+		if err != nil {
+			rh.Node.logger.Warn().Msgf("%s[Node %d] [RegisterHandler] Round %d: %v\033[0m",
+				getNodeColor(rh.Node.ID), rh.Node.ID, txReg.RoundID, err)
+			zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+			return
+		}
 		rh.Node.logger.Info().Msgf(
 			"%s[Node %d] [RegisterHandler] Register TX validated.\033[0m",
 			getNodeColor(rh.Node.ID), rh.Node.ID)
-		// Update your local lists
-		SnList = append(SnList, txOneCoin.TxResult.SnOld)
-		TxListDefaultOneCoin = append(TxListDefaultOneCoin, txOneCoin.TxResult)
-		CmListTemp = append(CmListTemp, txOneCoin.TxResult.CmNew)
-		TxListTemp = append(TxListTemp, zn.Transaction{Tx: txReg, Id: txOneCoin.ID})
-		//
-		//var EncVal [5]bl.Element = txOneCoin.EncVal[0:5]
-		AuxList = append(AuxList, zn.AuxList{C: txOneCoin.EncVal, Proof: txReg.PiReg, Id: txOneCoin.ID})
-		InfoBid = append(InfoBid, zn.InfoBid{Gamma: zg.Gamma{Coins: txReg.Ip.GammaInCoins, Energy: txReg.Ip.GammaInEnergy}, Bid: txReg.Ip.Bid, Kind: txReg.Kind})
+		if err := zn.Reply(conn, msg, "round_info", round.Info()); err != nil {
+			rh.Node.logger.Error().Err(err).Msgf("%s[Node %d] [RegisterHandler] Error sending round info\033[0m",
+				getNodeColor(rh.Node.ID), rh.Node.ID)
+		}
 	} else {
 		rh.Node.logger.Info().Msgf(
 			"%s[Node %d] [RegisterHandler] Register TX invalid.\033[0m",
 			getNodeColor(rh.Node.ID), rh.Node.ID)
+		switch {
+		case !valid_0:
+			zn.ReplyError(conn, msg, zn.CodeRefused, "invalid registration proof")
+		case !validIn:
+			zn.ReplyError(conn, msg, zn.CodeRefused, "invalid one-coin transaction")
+		default:
+			zn.ReplyError(conn, msg, zn.CodeRefused, "%v", ErrDoubleSpend)
+		}
+	}
+}
+
+// -------------------------------
+// SellerRegisterHandler
+// -------------------------------
+
+// SellerRegisterHandler handles "register_seller" messages on the validator.
+type SellerRegisterHandler struct {
+	Node *Node
+}
+
+// NewSellerRegisterHandler creates a new seller registration handler.
+func NewSellerRegisterHandler(node *Node) *SellerRegisterHandler {
+	return &SellerRegisterHandler{Node: node}
+}
+
+// HandleMessage checks the one-coin transaction locking the offered note and
+// the seller registration proof against the DH keys the validator shares with
+// the seller, then locks the note. The sender gets the round info back, or an
+// error saying why the registration was refused.
+func (sh *SellerRegisterHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	received := LedgerDB.Now()
+	sh.Node.logger.Info().Msgf("%s[Node %d] [SellerRegisterHandler] 'register_seller' message from %v\033[0m",
+		getNodeColor(sh.Node.ID), sh.Node.ID, conn.RemoteAddr())
+
+	txSeller, ok := msg.Payload.(zn.TxSellerRegister)
+	if !ok {
+		sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] Payload is not TxSellerRegister\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a TxSellerRegister")
+		return
+	}
+	txOneCoin, ok := txSeller.TxIn.Payload.(zn.TxDefaultOneCoinPayload)
+	if txSeller.TxIn.Kind != 1 || !ok {
+		sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] TxIn is not a one-coin transaction\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "registration does not lock a one-coin transaction")
+		return
+	}
+	round, err := Rounds.Get(txSeller.RoundID)
+	if err != nil || !round.AcceptsAt(received) {
+		sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] Round %d is not open for registrations\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID, txSeller.RoundID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "round %d is not open for registrations", txSeller.RoundID)
+		return
+	}
+	if txSeller.TargetID != sh.Node.ID {
+		sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] Reserve is not encrypted for this validator (target %d)\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID, txSeller.TargetID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "reserve is not encrypted for node %d", sh.Node.ID)
+		return
+	}
+
+	// The one-coin transaction must create the note put up for sale, so that
+	// the seller cannot offer a note it does not own.
+	if !bytes.Equal(txOneCoin.TxResult.CmNew, txSeller.CmIn) {
+		sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] CmIn is not the note created by TxIn\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "CmIn is not the note created by TxIn")
+		return
+	}
+
+	// Vue du validateur sur l'échange DH avec le vendeur : sa clé éphémère est
+	// le G_b du vendeur, et la clé éphémère du vendeur est G_r.
+	dh, ok := sh.Node.DH.Get(txSeller.ID, txSeller.RoundID)
+	if !ok {
+		sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] No DH exchange with node %d\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID, txSeller.ID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "no DH session for round %d", txSeller.RoundID)
+		return
+	}
+
+	// TxIn is addressed to this validator, which therefore holds its DH keys.
+	validIn := zg.ValidateTxDefaultCoin(
+		txOneCoin.TxResult,
+		txOneCoin.Old,
+		txOneCoin.NewVal,
+		sh.Node.G,
+		dh.EphemeralPublic,
+		dh.PartnerPublic,
+		LedgerDB.TxContext(),
+		globalVKOneCoin,
+	)
+	valid := validIn && zg.ValidateTxSellerRegister(
+		txSeller.PiReg,
+		txSeller.CmIn,
+		txSeller.AuxCipher,
+		txSeller.GammaIn,
+		sh.Node.G,
+		dh.EphemeralPublic,
+		dh.PartnerPublic,
+		txSeller.Expiry,
+		LedgerDB.TxContext(),
+		globalVKSellerRegister,
+	)
+	notDoubleSpent := !LedgerDB.HasNullifier(txOneCoin.TxResult.SnOld)
+
+	var bundles []*zg.ProofBundle
+	if valid {
+		ctx := LedgerDB.TxContext()
+		in, errIn := zg.BundleOf("oneCoin", globalVKOneCoin, txOneCoin.TxResult.Proof,
+			zg.TxDefaultCoinStatement(txOneCoin.TxResult, txOneCoin.Old, txOneCoin.NewVal, sh.Node.G, dh.EphemeralPublic, dh.PartnerPublic, ctx))
+		reg, errReg := zg.BundleOf("sellerRegister", globalVKSellerRegister, txSeller.PiReg, &zg.InputProverSellerRegister{
+			CmIn:          txSeller.CmIn,
+			CAux:          txSeller.AuxCipher,
+			GammaInCoins:  txSeller.GammaIn.Coins,
+			GammaInEnergy: txSeller.GammaIn.Energy,
+			G:             sh.Node.G,
+			G_b:           dh.EphemeralPublic,
+			G_r:           dh.PartnerPublic,
+			ChainID:       ctx.ChainID,
+			Expiry:        txSeller.Expiry,
+		})
+		if errIn != nil || errReg != nil {
+			sh.Node.logger.Error().Msgf("%s[Node %d] [SellerRegisterHandler] Cannot export the registration proofs: %v\033[0m",
+				getNodeColor(sh.Node.ID), sh.Node.ID, errors.Join(errIn, errReg))
+			zn.ReplyError(conn, msg, zn.CodeInternal, "cannot export the registration proofs")
+			return
+		}
+		bundles = []*zg.ProofBundle{in, reg}
+	}
+
+	if valid && notDoubleSpent {
+		ev := RoundEvent{
+			Kind: RoundEventSeller,
+			ID:   txSeller.RoundID,
+			At:   received,
+			Tx:   zn.Transaction{Tx: txSeller, Id: txSeller.ID},
+			Cm:   txOneCoin.TxResult.CmNew,
+		}
+		err := round.AddSeller(ev.At, ev.Tx, ev.Cm, func() error {
+			return LedgerDB.Append(LedgerEntry{
+				Sns:       [][]byte{txOneCoin.TxResult.SnOld},
+				Spent:     [][]byte{txOneCoin.Old.Cm},
+				TxOneCoin: &txOneCoin.TxResult,
+				Round:     &ev,
+				Proofs:    bundles,
+			})
+		})
+		if err != nil {
+			sh.Node.logger.Warn().Msgf("%s[Node %d] [SellerRegisterHandler] Round %d: %v\033[0m",
+				getNodeColor(sh.Node.ID), sh.Node.ID, txSeller.RoundID, err)
+			zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+			return
+		}
+		sh.Node.logger.Info().Msgf("%s[Node %d] [SellerRegisterHandler] Seller registration validated.\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID)
+		if err := zn.Reply(conn, msg, "round_info", round.Info()); err != nil {
+			sh.Node.logger.Error().Err(err).Msgf("%s[Node %d] [SellerRegisterHandler] Error sending round info\033[0m",
+				getNodeColor(sh.Node.ID), sh.Node.ID)
+		}
+	} else {
+		sh.Node.logger.Info().Msgf("%s[Node %d] [SellerRegisterHandler] Seller registration invalid.\033[0m",
+			getNodeColor(sh.Node.ID), sh.Node.ID)
+		switch {
+		case !validIn:
+			zn.ReplyError(conn, msg, zn.CodeRefused, "invalid one-coin transaction")
+		case !valid:
+			zn.ReplyError(conn, msg, zn.CodeRefused, "invalid seller registration proof")
+		default:
+			zn.ReplyError(conn, msg, zn.CodeRefused, "%v", ErrDoubleSpend)
+		}
+	}
+}
+
+// -------------------------------
+// RefundHandler
+// -------------------------------
+
+// RefundHandler handles "refund" messages on the validator.
+type RefundHandler struct {
+	Node *Node
+}
+
+// NewRefundHandler creates a new refund handler.
+func NewRefundHandler(node *Node) *RefundHandler {
+	return &RefundHandler{Node: node}
+}
+
+// HandleMessage releases a note locked in an expired round, or one the
+// accepted settlement of the round did not spend. The refund must
+// spend the registered note itself (CmOld == CmIn), so its serial number is
+// the one a settlement of the round would have published. The sender gets the
+// round info back, or an error saying why the refund was refused.
+func (fh *RefundHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	fh.Node.logger.Info().Msgf("%s[Node %d] [RefundHandler] 'refund' message from %v\033[0m",
+		getNodeColor(fh.Node.ID), fh.Node.ID, conn.RemoteAddr())
+
+	txRefund, ok := msg.Payload.(zn.TxRefund)
+	if !ok {
+		fh.Node.logger.Warn().Msgf("%s[Node %d] [RefundHandler] Payload is not TxRefund\033[0m",
+			getNodeColor(fh.Node.ID), fh.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a TxRefund")
+		return
+	}
+	tx := txRefund.TxIn
+	round, err := Rounds.Get(txRefund.RoundID)
+	if err != nil {
+		fh.Node.logger.Warn().Msgf("%s[Node %d] [RefundHandler] %v\033[0m", getNodeColor(fh.Node.ID), fh.Node.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeNotFound, "%v", err)
+		return
+	}
+	if tx.TargetID != fh.Node.ID {
+		fh.Node.logger.Warn().Msgf("%s[Node %d] [RefundHandler] Refund is not encrypted for this validator (target %d)\033[0m",
+			getNodeColor(fh.Node.ID), fh.Node.ID, tx.TargetID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "refund is not encrypted for node %d", fh.Node.ID)
+		return
+	}
+	dh, ok := fh.Node.DH.Get(tx.ID, 0)
+	if !ok {
+		fh.Node.logger.Warn().Msgf("%s[Node %d] [RefundHandler] No DH exchange with node %d\033[0m",
+			getNodeColor(fh.Node.ID), fh.Node.ID, tx.ID)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "no DH session with node %d", tx.ID)
+		return
+	}
+
+	err = round.Refund(tx.Old.Cm, func() error {
+		if LedgerDB.HasNullifier(tx.TxResult.SnOld) {
+			return ErrDoubleSpend
+		}
+		ctx := LedgerDB.TxContext()
+		if !zg.ValidateTxDefaultCoin(tx.TxResult, tx.Old, tx.NewVal, fh.Node.G, dh.EphemeralPublic, dh.PartnerPublic, ctx, globalVKOneCoin) {
+			return errors.New("invalid refund proof")
+		}
+		fee, err := txFee(tx.TxResult.Fee)
+		if err != nil {
+			return err
+		}
+		bundle, err := zg.BundleOf("oneCoin", globalVKOneCoin, tx.TxResult.Proof,
+			zg.TxDefaultCoinStatement(tx.TxResult, tx.Old, tx.NewVal, fh.Node.G, dh.EphemeralPublic, dh.PartnerPublic, ctx))
+		if err != nil {
+			return err
+		}
+		return LedgerDB.Append(LedgerEntry{
+			Sns:       [][]byte{tx.TxResult.SnOld},
+			Cms:       [][]byte{tx.TxResult.CmNew},
+			Spent:     [][]byte{tx.Old.Cm},
+			TxOneCoin: &tx.TxResult,
+			Fee:       fee,
+			Expiry:    tx.TxResult.Expiry,
+			Round:     &RoundEvent{Kind: RoundEventRefunded, ID: txRefund.RoundID, At: LedgerDB.Now(), CmIn: tx.Old.Cm},
+			Proofs:    []*zg.ProofBundle{bundle},
+		})
+	})
+	if err != nil {
+		fh.Node.logger.Warn().Msgf("%s[Node %d] [RefundHandler] Round %d: refund rejected: %v\033[0m",
+			getNodeColor(fh.Node.ID), fh.Node.ID, txRefund.RoundID, err)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+		return
+	}
+	fh.Node.logger.Info().Msgf("%s[Node %d] [RefundHandler] Round %d: note refunded to node %d\033[0m",
+		getNodeColor(fh.Node.ID), fh.Node.ID, txRefund.RoundID, tx.ID)
+	if err := zn.Reply(conn, msg, "round_info", round.Info()); err != nil {
+		fh.Node.logger.Error().Err(err).Msgf("%s[Node %d] [RefundHandler] Error sending round info\033[0m", getNodeColor(fh.Node.ID), fh.Node.ID)
+	}
+}
+
+// -------------------------------
+// ChallengeHandler
+// -------------------------------
+
+// ErrNoFraud is returned when a challenge is consistent with the settlement.
+var ErrNoFraud = errors.New("settlement is consistent with the revealed bid")
+
+// ChallengeHandler handles "challenge" messages on the validator.
+type ChallengeHandler struct {
+	Node *Node
+}
+
+// NewChallengeHandler creates a new challenge handler.
+func NewChallengeHandler(node *Node) *ChallengeHandler {
+	return &ChallengeHandler{Node: node}
+}
+
+// HandleMessage checks a bidder's challenge against the settlement of its
+// round. The revealed opening must match the registered ciphertext (register
+// proof under the committee key); if the settlement omits or underfills that
+// bid, it is reverted and the round's locked notes become refundable. The
+// settlement outputs cannot have been spent in the meantime: they stay locked
+// until the end of the challenge window (see LedgerEntry.LockedUntil). The
+// sender gets the disputed round info back, or an error.
+func (ch *ChallengeHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	ch.Node.logger.Info().Msgf("%s[Node %d] [ChallengeHandler] 'challenge' message from %v\033[0m",
+		getNodeColor(ch.Node.ID), ch.Node.ID, conn.RemoteAddr())
+
+	tx, ok := msg.Payload.(zn.TxChallenge)
+	if !ok {
+		ch.Node.logger.Warn().Msgf("%s[Node %d] [ChallengeHandler] Payload is not TxChallenge\033[0m",
+			getNodeColor(ch.Node.ID), ch.Node.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a TxChallenge")
+		return
+	}
+	round, err := Rounds.Get(tx.RoundID)
+	if err != nil {
+		ch.Node.logger.Warn().Msgf("%s[Node %d] [ChallengeHandler] %v\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeNotFound, "%v", err)
+		return
+	}
+	if ch.Node.Committee == nil {
+		ch.Node.logger.Warn().Msgf("%s[Node %d] [ChallengeHandler] %v\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID, ErrCommitteeNotReady)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", ErrCommitteeNotReady)
+		return
+	}
+	committeeKey, err := ch.Node.Committee.PublicKey()
+	if err != nil {
+		ch.Node.logger.Warn().Msgf("%s[Node %d] [ChallengeHandler] %v\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+		return
+	}
+
+	err = round.Dispute(func(info zn.RoundInfo, settlement zn.TxSettlePayload) error {
+		var txReg zn.TxRegister
+		registered := false
+		for _, t := range info.Bids {
+			reg, ok := t.Tx.(zn.TxRegister)
+			if ok && t.Id == tx.ID && bytes.Equal(reg.CmIn, tx.CmIn) {
+				txReg, registered = reg, true
+				break
+			}
+		}
+		if !registered {
+			return ErrNoteNotLocked
+		}
+		opening := zg.BidOpening{
+			CmIn:          txReg.CmIn,
+			CAux:          txReg.AuxCipher,
+			GammaInCoins:  tx.Coins,
+			GammaInEnergy: tx.Energy,
+			Bid:           tx.Bid,
+			G:             ch.Node.G,
+			G_b:           committeeKey,
+			G_r:           txReg.Ip.G_r,
+			Expiry:        tx.Expiry,
+		}
+		if !zg.ValidateBidOpening(tx.Proof, opening, LedgerDB.TxContext(), globalVKBidOpening) {
+			return errors.New("invalid opening proof")
+		}
+		if err := checkSettlement(info, settlement.Ip, tx.CmIn, tx.Coins, tx.Bid); err != nil {
+			return err
+		}
+		opening.ChainID = ChainID
+		bundle, err := zg.BundleOf("bidOpening", globalVKBidOpening, tx.Proof, &opening)
+		if err != nil {
+			return err
+		}
+		sns, cms := settlementNotes(settlement.Ip)
+		return LedgerDB.Append(LedgerEntry{
+			RevokedSns: sns,
+			RevokedCms: cms,
+			Round:      &RoundEvent{Kind: RoundEventDisputed, ID: tx.RoundID, At: LedgerDB.Now()},
+			Proofs:     []*zg.ProofBundle{bundle},
+		})
+	})
+	if err != nil {
+		ch.Node.logger.Warn().Msgf("%s[Node %d] [ChallengeHandler] Round %d: challenge from node %d rejected: %v\033[0m",
+			getNodeColor(ch.Node.ID), ch.Node.ID, tx.RoundID, tx.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+		return
+	}
+	ch.Node.logger.Warn().Msgf("%s[Node %d] [ChallengeHandler] Round %d: settlement reverted on challenge from node %d\033[0m",
+		getNodeColor(ch.Node.ID), ch.Node.ID, tx.RoundID, tx.ID)
+	if err := zn.Reply(conn, msg, "round_info", round.Info()); err != nil {
+		ch.Node.logger.Error().Err(err).Msgf("%s[Node %d] [ChallengeHandler] Error sending round info\033[0m", getNodeColor(ch.Node.ID), ch.Node.ID)
+	}
+}
+
+// checkSettlement confronte le bid révélé (coins, bid) de la note cmIn au
+// règlement ip, selon les règles de clearAuction : un bid strictement au-dessus
+// du prix est servi entièrement (coins/bid unités), un bid égal au prix l'est
+// tant qu'il reste de l'énergie à vendre. Un bid enregistré absent du règlement
+// est une fraude, quel que soit son montant. Renvoie ErrNoFraud si le
+// règlement est cohérent. Sans vente (prix nul), la réserve secrète du vendeur
+// ne permet pas de trancher.
+func checkSettlement(info zn.RoundInfo, ip zg.InputProverSettle, cmIn []byte, coins, bid *big.Int) error {
+	var fill *big.Int
+	for _, b := range ip.Bidders {
+		if bytes.Equal(b.InCm, cmIn) {
+			fill = b.Fill
+			break
+		}
+	}
+	if fill == nil {
+		return nil
 	}
+	if ip.Price == nil || ip.Price.Sign() == 0 || bid.Sign() <= 0 || bid.Cmp(ip.Price) < 0 {
+		return ErrNoFraud
+	}
+	demand := new(big.Int).Quo(coins, bid)
+	if fill.Cmp(demand) >= 0 {
+		return ErrNoFraud
+	}
+	if bid.Cmp(ip.Price) > 0 {
+		return nil
+	}
+	for _, t := range info.Sellers {
+		reg, ok := t.Tx.(zn.TxSellerRegister)
+		if ok && bytes.Equal(reg.CmIn, ip.Seller.InCm) {
+			if ip.Seller.Sold.Cmp(reg.GammaIn.Energy) < 0 {
+				return nil
+			}
+			break
+		}
+	}
+	return ErrNoFraud
+}
+
+// settlementInputs renvoie les notes enregistrées que dépense le règlement ip.
+func settlementInputs(ip zg.InputProverSettle) [][]byte {
+	cms := [][]byte{ip.Seller.InCm}
+	for _, b := range ip.Bidders {
+		cms = append(cms, b.InCm)
+	}
+	return cms
+}
+
+// settlementNotes renvoie les nullifiers publiés et les notes de sortie créées
+// par le règlement ip (vendeur puis bidders).
+func settlementNotes(ip zg.InputProverSettle) (sns, cms [][]byte) {
+	sns = [][]byte{ip.Seller.InSn}
+	for _, b := range ip.Bidders {
+		sns = append(sns, b.InSn)
+		cms = append(cms, b.OutCm, b.ChangeCm)
+	}
+	cms = append(cms, ip.Seller.OutPayCm, ip.Seller.OutChangeCm)
+	return sns, cms
 }
 
 // func (rh *RegisterHandler) HandleMessage(msg zn.Message, conn net.Conn) { //TODOGREG!
@@ -3412,14 +4634,55 @@ var globalCCSDraw constraint.ConstraintSystem
 var globalPKDraw groth16.ProvingKey
 var globalVKDraw groth16.VerifyingKey
 
-var CmList [][]byte
-var SnList [][]byte
-var TxListDefaultOneCoin []zg.TxResultDefaultOneCoin
-var TxList []zg.TxResult
-var TxListTemp []zn.Transaction
-var CmListTemp [][]byte
-var AuxList []zn.AuxList
-var InfoBid []zn.InfoBid
+var globalCCSSellerRegister constraint.ConstraintSystem
+var globalPKSellerRegister groth16.ProvingKey
+var globalVKSellerRegister groth16.VerifyingKey
+
+var globalCCSBidOpening constraint.ConstraintSystem
+var globalPKBidOpening groth16.ProvingKey
+var globalVKBidOpening groth16.VerifyingKey
+
+var globalCCSSettle2 constraint.ConstraintSystem
+var globalPKSettle2 groth16.ProvingKey
+var globalVKSettle2 groth16.VerifyingKey
+
+var globalCCSSettle3 constraint.ConstraintSystem
+var globalPKSettle3 groth16.ProvingKey
+var globalVKSettle3 groth16.VerifyingKey
+
+var globalCCSFee constraint.ConstraintSystem
+var globalPKFee groth16.ProvingKey
+var globalVKFee groth16.VerifyingKey
+
+// ChainID identifie le réseau (flag -chain-id). Chaque preuve l'engage en
+// entrée publique : une transaction capturée ne peut être rejouée sur un autre
+// déploiement.
+var ChainID uint64 = 1
+
+// DHSessionTTL est la durée de vie d'une session DH (flag -dh-ttl) ; passé ce
+// délai, la session est effacée et un nouvel échange est nécessaire.
+var DHSessionTTL = DefaultDHSessionTTL
+
+// DHStoreDir est le répertoire où chaque nœud conserve ses sessions DH entre
+// deux redémarrages (flag -dh-dir ; vide : sessions en mémoire seulement).
+var DHStoreDir string
+
+// ProofDir est le répertoire où le validateur écrit les bundles de preuve des
+// entrées qu'il admet, vérifiables hors ligne par la sous-commande verify
+// (flag -proof-dir ; vide : pas d'export).
+var ProofDir string
+
+// RPCTimeout borne chaque appel requête/réponse d'un nœud (flag -rpc-timeout) :
+// passé ce délai, l'appel échoue au lieu d'attendre indéfiniment.
+var RPCTimeout = zn.DefaultCallTimeout
+
+// MessageRate et MessageBurst limitent les messages que chaque pair peut
+// envoyer à un nœud (flags -msg-rate et -msg-burst) : MessageRate par seconde
+// en moyenne, par rafales d'au plus MessageBurst ; l'excédent est ignoré.
+var (
+	MessageRate  = 200.0
+	MessageBurst = 400
+)
 
 // containsByteSlice checks if a slice of byte slices contains a specific byte slice.
 func containsByteSlice(slice [][]byte, item []byte) bool {
@@ -3431,19 +4694,228 @@ func containsByteSlice(slice [][]byte, item []byte) bool {
 	return false
 }
 
-func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, AuxList []zn.AuxList, nInList []zg.Note, targetIdList []int, targetAddresses []string) zn.AuctionResultN {
+// clearAuction calcule le règlement face à l'offre du vendeur. Chaque bidder
+// demande Coins/Bid unités ; seuls les bids >= reserve sont retenus, servis du
+// plus offrant au moins offrant, le dernier servi pouvant l'être partiellement.
+// Le prix uniforme est le plus petit bid servi. Sans vente, tout vaut 0.
+func clearAuction(reserve, offered *big.Int, coins, bids []*big.Int) (fills []*big.Int, sold, price *big.Int) {
+	fills = make([]*big.Int, len(bids))
+	order := make([]int, 0, len(bids))
+	for i := range bids {
+		fills[i] = big.NewInt(0)
+		if bids[i].Sign() > 0 && bids[i].Cmp(reserve) >= 0 {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return bids[order[a]].Cmp(bids[order[b]]) > 0 })
 
-	conn, _ := net.Dial("tcp", validatorAddress)
+	sold = big.NewInt(0)
+	price = big.NewInt(0)
+	remaining := new(big.Int).Set(offered)
+	for _, i := range order {
+		if remaining.Sign() == 0 {
+			break
+		}
+		demand := new(big.Int).Quo(coins[i], bids[i])
+		if demand.Sign() == 0 {
+			continue
+		}
+		if demand.Cmp(remaining) > 0 {
+			demand.Set(remaining)
+		}
+		fills[i] = demand
+		sold.Add(sold, demand)
+		remaining.Sub(remaining, demand)
+		price.Set(bids[i])
+	}
+	return fills, sold, price
+}
 
-	defer conn.Close()
+// ProveSettle déchiffre les enregistrements des bidders et du vendeur, calcule
+// le règlement avec clearAuction et prouve CircuitTxSettle. nInList et
+// sellerNote fournissent rho/rand des notes verrouillées, absents des ciphertexts.
+// bidKeys[i] est la clé de déchiffrement du bid i, combinée à partir des parts
+// bidParts[i] du comité (cf. RequestBidKeys). expiry borne la hauteur du bloc
+// qui peut inclure le règlement ; les frais fee du validateur sont prélevés sur
+// le paiement du vendeur.
+func (n *Node) ProveSettle(bidList []zn.Transaction, nInList []zg.Note, txSeller zn.TxSellerRegister, sellerNote zg.Note, bidKeys []bls12377.G1Affine, bidParts [][]zg.PartialDecryption, expiry uint64, fee *big.Int) (zn.TxSettlePayload, error) {
+	var ccs constraint.ConstraintSystem
+	var pk groth16.ProvingKey
+	switch len(bidList) {
+	case 2:
+		ccs, pk = globalCCSSettle2, globalPKSettle2
+	case 3:
+		ccs, pk = globalCCSSettle3, globalPKSettle3
+	default:
+		return zn.TxSettlePayload{}, fmt.Errorf("no settlement circuit for %d bidders", len(bidList))
+	}
+
+	// 1) Déchiffrement des enregistrements
+	decBids := make([]*zg.RegDecryptedValues, len(bidList))
+	coins := make([]*big.Int, len(bidList))
+	bids := make([]*big.Int, len(bidList))
+	sessions := make([]zn.DHParams, len(bidList))
+	for i, t := range bidList {
+		reg, ok := t.Tx.(zn.TxRegister)
+		if !ok {
+			return zn.TxSettlePayload{}, fmt.Errorf("bid %d is not a TxRegister", i)
+		}
+		if sessions[i], ok = n.DH.Get(t.Id, txSeller.RoundID); !ok {
+			return zn.TxSettlePayload{}, fmt.Errorf("no DH session with node %d for round %d", t.Id, txSeller.RoundID)
+		}
+		dec, err := zg.BuildDecRegMimc(bidKeys[i], reg.EncVal)
+		if err != nil {
+			return zn.TxSettlePayload{}, fmt.Errorf("decrypt bid %d: %w", i, err)
+		}
+		decBids[i] = dec
+		coins[i] = dec.Coins
+		bids[i] = dec.Bid
+	}
+	sellerDH, ok := n.DH.Get(txSeller.TargetID, txSeller.RoundID)
+	if !ok {
+		return zn.TxSettlePayload{}, fmt.Errorf("no DH session with node %d for round %d", txSeller.TargetID, txSeller.RoundID)
+	}
+	decSeller, err := zg.BuildDecRegMimc(sellerDH.SharedSecret, txSeller.EncVal)
+	if err != nil {
+		return zn.TxSettlePayload{}, fmt.Errorf("decrypt seller registration: %w", err)
+	}
+
+	// 2) Règlement
+	fills, sold, price := clearAuction(decSeller.Bid, decSeller.Energy, coins, bids)
+	fmt.Printf("%s[Node %d] [Auction] Clearing: sold=%v price=%v fills=%v (reserve=%v)\033[0m\n", getNodeColor(n.ID), n.ID, sold, price, fills, decSeller.Bid)
+
+	// 3) Input du prouveur ; Openings suit l'ordre des notes de sortie :
+	//    bidder i -> (livrée, rendue) en 2i, 2i+1, puis vendeur -> (paiement, invendu).
+	ip := zg.InputProverSettle{Price: price, Fee: fee, G: n.G, ChainID: ChainID, Expiry: expiry}
+	var openings [][6]bls12377_fp.Element
+	for i, t := range bidList {
+		reg := t.Tx.(zn.TxRegister)
+		dh := sessions[i]
+		dec := decBids[i]
+
+		changeCoins := new(big.Int).Sub(dec.Coins, new(big.Int).Mul(price, fills[i]))
+		outRho, outRand := zg.RandBigInt(), zg.RandBigInt()
+		changeRho, changeRand := zg.RandBigInt(), zg.RandBigInt()
+		outCm := zg.Committment(big.NewInt(0), fills[i], outRho, outRand)
+		changeCm := zg.Committment(changeCoins, dec.Energy, changeRho, changeRand)
+
+		ip.Bidders = append(ip.Bidders, zg.InputSettleBidder{
+			InCm:       reg.CmIn,
+			InSn:       zg.CalcSerialMimc(dec.SkIn, nInList[i].Rho),
+			C:          reg.AuxCipher,
+			Fill:       fills[i],
+			OutCm:      outCm,
+			ChangeCm:   changeCm,
+			EncKey:     bidKeys[i],
+			InCoin:     dec.Coins,
+			InEnergy:   dec.Energy,
+			InSk:       dec.SkIn,
+			InRho:      nInList[i].Rho,
+			InRand:     nInList[i].Rand,
+			OutRho:     outRho,
+			OutRand:    outRand,
+			ChangeRho:  changeRho,
+			ChangeRand: changeRand,
+		})
+		openings = append(openings,
+			zg.BuildEncMimc(dh.SharedSecret, dec.PK, big.NewInt(0), fills[i], outRho, outRand, outCm),
+			zg.BuildEncMimc(dh.SharedSecret, dec.PK, changeCoins, dec.Energy, changeRho, changeRand, changeCm))
+	}
+
+	payCoins := new(big.Int).Add(decSeller.Coins, new(big.Int).Mul(price, sold))
+	if fee != nil {
+		if fee.Sign() < 0 || fee.Cmp(payCoins) > 0 {
+			return zn.TxSettlePayload{}, fmt.Errorf("fee %v exceeds the seller proceeds %v", fee, payCoins)
+		}
+		payCoins.Sub(payCoins, fee)
+	}
+	unsold := new(big.Int).Sub(decSeller.Energy, sold)
+	payRho, payRand := zg.RandBigInt(), zg.RandBigInt()
+	changeRho, changeRand := zg.RandBigInt(), zg.RandBigInt()
+	payCm := zg.Committment(payCoins, big.NewInt(0), payRho, payRand)
+	unsoldCm := zg.Committment(big.NewInt(0), unsold, changeRho, changeRand)
+	ip.Seller = zg.InputSettleSeller{
+		InCm:        sellerNote.Cm,
+		InSn:        zg.CalcSerialMimc(decSeller.SkIn, sellerNote.Rho),
+		C:           txSeller.AuxCipher,
+		Sold:        sold,
+		OutPayCm:    payCm,
+		OutChangeCm: unsoldCm,
+		G_b:         sellerDH.PartnerPublic,
+		G_r:         sellerDH.EphemeralPublic,
+		InCoin:      decSeller.Coins,
+		InEnergy:    decSeller.Energy,
+		InSk:        decSeller.SkIn,
+		InRho:       sellerNote.Rho,
+		InRand:      sellerNote.Rand,
+		PayRho:      payRho,
+		PayRand:     payRand,
+		ChangeRho:   changeRho,
+		ChangeRand:  changeRand,
+		EncKey:      sellerDH.SharedSecret,
+		R:           sellerDH.Secret,
+	}
+	openings = append(openings,
+		zg.BuildEncMimc(sellerDH.SharedSecret, decSeller.PK, payCoins, big.NewInt(0), payRho, payRand, payCm),
+		zg.BuildEncMimc(sellerDH.SharedSecret, decSeller.PK, big.NewInt(0), unsold, changeRho, changeRand, unsoldCm))
+
+	// 4) Preuve
+	wc, _ := ip.BuildWitness()
+	w, err := frontend.NewWitness(wc, ecc.BW6_761.ScalarField())
+	if err != nil {
+		return zn.TxSettlePayload{}, fmt.Errorf("NewWitness: %w", err)
+	}
+	proof, err := groth16.Prove(ccs, pk, w)
+	if err != nil {
+		return zn.TxSettlePayload{}, fmt.Errorf("groth16.Prove: %w", err)
+	}
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		return zn.TxSettlePayload{}, fmt.Errorf("proof.WriteTo: %w", err)
+	}
+
+	return zn.TxSettlePayload{
+		Proof:       buf.Bytes(),
+		Ip:          ip.Public(),
+		Openings:    openings,
+		Decryptions: bidParts,
+	}, nil
+}
+
+func (n *Node) Auction(validatorAddress string, round zn.RoundInfo, nInList []zg.Note, targetIdList []int, targetAddresses []string, sellerNote zg.Note, settleFee *big.Int) zn.AuctionResultN {
+	TxListTemp, AuxList, InfoBid, sellerList := round.Bids, round.Aux, round.InfoBid, round.Sellers
+
+	// Les bids sont chiffrés sous la clé du comité : on en obtient les clés
+	// auprès des membres, qui ne déchiffrent qu'un round fermé.
+	bidKeys, bidParts, err := n.RequestBidKeys(round)
+	if err != nil {
+		fmt.Printf("%s[Node %d] [Auction] Bid decryption failed: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+		return zn.AuctionResultN{}
+	}
+
+	// Sessions DH du round, partagées avec chaque bidder lors de son
+	// enregistrement. Le rôle du commissaire-priseur s'arrête avec cet appel :
+	// ses sessions du round sont effacées au retour.
+	defer n.EndRound(round.ID)
+	ids := append([]int(nil), targetIdList...)
+	for _, t := range TxListTemp {
+		ids = append(ids, t.Id)
+	}
+	sessions := make(map[int]zn.DHParams)
+	for _, id := range ids {
+		dh, ok := n.DH.Get(id, round.ID)
+		if !ok {
+			fmt.Printf("%s[Node %d] [Auction] No DH session with node %d for round %d\033[0m\n", getNodeColor(n.ID), n.ID, id, round.ID)
+			return zn.AuctionResultN{}
+		}
+		sessions[id] = dh
+	}
 
 	//Decipher Caux
 	var decCinList []*zg.DecryptedValues
 	for i := 0; i < len(TxListTemp); i++ {
 		fmt.Println("TxListTemp[i].Id=", TxListTemp[i].Id)
-		fmt.Println("n.DHExchanges[TxListTemp[i].Id]=", n.DHExchanges[TxListTemp[i].Id])
-		fmt.Println("n.DHExchanges[TxListTemp[i].Id].SharedSecret=", n.DHExchanges[TxListTemp[i].Id].SharedSecret)
-		decValues, err := zg.BuildDecMimc(n.DHExchanges[TxListTemp[i].Id].SharedSecret, AuxList[i].C)
+		decValues, err := zg.BuildDecMimc(sessions[TxListTemp[i].Id].SharedSecret, AuxList[i].C)
 		if err != nil {
 			fmt.Println("Error deciphering Caux")
 		}
@@ -3459,7 +4931,7 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 			continue
 		}
 		//fmt.Println("txRegister.EncVal = ", txRegister.EncVal)
-		decRegValues, err := zg.BuildDecRegMimc(n.DHExchanges[TxListTemp[i].Id].SharedSecret, txRegister.EncVal)
+		decRegValues, err := zg.BuildDecRegMimc(bidKeys[i], txRegister.EncVal)
 		if err != nil {
 			fmt.Println("Error deciphering Caux")
 		}
@@ -3474,7 +4946,12 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 		gammaOutList = append(gammaOutList, zg.Gamma{Coins: InfoBid[i].Gamma.Coins, Energy: InfoBid[i].Gamma.Energy})
 	}
 
+	// Les trois preuves du résultat partagent la même expiration.
+	expiry := n.TxExpiry(validatorAddress)
+
 	var inp zg.TxProverInputHighLevelDefaultNCoin
+	inp.ChainID = ChainID
+	inp.Expiry = expiry
 	var rhoNewList []*big.Int
 	var randNewList []*big.Int
 
@@ -3510,11 +4987,11 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 		//////
 
 		fmt.Println("i = ", i)
-		inp.R[i] = n.DHExchanges[targetIdList[i]].Secret
-		inp.EncKey[i] = n.DHExchanges[targetIdList[i]].SharedSecret
+		inp.R[i] = sessions[targetIdList[i]].Secret
+		inp.EncKey[i] = sessions[targetIdList[i]].SharedSecret
 		inp.G[i] = n.G
-		inp.G_b[i] = n.DHExchanges[targetIdList[i]].PartnerPublic
-		inp.G_r[i] = n.DHExchanges[targetIdList[i]].EphemeralPublic
+		inp.G_b[i] = sessions[targetIdList[i]].PartnerPublic
+		inp.G_r[i] = sessions[targetIdList[i]].EphemeralPublic
 	}
 
 	// Remplissage des paramètres globaux (communs à tous les coins) //A CHANGER!!!!
@@ -3536,10 +5013,12 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 	globalPKNCoin = append(globalPKNCoin, globalPK2Coin)
 	globalPKNCoin = append(globalPKNCoin, globalPK3Coin)
 
-	tx_out := TransactionNCoin(inp, globalCCSNCoin, globalPKNCoin, conn, n.ID, targetAddresses[0], targetIdList[0], rhoNewList, randNewList)
+	tx_out := TransactionNCoin(inp, globalCCSNCoin, globalPKNCoin, n.ID, targetAddresses[0], targetIdList[0], rhoNewList, randNewList)
 
 	// Initialisation d'une instance unique pour N coins
 	var inp_ zg.TxProverInputHighLevelFN
+	inp_.ChainID = ChainID
+	inp_.Expiry = expiry
 
 	coinCount = len(TxListTemp)
 
@@ -3626,12 +5105,12 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 		DecValArray[4] = decB
 		inp_.DecVal = append(inp_.DecVal, DecValArray)
 
-		inp_.SkT[i] = n.DHExchanges[targetIdList[i]].SharedSecret
-		inp_.R[i] = n.DHExchanges[targetIdList[i]].Secret
+		inp_.SkT[i] = bidKeys[i]
+		inp_.R[i] = sessions[targetIdList[i]].Secret
 		inp_.G[i] = n.G
-		inp_.G_b[i] = n.DHExchanges[targetIdList[i]].PartnerPublic
-		inp_.G_r[i] = n.DHExchanges[targetIdList[i]].EphemeralPublic
-		inp_.EncKey[i] = n.DHExchanges[targetIdList[i]].SharedSecret
+		inp_.G_b[i] = sessions[targetIdList[i]].PartnerPublic
+		inp_.G_r[i] = sessions[targetIdList[i]].EphemeralPublic
+		inp_.EncKey[i] = sessions[targetIdList[i]].SharedSecret
 	}
 
 	var globalCCSFN []constraint.ConstraintSystem
@@ -3647,7 +5126,26 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 	globalPKFN = append(globalPKFN, globalPKF2)
 	globalPKFN = append(globalPKFN, globalPKF3)
 
-	tx_FN := TransactionFN(inp_, globalCCSFN, globalPKFN, conn, n.ID, targetAddresses[0], targetIdList[0])
+	tx_FN := TransactionFN(inp_, globalCCSFN, globalPKFN, n.ID, targetAddresses[0], targetIdList[0])
+
+	// Règlement du round (remplissages partiels, conservation prouvée en
+	// circuit) : uniquement si ce nœud s'est enregistré comme vendeur. Sans
+	// règlement, le validateur refuse le résultat : le round expire et les
+	// notes sont remboursées.
+	var tx_Settle zn.TxSettlePayload
+	for _, t := range sellerList {
+		txSeller, ok := t.Tx.(zn.TxSellerRegister)
+		if !ok || txSeller.ID != n.ID {
+			continue
+		}
+		p, err := n.ProveSettle(TxListTemp, nInList, txSeller, sellerNote, bidKeys, bidParts, expiry, settleFee)
+		if err != nil {
+			fmt.Printf("%s[Node %d] [Auction] Settlement proof failed: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+			break
+		}
+		tx_Settle = p
+		break
+	}
 
 	txAuction := zn.AuctionResultN{
 		TxOut:    tx_out,
@@ -3658,16 +5156,21 @@ func (n *Node) Auction(validatorAddress string, TxListTemp []zn.Transaction, Aux
 		RhoNew:   rhoNewList,
 		RandNew:  randNewList,
 		//N:        2, //////////CHANGE
+		TxSettle: tx_Settle,
+		RoundID:  round.ID,
 	}
 
 	//SEND TO THE LEDGER FOR VERIFICATION
+	if tx_Settle.Proof == nil {
+		fmt.Printf("%s[Node %d] [Auction] No settlement for round %d, result not sent\033[0m\n", getNodeColor(n.ID), n.ID, round.ID)
+		return txAuction
+	}
 
-	// Packager et envoyer le message
-	msg := zn.PackMessage("auction", txAuction)
-	if err := zn.SendMessage(conn, msg); err != nil {
-		fmt.Printf("%s[Node %d] [Transaction] Error sending transaction: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
+	// Le validateur répond par l'état du round une fois le règlement accepté.
+	if _, err := zn.Call[zn.RoundInfo](context.Background(), n.RPC, validatorAddress, "auction", txAuction); err != nil {
+		fmt.Printf("%s[Node %d] [Auction] Round %d result rejected: %v\033[0m\n", getNodeColor(n.ID), n.ID, round.ID, err)
 	} else {
-		fmt.Printf("%s[Node %d] [Transaction] Transaction sent successfully for validation\033[0m\n", getNodeColor(n.ID), n.ID)
+		fmt.Printf("%s[Node %d] [Auction] Round %d settled\033[0m\n", getNodeColor(n.ID), n.ID, round.ID)
 	}
 
 	return txAuction
@@ -3685,15 +5188,56 @@ func DiffieHellmanInit(nodes []*Node) {
 }
 
 func main() {
+	// Sous-commande hors ligne : vérification d'un bundle de preuve.
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	//zn.RegisterHandler("tx", NewTransactionHandler())
 
 	mainLogger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
 
 	numNodes := flag.Int("n", 3, "Number of nodes to create")
 	basePort := flag.Int("basePort", 9000, "Base port for nodes")
+	ledgerPath := flag.String("ledger", "_ledger/ledger.log", "Validator ledger log (empty: in-memory state)")
+	blockInterval := flag.Duration("block-interval", 2*time.Second, "Interval between blocks sealed by the validator")
+	numValidators := flag.Int("validators", 1, "Number of validators (nodes 0..v-1) agreeing on blocks by BFT consensus")
+	configPath := flag.String("config", "", "JSON file describing the nodes, their roles and peers (overrides -n, -basePort and -validators)")
+	flag.Uint64Var(&ChainID, "chain-id", ChainID, "Network identifier bound into every proof")
+	flag.DurationVar(&DHSessionTTL, "dh-ttl", DHSessionTTL, "Lifetime of a Diffie-Hellman session")
+	flag.DurationVar(&RPCTimeout, "rpc-timeout", RPCTimeout, "Longest wait for the response to a request sent to another node")
+	flag.Float64Var(&MessageRate, "msg-rate", MessageRate, "Messages per second each peer may send to a node, on average")
+	flag.IntVar(&MessageBurst, "msg-burst", MessageBurst, "Largest burst of messages a peer may send to a node")
+	flag.StringVar(&DHStoreDir, "dh-dir", "", "Directory persisting the Diffie-Hellman sessions of each node (empty: in memory)")
+	flag.StringVar(&ProofDir, "proof-dir", "", "Directory receiving the proof bundle of every admitted entry, for the verify subcommand (empty: no export)")
 	flag.Parse()
 
-	mainLogger.Info().Msgf("Initializing %d nodes starting from port %d", *numNodes, *basePort)
+	// Sans -config, le réseau est celui de toujours : -n nœuds sur des ports
+	// consécutifs, les -validators premiers validateurs et le nœud 4 auctioneer.
+	cfg := DefaultConfig(*numNodes, *basePort, *numValidators)
+	if *configPath != "" {
+		var err error
+		if cfg, err = LoadConfig(*configPath); err != nil {
+			mainLogger.Fatal().Err(err).Msg("Failed to load the network configuration")
+		}
+		chainIDSet := false
+		flag.Visit(func(f *flag.Flag) { chainIDSet = chainIDSet || f.Name == "chain-id" })
+		if cfg.ChainID != 0 && !chainIDSet {
+			ChainID = cfg.ChainID
+		}
+	}
+
+	// Le ledger du validateur est rejoué avant que les nœuds n'acceptent de
+	// transactions.
+	if *ledgerPath != "" {
+		db, err := OpenLedger(*ledgerPath)
+		if err != nil {
+			mainLogger.Fatal().Err(err).Msg("Failed to open ledger")
+		}
+		LedgerDB = db
+		mainLogger.Info().Msgf("Ledger %s replayed: %d blocks, %d commitments, %d nullifiers, %d pending", *ledgerPath, db.Height(), db.NumCommitments(), db.NumNullifiers(), db.pool.Len())
+	}
+	mainLogger.Info().Msgf("Initializing %d nodes", len(cfg.Nodes))
 
 	// Compute the common G (computed once).
 	var commonG bls12377.G1Affine
@@ -3702,22 +5246,64 @@ func main() {
 		commonG = *new(bls12377.G1Affine).ScalarMultiplicationBase(gElem.BigInt(new(big.Int)))
 	}
 
-	// Create and start the nodes.
-	nodes := make([]*Node, *numNodes)
+	// Create and start the nodes. The first validator admits transactions.
+	nodes := make([]*Node, len(cfg.Nodes))
+	byID := make(map[int]*Node)
+	var validators, participants []*Node
+	var auctioneer *Node
 	var wg sync.WaitGroup
-	for i := 0; i < *numNodes; i++ {
-		port := *basePort + i
-		var node *Node
-		if i == 0 { // The first node is the validator
-			node = NewNode(port, i, commonG, true)
-		} else {
-			node = NewNode(port, i, commonG, false)
+	for i, nc := range cfg.Nodes {
+		node, err := NewNodeFromConfig(nc, commonG, nc.Role == RoleValidator && validators == nil)
+		if err != nil {
+			mainLogger.Fatal().Err(err).Msgf("Failed to create node %d", nc.ID)
 		}
 		nodes[i] = node
+		byID[nc.ID] = node
+		switch nc.Role {
+		case RoleValidator:
+			validators = append(validators, node)
+		case RoleAuctioneer:
+			if auctioneer == nil {
+				auctioneer = node
+			}
+		case RoleParticipant:
+			participants = append(participants, node)
+		}
 		wg.Add(1)
 		go node.Run(&wg)
 		time.Sleep(100 * time.Millisecond)
 	}
+	if auctioneer == nil || len(participants) < 3 {
+		mainLogger.Fatal().Msg("The simulation needs an auctioneer and at least 3 participants")
+	}
+	var auctioneers []int
+	for _, nc := range cfg.Nodes {
+		if nc.Role == RoleAuctioneer {
+			auctioneers = append(auctioneers, nc.ID)
+		}
+	}
+	for _, node := range nodes {
+		node.Auctioneers = auctioneers
+	}
+	validator := validators[0]
+
+	// Chaque nœud épingle la clé d'identité de ses pairs configurés : une
+	// connexion dont le pair ne prouve pas la possession d'une clé épinglée est
+	// refusée. Les nœuds découvrent ensuite le reste du réseau en échangeant
+	// leurs pairs, jusqu'à ce qu'aucun n'apprenne plus rien.
+	for _, a := range nodes {
+		for _, id := range cfg.PeersOf(a.ID) {
+			if _, err := a.AddPeer(byID[id].Info()); err != nil {
+				mainLogger.Fatal().Err(err).Msgf("Node %d cannot add peer %d", a.ID, id)
+			}
+		}
+	}
+	for learned := -1; learned != 0; {
+		learned = 0
+		for _, a := range nodes {
+			learned += a.DiscoverPeers()
+		}
+	}
 
 	time.Sleep(1 * time.Second)
 
@@ -3731,6 +5317,61 @@ func main() {
 	globalCCSF2, globalPKF2, globalVKF2 = zg.LoadOrGenerateKeys("f2")
 	globalCCSF3, globalPKF3, globalVKF3 = zg.LoadOrGenerateKeys("f3")
 	globalCCSDraw, globalPKDraw, globalVKDraw = zg.LoadOrGenerateKeys("draw")
+	globalCCSSellerRegister, globalPKSellerRegister, globalVKSellerRegister = zg.LoadOrGenerateKeys("sellerRegister")
+	globalCCSBidOpening, globalPKBidOpening, globalVKBidOpening = zg.LoadOrGenerateKeys("bidOpening")
+	globalCCSSettle2, globalPKSettle2, globalVKSettle2 = zg.LoadOrGenerateKeys("settle2")
+	globalCCSSettle3, globalPKSettle3, globalVKSettle3 = zg.LoadOrGenerateKeys("settle3")
+	globalCCSFee, globalPKFee, globalVKFee = zg.LoadOrGenerateKeys("fee")
+
+	// Un validateur unique scelle seul les transactions acceptées en blocs ;
+	// plusieurs validateurs s'accordent sur les blocs par consensus : seul le
+	// premier admet des transactions, que les autres
+	// rejouent sur leur propre ledger. Les frais d'un bloc reviennent à son
+	// proposeur, dans une note prouvée par le circuit "fee". Faute de
+	// transactions, un bloc vide est produit à chaque intervalle pour que
+	// l'heure de la chaîne, qui fixe l'état des rounds, continue d'avancer.
+	LedgerDB.Heartbeat = *blockInterval
+	if len(validators) > 1 {
+		// Chaque validateur rejoue son propre ledger : à côté de -ledger, un
+		// fichier par nœud suivant le premier.
+		ledgers := []*Ledger{LedgerDB}
+		for _, v := range validators[1:] {
+			ledger := NewMemoryLedger()
+			if *ledgerPath != "" {
+				path := strings.TrimSuffix(*ledgerPath, filepath.Ext(*ledgerPath)) + fmt.Sprintf("-%d", v.ID) + filepath.Ext(*ledgerPath)
+				db, err := OpenReplicaLedger(path)
+				if err != nil {
+					mainLogger.Fatal().Err(err).Msgf("Failed to open the ledger of validator %d", v.ID)
+				}
+				ledger = db
+			}
+			ledger.Heartbeat = *blockInterval
+			ledgers = append(ledgers, ledger)
+		}
+		if err := JoinConsensus(validators, ledgers, TCPTransport{}); err != nil {
+			mainLogger.Fatal().Err(err).Msg("Failed to start consensus")
+		}
+		mainLogger.Info().Msgf("%d validators running BFT consensus", len(validators))
+	} else {
+		LedgerDB.Fees = validator
+		go LedgerDB.ProduceBlocks(*blockInterval, mainLogger)
+	}
+
+	// Les preuves des entrées admises sont exportées avant d'être relayées.
+	if ProofDir != "" {
+		if err := os.MkdirAll(ProofDir, 0755); err != nil {
+			mainLogger.Fatal().Err(err).Msg("Failed to create the proof directory")
+		}
+		relay := LedgerDB.OnAppend
+		LedgerDB.OnAppend = func(payload []byte) {
+			if err := exportProofs(ProofDir, payload); err != nil {
+				mainLogger.Warn().Err(err).Msg("Failed to export the proofs of an entry")
+			}
+			if relay != nil {
+				relay(payload)
+			}
+		}
+	}
 
 	n := len(nodes)
 	for i := 0; i < n; i++ {
@@ -3738,7 +5379,7 @@ func main() {
 			nodes[i].DiffieHellmanKeyExchange(nodes[j].Address)
 			nodes[j].DiffieHellmanKeyExchange(nodes[i].Address)
 		}
-		nodes[i].DiffieHellmanKeyExchange(nodes[0].Address)
+		nodes[i].DiffieHellmanKeyExchange(validator.Address)
 	}
 
 	//return
@@ -3747,16 +5388,68 @@ func main() {
 	//time.Sleep(1 * time.Second)
 	fmt.Println("Waiting for all nodes to finish DH exchanges...")
 	for {
-		if len(nodes[1].DHExchanges) == max && len(nodes[2].DHExchanges) == max && len(nodes[3].DHExchanges) == max {
+		if participants[0].DH.Len() == max && participants[1].DH.Len() == max && participants[2].DH.Len() == max {
 			break
 		}
-		fmt.Println("len(nodes[1].DHExchanges)=", len(nodes[1].DHExchanges))
-		fmt.Println("len(nodes[2].DHExchanges)=", len(nodes[2].DHExchanges))
-		fmt.Println("len(nodes[3].DHExchanges)=", len(nodes[3].DHExchanges))
+		fmt.Println("participants[0].DH.Len()=", participants[0].DH.Len())
+		fmt.Println("participants[1].DH.Len()=", participants[1].DH.Len())
+		fmt.Println("participants[2].DH.Len()=", participants[2].DH.Len())
 		time.Sleep(1 * time.Second)
 	}
 	fmt.Println("All nodes finished DH exchanges.")
 
+	// Comité de déchiffrement des bids : les trois premiers participants, 2
+	// parts sur 3. Le validateur et l'auctioneer observent la DKG pour
+	// connaître la clé du comité et vérifier les déchiffrements partiels ; eux
+	// seuls peuvent demander le déchiffrement d'un round.
+	members := participants[:3]
+	committee := zn.CommitteeConfig{
+		Members:    []int{members[0].ID, members[1].ID, members[2].ID},
+		Addresses:  []string{members[0].Address, members[1].Address, members[2].Address},
+		Threshold:  2,
+		Validator:  validator.Address,
+		Requesters: []int{auctioneer.ID, validator.ID},
+		Observers:  []string{validator.Address, auctioneer.Address},
+	}
+	for _, node := range nodes {
+		node.JoinCommittee(committee)
+	}
+	for _, m := range members {
+		if err := m.DealDKG(); err != nil {
+			mainLogger.Fatal().Err(err).Msgf("Node %d failed to deal its DKG share", m.ID)
+		}
+	}
+	// Un dealer qui ne s'est pas qualifié (échos d'un quorum de membres,
+	// plaintes justifiées) à l'échéance est disqualifié : la clé du comité est
+	// celle des dealers qualifiés.
+	fmt.Println("Waiting for the committee key generation...")
+	dkgDeadline := time.Now().Add(30 * time.Second)
+	dkgNodes := append(append([]*Node(nil), members...), validator, auctioneer)
+	for closed := false; ; {
+		ready := true
+		for _, node := range dkgNodes {
+			ready = ready && node.Committee.Ready()
+		}
+		if ready {
+			break
+		}
+		if closed {
+			mainLogger.Fatal().Msg("No dealer qualified for the committee key")
+		}
+		if time.Now().After(dkgDeadline) {
+			for _, node := range dkgNodes {
+				node.Committee.Close()
+			}
+			closed = true
+			continue
+		}
+		time.Sleep(1 * time.Second)
+	}
+	for dealer, err := range validator.Committee.Disqualified() {
+		mainLogger.Warn().Err(err).Msgf("Dealer %d disqualified", dealer)
+	}
+	fmt.Println("Committee key generated.")
+
 	// node1Notes := createNodeNotes(13, 2, 13, 2, 13)
 	// node2Notes := createNodeNotes(15, 1, 15, 1, 15)
 	// node3Notes := createNodeNotes(15, 1, 15, 1, 15)
@@ -3810,19 +5503,31 @@ func main() {
 	targetIdList := make([]int, K)
 	targetAddresses := make([]string, K)
 	for i := 0; i < K; i++ {
-		// On suppose que les nœuds concernés se trouvent dans nodes[1] à nodes[K]
+		// On suppose que les nœuds concernés sont participants[0] à participants[K-1]
 		nInList[i] = nodeNotesList[i].NIn
-		targetIdList[i] = nodes[i+1].ID
-		targetAddresses[i] = nodes[i+1].Address
+		targetIdList[i] = participants[i].ID
+		targetAddresses[i] = participants[i].Address
+	}
+
+	// Le nœud 4 ouvre un round auprès du validateur ; les enregistrements
+	// arrivés après l'échéance sont refusés. Sans règlement dans les 10 minutes
+	// qui suivent, les notes verrouillées peuvent être remboursées
+	// (SendTransactionRefund). Un règlement accepté peut être contesté
+	// pendant 5 minutes (SendChallenge).
+	challengeWindow := 5 * time.Minute
+	round, err := auctioneer.OpenRound(validator.Address, 2*time.Minute, 10*time.Minute, challengeWindow)
+	if err != nil {
+		mainLogger.Fatal().Err(err).Msg("Failed to open auction round")
 	}
 
 	// Maintenant, pour envoyer les transactions d'enregistrement, on boucle sur ces K notes.
-	// On utilise par exemple nodes[1] à nodes[K] pour envoyer les transactions.
+	// On utilise par exemple participants[0] à participants[K-1] pour envoyer les transactions.
 	for i, nn := range nodeNotesList {
-		err := nodes[i+1].SendTransactionRegisterN(
-			nodes[0].Address, // adresse du validateur (par exemple)
-			nodes[4].Address, // adresse de la cible (peut-être le ledger)
-			nodes[4].ID,      // ID de la cible
+		err := participants[i].SendTransactionRegisterN(
+			validator.Address,  // adresse du validateur (par exemple)
+			auctioneer.Address, // adresse de la cible (peut-être le ledger)
+			auctioneer.ID,      // ID de la cible
+			round.ID,           // round d'enchères
 			globalCCSOneCoin, globalPKOneCoin, globalVKOneCoin,
 			globalCCSRegister, globalPKRegister, globalVKRegister,
 			nn.NBase,     // OldNote
@@ -3836,17 +5541,36 @@ func main() {
 			true,         // kind (si c'est un one-coin transaction)
 		)
 		if err != nil {
-			fmt.Printf("Erreur lors de l'envoi de la transaction pour le nœud %d: %v\n", nodes[i+1].ID, err)
+			fmt.Printf("Erreur lors de l'envoi de la transaction pour le nœud %d: %v\n", participants[i].ID, err)
 		}
 	}
 
-	time.Sleep(6 * time.Second)
+	// Le nœud 4 (cible des enchères) met en vente 10 unités d'énergie avec un
+	// prix de réserve de 3, chiffré sous la clé DH partagée avec le validateur.
+	sellerNotes := createNodeNotes(0, 10, 0, 10, 3)
+	if err := auctioneer.SendTransactionSellerRegister(
+		validator.Address, validator.ID, round.ID,
+		globalCCSOneCoin, globalPKOneCoin,
+		globalCCSSellerRegister, globalPKSellerRegister,
+		sellerNotes.NBase, sellerNotes.SkBase, sellerNotes.PkIn, sellerNotes.NIn.Value,
+		sellerNotes.PkOut, sellerNotes.SkIn,
+		sellerNotes.Bid, // prix de réserve
+		sellerNotes.NIn,
+	); err != nil {
+		fmt.Printf("Erreur lors de l'enregistrement du vendeur %d: %v\n", auctioneer.ID, err)
+	}
+
+	time.Sleep(time.Until(round.Deadline))
 
 	/////////////////
 	///////Auction phase
 	/////////////////
 
-	nodes[4].Auction(nodes[0].Address, TxListTemp, AuxList, nInList, targetIdList, targetAddresses)
+	round, err = auctioneer.GetRound(validator.Address, round.ID)
+	if err != nil {
+		mainLogger.Fatal().Err(err).Msg("Failed to fetch auction round")
+	}
+	result := auctioneer.Auction(validator.Address, round, nInList, targetIdList, targetAddresses, sellerNotes.NIn, big.NewInt(0))
 
 	/////////////////
 	///////Draw test
@@ -3910,58 +5634,72 @@ func main() {
 
 	////
 
-	// Pour chaque note de nodeNotesList, on exécute l'opération de withdraw.
-	for idx := 0; idx < len(nodeNotesList); idx++ {
-		// Récupération des valeurs pour la note d'indice idx
-		SkIn := nodeNotesList[idx].SkIn
-		B := nodeNotesList[idx].Bid.Bytes()
-
-		NInCoins := nodeNotesList[idx].NBase.Value.Coins.Bytes()
-		NInEnergy := nodeNotesList[idx].NBase.Value.Energy.Bytes()
-		NInPkIn := nodeNotesList[idx].PkBase
-		NInRhoIn := nodeNotesList[idx].NBase.Rho
-		NInRIn := nodeNotesList[idx].NBase.Rand
-		NInCmIn := nodeNotesList[idx].NBase.Cm
-
-		NOutCoins := nodeNotesList[idx].NIn.Value.Coins.Bytes() // ici, pour simplifier, on réutilise la même valeur
-		NOutEnergy := nodeNotesList[idx].NIn.Value.Energy.Bytes()
-		NOutPkOut := nodeNotesList[idx].PkIn
-		NOutRhoOut := nodeNotesList[idx].NIn.Rho
-		NOutROut := nodeNotesList[idx].NIn.Rand
-		NOutCmOut := nodeNotesList[idx].NIn.Cm
-
-		// Récupération du secret partagé (PkT)
-		// Ici, on suppose que le validateur est le noeud d'indice 0
-		// et que chaque note possède un identifiant (par exemple, nodeNotesList[idx].ID)
-		// utilisé pour accéder à l'échange Diffie–Hellman.
-		PkT := nodes[idx].DHExchanges[nodes[4].ID].SharedSecret
-
-		// Calcul du serial number snIn hors-circuit
-		SnIn := zg.CalcSerialMimc(SkIn, NInRhoIn)
-
-		// Chiffrement auxiliaire via BuildEncWithdrawMimc.
-		// Ici, on suppose que la fonction BuildEncWithdrawMimc prend en paramètres :
-		// (NOutPkOut, SkIn, B, PkT) et retourne un tableau de 3 éléments.
-		CipherAux := zg.BuildEncWithdrawMimc(NOutPkOut, SkIn, B, PkT)
-		var CipherAuxBytes [3][]byte
-		for j := 0; j < 3; j++ {
-			temp := CipherAux[j].Bytes()
-			CipherAuxBytes[j] = temp[:]
+	// Une fois la fenêtre de contestation du règlement close, chaque bidder
+	// retire l'énergie Fill de la note livrée OutCm, dont il déchiffre
+	// l'ouverture dans le règlement ; sa note enregistrée NIn a déjà été
+	// dépensée par le règlement, qui lui a rendu sa monnaie (ChangeCm).
+	go func() {
+		if result.TxSettle.Proof == nil {
+			return
 		}
+		time.Sleep(challengeWindow)
+		settlement := result.TxSettle.Ip
+		for idx := 0; idx < len(nodeNotesList); idx++ {
+			nn := nodeNotesList[idx]
+			bidder := -1
+			for i, b := range settlement.Bidders {
+				if bytes.Equal(b.InCm, nn.NIn.Cm) {
+					bidder = i
+					break
+				}
+			}
+			if bidder < 0 || settlement.Bidders[bidder].Fill.Sign() == 0 {
+				continue
+			}
 
-		// Appel de la fonction Draw avec l'ensemble des paramètres pour cette note.
-		// La fonction Draw (que vous aurez implémentée dans Node) devra accepter ces paramètres.
+			// Clé DH partagée avec le commissaire-priseur (PkT), qui chiffre
+			// aussi l'ouverture de la note livrée
+			dh, _ := participants[idx].DH.Get(auctioneer.ID, round.ID)
+			PkT := dh.SharedSecret
+			delivered, err := zg.BuildDecMimc(dh.SharedSecret, result.TxSettle.Openings[2*bidder])
+			if err != nil || !bytes.Equal(delivered.Cm, settlement.Bidders[bidder].OutCm) {
+				mainLogger.Error().Msgf("Node %d cannot open its delivered note", participants[idx].ID)
+				continue
+			}
 
-		// Draw(SnIn, NOutCmOut, PkT, CipherAuxBytes, SkIn, B,
-		// 	NInCoins, NInEnergy, NInPkIn, NInRhoIn, NInRIn, NInCmIn,
-		// 	NOutCoins, NOutEnergy, NOutPkOut, NOutRhoOut, NOutROut, NOutCmOut)
-		nodes[idx].Draw(nodes[0].Address, nodes[4].ID, nodes[4].Address, nodeNotesList[idx].NIn, nodeNotesList[idx].NBase, SnIn, NOutCmOut, PkT, CipherAuxBytes, SkIn, B,
-			NInCoins, NInEnergy, NInPkIn, NInRhoIn, NInRIn, NInCmIn,
-			NOutCoins, NOutEnergy, NOutPkOut, NOutRhoOut, NOutROut, NOutCmOut)
-	}
+			// La note livrée appartient à pkOut ; elle passe, sans frais,
+			// dans une nouvelle note de même valeur.
+			Fee := big.NewInt(0).Bytes()
+			rhoOut := big.NewInt(3333)
+			randOut := big.NewInt(4444)
+			CmOut := zg.Committment(delivered.Coins, delivered.Energy, rhoOut, randOut)
+
+			// Calcul du serial number snIn hors-circuit
+			SnIn := zg.CalcSerialMimc(nn.SkOut, delivered.Rho.Bytes())
+
+			// Chiffrement auxiliaire (pkOut, skIn, b) sous PkT
+			CipherAux := zg.BuildEncWithdrawMimc(nn.PkOut, nn.SkOut, nn.Bid.Bytes(), PkT)
+			var CipherAuxBytes [3][]byte
+			for j := 0; j < 3; j++ {
+				temp := CipherAux[j].Bytes()
+				CipherAuxBytes[j] = temp[:]
+			}
 
+			participants[idx].Draw(validator.Address, auctioneer.ID, auctioneer.Address, round.ID, nn.NIn, nn.NIn, SnIn, CmOut, PkT, CipherAuxBytes, nn.SkOut, nn.Bid.Bytes(), delivered.Energy.Bytes(), Fee,
+				delivered.Coins.Bytes(), delivered.Energy.Bytes(), nn.PkOut, delivered.Rho.Bytes(), delivered.Rand.Bytes(), delivered.Cm,
+				delivered.Coins.Bytes(), delivered.Energy.Bytes(), nn.PkOut, rhoOut.Bytes(), randOut.Bytes())
+		}
+	}()
 	////
 
+	// Un nœud vérifie, en client léger, le chaînage des blocs du validateur.
+	time.Sleep(*blockInterval)
+	if height, err := participants[0].SyncHeaders(validator.Address); err != nil {
+		mainLogger.Error().Err(err).Msg("Header chain rejected")
+	} else {
+		mainLogger.Info().Msgf("Node %d verified %d block headers", participants[0].ID, height)
+	}
+
 	mainLogger.Info().Msg("All nodes are operational. Press Ctrl+C to stop.")
 	select {}
 }
@@ -3989,170 +5727,23 @@ func main() {
     NOutCmOut  []byte
 */
 
-func (n *Node) Draw(validatorAddress string, targetID int, targetAddress string, nIn zg.Note, nOut zg.Note, SnIn []byte, CmOut []byte, pkT bls12377.G1Affine, CipherAux [3][]byte, SkIn []byte, B []byte, NInCoins []byte, NInEnergy []byte, NInPkIn []byte, NInRhoIn []byte, NInRIn []byte, NInCmIn []byte, NOutCoins []byte, NOutEnergy []byte, NOutPkOut []byte, NOutRhoOut []byte, NOutROut []byte, NOutCmOut []byte) error {
+func (n *Node) Draw(validatorAddress string, targetID int, targetAddress string, roundID int, nIn zg.Note, nOut zg.Note, SnIn []byte, CmOut []byte, pkT bls12377.G1Affine, CipherAux [3][]byte, SkIn []byte, B []byte, Fill []byte, Fee []byte, NInCoins []byte, NInEnergy []byte, NInPkIn []byte, NInRhoIn []byte, NInRIn []byte, NInCmIn []byte, NOutCoins []byte, NOutEnergy []byte, NOutPkOut []byte, NOutRhoOut []byte, NOutROut []byte) error {
 
-	//Transaction proof
 	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
 
-	// Établir la connexion TCP
-	conn, err := net.Dial("tcp", validatorAddress)
-	if err != nil {
-		logger.Error().Err(err).Msgf("%s[Node %d] [Transaction] Error dialing %s\033[0m", getNodeColor(n.ID), n.ID, validatorAddress)
-		return err
-	}
-	defer conn.Close()
-
-	//c.PkOut, c.SkIn, c.Bid, c.GammaInCoins, c.GammaInEnergy, c.EncKey)
-
-	NInCoinsInt := new(big.Int).SetBytes(NInCoins)
-	NInEnergyInt := new(big.Int).SetBytes(NInEnergy)
-	gammaIn := zg.Gamma{Coins: NInCoinsInt, Energy: NInEnergyInt}
-	bid := new(big.Int).SetBytes(B)
-
-	// Construction de l'input de la preuve pour une transaction one coin
-	inp := zg.TxProverInputHighLevelDefaultOneCoin{
-		OldNote: nIn,
-		OldSk:   SkIn,
-		NewVal:  gammaIn,
-		NewPk:   NOutPkOut,
-		EncKey:  n.DHExchanges[targetID].SharedSecret,
-		R:       n.DHExchanges[targetID].Secret,
-		// B:      b_bytes[:], // à décommenter et définir si nécessaire
-		G:   n.G,
-		G_b: n.DHExchanges[targetID].PartnerPublic,
-		G_r: n.DHExchanges[targetID].EphemeralPublic,
-	}
-
-	// Appel de la fonction de chiffrement avec les paramètres requis
-	encVal := zg.BuildEncRegMimc(inp.EncKey, gammaIn, NOutPkOut, SkIn, bid)
-
-	//decVal, _ := zg.BuildDecRegMimc(inp.EncKey, encVal)
-
-	// Extraction et copie des différents éléments chiffrés
-	pk_enc := encVal[0].Bytes()
-	pk_enc_bytes := make([]byte, len(pk_enc))
-	copy(pk_enc_bytes, pk_enc[:])
-
-	coins_enc := encVal[3].Bytes()
-	coins_enc_bytes := make([]byte, len(coins_enc))
-	copy(coins_enc_bytes, coins_enc[:])
-
-	energy_enc := encVal[4].Bytes()
-	energy_enc_bytes := make([]byte, len(energy_enc))
-	copy(energy_enc_bytes, energy_enc[:])
-
-	skIn_enc := encVal[1].Bytes()
-	skIn_enc_bytes := make([]byte, len(skIn_enc))
-	copy(skIn_enc_bytes, skIn_enc[:])
-
-	bid_enc := encVal[2].Bytes()
-	bid_enc_bytes := make([]byte, len(bid_enc))
-	copy(bid_enc_bytes, bid_enc[:])
-
-	// Construction de l'input pour la preuve d'enregistrement
-	// inp_reg := zg.TxProverInputHighLevelRegister{
-	// 	InCoin:   gammaIn.Coins.Bytes(),  //big.NewInt(12).Bytes(),
-	// 	InEnergy: gammaIn.Energy.Bytes(), //big.NewInt(5).Bytes(),
-	// 	CmIn:     nIn.Cm,
-	// 	CAux:     [5][]byte{pk_enc_bytes, skIn_enc_bytes, bid_enc_bytes, coins_enc_bytes, energy_enc_bytes},
-	// 	SkIn:     SkIn,
-	// 	PkIn:     NInPkIn,
-	// 	PkOut:    NOutPkOut,
-	// 	Bid:      bid.Bytes(),
-	// 	RhoIn:    big.NewInt(1111).Bytes(),
-	// 	RandIn:   big.NewInt(2222).Bytes(),
-	// 	InVal:    gammaIn,
-	// 	EncKey:   n.DHExchanges[targetID].SharedSecret,
-	// 	R:        n.DHExchanges[targetID].Secret,
-	// 	// B:      b_bytes[:], // à décommenter et définir si nécessaire
-	// 	G:   n.G,
-	// 	G_b: n.DHExchanges[targetID].PartnerPublic,
-	// 	G_r: n.DHExchanges[targetID].EphemeralPublic,
-	// }
-
-	/*
-		cm := zg.Committment(inp.NewVal.Coins, inp.NewVal.Energy,
-			rhoNew, randNew)
-	*/
-
-	rhoNew := zg.RandBigInt()
-	randNew := zg.RandBigInt()
-
-	// Construction de la transaction one coin
-	tx := TransactionOneCoin(inp, globalCCSOneCoin, globalPKOneCoin, conn, n.ID, targetAddress, targetID, rhoNew, randNew)
-
-	//c, _ := tx.Inp.BuildWitness()
-	wc, _ := tx.Inp.BuildWitness()
-	ww, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField())
-
-	wPub, _ := ww.Public()
-	var pubBuf bytes.Buffer
-	if _, err := wPub.WriteTo(&pubBuf); err != nil {
-		panic(err)
-	}
-
-	// 4) Generate proof
-	_ = time.Now()
-	proof_, err := groth16.Prove(globalCCSOneCoin, globalPKOneCoin, ww)
-	if err != nil {
-		panic(err)
-	}
-
-	wPub, _ = frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
-
-	//Verify proof
-	// 4) vérification
-	if err := groth16.Verify(proof_, globalVKOneCoin, wPub); err != nil {
-		fmt.Println("Verify =>", err)
-		//return false
-	}
-
-	// Encapsulation de la transaction
-	tx_encapsulated := zn.TxEncapsulated{
-		Kind:    1, // 0 pour default, 1 pour one coin
-		Payload: tx,
-	}
-
-	/////
-
-	//circuitFull, _ := inp.I.BuildWitness()
-
-	// circuitFull := tx.Circuit
-	// proof := tx.TxResult.Proof
-
-	// wPub, err := frontend.NewWitness(circuitFull, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
-	// if err != nil {
-	// 	fmt.Println("NewWitness =>", err)
-	// 	//return false
-	// }
-
-	// // 4) vérification
-	// if err := groth16.Verify(proof, vk, wPub); err != nil {
-	// 	fmt.Println("Verify =>", err)
-	// 	//return false
-	// }
-
-	/////
-
-	// Packager et envoyer le message
-	msg := zn.PackMessage("tx_draw_one_coin", tx_encapsulated)
-	if err := zn.SendMessage(conn, msg); err != nil {
-		fmt.Printf("%s[Node %d] [Transaction] Error sending transaction: %v\033[0m\n", getNodeColor(n.ID), n.ID, err)
-		return nil
-	} else {
-		fmt.Printf("%s[Node %d] [Transaction] Transaction sent successfully for validation\033[0m\n", getNodeColor(n.ID), n.ID)
-	}
-
-	//fmt.Println("transaction done!:", tx)
-
 	//Draw proof
 
 	var InputTxDraw zg.InputTxDraw
 
 	// Remplissage des champs de la transaction
 	InputTxDraw.SnIn = SnIn
+	InputTxDraw.CmIn = NInCmIn
 	InputTxDraw.CmOut = CmOut
+	InputTxDraw.Fill = Fill
+	InputTxDraw.Fee = Fee
 	InputTxDraw.PkT = pkT
+	InputTxDraw.ChainID = ChainID
+	InputTxDraw.Expiry = n.TxExpiry(validatorAddress)
 
 	for i := 0; i < 3; i++ {
 		InputTxDraw.CipherAux[i] = CipherAux[i]
@@ -4166,32 +5757,44 @@ func (n *Node) Draw(validatorAddress string, targetID int, targetAddress string,
 	InputTxDraw.NInPkIn = NInPkIn
 	InputTxDraw.NInRhoIn = NInRhoIn
 	InputTxDraw.NInRIn = NInRIn
-	InputTxDraw.NInCmIn = NInCmIn
 	InputTxDraw.NOutCoins = NOutCoins
 	InputTxDraw.NOutEnergy = NOutEnergy
 	InputTxDraw.NOutPkOut = NOutPkOut
 	InputTxDraw.NOutRhoOut = NOutRhoOut
 	InputTxDraw.NOutROut = NOutROut
-	InputTxDraw.NOutCmOut = NOutCmOut
 
 	c, _ := InputTxDraw.BuildWitness()
 
 	w, _ := frontend.NewWitness(c, ecc.BW6_761.ScalarField())
 
 	// 4) Generate proof
-	_ = time.Now()
 	proof, err := groth16.Prove(globalCCSDraw, globalPKDraw, w)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
 	var buf bytes.Buffer
-	proof.WriteTo(&buf)
-
-	fmt.Println("proof: ", buf.Bytes())
+	if _, err := proof.WriteTo(&buf); err != nil {
+		return err
+	}
 
+	// 5) Envoi au validateur, qui répond par un reçu
+	receipt, err := zn.Call[zn.DrawReceipt](context.Background(), n.RPC, validatorAddress, "tx_draw_one_coin", zn.TxDrawPayload{
+		Proof:   buf.Bytes(),
+		Ip:      InputTxDraw.Public(),
+		ID:      n.ID,
+		RoundID: roundID,
+	})
+	if err != nil {
+		logger.Error().Err(err).Msgf("%s[Node %d] [Draw] Draw request to %s failed\033[0m", getNodeColor(n.ID), n.ID, validatorAddress)
+		return err
+	}
+	if !receipt.Accepted {
+		logger.Warn().Msgf("%s[Node %d] [Draw] Draw rejected: %s\033[0m", getNodeColor(n.ID), n.ID, receipt.Reason)
+		return fmt.Errorf("draw rejected: %s", receipt.Reason)
+	}
+	logger.Info().Msgf("%s[Node %d] [Draw] Draw accepted\033[0m", getNodeColor(n.ID), n.ID)
+	n.EndRound(roundID)
 	return nil
-
 }
 
 // NodeNotes regroupe les données générées pour un nœud
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mempool defaults.
+const (
+	DefaultMempoolSize = 4096
+	DefaultMempoolTTL  = 10 * time.Minute
+)
+
+// ErrMempoolFull is returned when the mempool is full of entries paying at
+// least the fee of a new one.
+var ErrMempoolFull = errors.New("mempool full")
+
+// poolEntry is an entry of the mempool.
+type poolEntry struct {
+	pendingEntry
+	seq uint64 // admission order, breaks fee ties
+}
+
+// Mempool holds the entries verified and admitted by the validator but not
+// yet committed in a block. It rejects an entry spending a nullifier that a
+// pending entry already spends (confirmed spends are checked by the Ledger),
+// orders entries by fee for block production and evicts the ones older than
+// TTL or past their expiry height. Entries carrying a round event are never
+// evicted: the round state already reflects them. A Mempool is safe for
+// concurrent use.
+type Mempool struct {
+	mu      sync.RWMutex
+	entries map[string]*poolEntry // by ID
+	spends  map[string]string     // nullifier -> ID of the entry spending it
+	seq     uint64
+
+	MaxSize int
+	TTL     time.Duration
+}
+
+// NewMempool returns an empty mempool with the default size and TTL.
+func NewMempool() *Mempool {
+	return &Mempool{
+		entries: make(map[string]*poolEntry),
+		spends:  make(map[string]string),
+		MaxSize: DefaultMempoolSize,
+		TTL:     DefaultMempoolTTL,
+	}
+}
+
+// HasNullifier reports whether a pending entry spends sn.
+func (m *Mempool) HasNullifier(sn []byte) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.spends[string(sn)]
+	return ok
+}
+
+// Len returns the number of pending entries.
+func (m *Mempool) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+func (m *Mempool) get(id []byte) (pendingEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pe, ok := m.entries[string(id)]
+	if !ok {
+		return pendingEntry{}, false
+	}
+	return pe.pendingEntry, true
+}
+
+// check returns ErrDoubleSpend if pe spends a nullifier spent by a pending
+// entry, and otherwise the ID of the entry to evict to make room for pe, if
+// the mempool is full.
+func (m *Mempool) check(pe pendingEntry) (victim []byte, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, sn := range pe.entry.Sns {
+		if _, ok := m.spends[string(sn)]; ok {
+			return nil, ErrDoubleSpend
+		}
+	}
+	if len(m.entries) < m.MaxSize {
+		return nil, nil
+	}
+	var lowest *poolEntry
+	for _, e := range m.entries {
+		if e.entry.Round != nil {
+			continue
+		}
+		if lowest == nil || e.entry.Fee < lowest.entry.Fee || (e.entry.Fee == lowest.entry.Fee && e.seq > lowest.seq) {
+			lowest = e
+		}
+	}
+	if lowest == nil || lowest.entry.Fee >= pe.entry.Fee {
+		return nil, ErrMempoolFull
+	}
+	return lowest.id, nil
+}
+
+// add adds pe, which must have passed check.
+func (m *Mempool) add(pe pendingEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	m.entries[string(pe.id)] = &poolEntry{pendingEntry: pe, seq: m.seq}
+	for _, sn := range pe.entry.Sns {
+		m.spends[string(sn)] = string(pe.id)
+	}
+}
+
+// remove removes the entries ids, if pending.
+func (m *Mempool) remove(ids [][]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		pe, ok := m.entries[string(id)]
+		if !ok {
+			continue
+		}
+		delete(m.entries, string(id))
+		for _, sn := range pe.entry.Sns {
+			if m.spends[string(sn)] == string(id) {
+				delete(m.spends, string(sn))
+			}
+		}
+	}
+}
+
+// ordered returns the pending entries by decreasing fee, then in admission
+// order.
+func (m *Mempool) ordered() []pendingEntry {
+	m.mu.RLock()
+	sorted := make([]*poolEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		sorted = append(sorted, e)
+	}
+	m.mu.RUnlock()
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].entry.Fee != sorted[j].entry.Fee {
+			return sorted[i].entry.Fee > sorted[j].entry.Fee
+		}
+		return sorted[i].seq < sorted[j].seq
+	})
+	entries := make([]pendingEntry, len(sorted))
+	for i, e := range sorted {
+		entries[i] = e.pendingEntry
+	}
+	return entries
+}
+
+// expired returns the IDs of the entries admitted more than TTL before now or
+// that cannot be included in the block at height.
+func (m *Mempool) expired(now time.Time, height uint64) [][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ids [][]byte
+	for _, e := range m.entries {
+		if e.entry.Round == nil && (now.Sub(e.entry.Admitted) > m.TTL || e.entry.expired(height)) {
+			ids = append(ids, e.id)
+		}
+	}
+	return ids
+}
+
+// conflicting returns the IDs of the entries spending a nullifier spent in
+// store.
+func (m *Mempool) conflicting(store *NoteStore) [][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ids [][]byte
+	for _, e := range m.entries {
+		for _, sn := range e.entry.Sns {
+			if store.HasNullifier(sn) {
+				ids = append(ids, e.id)
+				break
+			}
+		}
+	}
+	return ids
+}
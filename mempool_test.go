@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// feeEntry returns an entry paying fee that spends a note of its own.
+func feeEntry(fee uint64) LedgerEntry {
+	return LedgerEntry{Sns: [][]byte{[]byte(fmt.Sprint("sn", fee))}, Fee: fee, Expiry: 10}
+}
+
+func TestMempoolFeeEviction(t *testing.T) {
+	l := NewMemoryLedger()
+	l.pool.MaxSize = 2
+	steps := []struct {
+		name string
+		e    LedgerEntry
+		want error
+		fees []uint64 // pending fees afterwards, in block order
+	}{
+		{"room", feeEntry(5), nil, []uint64{5}},
+		{"room", feeEntry(3), nil, []uint64{5, 3}},
+		{"evicts the lowest fee", feeEntry(4), nil, []uint64{5, 4}},
+		{"pays no more than the lowest", LedgerEntry{Sns: [][]byte{[]byte("other")}, Fee: 4, Expiry: 10}, ErrMempoolFull, []uint64{5, 4}},
+		{"pays less", feeEntry(1), ErrMempoolFull, []uint64{5, 4}},
+		{"evicts the lowest fee again", feeEntry(6), nil, []uint64{6, 5}},
+	}
+	for _, s := range steps {
+		if err := l.Append(s.e); err != s.want {
+			t.Fatalf("%s: err = %v, want %v", s.name, err, s.want)
+		}
+		var fees []uint64
+		for _, pe := range l.pool.ordered() {
+			fees = append(fees, pe.entry.Fee)
+		}
+		if !slices.Equal(fees, s.fees) {
+			t.Fatalf("%s: pending fees %v, want %v", s.name, fees, s.fees)
+		}
+	}
+	// An evicted entry releases its nullifier and can be admitted again.
+	if l.HasNullifier([]byte("sn3")) {
+		t.Fatal("evicted entry still spends its note")
+	}
+}
+
+func TestMempoolKeepsRoundEvents(t *testing.T) {
+	l := NewMemoryLedger()
+	l.pool.MaxSize = 1
+	if err := l.Append(LedgerEntry{Round: &RoundEvent{Kind: RoundEventOpened, ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(feeEntry(100)); err != ErrMempoolFull {
+		t.Fatalf("err = %v, want ErrMempoolFull: round events are never evicted", err)
+	}
+}
+
+func TestMempoolExpiry(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		e       LedgerEntry
+		evicted bool
+	}{
+		{"fresh", LedgerEntry{Admitted: now, Expiry: 5}, false},
+		{"older than TTL", LedgerEntry{Admitted: now.Add(-DefaultMempoolTTL - time.Second), Expiry: 5}, true},
+		{"past its expiry height", LedgerEntry{Admitted: now, Expiry: 2}, true},
+		{"round event older than TTL", LedgerEntry{Admitted: now.Add(-2 * DefaultMempoolTTL), Round: &RoundEvent{ID: 1}}, false},
+	}
+	for _, tt := range tests {
+		m := NewMempool()
+		m.add(pendingEntry{id: []byte(tt.name), entry: tt.e})
+		if evicted := len(m.expired(now, 3)) == 1; evicted != tt.evicted {
+			t.Errorf("%s: evicted %v, want %v", tt.name, evicted, tt.evicted)
+		}
+	}
+}
+
+func TestEvictExpired(t *testing.T) {
+	l := NewMemoryLedger()
+	old := feeEntry(1)
+	old.Admitted = time.Now().Add(-DefaultMempoolTTL - time.Second)
+	for _, e := range []LedgerEntry{old, feeEntry(2)} {
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := l.EvictExpired(time.Now())
+	if err != nil || n != 1 {
+		t.Fatalf("evicted %d entries (%v), want 1", n, err)
+	}
+	if l.HasNullifier([]byte("sn1")) || !l.HasNullifier([]byte("sn2")) {
+		t.Fatal("wrong entry evicted")
+	}
+	// The same note can be spent again once the entry has been evicted.
+	if err := l.Append(feeEntry(1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	zn "zerocash_gnark/zerocash_network"
+)
+
+// PeerTable is the directory of the nodes a node knows about, itself
+// included. A PeerTable is safe for concurrent use.
+type PeerTable struct {
+	mu    sync.RWMutex
+	peers map[int]zn.PeerInfo
+}
+
+// NewPeerTable returns a table holding only self.
+func NewPeerTable(self zn.PeerInfo) *PeerTable {
+	return &PeerTable{peers: map[int]zn.PeerInfo{self.ID: self}}
+}
+
+// Get returns the peer id.
+func (t *PeerTable) Get(id int) (zn.PeerInfo, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	p, ok := t.peers[id]
+	return p, ok
+}
+
+// List returns the known peers sorted by ID.
+func (t *PeerTable) List() []zn.PeerInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	peers := make([]zn.PeerInfo, 0, len(t.peers))
+	for _, p := range t.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// ByRole returns the known peers with the given role, sorted by ID.
+func (t *PeerTable) ByRole(role string) []zn.PeerInfo {
+	var peers []zn.PeerInfo
+	for _, p := range t.List() {
+		if p.Role == role {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// set records p. It must be called with t.mu held.
+func (t *PeerTable) set(p zn.PeerInfo) {
+	p.PublicKey = append([]byte(nil), p.PublicKey...)
+	p.Signature = append([]byte(nil), p.Signature...)
+	t.peers[p.ID] = p
+}
+
+// selfInfo returns the entry of the node of identity id, signed by it.
+func selfInfo(id *zn.Identity, address, role string) zn.PeerInfo {
+	p := zn.PeerInfo{Address: address, Role: role}
+	p.Sign(id)
+	return p
+}
+
+// Info returns the entry of n in peer tables.
+func (n *Node) Info() zn.PeerInfo {
+	p, _ := n.Peers.Get(n.ID)
+	return p
+}
+
+// AddPeer records p and pins its identity key. It is meant for the bootstrap
+// peers of the configuration, which n trusts as given; entries learned from
+// other nodes go through mergePeers. The key of a known peer is never
+// replaced: an entry announcing another key for it is rejected, while its
+// address and role are updated.
+func (n *Node) AddPeer(p zn.PeerInfo) (bool, error) {
+	if p.ID == n.ID {
+		return false, nil
+	}
+	if len(p.PublicKey) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("peer %d: invalid identity key", p.ID)
+	}
+	n.Peers.mu.Lock()
+	defer n.Peers.mu.Unlock()
+	pinned, ok := n.Identity.PeerKey(p.ID)
+	if ok && !bytes.Equal(pinned, p.PublicKey) {
+		return false, fmt.Errorf("peer %d: identity key differs from the pinned one", p.ID)
+	}
+	_, known := n.Peers.peers[p.ID]
+	n.Peers.set(p)
+	n.Identity.Trust(p.ID, ed25519.PublicKey(p.PublicKey))
+	return !known, nil
+}
+
+// mergePeers adds the peers announced by from and returns how many were new.
+// Only entries signed by the node they describe are accepted (see
+// zn.PeerInfo.Check): from relays the entries of other nodes but cannot
+// assert their key or role. A known peer is only updated by its own entry,
+// received from it directly, so that a relayed stale entry cannot roll back
+// its address, and its pinned key is never replaced (see AddPeer).
+func (n *Node) mergePeers(from int, peers []zn.PeerInfo) int {
+	added := 0
+	for _, p := range peers {
+		if _, known := n.Peers.Get(p.ID); known && p.ID != from {
+			continue
+		}
+		if err := p.Check(); err != nil {
+			n.logger.Warn().Err(err).Msgf("%s[Node %d] [Peers] Entry from node %d ignored\033[0m", getNodeColor(n.ID), n.ID, from)
+			continue
+		}
+		isNew, err := n.AddPeer(p)
+		if err != nil {
+			n.logger.Warn().Err(err).Msgf("%s[Node %d] [Peers] Entry from node %d ignored\033[0m", getNodeColor(n.ID), n.ID, from)
+			continue
+		}
+		if isNew {
+			added++
+		}
+	}
+	return added
+}
+
+// ExchangePeers sends the peers n knows to the node at address and merges the
+// peers it knows in return. It returns how many peers n learned.
+func (n *Node) ExchangePeers(address string) (int, error) {
+	ctx := context.Background()
+	peer, err := n.RPC.Peer(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := zn.Call[zn.PeersPayload](ctx, n.RPC, address, zn.PeersGetMsg, zn.PeersPayload{Peers: n.Peers.List()})
+	if err != nil {
+		return 0, err
+	}
+	return n.mergePeers(peer, payload.Peers), nil
+}
+
+// DiscoverPeers exchanges peers with every known peer until a pass teaches n
+// nothing new, and returns the number of peers learned.
+func (n *Node) DiscoverPeers() int {
+	total := 0
+	for {
+		added := 0
+		for _, p := range n.Peers.List() {
+			if p.ID == n.ID {
+				continue
+			}
+			k, err := n.ExchangePeers(p.Address)
+			if err != nil {
+				n.logger.Warn().Err(err).Msgf("%s[Node %d] [Peers] Exchange with node %d failed\033[0m", getNodeColor(n.ID), n.ID, p.ID)
+				continue
+			}
+			added += k
+		}
+		total += added
+		if added == 0 {
+			return total
+		}
+	}
+}
+
+// PeerHandler answers "peers_get" messages.
+type PeerHandler struct {
+	Node *Node
+}
+
+// NewPeerHandler creates a new peer exchange handler.
+func NewPeerHandler(node *Node) *PeerHandler {
+	return &PeerHandler{Node: node}
+}
+
+// HandleMessage merges the peers announced by the authenticated sender and
+// replies with the peers the node knows.
+func (ph *PeerHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	n := ph.Node
+	req, ok := msg.Payload.(zn.PeersPayload)
+	if !ok {
+		n.logger.Warn().Msgf("%s[Node %d] [Peers] Payload is not PeersPayload\033[0m", getNodeColor(n.ID), n.ID)
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a PeersPayload")
+		return
+	}
+	from, _ := zn.PeerID(conn)
+	if added := n.mergePeers(from, req.Peers); added > 0 {
+		n.logger.Info().Msgf("%s[Node %d] [Peers] Learned %d peers from node %d\033[0m", getNodeColor(n.ID), n.ID, added, from)
+	}
+	if err := zn.Reply(conn, msg, zn.PeersMsg, zn.PeersPayload{Peers: n.Peers.List()}); err != nil {
+		n.logger.Warn().Err(err).Msgf("%s[Node %d] [Peers] Error replying to node %d\033[0m", getNodeColor(n.ID), n.ID, from)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+	zn "zerocash_gnark/zerocash_network"
+
+	"github.com/rs/zerolog"
+)
+
+// -------------------------------
+// AuctionRound
+// -------------------------------
+
+var (
+	ErrUnknownRound    = errors.New("unknown auction round")
+	ErrRoundClosed     = errors.New("auction round is closed to registrations")
+	ErrRoundNotClosed  = errors.New("auction round is not closed")
+	ErrRoundSettled    = errors.New("auction round is already settled")
+	ErrRoundExpired    = errors.New("auction round expired without settlement")
+	ErrRoundNotExpired = errors.New("auction round has not expired")
+	ErrNoteNotLocked   = errors.New("note is not locked in this round")
+	ErrNoteRefunded    = errors.New("note is already refunded")
+	ErrRoundNotSettled = errors.New("auction round is not settled")
+	ErrChallengeClosed = errors.New("challenge window is closed")
+	ErrChallengeOpen   = errors.New("settlement can still be challenged")
+	ErrNoAllocation    = errors.New("note is not an allocation of this round")
+	ErrNotAllocated    = errors.New("draw does not match the allocation")
+	ErrAlreadyDrawn    = errors.New("allocation is already drawn")
+)
+
+// AuctionRound holds the registrations of one auction round on the validator.
+// A round is open until Deadline, closed once the deadline has passed, and
+// settled once the auctioneer's settlement has been accepted. A round that is
+// not settled by SettleBy expires: it can no longer be settled and its locked
+// notes can be refunded instead. A settlement can be challenged for
+// ChallengeWindow; a successful challenge reverts it and the round becomes
+// disputed, which also opens refunds.
+//
+// Deadlines are checked against the chain time (see Ledger.Now), not the
+// local clock, so that every validator agrees on the state of a round.
+type AuctionRound struct {
+	ID              int
+	Deadline        time.Time
+	SettleBy        time.Time
+	ChallengeWindow time.Duration
+
+	clock   func() time.Time // chain time
+	mu      sync.Mutex
+	state   zn.RoundState
+	bids    []zn.Transaction
+	aux     []zn.AuxList
+	infoBid []zn.InfoBid
+	cmTemp  [][]byte
+	sellers []zn.Transaction
+	// refunded holds the CmIn of the notes already refunded.
+	refunded map[string]bool
+	// drawn holds the OutCm of the allocations already drawn.
+	drawn map[string]bool
+	// settlement is the accepted settlement, challengeable until challengeBy.
+	settlement  zn.TxSettlePayload
+	challengeBy time.Time
+}
+
+// stateLocked closes the round once its deadline has passed and expires it
+// once SettleBy has passed without a settlement. r.mu must be held.
+func (r *AuctionRound) stateLocked(now time.Time) zn.RoundState {
+	if r.state == zn.RoundOpen && !now.Before(r.Deadline) {
+		r.state = zn.RoundClosed
+	}
+	if r.state == zn.RoundClosed && !now.Before(r.SettleBy) {
+		r.state = zn.RoundExpired
+	}
+	return r.state
+}
+
+// State returns the current state of the round.
+func (r *AuctionRound) State() zn.RoundState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stateLocked(r.clock())
+}
+
+// AcceptsAt reports whether a registration received at chain time t is on
+// time.
+func (r *AuctionRound) AcceptsAt(t time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state == zn.RoundOpen && t.Before(r.Deadline)
+}
+
+// AddBid runs apply and records a validated bidder registration received at
+// chain time t if it succeeds.
+func (r *AuctionRound) AddBid(t time.Time, tx zn.Transaction, aux zn.AuxList, info zn.InfoBid, cm []byte, apply func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != zn.RoundOpen || !t.Before(r.Deadline) {
+		return ErrRoundClosed
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	r.bids = append(r.bids, tx)
+	r.aux = append(r.aux, aux)
+	r.infoBid = append(r.infoBid, info)
+	r.cmTemp = append(r.cmTemp, cm)
+	return nil
+}
+
+// AddSeller runs apply and records a validated seller registration received
+// at chain time t if it succeeds.
+func (r *AuctionRound) AddSeller(t time.Time, tx zn.Transaction, cm []byte, apply func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != zn.RoundOpen || !t.Before(r.Deadline) {
+		return ErrRoundClosed
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	r.sellers = append(r.sellers, tx)
+	r.cmTemp = append(r.cmTemp, cm)
+	return nil
+}
+
+// Close stops registrations before the deadline.
+func (r *AuctionRound) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == zn.RoundOpen {
+		r.state = zn.RoundClosed
+	}
+}
+
+// Settle runs apply with the time of the settlement, from which its challenge
+// window runs, and marks the round settled with settlement if it succeeds.
+// Only a closed round can be settled, and only once: the round stays locked
+// while apply runs so that two settlements of the same round cannot both be
+// accepted.
+func (r *AuctionRound) Settle(settlement zn.TxSettlePayload, apply func(at time.Time) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.stateLocked(r.clock()) {
+	case zn.RoundOpen:
+		return ErrRoundNotClosed
+	case zn.RoundSettled, zn.RoundDisputed:
+		return ErrRoundSettled
+	case zn.RoundExpired:
+		return ErrRoundExpired
+	}
+	at := r.clock()
+	if err := apply(at); err != nil {
+		return err
+	}
+	r.state = zn.RoundSettled
+	r.settlement = settlement
+	r.challengeBy = at.Add(r.ChallengeWindow)
+	return nil
+}
+
+// Dispute runs apply on the accepted settlement and marks the round disputed
+// if it succeeds; apply must check the challenge and revert the settlement.
+// Only a settled round can be disputed, before the end of its challenge window.
+func (r *AuctionRound) Dispute(apply func(info zn.RoundInfo, settlement zn.TxSettlePayload) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock()
+	if r.stateLocked(now) != zn.RoundSettled {
+		return ErrRoundNotSettled
+	}
+	if !now.Before(r.challengeBy) {
+		return ErrChallengeClosed
+	}
+	if err := apply(r.infoLocked(now), r.settlement); err != nil {
+		return err
+	}
+	r.state = zn.RoundDisputed
+	return nil
+}
+
+// lockedLocked reports whether cmIn is the note locked by a registration of
+// the round. r.mu must be held.
+func (r *AuctionRound) lockedLocked(cmIn []byte) bool {
+	for _, t := range r.bids {
+		if reg, ok := t.Tx.(zn.TxRegister); ok && bytes.Equal(reg.CmIn, cmIn) {
+			return true
+		}
+	}
+	for _, t := range r.sellers {
+		if reg, ok := t.Tx.(zn.TxSellerRegister); ok && bytes.Equal(reg.CmIn, cmIn) {
+			return true
+		}
+	}
+	return false
+}
+
+// settledLocked reports whether the accepted settlement spends the note cmIn.
+// r.mu must be held.
+func (r *AuctionRound) settledLocked(cmIn []byte) bool {
+	ip := r.settlement.Ip
+	if bytes.Equal(ip.Seller.InCm, cmIn) {
+		return true
+	}
+	for _, b := range ip.Bidders {
+		if bytes.Equal(b.InCm, cmIn) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refund runs apply for the refund of the locked note cmIn and records it if
+// apply succeeds. Refunds are accepted once the round has expired or its
+// settlement has been reverted, and neither can be settled again; while the
+// settlement stands, only the notes it does not spend can be refunded. A note
+// is thus either settled or refunded, never both.
+func (r *AuctionRound) Refund(cmIn []byte, apply func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.stateLocked(r.clock()) {
+	case zn.RoundOpen, zn.RoundClosed:
+		return ErrRoundNotExpired
+	case zn.RoundSettled:
+		if r.settledLocked(cmIn) {
+			return ErrRoundSettled
+		}
+	}
+	if !r.lockedLocked(cmIn) {
+		return ErrNoteNotLocked
+	}
+	if r.refunded[string(cmIn)] {
+		return ErrNoteRefunded
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	r.refunded[string(cmIn)] = true
+	return nil
+}
+
+// Draw runs apply for the draw of the allocation delivered in the note cmOut
+// and records it if apply succeeds. cmOut must be the OutCm the settlement
+// creates for a bid, carrying its fill, and can only be drawn once the
+// settlement can no longer be challenged; each allocation is drawn at most
+// once. The bid note itself is spent by the settlement, not by the draw.
+func (r *AuctionRound) Draw(cmOut []byte, fill *big.Int, apply func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock()
+	if r.stateLocked(now) != zn.RoundSettled {
+		return ErrRoundNotSettled
+	}
+	if now.Before(r.challengeBy) {
+		return ErrChallengeOpen
+	}
+	var allocated *big.Int
+	for _, b := range r.settlement.Ip.Bidders {
+		if bytes.Equal(b.OutCm, cmOut) {
+			allocated = b.Fill
+			break
+		}
+	}
+	if allocated == nil {
+		return ErrNoAllocation
+	}
+	if fill.Cmp(allocated) != 0 {
+		return ErrNotAllocated
+	}
+	if r.drawn[string(cmOut)] {
+		return ErrAlreadyDrawn
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	r.drawn[string(cmOut)] = true
+	return nil
+}
+
+// Info returns a snapshot of the round for the wire.
+func (r *AuctionRound) Info() zn.RoundInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.infoLocked(r.clock())
+}
+
+// infoLocked builds the snapshot returned by Info. r.mu must be held.
+func (r *AuctionRound) infoLocked(now time.Time) zn.RoundInfo {
+	return zn.RoundInfo{
+		ID:          r.ID,
+		State:       r.stateLocked(now),
+		Deadline:    r.Deadline,
+		SettleBy:    r.SettleBy,
+		ChallengeBy: r.challengeBy,
+		Bids:        append([]zn.Transaction(nil), r.bids...),
+		Aux:         append([]zn.AuxList(nil), r.aux...),
+		InfoBid:     append([]zn.InfoBid(nil), r.infoBid...),
+		Sellers:     append([]zn.Transaction(nil), r.sellers...),
+	}
+}
+
+// RoundRegistry indexes the rounds of a validator; several rounds may be open
+// at the same time. Clock returns the chain time the rounds are checked
+// against: that of LedgerDB unless set otherwise.
+type RoundRegistry struct {
+	Clock  func() time.Time
+	mu     sync.Mutex
+	nextID int
+	rounds map[int]*AuctionRound
+}
+
+func NewRoundRegistry() *RoundRegistry {
+	return &RoundRegistry{
+		Clock:  func() time.Time { return LedgerDB.Now() },
+		nextID: 1,
+		rounds: make(map[int]*AuctionRound),
+	}
+}
+
+func (rr *RoundRegistry) newAuctionRound(id int, deadline, settleBy time.Time, challengeWindow time.Duration) *AuctionRound {
+	return &AuctionRound{
+		ID:              id,
+		Deadline:        deadline,
+		SettleBy:        settleBy,
+		ChallengeWindow: challengeWindow,
+		clock:           rr.Clock,
+		state:           zn.RoundOpen,
+		refunded:        make(map[string]bool),
+		drawn:           make(map[string]bool),
+	}
+}
+
+// Open runs apply with the ID of a new round and creates the round if apply
+// succeeds. The round accepts registrations until deadline, expires if it is
+// not settled by settleBy, and its settlement can be challenged for
+// challengeWindow.
+func (rr *RoundRegistry) Open(deadline, settleBy time.Time, challengeWindow time.Duration, apply func(id int) error) (*AuctionRound, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if err := apply(rr.nextID); err != nil {
+		return nil, err
+	}
+	r := rr.newAuctionRound(rr.nextID, deadline, settleBy, challengeWindow)
+	rr.rounds[r.ID] = r
+	rr.nextID++
+	return r, nil
+}
+
+// Get returns the round with the given ID.
+func (rr *RoundRegistry) Get(id int) (*AuctionRound, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	r, ok := rr.rounds[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownRound, id)
+	}
+	return r, nil
+}
+
+// replay applies a round event read back from the ledger.
+func (rr *RoundRegistry) replay(ev RoundEvent) error {
+	if ev.Kind == RoundEventOpened {
+		rr.mu.Lock()
+		defer rr.mu.Unlock()
+		rr.rounds[ev.ID] = rr.newAuctionRound(ev.ID, ev.Deadline, ev.SettleBy, ev.ChallengeWindow)
+		if ev.ID >= rr.nextID {
+			rr.nextID = ev.ID + 1
+		}
+		return nil
+	}
+	r, err := rr.Get(ev.ID)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev.Kind {
+	case RoundEventBid:
+		r.bids = append(r.bids, ev.Tx)
+		r.aux = append(r.aux, ev.Aux)
+		r.infoBid = append(r.infoBid, ev.Info)
+		r.cmTemp = append(r.cmTemp, ev.Cm)
+	case RoundEventSeller:
+		r.sellers = append(r.sellers, ev.Tx)
+		r.cmTemp = append(r.cmTemp, ev.Cm)
+	case RoundEventSettled:
+		r.state = zn.RoundSettled
+		r.settlement = ev.Settlement
+		r.challengeBy = ev.At.Add(r.ChallengeWindow)
+	case RoundEventDisputed:
+		r.state = zn.RoundDisputed
+	case RoundEventRefunded:
+		r.refunded[string(ev.CmIn)] = true
+	case RoundEventDrawn:
+		r.drawn[string(ev.CmIn)] = true
+	default:
+		return fmt.Errorf("unknown round event %d", ev.Kind)
+	}
+	return nil
+}
+
+// Rounds is the validator's round registry.
+var Rounds = NewRoundRegistry()
+
+// -------------------------------
+// RoundHandler
+// -------------------------------
+
+// RoundHandler handles "round_open" and "round_get" requests on the validator
+// and answers with a "round_info" message.
+type RoundHandler struct {
+	Node *Node
+}
+
+func NewRoundHandler(node *Node) *RoundHandler {
+	return &RoundHandler{Node: node}
+}
+
+func (rh *RoundHandler) HandleMessage(msg zn.Message, conn net.Conn) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+
+	var round *AuctionRound
+	var err error
+	switch req := msg.Payload.(type) {
+	case zn.RoundOpenPayload:
+		if req.SettleBy.Before(req.Deadline) {
+			err = errors.New("settlement deadline precedes registration deadline")
+			break
+		}
+		round, err = Rounds.Open(req.Deadline, req.SettleBy, req.ChallengeWindow, func(id int) error {
+			return LedgerDB.Append(LedgerEntry{Round: &RoundEvent{
+				Kind:            RoundEventOpened,
+				ID:              id,
+				At:              LedgerDB.Now(),
+				Deadline:        req.Deadline,
+				SettleBy:        req.SettleBy,
+				ChallengeWindow: req.ChallengeWindow,
+			}})
+		})
+		if err != nil {
+			break
+		}
+		logger.Info().Msgf("%s[Node %d] [Round] Round %d opened until %s\033[0m",
+			getNodeColor(rh.Node.ID), rh.Node.ID, round.ID, req.Deadline.Format(time.RFC3339))
+	case zn.RoundRequestPayload:
+		round, err = Rounds.Get(req.ID)
+	default:
+		fmt.Println("RoundHandler: invalid payload")
+		zn.ReplyError(conn, msg, zn.CodeBadRequest, "payload is not a round request")
+		return
+	}
+	if err != nil {
+		logger.Warn().Msgf("%s[Node %d] [Round] %v\033[0m", getNodeColor(rh.Node.ID), rh.Node.ID, err)
+		zn.ReplyError(conn, msg, zn.CodeRefused, "%v", err)
+		return
+	}
+
+	if err := zn.Reply(conn, msg, "round_info", round.Info()); err != nil {
+		logger.Error().Err(err).Msgf("%s[Node %d] [Round] Error sending round info\033[0m", getNodeColor(rh.Node.ID), rh.Node.ID)
+	}
+}
+
+// requestRound sends a round request to the validator and waits for its info.
+func (n *Node) requestRound(validatorAddress, msgType string, req interface{}) (zn.RoundInfo, error) {
+	return zn.Call[zn.RoundInfo](context.Background(), n.RPC, validatorAddress, msgType, req)
+}
+
+// OpenRound asks the validator to open a round whose registration window
+// lasts for window, which must then be settled within settleWindow, and whose
+// settlement can be challenged for challengeWindow.
+func (n *Node) OpenRound(validatorAddress string, window, settleWindow, challengeWindow time.Duration) (zn.RoundInfo, error) {
+	deadline := time.Now().Add(window)
+	return n.requestRound(validatorAddress, "round_open", zn.RoundOpenPayload{
+		Deadline:        deadline,
+		SettleBy:        deadline.Add(settleWindow),
+		ChallengeWindow: challengeWindow,
+	})
+}
+
+// GetRound fetches the state and registrations of a round from the validator.
+func (n *Node) GetRound(validatorAddress string, id int) (zn.RoundInfo, error) {
+	return n.requestRound(validatorAddress, "round_get", zn.RoundRequestPayload{ID: id})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	zg "zerocash_gnark/zerocash_gnark"
+	zn "zerocash_gnark/zerocash_network"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// useTestState points the validator state at a fresh ledger and round
+// registry for the duration of the test.
+func useTestState(t *testing.T) {
+	t.Helper()
+	ledger, rounds := LedgerDB, Rounds
+	LedgerDB, Rounds = NewMemoryLedger(), NewRoundRegistry()
+	t.Cleanup(func() { LedgerDB, Rounds = ledger, rounds })
+}
+
+// sealPending commits the pending entries of LedgerDB in a block.
+func sealPending(t *testing.T) {
+	t.Helper()
+	if _, err := LedgerDB.Seal(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// proveDraw proves the draw of the note delivered by a settlement, owned by
+// skOut, into a fresh note of the same value.
+func proveDraw(t *testing.T, cs constraint.ConstraintSystem, pk groth16.ProvingKey, skOut []byte, delivered zg.Note, bid *big.Int) zn.TxDrawPayload {
+	t.Helper()
+	_, _, G, _ := bls12377.Generators()
+	pkOut := GeneratePk(skOut)
+	out := GenerateNote(delivered.Value, pkOut, GenerateSk(), GenerateSk())
+	cipher := zg.BuildEncWithdrawMimc(pkOut, skOut, bid.Bytes(), G)
+	ip := zg.InputTxDraw{
+		SnIn:       zg.CalcSerialMimc(skOut, delivered.Rho),
+		CmIn:       delivered.Cm,
+		CmOut:      out.Cm,
+		Fill:       delivered.Value.Energy.Bytes(),
+		ChainID:    ChainID,
+		Expiry:     LedgerDB.Height() + 10,
+		PkT:        G,
+		SkIn:       skOut,
+		B:          bid.Bytes(),
+		NInCoins:   delivered.Value.Coins.Bytes(),
+		NInEnergy:  delivered.Value.Energy.Bytes(),
+		NInPkIn:    pkOut,
+		NInRhoIn:   delivered.Rho,
+		NInRIn:     delivered.Rand,
+		NOutCoins:  out.Value.Coins.Bytes(),
+		NOutEnergy: out.Value.Energy.Bytes(),
+		NOutPkOut:  pkOut,
+		NOutRhoOut: out.Rho,
+		NOutROut:   out.Rand,
+	}
+	for i := range cipher {
+		b := cipher[i].Bytes()
+		ip.CipherAux[i] = b[:]
+	}
+	assignment, _ := ip.BuildWitness()
+	w, err := frontend.NewWitness(assignment, ecc.BW6_761.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(cs, pk, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return zn.TxDrawPayload{Proof: buf.Bytes(), Ip: ip.Public(), ID: 1}
+}
+
+// TestDrawAfterSettlement settles a round and then draws: the settlement
+// spends the bid note, so the draw must spend the note it delivers instead,
+// exactly once and for the allocated fill.
+func TestDrawAfterSettlement(t *testing.T) {
+	useTestState(t)
+	cs, err := frontend.Compile(ecc.BW6_761.ScalarField(), r1cs.NewBuilder, &zg.CircuitWithdraw{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vkDraw := globalVKDraw
+	globalVKDraw = vk
+	t.Cleanup(func() { globalVKDraw = vkDraw })
+
+	// The bidder's registered note is on the ledger.
+	skIn, skOut := GenerateSk(), GenerateSk()
+	bidNote := GenerateNote(zg.Gamma{Coins: big.NewInt(100), Energy: big.NewInt(5)}, GeneratePk(skIn), GenerateSk(), GenerateSk())
+	if err := LedgerDB.Append(LedgerEntry{Cms: [][]byte{bidNote.Cm}, Expiry: 10}); err != nil {
+		t.Fatal(err)
+	}
+	sealPending(t)
+
+	// The settlement spends it and delivers the fill in a note owned by pkOut,
+	// with the change in another.
+	price, fill, bid := big.NewInt(10), big.NewInt(3), big.NewInt(12)
+	delivered := GenerateNote(zg.Gamma{Coins: big.NewInt(0), Energy: fill}, GeneratePk(skOut), GenerateSk(), GenerateSk())
+	change := GenerateNote(zg.Gamma{Coins: big.NewInt(70), Energy: big.NewInt(5)}, GeneratePk(skOut), GenerateSk(), GenerateSk())
+	settlement := zn.TxSettlePayload{Ip: zg.InputProverSettle{
+		Price: price,
+		Bidders: []zg.InputSettleBidder{{
+			InCm:     bidNote.Cm,
+			InSn:     zg.CalcSerialMimc(skIn, bidNote.Rho),
+			Fill:     fill,
+			OutCm:    delivered.Cm,
+			ChangeCm: change.Cm,
+		}},
+		Seller: zg.InputSettleSeller{
+			InSn:        GenerateSk(),
+			OutPayCm:    GenerateSk(),
+			OutChangeCm: GenerateSk(),
+		},
+	}}
+	round, err := Rounds.Open(time.Now().Add(time.Hour), time.Now().Add(2*time.Hour), 0, func(int) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	round.Close()
+	err = round.Settle(settlement, func(at time.Time) error {
+		sns, cms := settlementNotes(settlement.Ip)
+		return LedgerDB.Append(LedgerEntry{
+			Sns:   sns,
+			Cms:   cms,
+			Round: &RoundEvent{Kind: RoundEventSettled, ID: round.ID, At: at, Settlement: settlement},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealPending(t)
+
+	draw := proveDraw(t, cs, pk, skOut, delivered, bid)
+	draw.RoundID = round.ID
+	respend := zn.TxDrawPayload{RoundID: round.ID, Ip: zg.InputTxDraw{
+		SnIn:   settlement.Ip.Bidders[0].InSn,
+		CmIn:   bidNote.Cm,
+		Fill:   fill.Bytes(),
+		Expiry: draw.Ip.Expiry,
+	}}
+	changeDraw := zn.TxDrawPayload{RoundID: round.ID, Ip: zg.InputTxDraw{
+		SnIn:   zg.CalcSerialMimc(skOut, change.Rho),
+		CmIn:   change.Cm,
+		Fill:   fill.Bytes(),
+		Expiry: draw.Ip.Expiry,
+	}}
+	overdraw := draw
+	overdraw.Ip.Fill = big.NewInt(4).Bytes()
+
+	drh := NewTxDrawCoinHandler(&Node{ID: 0})
+	tests := []struct {
+		name string
+		req  zn.TxDrawPayload
+		want error // nil if accepted
+	}{
+		{"bid note spent again", respend, ErrDoubleSpend},
+		{"change note", changeDraw, ErrNoAllocation},
+		{"other fill", overdraw, ErrNotAllocated},
+		{"delivered note", draw, nil},
+		{"drawn twice", draw, ErrDoubleSpend},
+	}
+	for _, tt := range tests {
+		receipt := drh.draw(tt.req)
+		switch {
+		case tt.want == nil && !receipt.Accepted:
+			t.Fatalf("%s: rejected: %s", tt.name, receipt.Reason)
+		case tt.want != nil && receipt.Accepted:
+			t.Fatalf("%s: accepted", tt.name)
+		case tt.want != nil && receipt.Reason != tt.want.Error():
+			t.Fatalf("%s: rejected with %q, want %q", tt.name, receipt.Reason, tt.want)
+		}
+	}
+	if !LedgerDB.HasNullifier(draw.Ip.SnIn) {
+		t.Fatal("draw nullifier not recorded")
+	}
+}
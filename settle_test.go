@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	zg "zerocash_gnark/zerocash_gnark"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	bls12377_fp "github.com/consensys/gnark-crypto/ecc/bls12-377/fp"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// encryptRegistration returns the registration ciphertext of a note of value
+// gamma owned by skIn, encrypted under encKey.
+func encryptRegistration(encKey bls12377.G1Affine, gamma zg.Gamma, pkOut, skIn []byte, bid *big.Int) [5][]byte {
+	var c [5][]byte
+	for i, e := range zg.BuildEncRegMimc(encKey, gamma, pkOut, skIn, bid) {
+		b := e.Bytes()
+		c[i] = b[:]
+	}
+	return c
+}
+
+// settleBidder returns the settlement of a bidder whose note holds coins,
+// filled with fill at price: the notes it spends and receives.
+func settleBidder(G bls12377.G1Affine, coins, bid, price, fill *big.Int) zg.InputSettleBidder {
+	skIn := GenerateSk()
+	note := GenerateNote(zg.Gamma{Coins: coins, Energy: big.NewInt(0)}, GeneratePk(skIn), GenerateSk(), GenerateSk())
+	var encKey bls12377.G1Affine
+	encKey.ScalarMultiplication(&G, big.NewInt(7))
+	b := zg.InputSettleBidder{
+		InCm:       note.Cm,
+		InSn:       zg.CalcSerialMimc(skIn, note.Rho),
+		C:          encryptRegistration(encKey, note.Value, GeneratePk(GenerateSk()), skIn, bid),
+		EncKey:     encKey,
+		InCoin:     coins,
+		InEnergy:   big.NewInt(0),
+		InSk:       skIn,
+		InRho:      note.Rho,
+		InRand:     note.Rand,
+		OutRho:     big.NewInt(11),
+		OutRand:    big.NewInt(12),
+		ChangeRho:  big.NewInt(13),
+		ChangeRand: big.NewInt(14),
+	}
+	setFill(&b, price, fill)
+	return b
+}
+
+// setFill allocates fill to b at price, reduced modulo the scalar field as
+// the circuit computes it, and recomputes the notes b receives.
+func setFill(b *zg.InputSettleBidder, price, fill *big.Int) {
+	r := ecc.BW6_761.ScalarField()
+	b.Fill = new(big.Int).Mod(fill, r)
+	change := new(big.Int).Sub(b.InCoin, new(big.Int).Mul(price, b.Fill))
+	change.Mod(change, r)
+	b.OutCm = zg.Committment(big.NewInt(0), b.Fill, b.OutRho, b.OutRand)
+	b.ChangeCm = zg.Committment(change, b.InEnergy, b.ChangeRho, b.ChangeRand)
+}
+
+// settleSeller returns the settlement of a seller offering energy at reserve
+// who sells sold at price.
+func settleSeller(G bls12377.G1Affine, energy, reserve, price, sold *big.Int) zg.InputSettleSeller {
+	skIn := GenerateSk()
+	note := GenerateNote(zg.Gamma{Coins: big.NewInt(0), Energy: energy}, GeneratePk(skIn), GenerateSk(), GenerateSk())
+	R := big.NewInt(5)
+	var G_b, G_r, encKey bls12377.G1Affine
+	G_b.ScalarMultiplication(&G, big.NewInt(3))
+	G_r.ScalarMultiplication(&G, R)
+	encKey.ScalarMultiplication(&G_b, R)
+	s := zg.InputSettleSeller{
+		InCm:       note.Cm,
+		InSn:       zg.CalcSerialMimc(skIn, note.Rho),
+		C:          encryptRegistration(encKey, note.Value, GeneratePk(GenerateSk()), skIn, reserve),
+		Sold:       sold,
+		G_b:        G_b,
+		G_r:        G_r,
+		InCoin:     big.NewInt(0),
+		InEnergy:   energy,
+		InSk:       skIn,
+		InRho:      note.Rho,
+		InRand:     note.Rand,
+		PayRho:     big.NewInt(21),
+		PayRand:    big.NewInt(22),
+		ChangeRho:  big.NewInt(23),
+		ChangeRand: big.NewInt(24),
+		EncKey:     encKey,
+		R:          R.Bytes(),
+	}
+	s.OutPayCm = zg.Committment(new(big.Int).Mul(price, sold), big.NewInt(0), s.PayRho, s.PayRand)
+	s.OutChangeCm = zg.Committment(big.NewInt(0), new(big.Int).Sub(energy, sold), s.ChangeRho, s.ChangeRand)
+	return s
+}
+
+// TestSettleRejectsWrappedFill checks that the settlement circuit bounds the
+// fills: a fill whose price wraps around the scalar field, paid back by a
+// negative fill of another bidder, balances every conservation equation but
+// must not be provable.
+func TestSettleRejectsWrappedFill(t *testing.T) {
+	cs, err := frontend.Compile(ecc.BW6_761.ScalarField(), r1cs.NewBuilder, zg.NewCircuitTxSettle(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, G, _ := bls12377.Generators()
+
+	// The seller offers 10 at a reserve of 5; both bids of 10 and 12 are
+	// served at a price of 10 (fills of 2 and 8).
+	price := big.NewInt(10)
+	ip := zg.InputProverSettle{
+		Price:   price,
+		ChainID: ChainID,
+		Expiry:  100,
+		G:       G,
+		Bidders: []zg.InputSettleBidder{
+			settleBidder(G, big.NewInt(100), big.NewInt(10), price, big.NewInt(2)),
+			settleBidder(G, big.NewInt(100), big.NewInt(12), price, big.NewInt(8)),
+		},
+		Seller: settleSeller(G, big.NewInt(10), big.NewInt(5), price, big.NewInt(10)),
+	}
+
+	// The first bidder pays 55 for a fill of 55/price in the field, a huge
+	// amount of energy, which the second bidder balances.
+	var inv bls12377_fp.Element
+	inv.SetBigInt(price).Inverse(&inv)
+	wrapped := new(big.Int).Mul(big.NewInt(55), inv.BigInt(new(big.Int)))
+	forged := ip
+	forged.Bidders = append([]zg.InputSettleBidder(nil), ip.Bidders...)
+	setFill(&forged.Bidders[0], price, wrapped)
+	setFill(&forged.Bidders[1], price, new(big.Int).Sub(big.NewInt(10), wrapped))
+
+	tests := []struct {
+		name string
+		ip   zg.InputProverSettle
+		ok   bool
+	}{
+		{"honest", ip, true},
+		{"wrapped fill", forged, false},
+	}
+	for _, tt := range tests {
+		assignment, _ := tt.ip.BuildWitness()
+		w, err := frontend.NewWitness(assignment, ecc.BW6_761.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cs.IsSolved(w)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: solved = %v, want %v (%v)", tt.name, err == nil, tt.ok, err)
+		}
+	}
+}
+
+// TestSettleEnforcesReserve checks that the settlement circuit holds the
+// seller to its reserve price: nothing may be sold below it, while a round
+// that sells nothing may settle at any price.
+func TestSettleEnforcesReserve(t *testing.T) {
+	cs, err := frontend.Compile(ecc.BW6_761.ScalarField(), r1cs.NewBuilder, zg.NewCircuitTxSettle(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, G, _ := bls12377.Generators()
+	price := big.NewInt(10)
+	tests := []struct {
+		name          string
+		reserve, fill int64
+		ok            bool
+	}{
+		{"above reserve", 8, 4, true},
+		{"at reserve", 10, 4, true},
+		{"below reserve", 11, 4, false},
+		{"nothing sold", 11, 0, true},
+	}
+	for _, tt := range tests {
+		fill := big.NewInt(tt.fill)
+		ip := zg.InputProverSettle{
+			Price:   price,
+			ChainID: ChainID,
+			Expiry:  100,
+			G:       G,
+			Bidders: []zg.InputSettleBidder{settleBidder(G, big.NewInt(100), big.NewInt(12), price, fill)},
+			Seller:  settleSeller(G, big.NewInt(10), big.NewInt(tt.reserve), price, fill),
+		}
+		assignment, _ := ip.BuildWitness()
+		w, err := frontend.NewWitness(assignment, ecc.BW6_761.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cs.IsSolved(w)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: solved = %v, want %v (%v)", tt.name, err == nil, tt.ok, err)
+		}
+	}
+}
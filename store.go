@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	zg "zerocash_gnark/zerocash_gnark"
+)
+
+// ErrDoubleSpend is returned when an entry publishes a nullifier that is
+// already spent, or the same nullifier twice.
+var ErrDoubleSpend = errors.New("nullifier already spent")
+
+// ErrUnknownNote is returned when a transaction spends a note that is not
+// committed on the ledger.
+var ErrUnknownNote = errors.New("note is not committed on the ledger")
+
+// NoteStore indexes the validator state. Nullifiers and commitments are kept
+// in hash sets keyed by their bytes, so lookups do not scan the ledger;
+// commitments are also kept in insertion order, along with the transaction
+// history. A NoteStore is safe for concurrent use. It is only changed through
+// Ledger.Append, which checks and inserts the nullifiers of an entry
+// atomically.
+type NoteStore struct {
+	mu         sync.RWMutex
+	sns        map[string]struct{}
+	cmIndex    map[string]int // position in cms
+	cms        [][]byte
+	txs        []zg.TxResult
+	txsOneCoin []zg.TxResultDefaultOneCoin
+}
+
+// NewNoteStore returns an empty store.
+func NewNoteStore() *NoteStore {
+	return &NoteStore{
+		sns:     make(map[string]struct{}),
+		cmIndex: make(map[string]int),
+	}
+}
+
+// clone returns a copy of s that can be changed independently.
+func (s *NoteStore) clone() *NoteStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := NewNoteStore()
+	for sn := range s.sns {
+		c.sns[sn] = struct{}{}
+	}
+	for cm, i := range s.cmIndex {
+		c.cmIndex[cm] = i
+	}
+	c.cms = append(c.cms, s.cms...)
+	c.txs = append(c.txs, s.txs...)
+	c.txsOneCoin = append(c.txsOneCoin, s.txsOneCoin...)
+	return c
+}
+
+// HasNullifier reports whether sn has been spent.
+func (s *NoteStore) HasNullifier(sn []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.sns[string(sn)]
+	return ok
+}
+
+// HasCommitment reports whether cm is a commitment of the ledger.
+func (s *NoteStore) HasCommitment(cm []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.cmIndex[string(cm)]
+	return ok
+}
+
+// Commitments returns the commitments in insertion order.
+func (s *NoteStore) Commitments() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([][]byte(nil), s.cms...)
+}
+
+// Nullifiers returns the spent nullifiers, in no particular order.
+func (s *NoteStore) Nullifiers() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sns := make([][]byte, 0, len(s.sns))
+	for sn := range s.sns {
+		sns = append(sns, []byte(sn))
+	}
+	return sns
+}
+
+// NumNullifiers returns the number of spent nullifiers.
+func (s *NoteStore) NumNullifiers() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sns)
+}
+
+// NumCommitments returns the number of commitments.
+func (s *NoteStore) NumCommitments() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.cms)
+}
+
+// checkEntry returns ErrDoubleSpend if e spends a nullifier twice or one that
+// is already spent (and not revoked by e itself).
+func (s *NoteStore) checkEntry(e LedgerEntry) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revoked := make(map[string]bool, len(e.RevokedSns))
+	for _, sn := range e.RevokedSns {
+		revoked[string(sn)] = true
+	}
+	seen := make(map[string]bool, len(e.Sns))
+	for _, sn := range e.Sns {
+		key := string(sn)
+		if _, spent := s.sns[key]; (spent && !revoked[key]) || seen[key] {
+			return ErrDoubleSpend
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// apply applies the changes of e.
+func (s *NoteStore) apply(e LedgerEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sn := range e.RevokedSns {
+		delete(s.sns, string(sn))
+	}
+	for _, cm := range e.RevokedCms {
+		s.removeCommitmentLocked(cm)
+	}
+	for _, sn := range e.Sns {
+		s.sns[string(sn)] = struct{}{}
+	}
+	for _, cm := range e.Cms {
+		if _, ok := s.cmIndex[string(cm)]; ok {
+			continue
+		}
+		s.cmIndex[string(cm)] = len(s.cms)
+		s.cms = append(s.cms, cm)
+	}
+	if e.Tx != nil {
+		s.txs = append(s.txs, *e.Tx)
+	}
+	if e.TxOneCoin != nil {
+		s.txsOneCoin = append(s.txsOneCoin, *e.TxOneCoin)
+	}
+}
+
+// removeCommitmentLocked removes cm and reindexes the commitments that follow
+// it. s.mu must be held.
+func (s *NoteStore) removeCommitmentLocked(cm []byte) {
+	i, ok := s.cmIndex[string(cm)]
+	if !ok {
+		return
+	}
+	delete(s.cmIndex, string(cm))
+	s.cms = append(s.cms[:i], s.cms[i+1:]...)
+	for j := i; j < len(s.cms); j++ {
+		s.cmIndex[string(s.cms[j])] = j
+	}
+}
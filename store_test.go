@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestCheckEntry(t *testing.T) {
+	s := NewNoteStore()
+	s.apply(LedgerEntry{Sns: [][]byte{[]byte("spent")}})
+	tests := []struct {
+		name string
+		e    LedgerEntry
+		want error
+	}{
+		{"fresh", LedgerEntry{Sns: [][]byte{[]byte("a"), []byte("b")}}, nil},
+		{"spent", LedgerEntry{Sns: [][]byte{[]byte("a"), []byte("spent")}}, ErrDoubleSpend},
+		{"twice in the entry", LedgerEntry{Sns: [][]byte{[]byte("a"), []byte("a")}}, ErrDoubleSpend},
+		{"revoked by the entry", LedgerEntry{Sns: [][]byte{[]byte("spent")}, RevokedSns: [][]byte{[]byte("spent")}}, nil},
+	}
+	for _, tt := range tests {
+		if err := s.checkEntry(tt.e); err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestApplyRevokedCommitments(t *testing.T) {
+	s := NewNoteStore()
+	s.apply(LedgerEntry{Cms: [][]byte{[]byte("a"), []byte("b"), []byte("c")}})
+	s.apply(LedgerEntry{Cms: [][]byte{[]byte("d")}, RevokedCms: [][]byte{[]byte("b")}})
+	if got := s.Commitments(); !slices.EqualFunc(got, [][]byte{[]byte("a"), []byte("c"), []byte("d")}, slices.Equal) {
+		t.Fatalf("commitments %q, want a, c, d", got)
+	}
+	if s.HasCommitment([]byte("b")) || !s.HasCommitment([]byte("d")) {
+		t.Fatal("index out of date after a revocation")
+	}
+	// The commitments following a revoked one are reindexed.
+	s.apply(LedgerEntry{RevokedCms: [][]byte{[]byte("c")}})
+	if got := s.Commitments(); !slices.EqualFunc(got, [][]byte{[]byte("a"), []byte("d")}, slices.Equal) {
+		t.Fatalf("commitments %q, want a, d", got)
+	}
+}
+
+// TestConcurrentSpends spends each note from several goroutines at once:
+// exactly one spend of each must be admitted, whether the first one is
+// pending or committed.
+func TestConcurrentSpends(t *testing.T) {
+	tests := []struct {
+		name   string
+		commit bool // admit the first spend and commit it while the others race
+	}{
+		{"pending", false},
+		{"committed", true},
+	}
+	const notes, spenders = 8, 16
+	for _, tt := range tests {
+		l := NewMemoryLedger()
+		var admitted [notes]int
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for n := 0; n < notes; n++ {
+			if tt.commit {
+				if err := l.Append(LedgerEntry{Sns: [][]byte{[]byte(fmt.Sprint("sn", n))}, Expiry: 10}); err != nil {
+					t.Fatal(err)
+				}
+				admitted[n]++
+			}
+			for i := 0; i < spenders; i++ {
+				wg.Add(1)
+				go func(n, i int) {
+					defer wg.Done()
+					// Distinct outputs make distinct entries.
+					err := l.Append(LedgerEntry{
+						Sns:    [][]byte{[]byte(fmt.Sprint("sn", n))},
+						Cms:    [][]byte{[]byte(fmt.Sprint("cm", n, "-", i))},
+						Expiry: 10,
+					})
+					if err != nil && !errors.Is(err, ErrDoubleSpend) {
+						t.Errorf("%s: %v", tt.name, err)
+					}
+					if err == nil {
+						mu.Lock()
+						admitted[n]++
+						mu.Unlock()
+					}
+				}(n, i)
+			}
+			if tt.commit {
+				if _, err := l.Seal(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		wg.Wait()
+		for n, count := range admitted {
+			if count != 1 {
+				t.Errorf("%s: note %d spent %d times", tt.name, n, count)
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	zg "zerocash_gnark/zerocash_gnark"
+
+	"github.com/consensys/gnark/backend/groth16"
+)
+
+// runVerify implements the offline "verify" subcommand: it checks a proof
+// bundle (see zg.ProofBundle) against a verifying key without starting a
+// node, and prints "valid" or "invalid: <reason>". The exit status is 0 for a
+// valid proof, 1 for an invalid one and 2 for a usage or I/O error.
+//
+// The key must be the very one the bundle was proven under, which the bundle
+// records by hash: keys are regenerated by each Setup (see
+// zg.LoadOrGenerateKeys), so bundles exported before a regeneration need the
+// zk_vk that was current at the time.
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	circuit := fs.String("circuit", "", "Circuit of the proof: default, oneCoin, 2coin, 3coin, register, f1, f2, f3, draw, ... (default: the bundle's)")
+	vkPath := fs.String("vk", "", "Verifying key artifact (default: _run_<circuit>/zk_vk)")
+	verbose := fs.Bool("v", false, "Print the public inputs by name")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: zerocash_gnark verify [-circuit name] [-vk _run_*/zk_vk] [-v] bundle.json")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	bundle, err := zg.ReadProofBundle(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// The bundle names its circuit; -circuit only stands in for a missing
+	// name and must agree with it otherwise.
+	name := bundle.Circuit
+	if name == "" {
+		name = *circuit
+	}
+	id, c, dir, err := zg.CircuitByName(name)
+	if err != nil {
+		fmt.Fprintf(stdout, "invalid: %v\n", err)
+		return 1
+	}
+	if *circuit != "" && !strings.EqualFold(*circuit, id) {
+		fmt.Fprintf(stdout, "invalid: bundle is a %s proof, not %s\n", id, *circuit)
+		return 1
+	}
+	bundle.Circuit = id
+	inputs, err := bundle.Inputs()
+	if err != nil {
+		fmt.Fprintf(stdout, "invalid: %v\n", err)
+		return 1
+	}
+	names, err := zg.PublicInputNames(c)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if *vkPath == "" {
+		*vkPath = filepath.Join(dir, "zk_vk")
+	}
+	vk, err := zg.ReadVerifyingKey(*vkPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err := checkBundleKey(bundle, len(names), vk); err != nil {
+		fmt.Fprintf(stdout, "invalid: %s: %v\n", *vkPath, err)
+		return 1
+	}
+
+	if *verbose {
+		for _, n := range names {
+			fmt.Fprintf(stdout, "%s = %s\n", n, inputs[n])
+		}
+	}
+	if err := bundle.VerifyCircuit(id, vk); err != nil {
+		fmt.Fprintf(stdout, "invalid: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "valid: %s proof under %s\n", id, *vkPath)
+	return 0
+}
+
+// exportProofs writes the proof bundles of the ledger entry encoded in payload
+// to dir, as <entry ID>-<index>-<circuit>.json files for the "verify"
+// subcommand.
+func exportProofs(dir string, payload []byte) error {
+	e, err := decodeEntry(payload)
+	if err != nil {
+		return err
+	}
+	id := entryID(payload)
+	for i, b := range e.Proofs {
+		name := fmt.Sprintf("%x-%d-%s.json", id[:8], i, b.Circuit)
+		if err := zg.WriteProofBundle(filepath.Join(dir, name), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBundleKey checks that vk is the key the bundle was proven under: a key
+// of a circuit with nbPublic public inputs, whose hash the bundle records.
+func checkBundleKey(b *zg.ProofBundle, nbPublic int, vk groth16.VerifyingKey) error {
+	if n := vk.NbPublicWitness(); n != nbPublic {
+		return fmt.Errorf("key of a circuit with %d public inputs, %s has %d", n, b.Circuit, nbPublic)
+	}
+	h, err := zg.VKHash(vk)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(h, b.VKHash) {
+		return fmt.Errorf("key hash %s, bundle was proven under %s (keys regenerated since?)", h, b.VKHash)
+	}
+	return nil
+}
+
+// ledgerVerifyingKey returns the verifying key of a circuit whose proofs
+// ledger entries carry.
+func ledgerVerifyingKey(circuit string) (groth16.VerifyingKey, error) {
+	var vk groth16.VerifyingKey
+	switch circuit {
+	case "default":
+		vk = globalVK
+	case "oneCoin":
+		vk = globalVKOneCoin
+	case "register":
+		vk = globalVKRegister
+	case "bidOpening":
+		vk = globalVKBidOpening
+	case "sellerRegister":
+		vk = globalVKSellerRegister
+	case "draw":
+		vk = globalVKDraw
+	case "settle2":
+		vk = globalVKSettle2
+	case "settle3":
+		vk = globalVKSettle3
+	default:
+		return nil, fmt.Errorf("no ledger entry carries %q proofs", circuit)
+	}
+	if vk == nil {
+		return nil, fmt.Errorf("verifying key of %q not loaded", circuit)
+	}
+	return vk, nil
+}
+
+// noteKind is the part a note plays in an entry.
+type noteKind string
+
+const (
+	noteNullifier  noteKind = "nullifier"
+	noteCommitment noteKind = "commitment"
+	noteSpent      noteKind = "spent note"
+)
+
+// noteInputs gives the kind of the notes held by the public inputs of the
+// ledger circuits, by role (see inputRole).
+var noteInputs = map[string]noteKind{
+	"SnOld":       noteNullifier,
+	"SnIn":        noteNullifier,
+	"InSn":        noteNullifier,
+	"CmNew":       noteCommitment,
+	"CmOut":       noteCommitment,
+	"OutCm":       noteCommitment,
+	"ChangeCm":    noteCommitment,
+	"OutPayCm":    noteCommitment,
+	"OutChangeCm": noteCommitment,
+	"CmOld":       noteSpent,
+	"CmIn":        noteSpent,
+	"InCm":        noteSpent,
+}
+
+// inputRole returns the last field of the public input name, without the
+// indices of arrays: "Bidders_0_InSn" and "SnOld_1" have the roles "InSn" and
+// "SnOld".
+func inputRole(name string) string {
+	fields := strings.Split(name, "_")
+	for i := len(fields) - 1; i >= 0; i-- {
+		if _, err := strconv.Atoi(fields[i]); err != nil {
+			return fields[i]
+		}
+	}
+	return name
+}
+
+// verifyEntry checks an entry admitted by another validator, as replicas do
+// before relaying it or voting for a block holding it. Each of its proofs must
+// verify under the verifying key of its circuit, for this chain, and the
+// entry may only publish what they prove: each nullifier, commitment and
+// spent note of e must be a public input of one of them holding a note of
+// that kind, and its fee and expiry, if any, those of one of them. The round
+// rules the admitting validator checks besides the proofs (deadlines,
+// committee decryptions, fraud of a disputed settlement) are not checked
+// again.
+func verifyEntry(e LedgerEntry) error {
+	if e.Seal != nil || e.Evicted != nil {
+		return errors.New("block records are not entries")
+	}
+	proven := map[noteKind]map[string]bool{noteNullifier: {}, noteCommitment: {}, noteSpent: {}}
+	fees := make(map[uint64]bool)
+	expiries := make(map[uint64]bool)
+	for i, b := range e.Proofs {
+		if b == nil {
+			return fmt.Errorf("proof %d missing", i)
+		}
+		vk, err := ledgerVerifyingKey(b.Circuit)
+		if err != nil {
+			return fmt.Errorf("proof %d: %w", i, err)
+		}
+		inputs, err := b.Inputs()
+		if err != nil {
+			return fmt.Errorf("proof %d: %w", i, err)
+		}
+		if id, ok := inputs["ChainID"]; !ok || !id.IsUint64() || id.Uint64() != ChainID {
+			return fmt.Errorf("proof %d is not bound to chain %d", i, ChainID)
+		}
+		if err := b.VerifyCircuit(b.Circuit, vk); err != nil {
+			return fmt.Errorf("proof %d: %w", i, err)
+		}
+		for name, v := range inputs {
+			if kind, ok := noteInputs[inputRole(name)]; ok {
+				proven[kind][string(v.Bytes())] = true
+			}
+		}
+		if fee, ok := inputs["Fee"]; ok && fee.IsUint64() {
+			fees[fee.Uint64()] = true
+		}
+		if expiry, ok := inputs["Expiry"]; ok && expiry.IsUint64() {
+			expiries[expiry.Uint64()] = true
+		}
+	}
+	for kind, notes := range map[noteKind][][]byte{noteNullifier: e.Sns, noteCommitment: e.Cms, noteSpent: e.Spent} {
+		for _, cm := range notes {
+			if !proven[kind][string(new(big.Int).SetBytes(cm).Bytes())] {
+				return fmt.Errorf("%s %x is not proven by the entry", kind, cm)
+			}
+		}
+	}
+	if e.Fee != 0 && !fees[e.Fee] {
+		return fmt.Errorf("fee %d is not proven by the entry", e.Fee)
+	}
+	if e.Expiry != 0 && !expiries[e.Expiry] {
+		return fmt.Errorf("expiry %d is not proven by the entry", e.Expiry)
+	}
+	return nil
+}
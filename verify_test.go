@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	zg "zerocash_gnark/zerocash_gnark"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+func writeVK(t *testing.T, path string, vk groth16.VerifyingKey) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := vk.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyExportedBundle(t *testing.T) {
+	cs, err := frontend.Compile(ecc.BW6_761.ScalarField(), r1cs.NewBuilder, &zg.CircuitFeeNote{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherVK, err := groth16.Setup(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fee note proof, carried by a ledger entry and exported as a validator
+	// running with -proof-dir does.
+	note := GenerateNote(zg.Gamma{Coins: big.NewInt(7), Energy: big.NewInt(0)}, GeneratePk(GenerateSk()), GenerateSk(), GenerateSk())
+	ip := &zg.InputFeeNote{Fees: big.NewInt(7), Cm: note.Cm, Rho: new(big.Int).SetBytes(note.Rho), Rand: new(big.Int).SetBytes(note.Rand)}
+	assignment, _ := ip.BuildWitness()
+	w, err := frontend.NewWitness(assignment, ecc.BW6_761.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(cs, pk, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var proofBytes bytes.Buffer
+	if _, err := proof.WriteTo(&proofBytes); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := zg.BundleOf("fee", vk, proofBytes.Bytes(), ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := encodeEntry(LedgerEntry{Cms: [][]byte{note.Cm}, Proofs: []*zg.ProofBundle{bundle}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := exportProofs(dir, payload); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*-0-fee.json"))
+	if len(files) != 1 {
+		t.Fatalf("exported files: %v", files)
+	}
+	exported := files[0]
+
+	// The same proof with a forged public input.
+	forged, err := zg.ReadProofBundle(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.PublicInputs[0] = zg.FieldElement{Dec: "8"}
+	forgedPath := filepath.Join(dir, "forged.json")
+	if err := zg.WriteProofBundle(forgedPath, forged); err != nil {
+		t.Fatal(err)
+	}
+
+	vkPath := writeVK(t, filepath.Join(dir, "zk_vk"), vk)
+	otherPath := writeVK(t, filepath.Join(dir, "other_vk"), otherVK)
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		output string
+	}{
+		{"valid", []string{"-vk", vkPath, exported}, 0, "valid: fee proof"},
+		{"verbose", []string{"-v", "-vk", vkPath, exported}, 0, "Fees = 7"},
+		{"circuit agrees", []string{"-circuit", "FEE", "-vk", vkPath, exported}, 0, "valid"},
+		{"circuit disagrees", []string{"-circuit", "oneCoin", "-vk", vkPath, exported}, 1, "is a fee proof, not oneCoin"},
+		{"other key", []string{"-vk", otherPath, exported}, 1, "bundle was proven under"},
+		{"forged input", []string{"-vk", vkPath, forgedPath}, 1, "proof does not verify"},
+		{"missing bundle", []string{"-vk", vkPath, filepath.Join(dir, "none.json")}, 2, ""},
+		{"no bundle", nil, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			status := runVerify(tt.args, &stdout, &stderr)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d (stdout %q, stderr %q)", status, tt.status, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.output) {
+				t.Fatalf("output %q does not contain %q", stdout.String(), tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package zerocash_gnark
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bw6761_fr "github.com/consensys/gnark-crypto/ecc/bw6-761/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/schema"
+)
+
+// -----------------------------------------------------------------------------
+// Export / import JSON des preuves et de leurs énoncés publics
+// -----------------------------------------------------------------------------
+
+// ProofBundle est la représentation JSON d'une preuve Groth16 (BW6-761) et de
+// son énoncé : l'identifiant du circuit (nom passé à LoadOrGenerateKeys :
+// "default", "oneCoin", "register", "f1", ...), le hash de la clé de
+// vérification, les entrées publiques dans l'ordre du witness gnark et la
+// preuve sérialisée (groth16.Proof.WriteTo), en hexadécimal.
+type ProofBundle struct {
+	Circuit      string         `json:"circuit"`
+	Curve        string         `json:"curve"`
+	VKHash       string         `json:"vk_hash"`
+	PublicInputs []FieldElement `json:"public_inputs"`
+	Proof        string         `json:"proof"`
+}
+
+// FieldElement est un élément du corps scalaire de BW6-761, donné en décimal
+// et en hexadécimal (0x...). À l'import, l'un des deux suffit ; s'ils sont tous
+// deux présents, ils doivent coïncider.
+type FieldElement struct {
+	Dec string `json:"dec,omitempty"`
+	Hex string `json:"hex,omitempty"`
+}
+
+// VKHash renvoie le sha256, en hexadécimal, de la clé de vérification sérialisée.
+func VKHash(vk groth16.VerifyingKey) (string, error) {
+	var buf bytes.Buffer
+	if _, err := vk.WriteTo(&buf); err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(h[:]), nil
+}
+
+// ExportProof construit le ProofBundle de proofBytes pour le circuit circuit.
+// assignment est le witness du prouveur (cf. BuildWitness) : seules ses
+// entrées publiques sont exportées.
+func ExportProof(circuit string, vk groth16.VerifyingKey, proofBytes []byte, assignment frontend.Circuit) (*ProofBundle, error) {
+	p := groth16.NewProof(ecc.BW6_761)
+	if _, err := p.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		return nil, fmt.Errorf("invalid proof: %w", err)
+	}
+	pub, err := frontend.NewWitness(assignment, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, err
+	}
+	return ExportWitness(circuit, vk, p, pub)
+}
+
+// ExportWitness est ExportProof pour une preuve et un witness public déjà construits.
+func ExportWitness(circuit string, vk groth16.VerifyingKey, proof groth16.Proof, pub witness.Witness) (*ProofBundle, error) {
+	vkHash, err := VKHash(vk)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := proof.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	vec, ok := pub.Vector().(bw6761_fr.Vector)
+	if !ok {
+		return nil, fmt.Errorf("public witness is not over the BW6-761 scalar field")
+	}
+	b := &ProofBundle{
+		Circuit:      circuit,
+		Curve:        ecc.BW6_761.String(),
+		VKHash:       vkHash,
+		PublicInputs: make([]FieldElement, len(vec)),
+		Proof:        hex.EncodeToString(buf.Bytes()),
+	}
+	for i := range vec {
+		v := vec[i].BigInt(new(big.Int))
+		b.PublicInputs[i] = FieldElement{Dec: v.String(), Hex: "0x" + v.Text(16)}
+	}
+	return b, nil
+}
+
+// Statement est l'énoncé d'une preuve : l'entrée du prouveur ou du
+// vérifieur, dont seules les entrées publiques sont retenues.
+type Statement interface {
+	BuildWitness() (frontend.Circuit, error)
+}
+
+// BundleOf construit le ProofBundle de proofBytes, preuve de l'énoncé st pour
+// le circuit circuit.
+func BundleOf(circuit string, vk groth16.VerifyingKey, proofBytes []byte, st Statement) (*ProofBundle, error) {
+	assignment, err := st.BuildWitness()
+	if err != nil {
+		return nil, err
+	}
+	return ExportProof(circuit, vk, proofBytes, assignment)
+}
+
+// Import reconstruit la preuve et le witness public du bundle.
+func (b *ProofBundle) Import() (groth16.Proof, witness.Witness, error) {
+	if b.Curve != "" && b.Curve != ecc.BW6_761.String() {
+		return nil, nil, fmt.Errorf("unsupported curve %q", b.Curve)
+	}
+	proofBytes, err := hex.DecodeString(strings.TrimPrefix(b.Proof, "0x"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("proof: %w", err)
+	}
+	p := groth16.NewProof(ecc.BW6_761)
+	if _, err := p.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		return nil, nil, fmt.Errorf("invalid proof: %w", err)
+	}
+
+	values := make([]*big.Int, len(b.PublicInputs))
+	for i, fe := range b.PublicInputs {
+		if values[i], err = fe.value(); err != nil {
+			return nil, nil, fmt.Errorf("public input %d: %w", i, err)
+		}
+	}
+	pub, err := witness.New(ecc.BW6_761.ScalarField())
+	if err != nil {
+		return nil, nil, err
+	}
+	ch := make(chan any, len(values))
+	for _, v := range values {
+		ch <- v
+	}
+	close(ch)
+	if err := pub.Fill(len(values), 0, ch); err != nil {
+		return nil, nil, err
+	}
+	return p, pub, nil
+}
+
+// Verify vérifie la preuve du bundle sous vk, après avoir contrôlé que vk est
+// bien la clé dont le bundle porte le hash.
+func (b *ProofBundle) Verify(vk groth16.VerifyingKey) error {
+	vkHash, err := VKHash(vk)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(vkHash, b.VKHash) {
+		return fmt.Errorf("verifying key hash %s does not match bundle (%s)", vkHash, b.VKHash)
+	}
+	p, pub, err := b.Import()
+	if err != nil {
+		return err
+	}
+	return groth16.Verify(p, vk, pub)
+}
+
+// value décode l'élément et vérifie qu'il est réduit modulo le corps scalaire.
+func (fe FieldElement) value() (*big.Int, error) {
+	var dec, hx *big.Int
+	if fe.Dec != "" {
+		v, ok := new(big.Int).SetString(fe.Dec, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid decimal %q", fe.Dec)
+		}
+		dec = v
+	}
+	if fe.Hex != "" {
+		v, ok := new(big.Int).SetString(strings.TrimPrefix(fe.Hex, "0x"), 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid hexadecimal %q", fe.Hex)
+		}
+		hx = v
+	}
+	v := dec
+	switch {
+	case dec == nil && hx == nil:
+		return nil, fmt.Errorf("empty field element")
+	case dec == nil:
+		v = hx
+	case hx != nil && dec.Cmp(hx) != 0:
+		return nil, fmt.Errorf("decimal %s and hexadecimal %s differ", fe.Dec, fe.Hex)
+	}
+	if v.Sign() < 0 || v.Cmp(ecc.BW6_761.ScalarField()) >= 0 {
+		return nil, fmt.Errorf("%s is not a field element", v)
+	}
+	return v, nil
+}
+
+// WriteProofBundle écrit le bundle en JSON indenté dans path.
+func WriteProofBundle(path string, b *ProofBundle) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadProofBundle lit un bundle JSON écrit par WriteProofBundle.
+func ReadProofBundle(path string) (*ProofBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b ProofBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &b, nil
+}
+
+// circuitKeys associe l'identifiant d'un circuit (nom de LoadOrGenerateKeys) au
+// répertoire de ses artefacts et à un constructeur du circuit vide.
+var circuitKeys = map[string]struct {
+	dir string
+	new func() frontend.Circuit
+}{
+	"default":        {"_run_default", func() frontend.Circuit { return &CircuitTxMulti{} }},
+	"oneCoin":        {"_run_oneCoin", func() frontend.Circuit { return &CircuitTxDefaultOneCoin{} }},
+	"2coin":          {"_run_2coin", func() frontend.Circuit { return &CircuitTxDefaultTwoCoin{} }},
+	"3coin":          {"_run_3coin", func() frontend.Circuit { return &CircuitTxDefault3Coin{} }},
+	"register":       {"_run_register", func() frontend.Circuit { return &CircuitTxRegister{} }},
+	"bidOpening":     {"_run_bidOpening", func() frontend.Circuit { return &CircuitBidOpening{} }},
+	"f1":             {"_run_F1", func() frontend.Circuit { return &CircuitTxF1{} }},
+	"f2":             {"_run_F2", func() frontend.Circuit { return &CircuitTxF2{} }},
+	"f3":             {"_run_F3", func() frontend.Circuit { return &CircuitTxF3{} }},
+	"draw":           {"_run_draw", func() frontend.Circuit { return &CircuitWithdraw{} }},
+	"sellerRegister": {"_run_sellerRegister", func() frontend.Circuit { return &CircuitTxSellerRegister{} }},
+	"settle2":        {"_run_settle2", func() frontend.Circuit { return NewCircuitTxSettle(2) }},
+	"settle3":        {"_run_settle3", func() frontend.Circuit { return NewCircuitTxSettle(3) }},
+	"fee":            {"_run_fee", func() frontend.Circuit { return &CircuitFeeNote{} }},
+}
+
+// CircuitByName renvoie l'identifiant canonique du circuit name (la casse est
+// ignorée : "F2" désigne "f2"), le circuit vide et le répertoire _run_* de ses clés.
+func CircuitByName(name string) (string, frontend.Circuit, string, error) {
+	for id, k := range circuitKeys {
+		if strings.EqualFold(id, name) {
+			return id, k.new(), k.dir, nil
+		}
+	}
+	return "", nil, "", fmt.Errorf("unknown circuit %q", name)
+}
+
+// PublicInputNames renvoie le nom des entrées publiques de c, dans l'ordre du
+// witness public (celui de ProofBundle.PublicInputs).
+func PublicInputNames(c frontend.Circuit) ([]string, error) {
+	var names []string
+	tVariable := reflect.TypeOf((*frontend.Variable)(nil)).Elem()
+	_, err := schema.Walk(c, tVariable, func(f schema.LeafInfo, _ reflect.Value) error {
+		if f.Visibility == schema.Public {
+			names = append(names, f.FullName())
+		}
+		return nil
+	})
+	return names, err
+}
+
+// VerifyCircuit vérifie le bundle comme une preuve du circuit name : le
+// witness public est reconstruit selon les entrées publiques du circuit, dont
+// le nombre doit correspondre au bundle et à vk, puis la preuve est vérifiée.
+// L'erreur renvoyée donne la raison du rejet.
+func (b *ProofBundle) VerifyCircuit(name string, vk groth16.VerifyingKey) error {
+	id, c, _, err := CircuitByName(name)
+	if err != nil {
+		return err
+	}
+	if b.Circuit != "" && !strings.EqualFold(b.Circuit, id) {
+		return fmt.Errorf("bundle is a %q proof, not %q", b.Circuit, id)
+	}
+	names, err := PublicInputNames(c)
+	if err != nil {
+		return err
+	}
+	if len(b.PublicInputs) != len(names) {
+		return fmt.Errorf("circuit %s has %d public inputs, bundle has %d", id, len(names), len(b.PublicInputs))
+	}
+	if n := vk.NbPublicWitness(); n != len(names) {
+		return fmt.Errorf("verifying key expects %d public inputs, circuit %s has %d", n, id, len(names))
+	}
+	if err := b.Verify(vk); err != nil {
+		return fmt.Errorf("proof does not verify: %w", err)
+	}
+	return nil
+}
+
+// Inputs renvoie les entrées publiques du bundle indexées par leur nom dans
+// le circuit qu'il désigne (cf. PublicInputNames).
+func (b *ProofBundle) Inputs() (map[string]*big.Int, error) {
+	_, c, _, err := CircuitByName(b.Circuit)
+	if err != nil {
+		return nil, err
+	}
+	names, err := PublicInputNames(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != len(b.PublicInputs) {
+		return nil, fmt.Errorf("circuit %s has %d public inputs, bundle has %d", b.Circuit, len(names), len(b.PublicInputs))
+	}
+	inputs := make(map[string]*big.Int, len(names))
+	for i, n := range names {
+		if inputs[n], err = b.PublicInputs[i].value(); err != nil {
+			return nil, fmt.Errorf("public input %s: %w", n, err)
+		}
+	}
+	return inputs, nil
+}
+
+// ReadVerifyingKey lit une clé de vérification sérialisée (fichier zk_vk).
+func ReadVerifyingKey(path string) (groth16.VerifyingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vk := groth16.NewVerifyingKey(ecc.BW6_761)
+	if _, err := vk.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vk, nil
+}
@@ -2,6 +2,7 @@ package zerocash_gnark
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"math/big"
 	"os"
@@ -45,13 +46,23 @@ func GenerateBls12377_frElement() (*bls12377_fr.Element, error) {
 // Committment calcule un engagement cm = MiMC(coins, energy, rho, rand).
 func Committment(coins, energy, rho, r *big.Int) []byte {
 	h := mimcNative.NewMiMC()
-	h.Write(coins.Bytes())
-	h.Write(energy.Bytes())
-	h.Write(rho.Bytes())
-	h.Write(r.Bytes())
+	h.Write(elementBytes(coins))
+	h.Write(elementBytes(energy))
+	h.Write(elementBytes(rho))
+	h.Write(elementBytes(r))
 	return h.Sum(nil)
 }
 
+// elementBytes encode x pour le MiMC natif : une valeur nulle donne un octet 0
+// (et non une tranche vide, que Write ignorerait), afin de rester cohérent avec
+// le circuit qui absorbe toujours un élément par champ.
+func elementBytes(x *big.Int) []byte {
+	if x.Sign() == 0 {
+		return []byte{0}
+	}
+	return x.Bytes()
+}
+
 // CalcSerialMimc : calcule sn = MiMC(sk, rho) hors-circuit, pour être cohérent
 // avec la PRF en circuit.
 func CalcSerialMimc(sk, rho []byte) []byte {
@@ -448,9 +459,12 @@ type TxProverInputHighLevelDefaultNCoin struct {
 	EncKey  []bls12377.G1Affine // Paramètre global (si chaque coin utilise la même clé d'encryption)
 	R       [][]byte            // Paramètre global
 	// B      []byte      // Si nécessaire
-	G   []bls12377.G1Affine // Paramètre global
-	G_b []bls12377.G1Affine // Paramètre global
-	G_r []bls12377.G1Affine // Paramètre global
+	G       []bls12377.G1Affine // Paramètre global
+	G_b     []bls12377.G1Affine // Paramètre global
+	G_r     []bls12377.G1Affine // Paramètre global
+	Fee     *big.Int            // Frais payés au validateur (nil : pas de frais)
+	ChainID uint64              // Réseau de la transaction
+	Expiry  uint64              // Dernière hauteur de bloc pouvant l'inclure
 }
 
 type TxProverInputHighLevelDefaultOneCoin struct {
@@ -461,9 +475,12 @@ type TxProverInputHighLevelDefaultOneCoin struct {
 	EncKey  bls12377.G1Affine
 	R       []byte
 	// B      []byte
-	G   bls12377.G1Affine
-	G_b bls12377.G1Affine
-	G_r bls12377.G1Affine
+	G       bls12377.G1Affine
+	G_b     bls12377.G1Affine
+	G_r     bls12377.G1Affine
+	Fee     *big.Int // frais payés au validateur (nil : pas de frais)
+	ChainID uint64   // réseau de la transaction
+	Expiry  uint64   // dernière hauteur de bloc pouvant l'inclure
 }
 
 func BuildEncRegMimc(EncKey bls12377.G1Affine, gammaIn Gamma, pk_out, skIn []byte, bid *big.Int) []bls12377_fp.Element {
@@ -570,6 +587,42 @@ type Note struct {
 	Cm      []byte
 }
 
+// feeValue renvoie les frais fee d'une transaction, 0 si fee vaut nil.
+func feeValue(fee *big.Int) *big.Int {
+	if fee == nil {
+		return big.NewInt(0)
+	}
+	return fee
+}
+
+// TxContext est le contexte dans lequel un validateur accepte une preuve : le
+// réseau ChainID et la hauteur Height du prochain bloc. Chaque preuve engage,
+// en entrées publiques, un ChainID et une hauteur d'expiration Expiry (dernière
+// hauteur de bloc pouvant l'inclure) : rejouée sur un autre réseau, ou après
+// expiration, elle est rejetée.
+type TxContext struct {
+	ChainID uint64
+	Height  uint64
+}
+
+// Expired indique si une transaction d'expiration expiry ne peut plus être
+// incluse dans le prochain bloc.
+func (ctx TxContext) Expired(expiry uint64) bool {
+	return expiry < ctx.Height
+}
+
+// maxAmount borne les montants (prix, quantités, frais) contraints en circuit :
+// ils tiennent sur 64 bits, comme les frais du validateur.
+const maxAmount uint64 = 1<<64 - 1
+
+// bindTx contraint le réseau et l'expiration d'une transaction à tenir sur 64
+// bits. Une entrée publique qui n'apparaît dans aucune contrainte n'est pas
+// vérifiée par Groth16 : ces contraintes lient chainID et expiry à l'énoncé.
+func bindTx(api frontend.API, chainID, expiry frontend.Variable) {
+	api.ToBinary(chainID, 64)
+	api.ToBinary(expiry, 64)
+}
+
 // -----------------------------------------------------------------------------
 // (3) CircuitTxMulti: 2 old notes -> 2 new notes
 // -----------------------------------------------------------------------------
@@ -588,6 +641,13 @@ type CircuitTxMulti struct {
 	CmNew     [2]frontend.Variable    `gnark:",public"`
 	CNew      [2][6]frontend.Variable `gnark:",public"` // "cipher" simulé
 
+	// Frais payés au validateur (PUBLIC) : sortent de la conservation des coins
+	Fee frontend.Variable `gnark:",public"`
+
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction (PUBLIC)
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
+
 	// old note data (PRIVATE)
 	SkOld   [2]frontend.Variable
 	RhoOld  [2]frontend.Variable
@@ -611,6 +671,8 @@ type CircuitTxMulti struct {
 }
 
 func (c *CircuitTxMulti) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// 1) Recalcule cmOld[i]
 	hasher, _ := mimc.NewMiMC(api)
 	for i := 0; i < 2; i++ {
@@ -649,9 +711,9 @@ func (c *CircuitTxMulti) Define(api frontend.API) error {
 		api.AssertIsEqual(c.CNew[j][4], encVal[4])
 		api.AssertIsEqual(c.CNew[j][5], encVal[5])
 	}
-	// 5) Vérifie conservation
+	// 5) Vérifie conservation : old = new + fee
 	oldCoinsSum := api.Add(c.OldCoins[0], c.OldCoins[1])
-	newCoinsSum := api.Add(c.NewCoins[0], c.NewCoins[1])
+	newCoinsSum := api.Add(c.NewCoins[0], c.NewCoins[1], c.Fee)
 	api.AssertIsEqual(oldCoinsSum, newCoinsSum)
 
 	oldEnergySum := api.Add(c.OldEnergy[0], c.OldEnergy[1])
@@ -696,6 +758,13 @@ type CircuitTxDefaultOneCoin struct {
 	CmNew     frontend.Variable    `gnark:",public"`
 	CNew      [6]frontend.Variable `gnark:",public"` // "cipher" simulé
 
+	// Frais payés au validateur (PUBLIC)
+	Fee frontend.Variable `gnark:",public"`
+
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction (PUBLIC)
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
+
 	// old note data (PRIVATE)
 	SkOld   frontend.Variable
 	RhoOld  frontend.Variable
@@ -719,6 +788,8 @@ type CircuitTxDefaultOneCoin struct {
 }
 
 func (c *CircuitTxDefaultOneCoin) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// 1) Recalcule cmOld[i]
 	hasher, _ := mimc.NewMiMC(api)
 	hasher.Reset()
@@ -749,10 +820,8 @@ func (c *CircuitTxDefaultOneCoin) Define(api frontend.API) error {
 	api.AssertIsEqual(c.CNew[3], encVal[3])
 	api.AssertIsEqual(c.CNew[4], encVal[4])
 	api.AssertIsEqual(c.CNew[5], encVal[5])
-	// 5) Vérifie conservation
-	oldCoinsSum := api.Add(c.OldCoin, c.OldCoin)
-	newCoinsSum := api.Add(c.NewCoin, c.NewCoin)
-	api.AssertIsEqual(oldCoinsSum, newCoinsSum)
+	// 5) Vérifie conservation : old = new + fee
+	api.AssertIsEqual(c.OldCoin, api.Add(c.NewCoin, c.Fee))
 
 	oldEnergySum := api.Add(c.OldEnergy, c.OldEnergy)
 	newEnergySum := api.Add(c.NewEnergy, c.NewEnergy)
@@ -924,6 +993,9 @@ type InputTxFN struct {
 	G   []bls12377.G1Affine
 	G_b []bls12377.G1Affine
 	G_r []bls12377.G1Affine
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction
+	ChainID uint64
+	Expiry  uint64
 }
 
 func (ip *InputTxFN) BuildWitness2() (frontend.Circuit, error) {
@@ -1023,6 +1095,8 @@ func (ip *InputTxFN) BuildWitness2() (frontend.Circuit, error) {
 	c.G_r0 = sw_bls12377.NewG1Affine(ip.G_r[0])
 	c.G_r1 = sw_bls12377.NewG1Affine(ip.G_r[1])
 
+	c.ChainID = ip.ChainID
+	c.Expiry = ip.Expiry
 	return &c, nil
 }
 
@@ -1098,6 +1172,9 @@ type TxProverInputHighLevelFN struct {
 	G      []bls12377.G1Affine
 	G_b    []bls12377.G1Affine
 	G_r    []bls12377.G1Affine
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction
+	ChainID uint64
+	Expiry  uint64
 }
 
 type CircuitTxF1 struct {
@@ -1317,9 +1394,15 @@ type CircuitTxF2 struct {
 	G_b1    sw_bls12377.G1Affine `gnark:",public"`
 	G_r1    sw_bls12377.G1Affine `gnark:",public"`
 	EncKey1 sw_bls12377.G1Affine
+
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction (PUBLIC)
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
 }
 
 func (c *CircuitTxF2) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// // --- Traitement du coin 0 ---
 	decVal0 := DecZKReg(api, c.C0[:], c.SkT0)
 	api.AssertIsEqual(c.DecVal0[0], decVal0[0])
@@ -1425,6 +1508,9 @@ type TxProverInputHighLevelRegister struct {
 	G   bls12377.G1Affine
 	G_b bls12377.G1Affine
 	G_r bls12377.G1Affine
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction
+	ChainID uint64
+	Expiry  uint64
 }
 
 // BuildWitness convertit TxProverInputHighLevelRegister en InputProverRegister
@@ -1471,6 +1557,9 @@ func (inp *TxProverInputHighLevelRegister) BuildWitness() (frontend.Circuit, err
 	ip.RhoIn = new(big.Int).SetBytes(inp.RhoIn)
 	ip.RandIn = new(big.Int).SetBytes(inp.RandIn)
 
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
+
 	// Maintenant, ip est un InputProverRegister correctement rempli.
 	// On appelle ip.BuildWitness() qui renverra un CircuitTxRegister.
 	return ip.BuildWitness()
@@ -1522,7 +1611,9 @@ type CircuitTxRegister struct {
 	CAux          [5]frontend.Variable `gnark:",public"` // ciphertext "aux"
 	GammaInEnergy frontend.Variable    `gnark:",public"` // energy "in"
 	GammaInCoins  frontend.Variable    `gnark:",public"` // coin  "in"
-	Bid           frontend.Variable    `gnark:",public"` // enchère
+	Bid           frontend.Variable    // enchère, privée : seul le comité l'ouvre (cf. CircuitBidOpening)
+	ChainID       frontend.Variable    `gnark:",public"` // réseau de la transaction
+	Expiry        frontend.Variable    `gnark:",public"` // dernière hauteur de bloc l'incluant
 	G             sw_bls12377.G1Affine `gnark:",public"`
 	G_b           sw_bls12377.G1Affine `gnark:",public"`
 	G_r           sw_bls12377.G1Affine `gnark:",public"`
@@ -1539,7 +1630,40 @@ type CircuitTxRegister struct {
 	R        frontend.Variable
 }
 
+// CircuitBidOpening prouve l'ouverture d'un enregistrement lors d'une
+// contestation : les contraintes de CircuitTxRegister, dont le bid est cette
+// fois public. Les champs sont ceux de CircuitTxRegister, dans le même ordre.
+type CircuitBidOpening struct {
+	CmIn          frontend.Variable    `gnark:",public"`
+	CAux          [5]frontend.Variable `gnark:",public"`
+	GammaInEnergy frontend.Variable    `gnark:",public"`
+	GammaInCoins  frontend.Variable    `gnark:",public"`
+	Bid           frontend.Variable    `gnark:",public"` // enchère révélée
+	ChainID       frontend.Variable    `gnark:",public"`
+	Expiry        frontend.Variable    `gnark:",public"`
+	G             sw_bls12377.G1Affine `gnark:",public"`
+	G_b           sw_bls12377.G1Affine `gnark:",public"`
+	G_r           sw_bls12377.G1Affine `gnark:",public"`
+
+	InCoin   frontend.Variable
+	InEnergy frontend.Variable
+	RhoIn    frontend.Variable
+	RandIn   frontend.Variable
+	SkIn     frontend.Variable
+	PkIn     frontend.Variable
+	PkOut    frontend.Variable
+	EncKey   sw_bls12377.G1Affine
+	R        frontend.Variable
+}
+
+func (c *CircuitBidOpening) Define(api frontend.API) error {
+	reg := CircuitTxRegister(*c)
+	return reg.Define(api)
+}
+
 func (c *CircuitTxRegister) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// 1) Recalcule cmIn
 	hasher, _ := mimc.NewMiMC(api)
 	hasher.Reset()
@@ -1755,6 +1879,9 @@ type InputProver struct {
 	NewEnergy [2]*big.Int
 	CmNew     [2][]byte
 	CNew      [2][][]byte
+	Fee       *big.Int // nil : pas de frais
+	ChainID   uint64   // réseau de la transaction
+	Expiry    uint64   // dernière hauteur de bloc pouvant l'inclure
 
 	///
 	R []byte
@@ -1806,6 +1933,10 @@ func (inp *InputProver) BuildWitness() (frontend.Circuit, error) {
 		c.RandNew[j] = inp.RandNew[j]
 	}
 
+	c.Fee = feeValue(inp.Fee)
+	c.ChainID = inp.ChainID
+	c.Expiry = inp.Expiry
+
 	c.R = new(big.Int).SetBytes(inp.R)
 	//c.B = new(big.Int).SetBytes(inp.B)
 	c.G = sw_bls12377.NewG1Affine(inp.G)
@@ -1828,6 +1959,9 @@ type InputProverDefaultOneCoin struct {
 	NewEnergy *big.Int
 	CmNew     []byte
 	CNew      [][]byte
+	Fee       *big.Int // nil : pas de frais
+	ChainID   uint64   // réseau de la transaction
+	Expiry    uint64   // dernière hauteur de bloc pouvant l'inclure
 
 	///
 	R []byte
@@ -1861,7 +1995,10 @@ type InputProverDefaultNCoin struct {
 	NewEnergy []*big.Int // nouvelle énergie pour chaque coin
 	CmNew     [][]byte   // nouveau commitment pour chaque coin
 	// CNew est un slice de slice de []byte (chaque coin fournit 6 éléments comme dans TransactionOneCoin)
-	CNew [][][]byte
+	CNew    [][][]byte
+	Fee     *big.Int // frais de la transaction (nil : pas de frais)
+	ChainID uint64   // réseau de la transaction
+	Expiry  uint64   // dernière hauteur de bloc pouvant l'inclure
 
 	// PARAMÈTRES PUBLICS GLOBAUX
 	R [][]byte
@@ -1938,6 +2075,10 @@ func (inp *InputProverDefaultNCoin) BuildWitness2() (frontend.Circuit, error) {
 	c.G_r1 = sw_bls12377.NewG1Affine(inp.G_r[1])
 	c.EncKey1 = sw_bls12377.NewG1Affine(inp.EncKey[1])
 
+	c.Fee = feeValue(inp.Fee)
+	c.ChainID = inp.ChainID
+	c.Expiry = inp.Expiry
+
 	return &c, nil
 }
 
@@ -1998,9 +2139,18 @@ type CircuitTxDefaultTwoCoin struct {
 	G_b1    sw_bls12377.G1Affine `gnark:",public"`
 	G_r1    sw_bls12377.G1Affine `gnark:",public"`
 	EncKey1 sw_bls12377.G1Affine
+
+	// Frais payés au validateur
+	Fee frontend.Variable `gnark:",public"`
+
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction (PUBLIC)
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
 }
 
 func (c *CircuitTxDefaultTwoCoin) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// ----- Pour le coin 0 -----
 	// 1) Recalcule CmOld0
 	hasher0, _ := mimc.NewMiMC(api)
@@ -2059,8 +2209,9 @@ func (c *CircuitTxDefaultTwoCoin) Define(api frontend.API) error {
 
 	// ----- Conservation globale -----
 	// La somme des anciens coins doit être égale à la somme des nouveaux coins
+	// plus les frais
 	totalOldCoin := api.Add(c.OldCoin0, c.OldCoin1)
-	totalNewCoin := api.Add(c.NewCoin0, c.NewCoin1)
+	totalNewCoin := api.Add(c.NewCoin0, c.NewCoin1, c.Fee)
 	api.AssertIsEqual(totalOldCoin, totalNewCoin)
 
 	totalOldEnergy := api.Add(c.OldEnergy0, c.OldEnergy1)
@@ -2146,6 +2297,8 @@ type InputVerifierRegister struct {
 	G_b bls12377.G1Affine `gnark:",public"`
 	G_r bls12377.G1Affine `gnark:",public"`
 	// EncKey bls12377.G1Affine
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
 }
 
 func (inp *InputVerifierRegister) BuildWitness() (frontend.Circuit, error) {
@@ -2178,6 +2331,8 @@ func (inp *InputVerifierRegister) BuildWitness() (frontend.Circuit, error) {
 	c.G_r = sw_bls12377.NewG1Affine(inp.G_r)
 	// c.EncKey = sw_bls12377.NewG1Affine(inp.EncKey)
 
+	c.ChainID = inp.ChainID
+	c.Expiry = inp.Expiry
 	return &c, nil
 }
 
@@ -2216,13 +2371,15 @@ type InputProverRegister struct {
 	CAux          [5][]byte
 	GammaInCoins  *big.Int
 	GammaInEnergy *big.Int
-	Bid           *big.Int
+	ChainID       uint64 // réseau de la transaction
+	Expiry        uint64 // dernière hauteur de bloc pouvant l'inclure
 
 	G   bls12377.G1Affine
 	G_b bls12377.G1Affine
 	G_r bls12377.G1Affine
 
 	// ------- PRIVÉ -----------
+	Bid      *big.Int // public dans BidOpening seulement
 	InCoin   *big.Int
 	InEnergy *big.Int
 	RhoIn    *big.Int
@@ -2263,9 +2420,70 @@ func (ip *InputProverRegister) BuildWitness() (frontend.Circuit, error) {
 	c.EncKey = sw_bls12377.NewG1Affine(ip.EncKey)
 	c.R = ip.R
 
+	c.ChainID = ip.ChainID
+	c.Expiry = ip.Expiry
 	return &c, nil
 }
 
+// Public renvoie une copie de ip réduite à ses champs publics : c'est tout ce
+// qu'un enregistrement transmet, le bid restant chiffré sous la clé du comité.
+func (ip *InputProverRegister) Public() InputProverRegister {
+	return InputProverRegister{
+		CmIn:          ip.CmIn,
+		CAux:          ip.CAux,
+		GammaInCoins:  ip.GammaInCoins,
+		GammaInEnergy: ip.GammaInEnergy,
+		ChainID:       ip.ChainID,
+		Expiry:        ip.Expiry,
+		G:             ip.G,
+		G_b:           ip.G_b,
+		G_r:           ip.G_r,
+	}
+}
+
+// BidOpening est l'énoncé de CircuitBidOpening : l'enregistrement dont le bid
+// est révélé.
+type BidOpening InputProverRegister
+
+// BuildWitness construit l'instance de CircuitBidOpening de o.
+func (o *BidOpening) BuildWitness() (frontend.Circuit, error) {
+	ip := InputProverRegister(*o)
+	c, err := ip.BuildWitness()
+	if err != nil {
+		return nil, err
+	}
+	opening := CircuitBidOpening(*c.(*CircuitTxRegister))
+	return &opening, nil
+}
+
+// ValidateBidOpening vérifie la preuve d'ouverture d'un enregistrement à
+// partir des champs publics de o, bid compris. La preuve doit être liée au
+// réseau de ctx et ne pas avoir expiré.
+func ValidateBidOpening(proofBytes []byte, o BidOpening, ctx TxContext, vk groth16.VerifyingKey) bool {
+	if ctx.Expired(o.Expiry) {
+		fmt.Println("transaction expirée =>", o.Expiry)
+		return false
+	}
+	o.ChainID = ctx.ChainID
+
+	proof := groth16.NewProof(ecc.BW6_761)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		fmt.Println("invalid proof =>", err)
+		return false
+	}
+	circuitPub, _ := o.BuildWitness()
+	wPub, err := frontend.NewWitness(circuitPub, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		fmt.Println("NewWitness =>", err)
+		return false
+	}
+	if err := groth16.Verify(proof, vk, wPub); err != nil {
+		fmt.Println("Verify =>", err)
+		return false
+	}
+	return true
+}
+
 // func (inp *InputProverRegister) BuildWitness() (frontend.Circuit, error) {
 // 	var c CircuitTxRegister
 
@@ -2324,6 +2542,10 @@ func (inp *InputProverDefaultOneCoin) BuildWitness() (frontend.Circuit, error) {
 	c.RhoNew = inp.RhoNew
 	c.RandNew = inp.RandNew
 
+	c.Fee = feeValue(inp.Fee)
+	c.ChainID = inp.ChainID
+	c.Expiry = inp.Expiry
+
 	c.R = new(big.Int).SetBytes(inp.R)
 	//c.B = new(big.Int).SetBytes(inp.B)
 	c.G = sw_bls12377.NewG1Affine(inp.G)
@@ -2339,10 +2561,12 @@ func (inp *InputProverDefaultOneCoin) BuildWitness() (frontend.Circuit, error) {
 // -----------------------------------------------------------------------------
 
 type TxResult struct {
-	SnOld [2][]byte
-	CmNew [2][]byte
-	CNew  [2]Note
-	Proof []byte
+	SnOld  [2][]byte
+	CmNew  [2][]byte
+	CNew   [2]Note
+	Proof  []byte
+	Fee    *big.Int // frais payés au validateur, entrée publique de la preuve
+	Expiry uint64   // dernière hauteur de bloc pouvant l'inclure, entrée publique de la preuve
 
 	// Pour la validation
 	RhoNew  [2]*big.Int
@@ -2354,10 +2578,12 @@ type TxResult struct {
 }
 
 type TxResultDefaultOneCoin struct {
-	SnOld []byte
-	CmNew []byte
-	CNew  Note
-	Proof []byte
+	SnOld  []byte
+	CmNew  []byte
+	CNew   Note
+	Proof  []byte
+	Fee    *big.Int // frais payés au validateur, entrée publique de la preuve
+	Expiry uint64   // dernière hauteur de bloc pouvant l'inclure, entrée publique de la preuve
 
 	// Pour la validation
 	RhoNew  *big.Int
@@ -2370,10 +2596,12 @@ type TxResultDefaultOneCoin struct {
 
 type TxResultDefaultNCoin struct {
 	// Pour chaque coin, on stocke les valeurs spécifiques sous forme de slices.
-	SnOld [][]byte // Le serial number pour chaque coin.
-	CmNew [][]byte // Le commitment new pour chaque coin.
-	CNew  []Note   // La note new pour chaque coin.
-	Proof []byte   // La preuve globale de la transaction.
+	SnOld  [][]byte // Le serial number pour chaque coin.
+	CmNew  [][]byte // Le commitment new pour chaque coin.
+	CNew   []Note   // La note new pour chaque coin.
+	Proof  []byte   // La preuve globale de la transaction.
+	Fee    *big.Int // Les frais payés au validateur.
+	Expiry uint64   // La dernière hauteur de bloc pouvant inclure la transaction.
 
 	// Pour la validation (chaque champ correspond aux données d'un coin)
 	RhoNew  []*big.Int
@@ -2398,9 +2626,12 @@ type TxProverInputHighLevel struct {
 	EncKey   bls12377.G1Affine
 	R        []byte
 	//B        []byte
-	G   bls12377.G1Affine
-	G_b bls12377.G1Affine
-	G_r bls12377.G1Affine
+	G       bls12377.G1Affine
+	G_b     bls12377.G1Affine
+	G_r     bls12377.G1Affine
+	Fee     *big.Int // frais payés au validateur (nil : pas de frais)
+	ChainID uint64   // réseau de la transaction
+	Expiry  uint64   // dernière hauteur de bloc pouvant l'inclure
 }
 
 // Transaction => alg.1
@@ -2517,6 +2748,9 @@ func Transaction(inp TxProverInputHighLevel) TxResult {
 	ip.G_b = inp.G_b
 	ip.G_r = inp.G_r
 	ip.EncKey = inp.EncKey
+	ip.Fee = feeValue(inp.Fee)
+	ip.ChainID = inp.ChainID
+	ip.Expiry = inp.Expiry
 
 	wc, _ := ip.BuildWitness()
 	w, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField())
@@ -2532,10 +2766,12 @@ func Transaction(inp TxProverInputHighLevel) TxResult {
 	proof.WriteTo(&buf)
 
 	return TxResult{
-		SnOld: snOld,
-		CmNew: cmNew,
-		CNew:  [2]Note{cNew[0], cNew[1]},
-		Proof: buf.Bytes(),
+		SnOld:  snOld,
+		CmNew:  cmNew,
+		CNew:   [2]Note{cNew[0], cNew[1]},
+		Proof:  buf.Bytes(),
+		Fee:    ip.Fee,
+		Expiry: ip.Expiry,
 
 		RhoNew:  rhoNew,
 		RandNew: randNew,
@@ -2555,17 +2791,10 @@ func Transaction(inp TxProverInputHighLevel) TxResult {
 	}
 }
 
-// ValidateTx => on refait un InputProver + publicOnly => groth16.Verify
-func ValidateTx(tx TxResult,
-	old [2]Note,
-	newVal [2]Gamma,
-	G bls12377.G1Affine,
-	G_b bls12377.G1Affine,
-	G_r bls12377.G1Affine,
-	vk groth16.VerifyingKey,
-) bool {
-
-	var ip InputProver
+// TxStatement reconstruit l'énoncé de la preuve de tx (circuit "default") tel
+// que le vérifie ValidateTx.
+func TxStatement(tx TxResult, old [2]Note, newVal [2]Gamma, G, G_b, G_r bls12377.G1Affine, ctx TxContext) *InputProver {
+	ip := new(InputProver)
 	// old
 	for i := 0; i < 2; i++ {
 		ip.OldCoins[i] = old[i].Value.Coins
@@ -2600,7 +2829,31 @@ func ValidateTx(tx TxResult,
 	ip.G = G
 	ip.G_b = G_b
 	ip.G_r = G_r
+	ip.Fee = tx.Fee
+	ip.ChainID = ctx.ChainID
+	ip.Expiry = tx.Expiry
 
+	return ip
+}
+
+// ValidateTx => on refait un InputProver + publicOnly => groth16.Verify
+// (ChainID de ctx : une preuve d'un autre réseau ne vérifie pas ; rejet si
+// tx.Expiry est dépassée)
+func ValidateTx(tx TxResult,
+	old [2]Note,
+	newVal [2]Gamma,
+	G bls12377.G1Affine,
+	G_b bls12377.G1Affine,
+	G_r bls12377.G1Affine,
+	ctx TxContext,
+	vk groth16.VerifyingKey,
+) bool {
+	if ctx.Expired(tx.Expiry) {
+		fmt.Println("transaction expirée =>", tx.Expiry)
+		return false
+	}
+
+	ip := TxStatement(tx, old, newVal, G, G_b, G_r, ctx)
 	wc, _ := ip.BuildWitness()
 	pubOnly, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
 
@@ -2637,8 +2890,13 @@ func ValidateTxRegisterProof(
 	G bls12377.G1Affine,
 	G_b bls12377.G1Affine,
 	G_r bls12377.G1Affine,
+	ctx TxContext,
 	vk groth16.VerifyingKey,
 ) bool {
+	if ctx.Expired(tx.Expiry) {
+		fmt.Println("transaction expirée =>", tx.Expiry)
+		return false
+	}
 
 	var ip InputProverDefaultOneCoin
 	// old
@@ -2671,6 +2929,9 @@ func ValidateTxRegisterProof(
 	ip.G = G
 	ip.G_b = G_b
 	ip.G_r = G_r
+	ip.Fee = tx.Fee
+	ip.ChainID = ctx.ChainID
+	ip.Expiry = tx.Expiry
 
 	wc, _ := ip.BuildWitness()
 	pubOnly, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
@@ -2690,16 +2951,10 @@ func ValidateTxRegisterProof(
 	return true
 }
 
-func ValidateTxDefaultCoin(tx TxResultDefaultOneCoin,
-	old Note,
-	newVal Gamma,
-	G bls12377.G1Affine,
-	G_b bls12377.G1Affine,
-	G_r bls12377.G1Affine,
-	vk groth16.VerifyingKey,
-) bool {
-
-	var ip InputProverDefaultOneCoin
+// TxDefaultCoinStatement reconstruit l'énoncé de la preuve de tx (circuit
+// "oneCoin") tel que le vérifie ValidateTxDefaultCoin.
+func TxDefaultCoinStatement(tx TxResultDefaultOneCoin, old Note, newVal Gamma, G, G_b, G_r bls12377.G1Affine, ctx TxContext) *InputProverDefaultOneCoin {
+	ip := new(InputProverDefaultOneCoin)
 	// old
 	ip.OldCoin = old.Value.Coins
 	ip.OldEnergy = old.Value.Energy
@@ -2730,7 +2985,28 @@ func ValidateTxDefaultCoin(tx TxResultDefaultOneCoin,
 	ip.G = G
 	ip.G_b = G_b
 	ip.G_r = G_r
+	ip.Fee = tx.Fee
+	ip.ChainID = ctx.ChainID
+	ip.Expiry = tx.Expiry
+
+	return ip
+}
 
+func ValidateTxDefaultCoin(tx TxResultDefaultOneCoin,
+	old Note,
+	newVal Gamma,
+	G bls12377.G1Affine,
+	G_b bls12377.G1Affine,
+	G_r bls12377.G1Affine,
+	ctx TxContext,
+	vk groth16.VerifyingKey,
+) bool {
+	if ctx.Expired(tx.Expiry) {
+		fmt.Println("transaction expirée =>", tx.Expiry)
+		return false
+	}
+
+	ip := TxDefaultCoinStatement(tx, old, newVal, G, G_b, G_r, ctx)
 	wc, _ := ip.BuildWitness()
 	pubOnly, _ := frontend.NewWitness(wc, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
 
@@ -2767,10 +3043,16 @@ func ValidateTxRegister(
 	// ou alors, si on doit le reconstruire:
 	cmIn []byte,
 	cAux [5][]byte,
-	gammaInCoins, gammaInEnergy, bid *big.Int,
+	gammaInCoins, gammaInEnergy *big.Int,
 	G, G_b, G_r bls12377.G1Affine,
+	ctx TxContext,
 	vk groth16.VerifyingKey,
 ) bool {
+	if ctx.Expired(Ip.Expiry) {
+		fmt.Println("transaction expirée =>", Ip.Expiry)
+		return false
+	}
+	Ip.ChainID = ctx.ChainID
 
 	// 1) relire la preuve
 	// proof := groth16.NewProof(ecc.BW6_761)
@@ -3020,6 +3302,17 @@ var (
 	globalVK  groth16.VerifyingKey
 )
 
+// LoadOrGenerateKeys charge le circuit compilé (css) et les clés (zk_pk,
+// zk_vk) de circuit_type depuis _run_<circuit>/, ou les compile et les génère
+// s'ils n'y sont pas. Ces artefacts ne sont plus versionnés : une copie neuve
+// les génère au premier lancement, et chaque Setup produit des clés
+// différentes. Après une modification d'un circuit, ils doivent être
+// supprimés (clean_circuit.py) pour être régénérés, sinon le circuit et les
+// clés chargés ne correspondent plus au code.
+//
+// Un bundle exporté (cf. ExportProof) enregistre le hash de la clé de
+// vérification sous laquelle il a été prouvé : après une régénération, il
+// n'est vérifiable qu'avec la zk_vk d'origine, qu'il faut conserver.
 func LoadOrGenerateKeys(circuit_type string) (constraint.ConstraintSystem, groth16.ProvingKey, groth16.VerifyingKey) {
 	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	switch circuit_type {
@@ -3582,6 +3875,19 @@ func LoadOrGenerateKeys(circuit_type string) (constraint.ConstraintSystem, groth
 			globalPK = pk
 			globalVK = vk
 		}
+	case "sellerRegister":
+		var c CircuitTxSellerRegister
+		loadOrGenerateCircuit("_run_sellerRegister", &c)
+	case "bidOpening":
+		var c CircuitBidOpening
+		loadOrGenerateCircuit("_run_bidOpening", &c)
+	case "settle2":
+		loadOrGenerateCircuit("_run_settle2", NewCircuitTxSettle(2))
+	case "settle3":
+		loadOrGenerateCircuit("_run_settle3", NewCircuitTxSettle(3))
+	case "fee":
+		var c CircuitFeeNote
+		loadOrGenerateCircuit("_run_fee", &c)
 
 	}
 
@@ -3595,6 +3901,68 @@ func fileExists(fname string) bool {
 	return false
 }
 
+// loadOrGenerateCircuit factorise la logique de chaque case de LoadOrGenerateKeys :
+// charge (ou compile) le circuit c depuis dir/css, puis charge (ou génère) dir/zk_pk et dir/zk_vk.
+// Le résultat est stocké dans globalCCS, globalPK et globalVK.
+func loadOrGenerateCircuit(dir string, c frontend.Circuit) {
+	logger := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		os.Mkdir(dir, 0755)
+	}
+	// 1) Charger/Compiler circuit
+	cssFile := dir + "/css"
+	if _, err := os.Stat(cssFile); err == nil {
+		d, _ := os.ReadFile(cssFile)
+		ccs := groth16.NewCS(ecc.BW6_761)
+		ccs.ReadFrom(bytes.NewReader(d))
+		logger.Info().Str("cssFile", cssFile).Msg("Circuit loaded from disk")
+		globalCCS = ccs
+	} else {
+		ccs, err := frontend.Compile(ecc.BW6_761.ScalarField(), r1cs.NewBuilder, c)
+		if err != nil {
+			panic(err)
+		}
+		var buf bytes.Buffer
+		ccs.WriteTo(&buf)
+		os.WriteFile(cssFile, buf.Bytes(), 0644)
+		globalCCS = ccs
+	}
+	// 2) Charger ou générer pk+vk
+	pkFile := dir + "/zk_pk"
+	vkFile := dir + "/zk_vk"
+	if fileExists(pkFile) && fileExists(vkFile) {
+		logger.Info().Str("vkFile", vkFile).Str("pkFile", pkFile).Msg("Loading keys from disk")
+		pkData, _ := os.ReadFile(pkFile)
+		vkData, _ := os.ReadFile(vkFile)
+
+		pk := groth16.NewProvingKey(ecc.BW6_761)
+		vk := groth16.NewVerifyingKey(ecc.BW6_761)
+		if _, err := pk.ReadFrom(bytes.NewReader(pkData)); err != nil {
+			panic(err)
+		}
+		if _, err := vk.ReadFrom(bytes.NewReader(vkData)); err != nil {
+			panic(err)
+		}
+		globalPK = pk
+		globalVK = vk
+	} else {
+		logger.Info().Str("vkFile", vkFile).Str("pkFile", pkFile).Msg("Generating keys")
+		pk, vk, err := groth16.Setup(globalCCS)
+		if err != nil {
+			panic(err)
+		}
+		var bufPK bytes.Buffer
+		pk.WriteTo(&bufPK)
+		os.WriteFile(pkFile, bufPK.Bytes(), 0644)
+		var bufVK bytes.Buffer
+		vk.WriteTo(&bufVK)
+		os.WriteFile(vkFile, bufVK.Bytes(), 0644)
+
+		globalPK = pk
+		globalVK = vk
+	}
+}
+
 //////////////
 
 type CircuitTxDefault3Coin struct {
@@ -3679,9 +4047,18 @@ type CircuitTxDefault3Coin struct {
 	G_b2    sw_bls12377.G1Affine `gnark:",public"`
 	G_r2    sw_bls12377.G1Affine `gnark:",public"`
 	EncKey2 sw_bls12377.G1Affine
+
+	// Frais payés au validateur
+	Fee frontend.Variable `gnark:",public"`
+
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction (PUBLIC)
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
 }
 
 func (c *CircuitTxDefault3Coin) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// ----- Pour le coin 0 -----
 	hasher0, _ := mimc.NewMiMC(api)
 	hasher0.Reset()
@@ -3760,9 +4137,9 @@ func (c *CircuitTxDefault3Coin) Define(api frontend.API) error {
 		api.AssertIsEqual(c.CNew2[i], encVal2[i])
 	}
 
-	// ----- Conservation globale -----
+	// ----- Conservation globale (frais compris) -----
 	totalOldCoin := api.Add(api.Add(c.OldCoin0, c.OldCoin1), c.OldCoin2)
-	totalNewCoin := api.Add(api.Add(c.NewCoin0, c.NewCoin1), c.NewCoin2)
+	totalNewCoin := api.Add(api.Add(c.NewCoin0, c.NewCoin1), c.NewCoin2, c.Fee)
 	api.AssertIsEqual(totalOldCoin, totalNewCoin)
 
 	totalOldEnergy := api.Add(api.Add(c.OldEnergy0, c.OldEnergy1), c.OldEnergy2)
@@ -3904,6 +4281,10 @@ func (inp *InputProverDefaultNCoin) BuildWitness3() (frontend.Circuit, error) {
 	c.G_r2 = sw_bls12377.NewG1Affine(inp.G_r[2])
 	c.EncKey2 = sw_bls12377.NewG1Affine(inp.EncKey[2])
 
+	c.Fee = feeValue(inp.Fee)
+	c.ChainID = inp.ChainID
+	c.Expiry = inp.Expiry
+
 	return &c, nil
 }
 
@@ -4004,10 +4385,16 @@ type CircuitTxF3 struct {
 	G_b2    sw_bls12377.G1Affine `gnark:",public"`
 	G_r2    sw_bls12377.G1Affine `gnark:",public"`
 	EncKey2 sw_bls12377.G1Affine
+
+	// Réseau et dernière hauteur de bloc pouvant inclure la transaction (PUBLIC)
+	ChainID frontend.Variable `gnark:",public"`
+	Expiry  frontend.Variable `gnark:",public"`
 }
 
 // Define implémente les contraintes du circuit pour 3 coins.
 func (c *CircuitTxF3) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
 	// --- Traitement du coin 0 ---
 	decVal0 := DecZKReg(api, c.C0[:], c.SkT0)
 	for i := 0; i < 5; i++ {
@@ -4223,6 +4610,8 @@ func (ip *InputTxFN) BuildWitness3() (frontend.Circuit, error) {
 	c.G_r1 = sw_bls12377.NewG1Affine(ip.G_r[1])
 	c.G_r2 = sw_bls12377.NewG1Affine(ip.G_r[2])
 
+	c.ChainID = ip.ChainID
+	c.Expiry = ip.Expiry
 	return &c, nil
 }
 
@@ -4230,32 +4619,35 @@ func (ip *InputTxFN) BuildWitness3() (frontend.Circuit, error) {
 
 // CircuitWithdraw formalise l’algo 4 (Withdraw).
 // Il faut prouver :
-// 1) snIn = PRF(skIn, rhoIn)
-// 2) cmOut = Com(Γout || pkOut || rhoOut, rOut)
-// 3) cipherAux = Enc(pkT, b, skIn, pkOut)   (avec la bonne aléa)
+// 1) cmIn = Com(Γin, rhoIn, rIn)   (NIn est la note livrée OutCm du règlement, cf. ValidateTxDraw)
+// 2) pkIn = MiMC(skIn), snIn = PRF(skIn, rhoIn)   (le propriétaire retire NIn)
+// 3) Γin.Energy = Fill, Γout = Γin - (Fee, 0)   (allocation de l'enchère sur 64 bits, Fee au validateur)
+// 4) cmOut = Com(Γout, rhoOut, rOut)
+// 5) cipherAux = Enc(pkT, b, skIn, pkOut)
 type CircuitWithdraw struct {
 	// ----- Variables PUBLIQUES -----
-	SnIn  frontend.Variable    `gnark:",public"` // snᵢ^(in)
-	CmOut frontend.Variable    `gnark:",public"` // cmᵢ^(out)
-	PkT   sw_bls12377.G1Affine `gnark:",public"` // pkₜ
-	// CipherAux correspond au ciphertext (bᵢ, skᵢ^(in), pkᵢ^(out)) chiffré sous pkₜ
-	// Selon votre schéma, ça peut être 4, 5, 6 field elements, etc.
-	// Ici on suppose 5 elements par ex.
+	SnIn    frontend.Variable    `gnark:",public"` // snᵢ^(in)
+	CmIn    frontend.Variable    `gnark:",public"` // cmᵢ^(in), note livrée par le règlement
+	CmOut   frontend.Variable    `gnark:",public"` // cmᵢ^(out)
+	Fill    frontend.Variable    `gnark:",public"` // énergie attribuée par l'enchère
+	Fee     frontend.Variable    `gnark:",public"` // frais payés au validateur
+	ChainID frontend.Variable    `gnark:",public"` // réseau de la transaction
+	Expiry  frontend.Variable    `gnark:",public"` // dernière hauteur de bloc l'incluant
+	PkT     sw_bls12377.G1Affine `gnark:",public"` // pkₜ
+	// CipherAux correspond au ciphertext (pkᵢ^(out), skᵢ^(in), bᵢ) chiffré sous pkₜ
 	CipherAux [3]frontend.Variable `gnark:",public"`
 
 	// ----- Variables PRIVEES (witness) -----
 	SkIn frontend.Variable // skᵢ^(in)
 	B    frontend.Variable // bᵢ
-	//REnc frontend.Variable // rEnc (aléa d’encryption)
 
 	// Note consommée (in)
 	NIn struct {
-		Coins  frontend.Variable // ou int64 si vous avez un seul champ
-		Energy frontend.Variable // si vous gérez 2 goods
+		Coins  frontend.Variable
+		Energy frontend.Variable
 		PkIn   frontend.Variable
 		RhoIn  frontend.Variable
 		RIn    frontend.Variable
-		CmIn   frontend.Variable
 	}
 
 	// Note de sortie (out)
@@ -4265,38 +4657,38 @@ type CircuitWithdraw struct {
 		PkOut  frontend.Variable
 		RhoOut frontend.Variable
 		ROut   frontend.Variable
-		CmOut  frontend.Variable
 	}
 }
 
 // Define impose les contraintes ZK pour le Withdraw.
 func (c *CircuitWithdraw) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
 
-	// (1) Vérifier snIn = PRF(skIn, rhoIn)
-	//     => On recalcule snIn via votre PRF
-	snComputed := PRF(api, c.SkIn, c.NIn.RhoIn)
-	api.AssertIsEqual(c.SnIn, snComputed)
+	// (1) cmIn = Com(Γin, rhoIn, rIn)
+	api.AssertIsEqual(c.CmIn, noteCm(api, c.NIn.Coins, c.NIn.Energy, c.NIn.RhoIn, c.NIn.RIn))
 
-	// (2) Vérifier cmOut = Com(Γout, pkOut, rhoOut, rOut)
-	//     => On recalcule le commit via MiMC ou autre
+	// (2) pkIn = MiMC(skIn) et snIn = PRF(skIn, rhoIn)
 	hasher, _ := mimc.NewMiMC(api)
-	hasher.Write(c.NOut.Coins)
-	hasher.Write(c.NOut.Energy)
-	//hasher.Write(c.NOut.PkOut)
-	hasher.Write(c.NOut.RhoOut)
-	// rOut peut être un champ de "commitment random"
-	// ou alors vous l’incluez différemment selon votre scheme
-	hasher.Write(c.NOut.ROut)
-	cmComputed := hasher.Sum()
-	api.AssertIsEqual(c.CmOut, cmComputed)
-	// On s’assure aussi que la note de sortie a un cmOut cohérent :
-	//api.AssertIsEqual(c.NOut.CmOut, cmComputed)
-
-	// (3) Vérifier que CipherAux = Enc(pkT, (b, skIn, pkOut)) avec rEnc
-	//     => On refait la logique "encryption" en circuit
-	//        puis on compare
+	hasher.Write(c.SkIn)
+	api.AssertIsEqual(c.NIn.PkIn, hasher.Sum())
+	api.AssertIsEqual(c.SnIn, PRF(api, c.SkIn, c.NIn.RhoIn))
+
+	// (3) Conservation : NIn est la note livrée par le règlement, qui porte
+	//     l'énergie Fill attribuée (le paiement Price*Fill a déjà été prélevé
+	//     sur la note enregistrée). NOut reçoit Fill, moins les frais.
+	api.AssertIsLessOrEqual(c.Fill, maxAmount)
+	api.AssertIsLessOrEqual(c.Fee, maxAmount)
+	api.AssertIsLessOrEqual(c.NIn.Coins, maxAmount)
+	api.AssertIsEqual(c.NIn.Energy, c.Fill)
+	api.AssertIsLessOrEqual(c.Fee, c.NIn.Coins)
+	api.AssertIsEqual(c.NOut.Coins, api.Sub(c.NIn.Coins, c.Fee))
+	api.AssertIsEqual(c.NOut.Energy, c.NIn.Energy)
+
+	// (4) cmOut = Com(Γout, rhoOut, rOut)
+	api.AssertIsEqual(c.CmOut, noteCm(api, c.NOut.Coins, c.NOut.Energy, c.NOut.RhoOut, c.NOut.ROut))
+
+	// (5) CipherAux = Enc(pkT, (pkOut, skIn, b))
 	encVal := EncWithdrawMimc(api, c.NOut.PkOut, c.SkIn, c.B, c.PkT)
-	// Compare chaque champ
 	for i := 0; i < 3; i++ {
 		api.AssertIsEqual(c.CipherAux[i], encVal[i])
 	}
@@ -4440,58 +4832,112 @@ func (c *CircuitWithdraw) Define(api frontend.API) error {
 // }
 
 type InputTxDraw struct {
+	// ------- PUBLIC -----------
 	SnIn      []byte
+	CmIn      []byte
 	CmOut     []byte
+	Fill      []byte
+	Fee       []byte
+	ChainID   uint64
+	Expiry    uint64
 	PkT       bls12377.G1Affine
 	CipherAux [3][]byte
+
+	// ------- PRIVÉ -----------
 	SkIn      []byte
 	B         []byte
 	REnc      []byte
-	NInCoins  []byte // ou int64 si vous avez un seul champ
-	NInEnergy []byte // si vous gérez 2 goods
+	NInCoins  []byte
+	NInEnergy []byte
 	NInPkIn   []byte
 	NInRhoIn  []byte
 	NInRIn    []byte
-	NInCmIn   []byte
 
 	NOutCoins  []byte
 	NOutEnergy []byte
 	NOutPkOut  []byte
 	NOutRhoOut []byte
 	NOutROut   []byte
-	NOutCmOut  []byte
 }
 
 func (ip *InputTxDraw) BuildWitness() (frontend.Circuit, error) {
 
-	var c CircuitWithdraw // CircuitTxF2 est défini avec des tableaux statiques pour 2 coins.
+	var c CircuitWithdraw
 
 	c.SnIn = new(big.Int).SetBytes(ip.SnIn)
+	c.CmIn = new(big.Int).SetBytes(ip.CmIn)
 	c.CmOut = new(big.Int).SetBytes(ip.CmOut)
+	c.Fill = new(big.Int).SetBytes(ip.Fill)
+	c.Fee = new(big.Int).SetBytes(ip.Fee)
 	c.PkT = sw_bls12377.NewG1Affine(ip.PkT)
 	for i := 0; i < 3; i++ {
 		c.CipherAux[i] = new(big.Int).SetBytes(ip.CipherAux[i])
 	}
 	c.SkIn = new(big.Int).SetBytes(ip.SkIn)
 	c.B = new(big.Int).SetBytes(ip.B)
-	//c.REnc = new(big.Int).SetBytes(ip.REnc)
 
 	c.NIn.Coins = new(big.Int).SetBytes(ip.NInCoins)
 	c.NIn.Energy = new(big.Int).SetBytes(ip.NInEnergy)
 	c.NIn.PkIn = new(big.Int).SetBytes(ip.NInPkIn)
 	c.NIn.RhoIn = new(big.Int).SetBytes(ip.NInRhoIn)
 	c.NIn.RIn = new(big.Int).SetBytes(ip.NInRIn)
-	c.NIn.CmIn = new(big.Int).SetBytes(ip.NInCmIn)
 
 	c.NOut.Coins = new(big.Int).SetBytes(ip.NOutCoins)
 	c.NOut.Energy = new(big.Int).SetBytes(ip.NOutEnergy)
 	c.NOut.PkOut = new(big.Int).SetBytes(ip.NOutPkOut)
 	c.NOut.RhoOut = new(big.Int).SetBytes(ip.NOutRhoOut)
 	c.NOut.ROut = new(big.Int).SetBytes(ip.NOutROut)
-	c.NOut.CmOut = new(big.Int).SetBytes(ip.NOutCmOut)
+	c.ChainID = ip.ChainID
+	c.Expiry = ip.Expiry
 	return &c, nil
 }
 
+// Public renvoie une copie de ip réduite à ses champs publics.
+func (ip *InputTxDraw) Public() InputTxDraw {
+	return InputTxDraw{
+		SnIn:      ip.SnIn,
+		CmIn:      ip.CmIn,
+		CmOut:     ip.CmOut,
+		Fill:      ip.Fill,
+		Fee:       ip.Fee,
+		ChainID:   ip.ChainID,
+		Expiry:    ip.Expiry,
+		PkT:       ip.PkT,
+		CipherAux: ip.CipherAux,
+	}
+}
+
+// ValidateTxDraw vérifie la preuve de retrait à partir des champs publics de
+// ip. La preuve doit être liée au réseau de ctx et ne pas avoir expiré. Le
+// circuit ne prouve pas l'appartenance de ip.CmIn au ledger : l'appelant doit
+// vérifier que ip.CmIn est une note engagée, celle que le règlement du round
+// livre pour l'allocation ip.Fill.
+func ValidateTxDraw(proofBytes []byte, ip InputTxDraw, ctx TxContext, vk groth16.VerifyingKey) bool {
+	if ctx.Expired(ip.Expiry) {
+		fmt.Println("transaction expirée =>", ip.Expiry)
+		return false
+	}
+	ip.ChainID = ctx.ChainID
+
+	proof := groth16.NewProof(ecc.BW6_761)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		fmt.Println("invalid proof =>", err)
+		return false
+	}
+	pub := ip.Public()
+	circuitPub, _ := pub.BuildWitness()
+	wPub, err := frontend.NewWitness(circuitPub, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		fmt.Println("NewWitness =>", err)
+		return false
+	}
+	if err := groth16.Verify(proof, vk, wPub); err != nil {
+		fmt.Println("Verify =>", err)
+		return false
+	}
+	return true
+}
+
 func BuildEncWithdrawMimc(pkOut, skIn, bid []byte, EncKey bls12377.G1Affine) [3]bls12377_fp.Element {
 
 	pk_out := new(big.Int).SetBytes(pkOut[:])
@@ -4712,3 +5158,835 @@ func EncWithdrawMimc(api frontend.API, pkOut, skIn, bid frontend.Variable, enc_k
 // 	api.AssertIsEqual(c.PkOld, pk)
 // 	return nil
 // }
+
+// -----------------------------------------------------------------------------
+// (8) Vendeur : enregistrement avec prix de réserve + preuve F côté vendeur
+// -----------------------------------------------------------------------------
+
+// CircuitTxSellerRegister est le pendant "vendeur" de CircuitTxRegister.
+// Le vendeur verrouille la note d'énergie qu'il met en vente (CmIn) et chiffre,
+// sous la clé DH partagée avec l'auctioneer, (pkOut, skIn, reserve, coins, energy)
+// avec le même schéma que EncZKReg (le prix de réserve prend la place du bid).
+// Contrairement au Bid de CircuitTxRegister, Reserve reste privé : il n'apparaît
+// que chiffré dans CAux[2].
+type CircuitTxSellerRegister struct {
+	// ====== Variables PUBLIQUES ======
+	CmIn          frontend.Variable    `gnark:",public"` // note d'énergie verrouillée
+	CAux          [5]frontend.Variable `gnark:",public"` // Enc(pkOut, skIn, reserve, coins, energy)
+	GammaInEnergy frontend.Variable    `gnark:",public"` // énergie mise en vente
+	GammaInCoins  frontend.Variable    `gnark:",public"`
+	ChainID       frontend.Variable    `gnark:",public"` // réseau de la transaction
+	Expiry        frontend.Variable    `gnark:",public"` // dernière hauteur de bloc l'incluant
+	G             sw_bls12377.G1Affine `gnark:",public"`
+	G_b           sw_bls12377.G1Affine `gnark:",public"`
+	G_r           sw_bls12377.G1Affine `gnark:",public"`
+
+	// ====== Variables PRIVEES ======
+	InCoin   frontend.Variable
+	InEnergy frontend.Variable
+	RhoIn    frontend.Variable
+	RandIn   frontend.Variable
+	SkIn     frontend.Variable
+	PkIn     frontend.Variable
+	PkOut    frontend.Variable // clé qui recevra le paiement et l'énergie invendue
+	Reserve  frontend.Variable // prix de réserve (par unité d'énergie)
+	EncKey   sw_bls12377.G1Affine
+	R        frontend.Variable
+}
+
+func (c *CircuitTxSellerRegister) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
+	// 1) cmIn = MiMC(coins, energy, rho, rand)
+	hasher, _ := mimc.NewMiMC(api)
+	hasher.Write(c.InCoin)
+	hasher.Write(c.InEnergy)
+	hasher.Write(c.RhoIn)
+	hasher.Write(c.RandIn)
+	cm := hasher.Sum()
+	api.AssertIsEqual(c.CmIn, cm)
+
+	// 2) la valeur publique est bien celle de la note verrouillée
+	api.AssertIsEqual(c.GammaInCoins, c.InCoin)
+	api.AssertIsEqual(c.GammaInEnergy, c.InEnergy)
+
+	// 3) pk_in = MiMC(sk_in)
+	hasher.Reset()
+	hasher.Write(c.SkIn)
+	pk := hasher.Sum()
+	api.AssertIsEqual(c.PkIn, pk)
+
+	// 4) CAux = Enc(pkOut, skIn, reserve, coins, energy)
+	encVal := EncZKReg(api, c.PkOut, c.SkIn, c.Reserve, c.InCoin, c.InEnergy, c.EncKey)
+	for i := 0; i < 5; i++ {
+		api.AssertIsEqual(c.CAux[i], encVal[i])
+	}
+
+	// 5) (G^r)^b == EncKey et G^r == G_r
+	G_r_b := new(sw_bls12377.G1Affine)
+	G_r_b.ScalarMul(api, c.G_b, c.R)
+	api.AssertIsEqual(c.EncKey.X, G_r_b.X)
+	api.AssertIsEqual(c.EncKey.Y, G_r_b.Y)
+
+	G_r := new(sw_bls12377.G1Affine)
+	G_r.ScalarMul(api, c.G, c.R)
+	api.AssertIsEqual(c.G_r.X, G_r.X)
+	api.AssertIsEqual(c.G_r.Y, G_r.Y)
+
+	return nil
+}
+
+type InputProverSellerRegister struct {
+	// ------- PUBLIC -----------
+	CmIn          []byte
+	CAux          [5][]byte
+	GammaInCoins  *big.Int
+	GammaInEnergy *big.Int
+	ChainID       uint64 // réseau de la transaction
+	Expiry        uint64 // dernière hauteur de bloc pouvant l'inclure
+
+	G   bls12377.G1Affine
+	G_b bls12377.G1Affine
+	G_r bls12377.G1Affine
+
+	// ------- PRIVÉ -----------
+	InCoin   *big.Int
+	InEnergy *big.Int
+	RhoIn    *big.Int
+	RandIn   *big.Int
+	SkIn     *big.Int
+	PkIn     *big.Int
+	PkOut    *big.Int
+	Reserve  *big.Int
+	EncKey   bls12377.G1Affine
+	R        *big.Int
+}
+
+// BuildWitness construit une instance de CircuitTxSellerRegister.
+// Côté vérifieur, seuls les champs PUBLIC ont besoin d'être remplis.
+func (ip *InputProverSellerRegister) BuildWitness() (frontend.Circuit, error) {
+	var c CircuitTxSellerRegister
+
+	// (1) champs PUBLIC
+	c.CmIn = ip.CmIn
+	for i := 0; i < 5; i++ {
+		c.CAux[i] = ip.CAux[i]
+	}
+	c.GammaInCoins = ip.GammaInCoins
+	c.GammaInEnergy = ip.GammaInEnergy
+	c.G = sw_bls12377.NewG1Affine(ip.G)
+	c.G_b = sw_bls12377.NewG1Affine(ip.G_b)
+	c.G_r = sw_bls12377.NewG1Affine(ip.G_r)
+
+	// (2) champs PRIVÉS
+	c.InCoin = ip.InCoin
+	c.InEnergy = ip.InEnergy
+	c.RhoIn = ip.RhoIn
+	c.RandIn = ip.RandIn
+	c.SkIn = ip.SkIn
+	c.PkIn = ip.PkIn
+	c.PkOut = ip.PkOut
+	c.Reserve = ip.Reserve
+	c.EncKey = sw_bls12377.NewG1Affine(ip.EncKey)
+	c.R = ip.R
+
+	c.ChainID = ip.ChainID
+	c.Expiry = ip.Expiry
+	return &c, nil
+}
+
+// ValidateTxSellerRegister vérifie une preuve d'enregistrement vendeur en
+// reconstruisant le witness public à partir des seules valeurs publiques, le
+// réseau étant celui de ctx.
+func ValidateTxSellerRegister(
+	proofBytes []byte,
+	cmIn []byte,
+	cAux [5][]byte,
+	gammaIn Gamma,
+	G, G_b, G_r bls12377.G1Affine,
+	expiry uint64,
+	ctx TxContext,
+	vk groth16.VerifyingKey,
+) bool {
+	if ctx.Expired(expiry) {
+		fmt.Println("transaction expirée =>", expiry)
+		return false
+	}
+	proof := groth16.NewProof(ecc.BW6_761)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		fmt.Println("invalid proof =>", err)
+		return false
+	}
+
+	ip := InputProverSellerRegister{
+		CmIn:          cmIn,
+		CAux:          cAux,
+		GammaInCoins:  gammaIn.Coins,
+		GammaInEnergy: gammaIn.Energy,
+		G:             G,
+		G_b:           G_b,
+		G_r:           G_r,
+		ChainID:       ctx.ChainID,
+		Expiry:        expiry,
+	}
+	circuitPub, _ := ip.BuildWitness()
+	wPub, err := frontend.NewWitness(circuitPub, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		fmt.Println("NewWitness =>", err)
+		return false
+	}
+	if err := groth16.Verify(proof, vk, wPub); err != nil {
+		fmt.Println("Verify =>", err)
+		return false
+	}
+	return true
+}
+
+// -----------------------------------------------------------------------------
+// (9) Règlement d'un round : remplissages partiels + conservation globale
+// -----------------------------------------------------------------------------
+
+// SettleBidder décrit la part d'un bidder dans CircuitTxSettle. Sa note
+// d'entrée est consommée et remplacée par deux notes :
+//   - OutCm    : énergie livrée, MiMC(0, Fill, OutRho, OutRand)
+//   - ChangeCm : monnaie rendue,  MiMC(InCoin - Price*Fill, InEnergy, ChangeRho, ChangeRand)
+//
+// Fill peut être inférieur à la demande du bidder (remplissage partiel), voire nul.
+//
+// Le ciphertext C est chiffré sous la clé du comité (cf. section 10) : EncKey
+// est public et sa validité (x·G_r) est établie hors circuit par les
+// déchiffrements partiels joints au règlement.
+type SettleBidder struct {
+	// ====== Variables PUBLIQUES ======
+	InCm     frontend.Variable    `gnark:",public"`
+	InSn     frontend.Variable    `gnark:",public"`
+	C        [5]frontend.Variable `gnark:",public"` // ciphertext d'enregistrement
+	Fill     frontend.Variable    `gnark:",public"` // énergie attribuée
+	OutCm    frontend.Variable    `gnark:",public"`
+	ChangeCm frontend.Variable    `gnark:",public"`
+	EncKey   sw_bls12377.G1Affine `gnark:",public"`
+
+	// ====== Variables PRIVEES ======
+	InCoin     frontend.Variable
+	InEnergy   frontend.Variable
+	InSk       frontend.Variable
+	InRho      frontend.Variable
+	InRand     frontend.Variable
+	OutRho     frontend.Variable
+	OutRand    frontend.Variable
+	ChangeRho  frontend.Variable
+	ChangeRand frontend.Variable
+}
+
+// SettleSeller est la part du vendeur. À partir du ciphertext d'enregistrement
+// C (identique à CAux), CircuitTxSettle prouve :
+//  1. que la note vendeur (InCm, InSn) est bien celle chiffrée dans C ;
+//  2. que Sold <= énergie offerte, Sold pouvant être inférieur (ask
+//     partiellement rempli) ;
+//  3. qu'aucune vente n'a lieu sous le prix de réserve (Price >= Reserve dès que Sold > 0) ;
+//  4. que le vendeur reçoit InCoin + Price*Sold coins, moins les frais Fee du
+//     règlement (OutPayCm) ;
+//  5. que l'énergie invendue InEnergy - Sold lui est rendue (OutChangeCm).
+type SettleSeller struct {
+	// ====== Variables PUBLIQUES ======
+	InCm        frontend.Variable    `gnark:",public"`
+	InSn        frontend.Variable    `gnark:",public"`
+	C           [5]frontend.Variable `gnark:",public"`
+	Sold        frontend.Variable    `gnark:",public"`
+	OutPayCm    frontend.Variable    `gnark:",public"`
+	OutChangeCm frontend.Variable    `gnark:",public"`
+	G_b         sw_bls12377.G1Affine `gnark:",public"`
+	G_r         sw_bls12377.G1Affine `gnark:",public"`
+
+	// ====== Variables PRIVEES ======
+	InCoin     frontend.Variable
+	InEnergy   frontend.Variable
+	InSk       frontend.Variable
+	InRho      frontend.Variable
+	InRand     frontend.Variable
+	PayRho     frontend.Variable
+	PayRand    frontend.Variable
+	ChangeRho  frontend.Variable
+	ChangeRand frontend.Variable
+	EncKey     sw_bls12377.G1Affine
+	R          frontend.Variable
+}
+
+// CircuitTxSettle prouve le règlement complet d'un round à prix uniforme Price :
+// chaque bidder paie Price*Fill (au plus ses coins, jamais au-dessus de son bid),
+// le vendeur ne vend pas sous sa réserve et paie les frais Fee du validateur sur
+// son produit, et la somme des Coins des notes de sortie plus Fee, comme celle de
+// leur Energy, est égale à celle des notes d'entrée sur tout le round.
+// Le nombre de bidders est fixé à la compilation (cf. NewCircuitTxSettle).
+type CircuitTxSettle struct {
+	Price frontend.Variable `gnark:",public"`
+	Fee   frontend.Variable `gnark:",public"` // frais payés au validateur
+	// Réseau et dernière hauteur de bloc pouvant inclure le règlement
+	ChainID frontend.Variable    `gnark:",public"`
+	Expiry  frontend.Variable    `gnark:",public"`
+	G       sw_bls12377.G1Affine `gnark:",public"`
+
+	Bidders []SettleBidder
+	Seller  SettleSeller
+}
+
+// NewCircuitTxSettle alloue un circuit de règlement pour n bidders.
+func NewCircuitTxSettle(n int) *CircuitTxSettle {
+	return &CircuitTxSettle{Bidders: make([]SettleBidder, n)}
+}
+
+// assertDHKey vérifie (G_b)^R == EncKey et G^R == G_r.
+func assertDHKey(api frontend.API, G, G_b, G_r, encKey sw_bls12377.G1Affine, R frontend.Variable) {
+	G_r_b := new(sw_bls12377.G1Affine)
+	G_r_b.ScalarMul(api, G_b, R)
+	api.AssertIsEqual(encKey.X, G_r_b.X)
+	api.AssertIsEqual(encKey.Y, G_r_b.Y)
+
+	G_r_ := new(sw_bls12377.G1Affine)
+	G_r_.ScalarMul(api, G, R)
+	api.AssertIsEqual(G_r.X, G_r_.X)
+	api.AssertIsEqual(G_r.Y, G_r_.Y)
+}
+
+// noteCm calcule MiMC(coins, energy, rho, rand) en circuit.
+func noteCm(api frontend.API, coins, energy, rho, rand frontend.Variable) frontend.Variable {
+	hasher, _ := mimc.NewMiMC(api)
+	hasher.Write(coins)
+	hasher.Write(energy)
+	hasher.Write(rho)
+	hasher.Write(rand)
+	return hasher.Sum()
+}
+
+func (c *CircuitTxSettle) Define(api frontend.API) error {
+	bindTx(api, c.ChainID, c.Expiry)
+
+	var inCoins, inEnergy, outCoins, outEnergy frontend.Variable = 0, 0, 0, 0
+
+	// Prix, quantités et montants des notes sont bornés à maxAmount : les
+	// produits Price*Fill et Price*Sold ne peuvent alors pas dépasser le module
+	// et faire boucler les équations de conservation.
+	api.AssertIsLessOrEqual(c.Price, maxAmount)
+
+	// 1) Bidders
+	for i := range c.Bidders {
+		b := &c.Bidders[i]
+
+		// [pkOut, coins, energy, skIn, bid]
+		decVal := DecZKReg(api, b.C[:], b.EncKey)
+		api.AssertIsEqual(decVal[1], b.InCoin)
+		api.AssertIsEqual(decVal[2], b.InEnergy)
+		api.AssertIsEqual(decVal[3], b.InSk)
+		bid := decVal[4]
+
+		api.AssertIsEqual(b.InCm, noteCm(api, b.InCoin, b.InEnergy, b.InRho, b.InRand))
+		api.AssertIsEqual(b.InSn, PRF(api, b.InSk, b.InRho))
+
+		api.AssertIsLessOrEqual(b.Fill, maxAmount)
+		api.AssertIsLessOrEqual(b.InCoin, maxAmount)
+		api.AssertIsLessOrEqual(b.InEnergy, maxAmount)
+
+		// Le bidder peut payer ce qui lui est attribué...
+		pay := api.Mul(c.Price, b.Fill)
+		api.AssertIsLessOrEqual(pay, b.InCoin)
+		// ...et n'est jamais servi au-dessus de son bid.
+		effectiveBid := api.Select(api.IsZero(b.Fill), c.Price, bid)
+		api.AssertIsLessOrEqual(c.Price, effectiveBid)
+
+		changeCoins := api.Sub(b.InCoin, pay)
+		api.AssertIsLessOrEqual(changeCoins, maxAmount)
+		api.AssertIsEqual(b.OutCm, noteCm(api, 0, b.Fill, b.OutRho, b.OutRand))
+		api.AssertIsEqual(b.ChangeCm, noteCm(api, changeCoins, b.InEnergy, b.ChangeRho, b.ChangeRand))
+
+		inCoins = api.Add(inCoins, b.InCoin)
+		inEnergy = api.Add(inEnergy, b.InEnergy)
+		outCoins = api.Add(outCoins, changeCoins)
+		outEnergy = api.Add(outEnergy, b.Fill, b.InEnergy)
+	}
+
+	// 2) Vendeur : [pkOut, coins, energy, skIn, reserve]
+	s := &c.Seller
+	decVal := DecZKReg(api, s.C[:], s.EncKey)
+	api.AssertIsEqual(decVal[1], s.InCoin)
+	api.AssertIsEqual(decVal[2], s.InEnergy)
+	api.AssertIsEqual(decVal[3], s.InSk)
+	reserve := decVal[4]
+
+	api.AssertIsEqual(s.InCm, noteCm(api, s.InCoin, s.InEnergy, s.InRho, s.InRand))
+	api.AssertIsEqual(s.InSn, PRF(api, s.InSk, s.InRho))
+
+	api.AssertIsLessOrEqual(s.InCoin, maxAmount)
+	api.AssertIsLessOrEqual(s.InEnergy, maxAmount)
+	api.AssertIsLessOrEqual(s.Sold, maxAmount)
+	api.AssertIsLessOrEqual(s.Sold, s.InEnergy)
+	effectivePrice := api.Select(api.IsZero(s.Sold), reserve, c.Price)
+	api.AssertIsLessOrEqual(reserve, effectivePrice)
+
+	// Les frais, bornés comme les montants, sont prélevés sur le produit de
+	// la vente, qui doit les couvrir.
+	api.AssertIsLessOrEqual(c.Fee, maxAmount)
+	proceeds := api.Add(s.InCoin, api.Mul(c.Price, s.Sold))
+	api.AssertIsLessOrEqual(c.Fee, proceeds)
+	payCoins := api.Sub(proceeds, c.Fee)
+	unsold := api.Sub(s.InEnergy, s.Sold)
+	api.AssertIsLessOrEqual(payCoins, maxAmount)
+	api.AssertIsLessOrEqual(unsold, maxAmount)
+	api.AssertIsEqual(s.OutPayCm, noteCm(api, payCoins, 0, s.PayRho, s.PayRand))
+	api.AssertIsEqual(s.OutChangeCm, noteCm(api, 0, unsold, s.ChangeRho, s.ChangeRand))
+
+	assertDHKey(api, c.G, s.G_b, s.G_r, s.EncKey, s.R)
+
+	inCoins = api.Add(inCoins, s.InCoin)
+	inEnergy = api.Add(inEnergy, s.InEnergy)
+	outCoins = api.Add(outCoins, payCoins)
+	outEnergy = api.Add(outEnergy, unsold)
+
+	// 3) Conservation sur tout le round
+	api.AssertIsEqual(inCoins, api.Add(outCoins, c.Fee))
+	api.AssertIsEqual(inEnergy, outEnergy)
+
+	return nil
+}
+
+// InputSettleBidder regroupe les valeurs d'un bidder pour InputProverSettle.
+type InputSettleBidder struct {
+	// ------- PUBLIC -----------
+	InCm     []byte
+	InSn     []byte
+	C        [5][]byte
+	Fill     *big.Int
+	OutCm    []byte
+	ChangeCm []byte
+	EncKey   bls12377.G1Affine
+
+	// ------- PRIVÉ -----------
+	InCoin     *big.Int
+	InEnergy   *big.Int
+	InSk       []byte
+	InRho      []byte
+	InRand     []byte
+	OutRho     *big.Int
+	OutRand    *big.Int
+	ChangeRho  *big.Int
+	ChangeRand *big.Int
+}
+
+// InputSettleSeller regroupe les valeurs du vendeur pour InputProverSettle.
+type InputSettleSeller struct {
+	// ------- PUBLIC -----------
+	InCm        []byte
+	InSn        []byte
+	C           [5][]byte
+	Sold        *big.Int
+	OutPayCm    []byte
+	OutChangeCm []byte
+	G_b         bls12377.G1Affine
+	G_r         bls12377.G1Affine
+
+	// ------- PRIVÉ -----------
+	InCoin     *big.Int
+	InEnergy   *big.Int
+	InSk       []byte
+	InRho      []byte
+	InRand     []byte
+	PayRho     *big.Int
+	PayRand    *big.Int
+	ChangeRho  *big.Int
+	ChangeRand *big.Int
+	EncKey     bls12377.G1Affine
+	R          []byte
+}
+
+type InputProverSettle struct {
+	Price   *big.Int
+	Fee     *big.Int // frais payés au validateur (nil : pas de frais)
+	ChainID uint64
+	Expiry  uint64
+	G       bls12377.G1Affine
+	Bidders []InputSettleBidder
+	Seller  InputSettleSeller
+}
+
+// BuildWitness construit une instance de CircuitTxSettle.
+// Côté vérifieur, seuls les champs PUBLIC ont besoin d'être remplis.
+func (ip *InputProverSettle) BuildWitness() (frontend.Circuit, error) {
+	c := NewCircuitTxSettle(len(ip.Bidders))
+	c.Price = ip.Price
+	c.Fee = feeValue(ip.Fee)
+	c.ChainID = ip.ChainID
+	c.Expiry = ip.Expiry
+	c.G = sw_bls12377.NewG1Affine(ip.G)
+
+	for i, b := range ip.Bidders {
+		cb := &c.Bidders[i]
+		cb.InCm = b.InCm
+		cb.InSn = b.InSn
+		for j := 0; j < 5; j++ {
+			cb.C[j] = b.C[j]
+		}
+		cb.Fill = b.Fill
+		cb.OutCm = b.OutCm
+		cb.ChangeCm = b.ChangeCm
+		cb.EncKey = sw_bls12377.NewG1Affine(b.EncKey)
+
+		cb.InCoin = b.InCoin
+		cb.InEnergy = b.InEnergy
+		cb.InSk = b.InSk
+		cb.InRho = b.InRho
+		cb.InRand = b.InRand
+		cb.OutRho = b.OutRho
+		cb.OutRand = b.OutRand
+		cb.ChangeRho = b.ChangeRho
+		cb.ChangeRand = b.ChangeRand
+	}
+
+	s := ip.Seller
+	cs := &c.Seller
+	cs.InCm = s.InCm
+	cs.InSn = s.InSn
+	for j := 0; j < 5; j++ {
+		cs.C[j] = s.C[j]
+	}
+	cs.Sold = s.Sold
+	cs.OutPayCm = s.OutPayCm
+	cs.OutChangeCm = s.OutChangeCm
+	cs.G_b = sw_bls12377.NewG1Affine(s.G_b)
+	cs.G_r = sw_bls12377.NewG1Affine(s.G_r)
+
+	cs.InCoin = s.InCoin
+	cs.InEnergy = s.InEnergy
+	cs.InSk = s.InSk
+	cs.InRho = s.InRho
+	cs.InRand = s.InRand
+	cs.PayRho = s.PayRho
+	cs.PayRand = s.PayRand
+	cs.ChangeRho = s.ChangeRho
+	cs.ChangeRand = s.ChangeRand
+	cs.EncKey = sw_bls12377.NewG1Affine(s.EncKey)
+	cs.R = s.R
+
+	return c, nil
+}
+
+// Public renvoie une copie de ip ne contenant que les champs publics.
+func (ip *InputProverSettle) Public() InputProverSettle {
+	pub := InputProverSettle{
+		Price:   ip.Price,
+		Fee:     ip.Fee,
+		ChainID: ip.ChainID,
+		Expiry:  ip.Expiry,
+		G:       ip.G,
+		Bidders: make([]InputSettleBidder, len(ip.Bidders)),
+		Seller: InputSettleSeller{
+			InCm:        ip.Seller.InCm,
+			InSn:        ip.Seller.InSn,
+			C:           ip.Seller.C,
+			Sold:        ip.Seller.Sold,
+			OutPayCm:    ip.Seller.OutPayCm,
+			OutChangeCm: ip.Seller.OutChangeCm,
+			G_b:         ip.Seller.G_b,
+			G_r:         ip.Seller.G_r,
+		},
+	}
+	for i, b := range ip.Bidders {
+		pub.Bidders[i] = InputSettleBidder{
+			InCm:     b.InCm,
+			InSn:     b.InSn,
+			C:        b.C,
+			Fill:     b.Fill,
+			OutCm:    b.OutCm,
+			ChangeCm: b.ChangeCm,
+			EncKey:   b.EncKey,
+		}
+	}
+	return pub
+}
+
+// ValidateTxSettle vérifie la preuve de règlement à partir des champs publics de ip,
+// dans le contexte ctx.
+func ValidateTxSettle(proofBytes []byte, ip InputProverSettle, ctx TxContext, vk groth16.VerifyingKey) bool {
+	if ctx.Expired(ip.Expiry) {
+		fmt.Println("transaction expirée =>", ip.Expiry)
+		return false
+	}
+	ip.ChainID = ctx.ChainID
+	proof := groth16.NewProof(ecc.BW6_761)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		fmt.Println("invalid proof =>", err)
+		return false
+	}
+	pub := ip.Public()
+	circuitPub, _ := pub.BuildWitness()
+	wPub, err := frontend.NewWitness(circuitPub, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		fmt.Println("NewWitness =>", err)
+		return false
+	}
+	if err := groth16.Verify(proof, vk, wPub); err != nil {
+		fmt.Println("Verify =>", err)
+		return false
+	}
+	return true
+}
+
+// -----------------------------------------------------------------------------
+// (10) Comité de déchiffrement t-sur-n : DKG (Feldman) + déchiffrement partiel
+// -----------------------------------------------------------------------------
+
+// Les bids sont chiffrés sous la clé du comité Y = G^x, où x n'est connu de
+// personne : chaque membre i distribue un polynôme f_i de degré t-1 et x est la
+// somme des f_i(0). Le bidder tire r, publie G_r = G^r et chiffre sous
+// EncKey = Y^r = x·G_r, exactement comme pour une clé DH (G_b = Y). Le membre
+// d'indice j détient x_j = Σ_i f_i(j) et publie x_j·G_r accompagné d'une preuve
+// d'égalité de logarithmes discrets (Chaum-Pedersen) ; t parts suffisent à
+// retrouver EncKey par interpolation de Lagrange en 0.
+//
+// Les indices DKG commencent à 1 (f(0) est le secret).
+
+// DKGPolynomial tire les t coefficients d'un polynôme de degré t-1.
+func DKGPolynomial(t int) ([]bls12377_fr.Element, error) {
+	if t < 1 {
+		return nil, fmt.Errorf("invalid threshold %d", t)
+	}
+	coeffs := make([]bls12377_fr.Element, t)
+	for k := range coeffs {
+		if _, err := coeffs[k].SetRandom(); err != nil {
+			return nil, err
+		}
+	}
+	return coeffs, nil
+}
+
+func g1Mul(P bls12377.G1Affine, s bls12377_fr.Element) bls12377.G1Affine {
+	return *new(bls12377.G1Affine).ScalarMultiplication(&P, s.BigInt(new(big.Int)))
+}
+
+func g1Add(P, Q bls12377.G1Affine) bls12377.G1Affine {
+	return *new(bls12377.G1Affine).Add(&P, &Q)
+}
+
+// DKGCommit renvoie les engagements de Feldman G^{a_k} des coefficients.
+func DKGCommit(G bls12377.G1Affine, coeffs []bls12377_fr.Element) []bls12377.G1Affine {
+	commitments := make([]bls12377.G1Affine, len(coeffs))
+	for k, a := range coeffs {
+		commitments[k] = g1Mul(G, a)
+	}
+	return commitments
+}
+
+// DKGShare évalue le polynôme en l'indice j du destinataire.
+func DKGShare(coeffs []bls12377_fr.Element, j int) bls12377_fr.Element {
+	var x, share bls12377_fr.Element
+	x.SetUint64(uint64(j))
+	for k := len(coeffs) - 1; k >= 0; k-- {
+		share.Mul(&share, &x).Add(&share, &coeffs[k])
+	}
+	return share
+}
+
+// dkgEval calcule Σ_k C_k j^k, soit G^{f(j)} pour des engagements honnêtes.
+func dkgEval(commitments []bls12377.G1Affine, j int) bls12377.G1Affine {
+	var x, xk bls12377_fr.Element
+	x.SetUint64(uint64(j))
+	xk.SetOne()
+	var acc bls12377.G1Affine
+	for _, C := range commitments {
+		acc = g1Add(acc, g1Mul(C, xk))
+		xk.Mul(&xk, &x)
+	}
+	return acc
+}
+
+// VerifyDKGShare vérifie que share = f(j) pour le polynôme engagé.
+func VerifyDKGShare(G bls12377.G1Affine, commitments []bls12377.G1Affine, j int, share bls12377_fr.Element) bool {
+	expected := dkgEval(commitments, j)
+	got := g1Mul(G, share)
+	return got.Equal(&expected)
+}
+
+// DKGPublicKey renvoie la clé du comité Y = Σ_i C_i0.
+func DKGPublicKey(dealings [][]bls12377.G1Affine) bls12377.G1Affine {
+	var Y bls12377.G1Affine
+	for _, commitments := range dealings {
+		Y = g1Add(Y, commitments[0])
+	}
+	return Y
+}
+
+// DKGVerificationKey renvoie X_j = G^{x_j}, calculable par tous à partir des
+// engagements publics ; elle sert à vérifier les déchiffrements partiels de j.
+func DKGVerificationKey(dealings [][]bls12377.G1Affine, j int) bls12377.G1Affine {
+	var X bls12377.G1Affine
+	for _, commitments := range dealings {
+		X = g1Add(X, dkgEval(commitments, j))
+	}
+	return X
+}
+
+// NewCommitteeEncKey tire l'aléa r d'un chiffrement sous la clé du comité Y et
+// renvoie (EncKey = Y^r, G_r = G^r, r), à utiliser comme (EncKey, G_r, R) avec
+// G_b = Y dans les circuits d'enregistrement.
+func NewCommitteeEncKey(G, Y bls12377.G1Affine) (encKey, G_r bls12377.G1Affine, r []byte, err error) {
+	var s bls12377_fr.Element
+	if _, err = s.SetRandom(); err != nil {
+		return
+	}
+	rBytes := s.Bytes()
+	return g1Mul(Y, s), g1Mul(G, s), rBytes[:], nil
+}
+
+// DLEQProof prouve log_G(X) = log_U(D) sans révéler le logarithme.
+type DLEQProof struct {
+	C []byte // défi
+	Z []byte // réponse
+}
+
+// PartialDecryption est la part D = x_j·U du membre d'indice Index.
+type PartialDecryption struct {
+	Index int
+	D     bls12377.G1Affine
+	Proof DLEQProof
+}
+
+func dleqChallenge(points ...bls12377.G1Affine) bls12377_fr.Element {
+	h := sha256.New()
+	for _, P := range points {
+		h.Write(P.Marshal())
+	}
+	var c bls12377_fr.Element
+	c.SetBytes(h.Sum(nil))
+	return c
+}
+
+// PartialDecrypt calcule la part du membre d'indice index pour le G_r U.
+func PartialDecrypt(G, U bls12377.G1Affine, index int, share bls12377_fr.Element) (PartialDecryption, error) {
+	var w bls12377_fr.Element
+	if _, err := w.SetRandom(); err != nil {
+		return PartialDecryption{}, err
+	}
+	X := g1Mul(G, share)
+	D := g1Mul(U, share)
+	c := dleqChallenge(G, X, U, D, g1Mul(G, w), g1Mul(U, w))
+
+	// z = w - c·x_j
+	var z bls12377_fr.Element
+	z.Mul(&c, &share)
+	z.Sub(&w, &z)
+	cBytes, zBytes := c.Bytes(), z.Bytes()
+	return PartialDecryption{
+		Index: index,
+		D:     D,
+		Proof: DLEQProof{C: cBytes[:], Z: zBytes[:]},
+	}, nil
+}
+
+// VerifyPartialDecryption vérifie la part pd pour U contre la clé de
+// vérification X du membre.
+func VerifyPartialDecryption(G, U, X bls12377.G1Affine, pd PartialDecryption) bool {
+	var c, z bls12377_fr.Element
+	c.SetBytes(pd.Proof.C)
+	z.SetBytes(pd.Proof.Z)
+	// A1 = G^z X^c, A2 = U^z D^c
+	A1 := g1Add(g1Mul(G, z), g1Mul(X, c))
+	A2 := g1Add(g1Mul(U, z), g1Mul(pd.D, c))
+	expected := dleqChallenge(G, X, U, pd.D, A1, A2)
+	return c.Equal(&expected)
+}
+
+// CombinePartialDecryptions retrouve x·U à partir de parts d'indices distincts
+// (au moins t, non vérifié ici) par interpolation de Lagrange en 0.
+func CombinePartialDecryptions(parts []PartialDecryption) (bls12377.G1Affine, error) {
+	var acc bls12377.G1Affine
+	for i, pi := range parts {
+		if pi.Index < 1 {
+			return acc, fmt.Errorf("invalid share index %d", pi.Index)
+		}
+		// λ_i = Π_{j≠i} j / (j - i)
+		var lambda, xi bls12377_fr.Element
+		lambda.SetOne()
+		xi.SetUint64(uint64(pi.Index))
+		for j, pj := range parts {
+			if i == j {
+				continue
+			}
+			if pi.Index == pj.Index {
+				return acc, fmt.Errorf("duplicate share index %d", pi.Index)
+			}
+			var xj, den bls12377_fr.Element
+			xj.SetUint64(uint64(pj.Index))
+			den.Sub(&xj, &xi).Inverse(&den)
+			lambda.Mul(&lambda, &xj).Mul(&lambda, &den)
+		}
+		acc = g1Add(acc, g1Mul(pi.D, lambda))
+	}
+	return acc, nil
+}
+
+// -----------------------------------------------------------------------------
+// (11) Frais : note du validateur collectant les frais d'un bloc
+// -----------------------------------------------------------------------------
+
+// Chaque transaction paie des frais publics Fee, sortis de la conservation des
+// coins de son circuit. Le validateur qui propose un bloc crée une note
+// cm = Com((Fees, 0), rho, rand) de la somme des frais du bloc, dont il garde
+// l'ouverture : comme l'ouverture d'une note suffit à la dépenser, elle ne peut
+// pas être publiée, et CircuitFeeNote prouve aux autres validateurs que cm
+// contient exactement Fees coins.
+type CircuitFeeNote struct {
+	Fees frontend.Variable `gnark:",public"`
+	Cm   frontend.Variable `gnark:",public"`
+
+	Rho  frontend.Variable
+	Rand frontend.Variable
+}
+
+func (c *CircuitFeeNote) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Cm, noteCm(api, c.Fees, 0, c.Rho, c.Rand))
+	return nil
+}
+
+type InputFeeNote struct {
+	// PUBLIC
+	Fees *big.Int
+	Cm   []byte
+
+	// PRIVÉ
+	Rho  *big.Int
+	Rand *big.Int
+}
+
+func (ip *InputFeeNote) BuildWitness() (frontend.Circuit, error) {
+	var c CircuitFeeNote
+	c.Fees = ip.Fees
+	c.Cm = new(big.Int).SetBytes(ip.Cm)
+	c.Rho = ip.Rho
+	c.Rand = ip.Rand
+	if ip.Rho == nil {
+		c.Rho = 0
+	}
+	if ip.Rand == nil {
+		c.Rand = 0
+	}
+	return &c, nil
+}
+
+// ValidateFeeNote vérifie que la note cm contient fees coins.
+func ValidateFeeNote(proofBytes []byte, fees *big.Int, cm []byte, vk groth16.VerifyingKey) bool {
+	proof := groth16.NewProof(ecc.BW6_761)
+	if _, err := proof.ReadFrom(bytes.NewReader(proofBytes)); err != nil {
+		fmt.Println("invalid proof =>", err)
+		return false
+	}
+	pub := InputFeeNote{Fees: fees, Cm: cm}
+	circuitPub, _ := pub.BuildWitness()
+	wPub, err := frontend.NewWitness(circuitPub, ecc.BW6_761.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		fmt.Println("NewWitness =>", err)
+		return false
+	}
+	if err := groth16.Verify(proof, vk, wPub); err != nil {
+		fmt.Println("Verify =>", err)
+		return false
+	}
+	return true
+}
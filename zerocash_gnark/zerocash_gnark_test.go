@@ -0,0 +1,68 @@
+package zerocash_gnark
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/hash/mimc"
+)
+
+// commitmentCircuit recalcule un engagement comme les circuits de transaction.
+type commitmentCircuit struct {
+	Coins, Energy, Rho, Rand frontend.Variable
+	Cm                       frontend.Variable `gnark:",public"`
+}
+
+func (c *commitmentCircuit) Define(api frontend.API) error {
+	h, err := mimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+	h.Write(c.Coins)
+	h.Write(c.Energy)
+	h.Write(c.Rho)
+	h.Write(c.Rand)
+	api.AssertIsEqual(h.Sum(), c.Cm)
+	return nil
+}
+
+// TestCommittmentEncoding fixe l'encodage natif des engagements. Une valeur
+// nulle est absorbée comme l'élément 0, comme dans les circuits : avant
+// elementBytes, Committment l'omettait, et l'engagement d'une note sans coins
+// ou sans énergie ne correspondait à aucune preuve.
+func TestCommittmentEncoding(t *testing.T) {
+	cs, err := frontend.Compile(ecc.BW6_761.ScalarField(), r1cs.NewBuilder, &commitmentCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                       string
+		coins, energy, rho, random int64
+		cm                         string // hex, fixé
+	}{
+		{"zero energy", 100, 0, 3, 4, "00e9d60b3be3211c6769b1e483265595dc3b398044371629ff9c6bce2f47aa27f4e9c42d0a5fb417dcefe34dec4e0c48"},
+		{"zero coins", 0, 7, 3, 4, "005728b872b6e48980c9a589c73f2082ceef8134d4b099abe3ca83e6487439a31f41c2330f5590eaa6b620c193b073ff"},
+		{"all zero", 0, 0, 0, 0, "01482d4b5e02b5c01bfb5afb4556bf35bde42fd5452a7af12db507df7da81828a01cfe7853e1da928886ec11ffff07b7"},
+		{"no zero", 100, 7, 3, 4, "015f455de07a9e8d0b0b5217b417cdb78ba8fb9d0cebe979940348f062d22d0b1cad298373172817a58379ef34342bc4"},
+	}
+	for _, tt := range tests {
+		coins, energy, rho, random := big.NewInt(tt.coins), big.NewInt(tt.energy), big.NewInt(tt.rho), big.NewInt(tt.random)
+		cm := Committment(coins, energy, rho, random)
+		if got := hex.EncodeToString(cm); got != tt.cm {
+			t.Errorf("%s: cm = %s, want %s", tt.name, got, tt.cm)
+		}
+		w, err := frontend.NewWitness(&commitmentCircuit{
+			Coins: coins, Energy: energy, Rho: rho, Rand: random, Cm: cm,
+		}, ecc.BW6_761.ScalarField())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.IsSolved(w); err != nil {
+			t.Errorf("%s: circuit disagrees with Committment: %v", tt.name, err)
+		}
+	}
+}
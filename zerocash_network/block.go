@@ -0,0 +1,231 @@
+// block.go
+package zerocash_network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// BlockHeader engage le contenu d'un bloc et l'état du validateur après ce
+// bloc : CmRoot est la racine de Merkle des commitments (ordre d'insertion),
+// NullifierDigest le condensé des nullifiers dépensés (triés) et TxRoot la
+// racine de Merkle des entrées du bloc (cf. BlockEntry.Leaf). Fees est la somme des
+// frais des entrées, collectés par le proposeur dans la note FeeCm, ajoutée
+// après les commitments des entrées (vide : les frais sont brûlés).
+type BlockHeader struct {
+	Height          uint64
+	PrevHash        []byte
+	CmRoot          []byte
+	NullifierDigest []byte
+	TxRoot          []byte
+	Fees            uint64
+	FeeCm           []byte
+	Timestamp       time.Time
+}
+
+// BlockEntry est une transaction acceptée, vue depuis le bloc : ID est le
+// condensé de l'entrée journalisée par le validateur, suivi des nullifiers et
+// commitments qu'elle publie ou révoque, des frais qu'elle paie et de sa
+// hauteur d'expiration.
+type BlockEntry struct {
+	ID         []byte
+	Sns        [][]byte
+	Cms        [][]byte
+	RevokedSns [][]byte
+	RevokedCms [][]byte
+	Fee        uint64
+	Expiry     uint64
+}
+
+// Leaf renvoie la feuille de e dans le TxRoot : l'encodage de tous ses champs,
+// chaque liste préfixée de sa longueur. Un bloc dont une entrée est modifiée
+// (nullifier, commitment, frais, expiration) ne correspond donc plus à son
+// en-tête.
+func (e BlockEntry) Leaf() []byte {
+	var buf bytes.Buffer
+	var n [8]byte
+	writeBytes(&buf, e.ID)
+	for _, list := range [][][]byte{e.Sns, e.Cms, e.RevokedSns, e.RevokedCms} {
+		binary.BigEndian.PutUint32(n[:4], uint32(len(list)))
+		buf.Write(n[:4])
+		for _, b := range list {
+			writeBytes(&buf, b)
+		}
+	}
+	binary.BigEndian.PutUint64(n[:], e.Fee)
+	buf.Write(n[:])
+	binary.BigEndian.PutUint64(n[:], e.Expiry)
+	buf.Write(n[:])
+	return buf.Bytes()
+}
+
+// Block est un bloc scellé. FeeProof prouve que Header.FeeCm contient
+// Header.Fees coins (cf. zg.ValidateFeeNote).
+type Block struct {
+	Header   BlockHeader
+	Entries  []BlockEntry
+	FeeProof []byte
+}
+
+// BlockRequestPayload demande au validateur au plus Count blocs à partir de la
+// hauteur From ; HeadersOnly suffit à un client léger.
+type BlockRequestPayload struct {
+	From        uint64
+	Count       int
+	HeadersOnly bool
+}
+
+// BlockListPayload répond à BlockRequestPayload. Height est le nombre de blocs
+// scellés par le validateur.
+type BlockListPayload struct {
+	Height uint64
+	Blocks []Block
+}
+
+var (
+	ErrBrokenChain = errors.New("header does not extend the chain")
+	ErrBadTxRoot   = errors.New("block entries do not match the header")
+	ErrBadFees     = errors.New("block fees do not match its entries")
+)
+
+// Hash renvoie le condensé SHA-256 de l'en-tête, qui est le PrevHash du bloc
+// suivant.
+func (h BlockHeader) Hash() []byte {
+	var buf bytes.Buffer
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], h.Height)
+	buf.Write(n[:])
+	for _, b := range [][]byte{h.PrevHash, h.CmRoot, h.NullifierDigest, h.TxRoot} {
+		writeBytes(&buf, b)
+	}
+	binary.BigEndian.PutUint64(n[:], h.Fees)
+	buf.Write(n[:])
+	writeBytes(&buf, h.FeeCm)
+	binary.BigEndian.PutUint64(n[:], uint64(h.Timestamp.UnixNano()))
+	buf.Write(n[:])
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// writeBytes écrit b préfixé de sa longueur.
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	buf.Write(n[:])
+	buf.Write(b)
+}
+
+// MerkleRoot renvoie la racine de l'arbre de Merkle SHA-256 des feuilles ;
+// feuilles et nœuds internes sont séparés par un préfixe (0x00 / 0x01), et un
+// nœud sans frère remonte tel quel. L'arbre vide a pour racine SHA-256("").
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		sum := sha256.Sum256(append([]byte{0x00}, l...))
+		level[i] = sum[:]
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			node := append([]byte{0x01}, level[i]...)
+			sum := sha256.Sum256(append(node, level[i+1]...))
+			next = append(next, sum[:])
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// NullifierDigest renvoie le condensé de l'ensemble sns, indépendant de
+// l'ordre de publication.
+func NullifierDigest(sns [][]byte) []byte {
+	sorted := append([][]byte(nil), sns...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	var buf bytes.Buffer
+	for _, sn := range sorted {
+		writeBytes(&buf, sn)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// TxRoot renvoie la racine de Merkle des feuilles des entrées.
+func TxRoot(entries []BlockEntry) []byte {
+	leaves := make([][]byte, len(entries))
+	for i, e := range entries {
+		leaves[i] = e.Leaf()
+	}
+	return MerkleRoot(leaves)
+}
+
+// VerifyHeader vérifie que h suit prev : hauteur suivante, PrevHash égal au
+// condensé de prev et horodatage non décroissant. prev vaut nil pour le bloc
+// de genèse, qui doit être à la hauteur 0 sans PrevHash.
+func VerifyHeader(prev *BlockHeader, h BlockHeader) error {
+	if prev == nil {
+		if h.Height != 0 || len(h.PrevHash) != 0 {
+			return fmt.Errorf("%w: genesis at height %d", ErrBrokenChain, h.Height)
+		}
+		return nil
+	}
+	switch {
+	case h.Height != prev.Height+1:
+		return fmt.Errorf("%w: height %d after %d", ErrBrokenChain, h.Height, prev.Height)
+	case !bytes.Equal(h.PrevHash, prev.Hash()):
+		return fmt.Errorf("%w: previous hash mismatch at height %d", ErrBrokenChain, h.Height)
+	case h.Timestamp.Before(prev.Timestamp):
+		return fmt.Errorf("%w: timestamp goes back at height %d", ErrBrokenChain, h.Height)
+	}
+	return nil
+}
+
+// VerifyHeaderChain vérifie le chaînage de headers, le premier suivant prev
+// (nil si headers commence à la genèse). C'est la vérification d'un client
+// léger : elle ne rejoue pas les preuves des transactions.
+func VerifyHeaderChain(prev *BlockHeader, headers []BlockHeader) error {
+	for i := range headers {
+		if err := VerifyHeader(prev, headers[i]); err != nil {
+			return err
+		}
+		prev = &headers[i]
+	}
+	return nil
+}
+
+// BlockFees renvoie la somme des frais des entrées, ou une erreur si elle
+// dépasse 64 bits.
+func BlockFees(entries []BlockEntry) (uint64, error) {
+	var fees uint64
+	for _, e := range entries {
+		if fees+e.Fee < fees {
+			return 0, ErrBadFees
+		}
+		fees += e.Fee
+	}
+	return fees, nil
+}
+
+// VerifyBlock vérifie que les entrées de b correspondent à son TxRoot et que
+// leurs frais font Header.Fees. La preuve FeeProof n'est pas vérifiée ici.
+func VerifyBlock(b Block) error {
+	if !bytes.Equal(TxRoot(b.Entries), b.Header.TxRoot) {
+		return fmt.Errorf("%w at height %d", ErrBadTxRoot, b.Header.Height)
+	}
+	if fees, err := BlockFees(b.Entries); err != nil || fees != b.Header.Fees {
+		return fmt.Errorf("%w at height %d", ErrBadFees, b.Header.Height)
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ package zerocash_network
 import (
 	"fmt"
 	"math/big"
-	"net"
 	"os"
 	"time"
 
@@ -26,7 +25,7 @@ func ClientMain(peerAddress string) {
 	var G_r_b bls12377.G1Affine
 
 	// Connexion au serveur à l'adresse passée en paramètre.
-	conn, err := net.Dial("tcp", peerAddress)
+	conn, err := Dial(peerAddress)
 	if err != nil {
 		fmt.Printf("Erreur lors de la connexion à %s : %v\n", peerAddress, err)
 		os.Exit(1)
@@ -0,0 +1,1173 @@
+// codec.go
+package zerocash_network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	zg "zerocash_gnark/zerocash_gnark"
+
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	bls12377_fp "github.com/consensys/gnark-crypto/ecc/bls12-377/fp"
+)
+
+// Codec binaire canonique des notes, transactions et preuves, indépendant de
+// gob et de l'ordre des champs Go. Un objet encodé commence par :
+//
+//	version uint8  CodecVersion
+//	tag     uint8  type de l'objet (tagGamma, tagNote, ...)
+//
+// suivi de ses champs, dans l'ordre fixé par les méthodes de writer ci-dessous.
+// Primitives (entiers en big-endian) :
+//
+//	int, uint64        8 octets (int en complément à deux)
+//	bool               1 octet, 0 ou 1
+//	[]byte             longueur uint32 puis les octets (preuves, clés, digests)
+//	*big.Int           1 octet de présence (0 : nil) puis 48 octets big-endian
+//	élément de corps   []byte big-endian (Cm, Sn, Rho, Rand, PkOwner) : encodé
+//	                   comme le *big.Int de même valeur, vide pour nil ; décodé
+//	                   sur exactement 48 octets
+//	fp.Element         48 octets big-endian, forme canonique (< p)
+//	bls12377.G1Affine  48 octets, forme compressée de gnark-crypto
+//	liste              nombre d'éléments uint32 puis les éléments
+//	tableau [N]        les N éléments, sans longueur
+//
+// Un *big.Int ou un élément de corps doit être réduit : 0 <= v < p, à
+// l'encodage comme au décodage, pour qu'une valeur n'ait qu'un encodage. Une
+// liste ou un []byte vide est décodé nil. Toute modification du format
+// incrémente CodecVersion.
+//
+// TxRegister et AuctionResultN implémentent encoding.BinaryMarshaler : gob les
+// transporte sous cette forme dans les messages "register" et "auction".
+const CodecVersion uint8 = 4
+
+// fieldSize est la taille fixe d'un élément de corps (fp de BLS12-377, fr de BW6-761).
+const fieldSize = 48
+
+// fieldModulus est p, le module commun à fp de BLS12-377 et fr de BW6-761.
+var fieldModulus = bls12377_fp.Modulus()
+
+const (
+	tagGamma uint8 = iota + 1
+	tagNote
+	tagTxResult
+	tagTxResultDefaultOneCoin
+	tagTxResultDefaultNCoin
+	tagTxRegister
+	tagAuctionResultN
+)
+
+var (
+	ErrCodecVersion = errors.New("zerocash_network: unsupported codec version")
+	ErrCodecTag     = errors.New("zerocash_network: encoded object has another type")
+	ErrCodecShort   = errors.New("zerocash_network: truncated encoding")
+	ErrCodecField   = errors.New("zerocash_network: value is not a reduced field element")
+)
+
+// MarshalBinary encode v, de type zg.Gamma, zg.Note, zg.TxResult,
+// zg.TxResultDefaultOneCoin, zg.TxResultDefaultNCoin, TxRegister ou
+// AuctionResultN (ou un pointeur vers l'un d'eux).
+func MarshalBinary(v interface{}) ([]byte, error) {
+	w := &writer{}
+	switch x := v.(type) {
+	case zg.Gamma:
+		w.header(tagGamma)
+		w.gamma(x)
+	case *zg.Gamma:
+		w.header(tagGamma)
+		w.gamma(*x)
+	case zg.Note:
+		w.header(tagNote)
+		w.note(x)
+	case *zg.Note:
+		w.header(tagNote)
+		w.note(*x)
+	case zg.TxResult:
+		w.header(tagTxResult)
+		w.txResult(x)
+	case *zg.TxResult:
+		w.header(tagTxResult)
+		w.txResult(*x)
+	case zg.TxResultDefaultOneCoin:
+		w.header(tagTxResultDefaultOneCoin)
+		w.txResultOneCoin(x)
+	case *zg.TxResultDefaultOneCoin:
+		w.header(tagTxResultDefaultOneCoin)
+		w.txResultOneCoin(*x)
+	case zg.TxResultDefaultNCoin:
+		w.header(tagTxResultDefaultNCoin)
+		w.txResultNCoin(x)
+	case *zg.TxResultDefaultNCoin:
+		w.header(tagTxResultDefaultNCoin)
+		w.txResultNCoin(*x)
+	case TxRegister:
+		w.header(tagTxRegister)
+		w.txRegister(x)
+	case *TxRegister:
+		w.header(tagTxRegister)
+		w.txRegister(*x)
+	case AuctionResultN:
+		w.header(tagAuctionResultN)
+		w.auctionResultN(x)
+	case *AuctionResultN:
+		w.header(tagAuctionResultN)
+		w.auctionResultN(*x)
+	default:
+		return nil, fmt.Errorf("zerocash_network: no binary encoding for %T", v)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// UnmarshalBinary décode data dans v, pointeur vers l'un des types acceptés
+// par MarshalBinary. Les octets restant après l'objet sont une erreur.
+func UnmarshalBinary(data []byte, v interface{}) error {
+	r := &reader{buf: data}
+	switch x := v.(type) {
+	case *zg.Gamma:
+		r.header(tagGamma)
+		*x = r.gamma()
+	case *zg.Note:
+		r.header(tagNote)
+		*x = r.note()
+	case *zg.TxResult:
+		r.header(tagTxResult)
+		*x = r.txResult()
+	case *zg.TxResultDefaultOneCoin:
+		r.header(tagTxResultDefaultOneCoin)
+		*x = r.txResultOneCoin()
+	case *zg.TxResultDefaultNCoin:
+		r.header(tagTxResultDefaultNCoin)
+		*x = r.txResultNCoin()
+	case *TxRegister:
+		r.header(tagTxRegister)
+		*x = r.txRegister()
+	case *AuctionResultN:
+		r.header(tagAuctionResultN)
+		*x = r.auctionResultN()
+	default:
+		return fmt.Errorf("zerocash_network: no binary decoding for %T", v)
+	}
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = fmt.Errorf("zerocash_network: %d trailing bytes", len(r.buf))
+	}
+	return r.err
+}
+
+// MarshalBinary encode t avec le codec binaire (cf. la fonction MarshalBinary).
+func (t TxRegister) MarshalBinary() ([]byte, error) { return MarshalBinary(t) }
+
+// UnmarshalBinary décode data dans t (cf. la fonction UnmarshalBinary).
+func (t *TxRegister) UnmarshalBinary(data []byte) error { return UnmarshalBinary(data, t) }
+
+// MarshalBinary encode a avec le codec binaire (cf. la fonction MarshalBinary).
+func (a AuctionResultN) MarshalBinary() ([]byte, error) { return MarshalBinary(a) }
+
+// UnmarshalBinary décode data dans a (cf. la fonction UnmarshalBinary).
+func (a *AuctionResultN) UnmarshalBinary(data []byte) error { return UnmarshalBinary(data, a) }
+
+// -------------------------------
+// Écriture
+// -------------------------------
+
+// writer accumule l'encodage ; la première erreur est conservée et les
+// écritures suivantes sont ignorées.
+type writer struct {
+	buf []byte
+	err error
+}
+
+func (w *writer) header(tag uint8) { w.buf = append(w.buf, CodecVersion, tag) }
+
+func (w *writer) u8(v uint8)   { w.buf = append(w.buf, v) }
+func (w *writer) u32(v int)    { w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(v)) }
+func (w *writer) u64(v uint64) { w.buf = binary.BigEndian.AppendUint64(w.buf, v) }
+func (w *writer) int(v int)    { w.u64(uint64(int64(v))) }
+
+func (w *writer) bool(v bool) {
+	if v {
+		w.u8(1)
+	} else {
+		w.u8(0)
+	}
+}
+
+func (w *writer) bytes(b []byte) {
+	w.u32(len(b))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *writer) bytesList(l [][]byte) {
+	w.u32(len(l))
+	for _, b := range l {
+		w.bytes(b)
+	}
+}
+
+func (w *writer) big(v *big.Int) {
+	if v == nil {
+		w.u8(0)
+		return
+	}
+	if v.Sign() < 0 || v.Cmp(fieldModulus) >= 0 {
+		if w.err == nil {
+			w.err = fmt.Errorf("%w: %v", ErrCodecField, v)
+		}
+		return
+	}
+	w.u8(1)
+	var b [fieldSize]byte
+	w.buf = append(w.buf, v.FillBytes(b[:])...)
+}
+
+// field écrit b, élément de corps en big-endian, sur fieldSize octets quelle
+// que soit sa longueur.
+func (w *writer) field(b []byte) {
+	if len(b) == 0 {
+		w.u8(0)
+		return
+	}
+	w.big(new(big.Int).SetBytes(b))
+}
+
+func (w *writer) fieldList(l [][]byte) {
+	w.u32(len(l))
+	for _, b := range l {
+		w.field(b)
+	}
+}
+
+func (w *writer) bigList(l []*big.Int) {
+	w.u32(len(l))
+	for _, v := range l {
+		w.big(v)
+	}
+}
+
+func (w *writer) fp(e bls12377_fp.Element) {
+	b := e.Bytes()
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *writer) fpList(l []bls12377_fp.Element) {
+	w.u32(len(l))
+	for _, e := range l {
+		w.fp(e)
+	}
+}
+
+func (w *writer) g1(p bls12377.G1Affine) {
+	b := p.Bytes()
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *writer) g1List(l []bls12377.G1Affine) {
+	w.u32(len(l))
+	for _, p := range l {
+		w.g1(p)
+	}
+}
+
+func (w *writer) gamma(g zg.Gamma) {
+	w.big(g.Coins)
+	w.big(g.Energy)
+}
+
+func (w *writer) note(n zg.Note) {
+	w.gamma(n.Value)
+	w.field(n.PkOwner)
+	w.field(n.Rho)
+	w.field(n.Rand)
+	w.field(n.Cm)
+}
+
+func (w *writer) txResult(t zg.TxResult) {
+	for i := 0; i < 2; i++ {
+		w.field(t.SnOld[i])
+		w.field(t.CmNew[i])
+		w.note(t.CNew[i])
+	}
+	w.bytes(t.Proof)
+	w.big(t.Fee)
+	w.u64(t.Expiry)
+	for i := 0; i < 2; i++ {
+		w.big(t.RhoNew[i])
+		w.big(t.RandNew[i])
+		w.bytes(t.SkOld[i])
+		w.big(t.RhoOld[i])
+		w.big(t.RandOld[i])
+		w.big(t.PkNew[i])
+	}
+}
+
+func (w *writer) txResultOneCoin(t zg.TxResultDefaultOneCoin) {
+	w.field(t.SnOld)
+	w.field(t.CmNew)
+	w.note(t.CNew)
+	w.bytes(t.Proof)
+	w.big(t.Fee)
+	w.u64(t.Expiry)
+	w.big(t.RhoNew)
+	w.big(t.RandNew)
+	w.bytes(t.SkOld)
+	w.big(t.RhoOld)
+	w.big(t.RandOld)
+	w.big(t.PkNew)
+}
+
+func (w *writer) txResultNCoin(t zg.TxResultDefaultNCoin) {
+	w.fieldList(t.SnOld)
+	w.fieldList(t.CmNew)
+	w.u32(len(t.CNew))
+	for _, n := range t.CNew {
+		w.note(n)
+	}
+	w.bytes(t.Proof)
+	w.big(t.Fee)
+	w.u64(t.Expiry)
+	w.bigList(t.RhoNew)
+	w.bigList(t.RandNew)
+	w.bytesList(t.SkOld)
+	w.bigList(t.RhoOld)
+	w.bigList(t.RandOld)
+	w.bigList(t.PkNew)
+}
+
+func (w *writer) tx(t Tx) {
+	w.txResult(t.TxResult)
+	for i := 0; i < 2; i++ {
+		w.note(t.Old[i])
+		w.gamma(t.NewVal[i])
+	}
+	w.int(t.ID)
+	w.bytes([]byte(t.TargetAddress))
+	w.int(t.TargetID)
+}
+
+func (w *writer) inputProverDefaultOneCoin(ip zg.InputProverDefaultOneCoin) {
+	w.big(ip.OldCoin)
+	w.big(ip.OldEnergy)
+	w.field(ip.CmOld)
+	w.field(ip.SnOld)
+	w.field(ip.PkOld)
+	w.big(ip.NewCoin)
+	w.big(ip.NewEnergy)
+	w.field(ip.CmNew)
+	w.bytesList(ip.CNew)
+	w.big(ip.Fee)
+	w.u64(ip.ChainID)
+	w.u64(ip.Expiry)
+	w.bytes(ip.R)
+	w.g1(ip.G)
+	w.g1(ip.G_b)
+	w.g1(ip.G_r)
+	w.g1(ip.EncKey)
+	w.big(ip.SkOld)
+	w.big(ip.RhoOld)
+	w.big(ip.RandOld)
+	w.big(ip.PkNew)
+	w.big(ip.RhoNew)
+	w.big(ip.RandNew)
+}
+
+func (w *writer) txOneCoinPayload(t TxDefaultOneCoinPayload) {
+	w.txResultOneCoin(t.TxResult)
+	w.note(t.Old)
+	w.gamma(t.NewVal)
+	w.int(t.ID)
+	w.bytes([]byte(t.TargetAddress))
+	w.int(t.TargetID)
+	w.bytes(t.PublicWitness)
+	for _, e := range t.EncVal {
+		w.fp(e)
+	}
+	w.inputProverDefaultOneCoin(t.Inp)
+}
+
+// txEncapsulated encode Kind puis le payload qu'il désigne : Tx pour 0,
+// TxDefaultOneCoinPayload pour 1 (cf. SendTransactionDummyImproved et
+// SendTransactionRegister).
+func (w *writer) txEncapsulated(t TxEncapsulated) {
+	w.int(t.Kind)
+	switch p := t.Payload.(type) {
+	case Tx:
+		if t.Kind == 0 {
+			w.tx(p)
+			return
+		}
+	case TxDefaultOneCoinPayload:
+		if t.Kind == 1 {
+			w.txOneCoinPayload(p)
+			return
+		}
+	}
+	if w.err == nil {
+		w.err = fmt.Errorf("zerocash_network: no binary encoding for kind %d payload %T", t.Kind, t.Payload)
+	}
+}
+
+// inputProverRegister n'encode que les champs publics de ip (cf.
+// zg.InputProverRegister.Public) : le bid et les secrets de l'enregistrement
+// ne sont jamais transmis.
+func (w *writer) inputProverRegister(ip zg.InputProverRegister) {
+	w.field(ip.CmIn)
+	for _, c := range ip.CAux {
+		w.bytes(c)
+	}
+	w.big(ip.GammaInCoins)
+	w.big(ip.GammaInEnergy)
+	w.u64(ip.ChainID)
+	w.u64(ip.Expiry)
+	w.g1(ip.G)
+	w.g1(ip.G_b)
+	w.g1(ip.G_r)
+}
+
+func (w *writer) txRegister(t TxRegister) {
+	w.txEncapsulated(t.TxIn)
+	w.field(t.CmIn)
+	w.bytes(t.PiReg)
+	w.bytes(t.PubW)
+	w.inputProverRegister(t.Ip)
+	for _, c := range t.AuxCipher {
+		w.bytes(c)
+	}
+	w.fpList(t.EncVal)
+	w.bool(t.Kind)
+	w.int(t.RoundID)
+}
+
+func (w *writer) txNCoinPayload(t TxDefaultNCoinPayload) {
+	w.txResultNCoin(t.TxResult)
+	w.u32(len(t.Old))
+	for _, n := range t.Old {
+		w.note(n)
+	}
+	w.u32(len(t.NewVal))
+	for _, g := range t.NewVal {
+		w.gamma(g)
+	}
+	w.int(t.ID)
+	w.bytes([]byte(t.TargetAddress))
+	w.int(t.TargetID)
+	w.bytes(t.PublicWitness)
+	w.u32(len(t.EncVal))
+	for _, enc := range t.EncVal {
+		for _, e := range enc {
+			w.fp(e)
+		}
+	}
+}
+
+func (w *writer) inputNCoin(inp zg.TxProverInputHighLevelDefaultNCoin) {
+	w.u32(len(inp.OldNote))
+	for _, n := range inp.OldNote {
+		w.note(n)
+	}
+	w.bytesList(inp.OldSk)
+	w.u32(len(inp.NewVal))
+	for _, g := range inp.NewVal {
+		w.gamma(g)
+	}
+	w.fieldList(inp.NewPk)
+	w.g1List(inp.EncKey)
+	w.bytesList(inp.R)
+	w.g1List(inp.G)
+	w.g1List(inp.G_b)
+	w.g1List(inp.G_r)
+	w.big(inp.Fee)
+	w.u64(inp.ChainID)
+	w.u64(inp.Expiry)
+}
+
+func (w *writer) inputFN(inp zg.TxProverInputHighLevelFN) {
+	for _, l := range [][][]byte{inp.InCoin, inp.InEnergy, inp.InSk, inp.OutCoin, inp.OutEnergy} {
+		w.bytesList(l)
+	}
+	for _, l := range [][][]byte{
+		inp.InCm, inp.InSn, inp.InPk, inp.InRho, inp.InRand,
+		inp.OutCm, inp.OutSn, inp.OutPk, inp.OutRho, inp.OutRand,
+	} {
+		w.fieldList(l)
+	}
+	w.g1List(inp.SkT)
+	w.u32(len(inp.C))
+	for _, c := range inp.C {
+		for _, e := range c {
+			w.fp(e)
+		}
+	}
+	w.u32(len(inp.DecVal))
+	for _, d := range inp.DecVal {
+		for _, b := range d {
+			w.bytes(b)
+		}
+	}
+	w.g1List(inp.EncKey)
+	w.bytesList(inp.R)
+	w.g1List(inp.G)
+	w.g1List(inp.G_b)
+	w.g1List(inp.G_r)
+	w.u64(inp.ChainID)
+	w.u64(inp.Expiry)
+}
+
+func (w *writer) inputSettle(ip zg.InputProverSettle) {
+	w.big(ip.Price)
+	w.big(ip.Fee)
+	w.u64(ip.ChainID)
+	w.u64(ip.Expiry)
+	w.g1(ip.G)
+	w.u32(len(ip.Bidders))
+	for _, b := range ip.Bidders {
+		w.field(b.InCm)
+		w.field(b.InSn)
+		for _, c := range b.C {
+			w.bytes(c)
+		}
+		w.big(b.Fill)
+		w.field(b.OutCm)
+		w.field(b.ChangeCm)
+		w.g1(b.EncKey)
+		w.big(b.InCoin)
+		w.big(b.InEnergy)
+		w.bytes(b.InSk)
+		w.field(b.InRho)
+		w.field(b.InRand)
+		w.big(b.OutRho)
+		w.big(b.OutRand)
+		w.big(b.ChangeRho)
+		w.big(b.ChangeRand)
+	}
+	s := ip.Seller
+	w.field(s.InCm)
+	w.field(s.InSn)
+	for _, c := range s.C {
+		w.bytes(c)
+	}
+	w.big(s.Sold)
+	w.field(s.OutPayCm)
+	w.field(s.OutChangeCm)
+	w.g1(s.G_b)
+	w.g1(s.G_r)
+	w.big(s.InCoin)
+	w.big(s.InEnergy)
+	w.bytes(s.InSk)
+	w.field(s.InRho)
+	w.field(s.InRand)
+	w.big(s.PayRho)
+	w.big(s.PayRand)
+	w.big(s.ChangeRho)
+	w.big(s.ChangeRand)
+	w.g1(s.EncKey)
+	w.bytes(s.R)
+}
+
+func (w *writer) txSettle(t TxSettlePayload) {
+	w.bytes(t.Proof)
+	w.inputSettle(t.Ip)
+	w.u32(len(t.Openings))
+	for _, o := range t.Openings {
+		for _, e := range o {
+			w.fp(e)
+		}
+	}
+	w.u32(len(t.Decryptions))
+	for _, parts := range t.Decryptions {
+		w.u32(len(parts))
+		for _, p := range parts {
+			w.int(p.Index)
+			w.g1(p.D)
+			w.bytes(p.Proof.C)
+			w.bytes(p.Proof.Z)
+		}
+	}
+}
+
+func (w *writer) auctionResultN(a AuctionResultN) {
+	w.txNCoinPayload(a.TxOut)
+	w.bytes(a.TxFN.Proof)
+	w.int(a.SenderID)
+	w.inputNCoin(a.InpDOC)
+	w.inputFN(a.InpF)
+	w.bigList(a.RhoNew)
+	w.bigList(a.RandNew)
+	w.txSettle(a.TxSettle)
+	w.int(a.RoundID)
+}
+
+// -------------------------------
+// Lecture
+// -------------------------------
+
+// reader consomme buf ; après la première erreur, toutes les lectures
+// renvoient des valeurs nulles.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf) {
+		r.fail(ErrCodecShort)
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) header(tag uint8) {
+	b := r.next(2)
+	if b == nil {
+		return
+	}
+	if b[0] != CodecVersion {
+		r.fail(fmt.Errorf("%w: %d", ErrCodecVersion, b[0]))
+	} else if b[1] != tag {
+		r.fail(fmt.Errorf("%w: tag %d, want %d", ErrCodecTag, b[1], tag))
+	}
+}
+
+func (r *reader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) int() int { return int(int64(r.u64())) }
+
+func (r *reader) bool() bool {
+	switch r.u8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	r.fail(errors.New("zerocash_network: invalid boolean"))
+	return false
+}
+
+// count lit un nombre d'éléments de taille au moins size octets, borné par ce
+// qui reste à lire pour qu'une longueur forgée n'entraîne pas d'allocation.
+func (r *reader) count(size int) int {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	n := int(binary.BigEndian.Uint32(b))
+	if n*size > len(r.buf) {
+		r.fail(ErrCodecShort)
+		return 0
+	}
+	return n
+}
+
+func (r *reader) bytes() []byte {
+	n := r.count(1)
+	if n == 0 {
+		return nil
+	}
+	return append([]byte(nil), r.next(n)...)
+}
+
+func (r *reader) bytesList() [][]byte {
+	n := r.count(4)
+	if n == 0 {
+		return nil
+	}
+	l := make([][]byte, n)
+	for i := range l {
+		l[i] = r.bytes()
+	}
+	return l
+}
+
+func (r *reader) big() *big.Int {
+	switch r.u8() {
+	case 0:
+		return nil
+	case 1:
+		b := r.next(fieldSize)
+		if b == nil {
+			return nil
+		}
+		v := new(big.Int).SetBytes(b)
+		if v.Cmp(fieldModulus) >= 0 {
+			r.fail(ErrCodecField)
+			return nil
+		}
+		return v
+	}
+	r.fail(errors.New("zerocash_network: invalid integer presence byte"))
+	return nil
+}
+
+// field lit un élément de corps et le renvoie sur exactement fieldSize octets.
+func (r *reader) field() []byte {
+	v := r.big()
+	if v == nil {
+		return nil
+	}
+	return v.FillBytes(make([]byte, fieldSize))
+}
+
+func (r *reader) fieldList() [][]byte {
+	n := r.count(1)
+	if n == 0 {
+		return nil
+	}
+	l := make([][]byte, n)
+	for i := range l {
+		l[i] = r.field()
+	}
+	return l
+}
+
+func (r *reader) bigList() []*big.Int {
+	n := r.count(1)
+	if n == 0 {
+		return nil
+	}
+	l := make([]*big.Int, n)
+	for i := range l {
+		l[i] = r.big()
+	}
+	return l
+}
+
+func (r *reader) fp() bls12377_fp.Element {
+	var e bls12377_fp.Element
+	if b := r.next(fieldSize); b != nil {
+		if err := e.SetBytesCanonical(b); err != nil {
+			r.fail(err)
+		}
+	}
+	return e
+}
+
+func (r *reader) fpList() []bls12377_fp.Element {
+	n := r.count(fieldSize)
+	if n == 0 {
+		return nil
+	}
+	l := make([]bls12377_fp.Element, n)
+	for i := range l {
+		l[i] = r.fp()
+	}
+	return l
+}
+
+func (r *reader) g1() bls12377.G1Affine {
+	var p bls12377.G1Affine
+	if b := r.next(bls12377.SizeOfG1AffineCompressed); b != nil {
+		if _, err := p.SetBytes(b); err != nil {
+			r.fail(err)
+		}
+	}
+	return p
+}
+
+func (r *reader) g1List() []bls12377.G1Affine {
+	n := r.count(bls12377.SizeOfG1AffineCompressed)
+	if n == 0 {
+		return nil
+	}
+	l := make([]bls12377.G1Affine, n)
+	for i := range l {
+		l[i] = r.g1()
+	}
+	return l
+}
+
+func (r *reader) gamma() zg.Gamma {
+	return zg.Gamma{Coins: r.big(), Energy: r.big()}
+}
+
+func (r *reader) note() zg.Note {
+	var n zg.Note
+	n.Value = r.gamma()
+	n.PkOwner = r.field()
+	n.Rho = r.field()
+	n.Rand = r.field()
+	n.Cm = r.field()
+	return n
+}
+
+func (r *reader) noteList() []zg.Note {
+	n := r.count(2)
+	if n == 0 {
+		return nil
+	}
+	l := make([]zg.Note, n)
+	for i := range l {
+		l[i] = r.note()
+	}
+	return l
+}
+
+func (r *reader) gammaList() []zg.Gamma {
+	n := r.count(2)
+	if n == 0 {
+		return nil
+	}
+	l := make([]zg.Gamma, n)
+	for i := range l {
+		l[i] = r.gamma()
+	}
+	return l
+}
+
+func (r *reader) txResult() zg.TxResult {
+	var t zg.TxResult
+	for i := 0; i < 2; i++ {
+		t.SnOld[i] = r.field()
+		t.CmNew[i] = r.field()
+		t.CNew[i] = r.note()
+	}
+	t.Proof = r.bytes()
+	t.Fee = r.big()
+	t.Expiry = r.u64()
+	for i := 0; i < 2; i++ {
+		t.RhoNew[i] = r.big()
+		t.RandNew[i] = r.big()
+		t.SkOld[i] = r.bytes()
+		t.RhoOld[i] = r.big()
+		t.RandOld[i] = r.big()
+		t.PkNew[i] = r.big()
+	}
+	return t
+}
+
+func (r *reader) txResultOneCoin() zg.TxResultDefaultOneCoin {
+	var t zg.TxResultDefaultOneCoin
+	t.SnOld = r.field()
+	t.CmNew = r.field()
+	t.CNew = r.note()
+	t.Proof = r.bytes()
+	t.Fee = r.big()
+	t.Expiry = r.u64()
+	t.RhoNew = r.big()
+	t.RandNew = r.big()
+	t.SkOld = r.bytes()
+	t.RhoOld = r.big()
+	t.RandOld = r.big()
+	t.PkNew = r.big()
+	return t
+}
+
+func (r *reader) txResultNCoin() zg.TxResultDefaultNCoin {
+	var t zg.TxResultDefaultNCoin
+	t.SnOld = r.fieldList()
+	t.CmNew = r.fieldList()
+	t.CNew = r.noteList()
+	t.Proof = r.bytes()
+	t.Fee = r.big()
+	t.Expiry = r.u64()
+	t.RhoNew = r.bigList()
+	t.RandNew = r.bigList()
+	t.SkOld = r.bytesList()
+	t.RhoOld = r.bigList()
+	t.RandOld = r.bigList()
+	t.PkNew = r.bigList()
+	return t
+}
+
+func (r *reader) tx() Tx {
+	var t Tx
+	t.TxResult = r.txResult()
+	for i := 0; i < 2; i++ {
+		t.Old[i] = r.note()
+		t.NewVal[i] = r.gamma()
+	}
+	t.ID = r.int()
+	t.TargetAddress = string(r.bytes())
+	t.TargetID = r.int()
+	return t
+}
+
+func (r *reader) inputProverDefaultOneCoin() zg.InputProverDefaultOneCoin {
+	var ip zg.InputProverDefaultOneCoin
+	ip.OldCoin = r.big()
+	ip.OldEnergy = r.big()
+	ip.CmOld = r.field()
+	ip.SnOld = r.field()
+	ip.PkOld = r.field()
+	ip.NewCoin = r.big()
+	ip.NewEnergy = r.big()
+	ip.CmNew = r.field()
+	ip.CNew = r.bytesList()
+	ip.Fee = r.big()
+	ip.ChainID = r.u64()
+	ip.Expiry = r.u64()
+	ip.R = r.bytes()
+	ip.G = r.g1()
+	ip.G_b = r.g1()
+	ip.G_r = r.g1()
+	ip.EncKey = r.g1()
+	ip.SkOld = r.big()
+	ip.RhoOld = r.big()
+	ip.RandOld = r.big()
+	ip.PkNew = r.big()
+	ip.RhoNew = r.big()
+	ip.RandNew = r.big()
+	return ip
+}
+
+func (r *reader) txOneCoinPayload() TxDefaultOneCoinPayload {
+	var t TxDefaultOneCoinPayload
+	t.TxResult = r.txResultOneCoin()
+	t.Old = r.note()
+	t.NewVal = r.gamma()
+	t.ID = r.int()
+	t.TargetAddress = string(r.bytes())
+	t.TargetID = r.int()
+	t.PublicWitness = r.bytes()
+	for i := range t.EncVal {
+		t.EncVal[i] = r.fp()
+	}
+	t.Inp = r.inputProverDefaultOneCoin()
+	return t
+}
+
+func (r *reader) txEncapsulated() TxEncapsulated {
+	t := TxEncapsulated{Kind: r.int()}
+	switch t.Kind {
+	case 0:
+		t.Payload = r.tx()
+	case 1:
+		t.Payload = r.txOneCoinPayload()
+	default:
+		r.fail(fmt.Errorf("zerocash_network: unknown transaction kind %d", t.Kind))
+	}
+	return t
+}
+
+func (r *reader) inputProverRegister() zg.InputProverRegister {
+	var ip zg.InputProverRegister
+	ip.CmIn = r.field()
+	for i := range ip.CAux {
+		ip.CAux[i] = r.bytes()
+	}
+	ip.GammaInCoins = r.big()
+	ip.GammaInEnergy = r.big()
+	ip.ChainID = r.u64()
+	ip.Expiry = r.u64()
+	ip.G = r.g1()
+	ip.G_b = r.g1()
+	ip.G_r = r.g1()
+	return ip
+}
+
+func (r *reader) txRegister() TxRegister {
+	var t TxRegister
+	t.TxIn = r.txEncapsulated()
+	t.CmIn = r.field()
+	t.PiReg = r.bytes()
+	t.PubW = r.bytes()
+	t.Ip = r.inputProverRegister()
+	for i := range t.AuxCipher {
+		t.AuxCipher[i] = r.bytes()
+	}
+	t.EncVal = r.fpList()
+	t.Kind = r.bool()
+	t.RoundID = r.int()
+	return t
+}
+
+func (r *reader) txNCoinPayload() TxDefaultNCoinPayload {
+	var t TxDefaultNCoinPayload
+	t.TxResult = r.txResultNCoin()
+	t.Old = r.noteList()
+	t.NewVal = r.gammaList()
+	t.ID = r.int()
+	t.TargetAddress = string(r.bytes())
+	t.TargetID = r.int()
+	t.PublicWitness = r.bytes()
+	if n := r.count(6 * fieldSize); n > 0 {
+		t.EncVal = make([][6]bls12377_fp.Element, n)
+		for i := range t.EncVal {
+			for j := range t.EncVal[i] {
+				t.EncVal[i][j] = r.fp()
+			}
+		}
+	}
+	return t
+}
+
+func (r *reader) inputNCoin() zg.TxProverInputHighLevelDefaultNCoin {
+	var inp zg.TxProverInputHighLevelDefaultNCoin
+	inp.OldNote = r.noteList()
+	inp.OldSk = r.bytesList()
+	inp.NewVal = r.gammaList()
+	inp.NewPk = r.fieldList()
+	inp.EncKey = r.g1List()
+	inp.R = r.bytesList()
+	inp.G = r.g1List()
+	inp.G_b = r.g1List()
+	inp.G_r = r.g1List()
+	inp.Fee = r.big()
+	inp.ChainID = r.u64()
+	inp.Expiry = r.u64()
+	return inp
+}
+
+func (r *reader) inputFN() zg.TxProverInputHighLevelFN {
+	var inp zg.TxProverInputHighLevelFN
+	for _, l := range []*[][]byte{&inp.InCoin, &inp.InEnergy, &inp.InSk, &inp.OutCoin, &inp.OutEnergy} {
+		*l = r.bytesList()
+	}
+	for _, l := range []*[][]byte{
+		&inp.InCm, &inp.InSn, &inp.InPk, &inp.InRho, &inp.InRand,
+		&inp.OutCm, &inp.OutSn, &inp.OutPk, &inp.OutRho, &inp.OutRand,
+	} {
+		*l = r.fieldList()
+	}
+	inp.SkT = r.g1List()
+	if n := r.count(5 * fieldSize); n > 0 {
+		inp.C = make([][5]bls12377_fp.Element, n)
+		for i := range inp.C {
+			for j := range inp.C[i] {
+				inp.C[i][j] = r.fp()
+			}
+		}
+	}
+	if n := r.count(5 * 4); n > 0 {
+		inp.DecVal = make([][5][]byte, n)
+		for i := range inp.DecVal {
+			for j := range inp.DecVal[i] {
+				inp.DecVal[i][j] = r.bytes()
+			}
+		}
+	}
+	inp.EncKey = r.g1List()
+	inp.R = r.bytesList()
+	inp.G = r.g1List()
+	inp.G_b = r.g1List()
+	inp.G_r = r.g1List()
+	inp.ChainID = r.u64()
+	inp.Expiry = r.u64()
+	return inp
+}
+
+func (r *reader) inputSettle() zg.InputProverSettle {
+	var ip zg.InputProverSettle
+	ip.Price = r.big()
+	ip.Fee = r.big()
+	ip.ChainID = r.u64()
+	ip.Expiry = r.u64()
+	ip.G = r.g1()
+	if n := r.count(1); n > 0 {
+		ip.Bidders = make([]zg.InputSettleBidder, n)
+		for i := range ip.Bidders {
+			b := &ip.Bidders[i]
+			b.InCm = r.field()
+			b.InSn = r.field()
+			for j := range b.C {
+				b.C[j] = r.bytes()
+			}
+			b.Fill = r.big()
+			b.OutCm = r.field()
+			b.ChangeCm = r.field()
+			b.EncKey = r.g1()
+			b.InCoin = r.big()
+			b.InEnergy = r.big()
+			b.InSk = r.bytes()
+			b.InRho = r.field()
+			b.InRand = r.field()
+			b.OutRho = r.big()
+			b.OutRand = r.big()
+			b.ChangeRho = r.big()
+			b.ChangeRand = r.big()
+		}
+	}
+	s := &ip.Seller
+	s.InCm = r.field()
+	s.InSn = r.field()
+	for j := range s.C {
+		s.C[j] = r.bytes()
+	}
+	s.Sold = r.big()
+	s.OutPayCm = r.field()
+	s.OutChangeCm = r.field()
+	s.G_b = r.g1()
+	s.G_r = r.g1()
+	s.InCoin = r.big()
+	s.InEnergy = r.big()
+	s.InSk = r.bytes()
+	s.InRho = r.field()
+	s.InRand = r.field()
+	s.PayRho = r.big()
+	s.PayRand = r.big()
+	s.ChangeRho = r.big()
+	s.ChangeRand = r.big()
+	s.EncKey = r.g1()
+	s.R = r.bytes()
+	return ip
+}
+
+func (r *reader) txSettle() TxSettlePayload {
+	var t TxSettlePayload
+	t.Proof = r.bytes()
+	t.Ip = r.inputSettle()
+	if n := r.count(6 * fieldSize); n > 0 {
+		t.Openings = make([][6]bls12377_fp.Element, n)
+		for i := range t.Openings {
+			for j := range t.Openings[i] {
+				t.Openings[i][j] = r.fp()
+			}
+		}
+	}
+	if n := r.count(4); n > 0 {
+		t.Decryptions = make([][]zg.PartialDecryption, n)
+		for i := range t.Decryptions {
+			m := r.count(8 + bls12377.SizeOfG1AffineCompressed + 8)
+			if m == 0 {
+				continue
+			}
+			t.Decryptions[i] = make([]zg.PartialDecryption, m)
+			for j := range t.Decryptions[i] {
+				p := &t.Decryptions[i][j]
+				p.Index = r.int()
+				p.D = r.g1()
+				p.Proof.C = r.bytes()
+				p.Proof.Z = r.bytes()
+			}
+		}
+	}
+	return t
+}
+
+func (r *reader) auctionResultN() AuctionResultN {
+	var a AuctionResultN
+	a.TxOut = r.txNCoinPayload()
+	a.TxFN.Proof = r.bytes()
+	a.SenderID = r.int()
+	a.InpDOC = r.inputNCoin()
+	a.InpF = r.inputFN()
+	a.RhoNew = r.bigList()
+	a.RandNew = r.bigList()
+	a.TxSettle = r.txSettle()
+	a.RoundID = r.int()
+	return a
+}
@@ -0,0 +1,242 @@
+package zerocash_network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	zg "zerocash_gnark/zerocash_gnark"
+
+	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
+	bls12377_fp "github.com/consensys/gnark-crypto/ecc/bls12-377/fp"
+)
+
+// fe renvoie v sous la forme décodée d'un élément de corps : 48 octets big-endian.
+func fe(v int64) []byte {
+	return big.NewInt(v).FillBytes(make([]byte, fieldSize))
+}
+
+func testNote(seed int64) zg.Note {
+	return zg.Note{
+		Value:   zg.NewGamma(seed, seed+1),
+		PkOwner: fe(seed + 2),
+		Rho:     fe(seed + 3),
+		Rand:    fe(seed + 4),
+		Cm:      fe(seed + 5),
+	}
+}
+
+func testRegister() TxRegister {
+	_, _, g1, _ := bls12377.Generators()
+	var enc bls12377_fp.Element
+	enc.SetUint64(42)
+	return TxRegister{
+		TxIn: TxEncapsulated{Kind: 0, Payload: Tx{
+			TxResult: zg.TxResult{
+				SnOld:  [2][]byte{fe(1), fe(2)},
+				CmNew:  [2][]byte{fe(3), fe(4)},
+				CNew:   [2]zg.Note{testNote(10), testNote(20)},
+				Proof:  []byte{0xde, 0xad},
+				Fee:    big.NewInt(3),
+				Expiry: 100,
+			},
+			Old:           [2]zg.Note{testNote(30), testNote(40)},
+			NewVal:        [2]zg.Gamma{zg.NewGamma(5, 6), zg.NewGamma(7, 8)},
+			ID:            1,
+			TargetAddress: "localhost:9001",
+			TargetID:      2,
+		}},
+		CmIn:  fe(9),
+		PiReg: []byte{1, 2, 3},
+		Ip: zg.InputProverRegister{
+			CmIn:         fe(9),
+			GammaInCoins: big.NewInt(12),
+			ChainID:      7,
+			Expiry:       100,
+			G:            g1,
+		},
+		EncVal:  []bls12377_fp.Element{enc},
+		Kind:    true,
+		RoundID: 3,
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		out  interface{} // pointeur vers la valeur zéro du type décodé
+		want interface{} // valeur attendue après décodage, in si nil
+	}{
+		{"gamma", zg.NewGamma(12, 5), new(zg.Gamma), nil},
+		{"gamma nil", zg.Gamma{}, new(zg.Gamma), nil},
+		{"note", testNote(1), new(zg.Note), nil},
+		{"note pointer", func() *zg.Note { n := testNote(1); return &n }(), new(zg.Note), testNote(1)},
+		{"note empty fields", zg.Note{Value: zg.NewGamma(1, 1)}, new(zg.Note), nil},
+		{
+			"note short fields",
+			zg.Note{Value: zg.NewGamma(1, 1), Rho: big.NewInt(1111).Bytes(), Rand: []byte{0, 0, 7}},
+			new(zg.Note),
+			zg.Note{Value: zg.NewGamma(1, 1), Rho: fe(1111), Rand: fe(7)},
+		},
+		{
+			"tx result one coin",
+			zg.TxResultDefaultOneCoin{SnOld: fe(1), CmNew: fe(2), CNew: testNote(3), Proof: []byte{9}, Fee: big.NewInt(1), Expiry: 5, RhoNew: big.NewInt(8)},
+			new(zg.TxResultDefaultOneCoin),
+			nil,
+		},
+		{
+			"tx result n coin",
+			zg.TxResultDefaultNCoin{SnOld: [][]byte{fe(1), fe(2)}, CmNew: [][]byte{fe(3)}, CNew: []zg.Note{testNote(4)}, RhoNew: []*big.Int{big.NewInt(5)}},
+			new(zg.TxResultDefaultNCoin),
+			nil,
+		},
+		{"tx register", testRegister(), new(TxRegister), nil},
+		{
+			"tx register secrets dropped",
+			func() TxRegister {
+				reg := testRegister()
+				reg.Ip.Bid = big.NewInt(4)
+				reg.Ip.SkIn = big.NewInt(5)
+				reg.Ip.R = big.NewInt(6)
+				return reg
+			}(),
+			new(TxRegister),
+			testRegister(),
+		},
+		{
+			"auction result",
+			AuctionResultN{
+				TxOut:    TxDefaultNCoinPayload{Old: []zg.Note{testNote(1)}, TargetAddress: "x"},
+				SenderID: 4,
+				InpF:     zg.TxProverInputHighLevelFN{InCm: [][]byte{fe(6)}, InCoin: [][]byte{{1}}},
+				RhoNew:   []*big.Int{big.NewInt(2)},
+				TxSettle: TxSettlePayload{Proof: []byte{1}, Ip: zg.InputProverSettle{Price: big.NewInt(3), Fee: big.NewInt(1)}},
+				RoundID:  2,
+			},
+			new(AuctionResultN),
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := MarshalBinary(tt.in)
+			if err != nil {
+				t.Fatalf("MarshalBinary: %v", err)
+			}
+			if data[0] != CodecVersion {
+				t.Fatalf("version byte %d, want %d", data[0], CodecVersion)
+			}
+			if err := UnmarshalBinary(data, tt.out); err != nil {
+				t.Fatalf("UnmarshalBinary: %v", err)
+			}
+			want := tt.want
+			if want == nil {
+				want = tt.in
+			}
+			got := reflect.ValueOf(tt.out).Elem().Interface()
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("round trip mismatch:\n got  %+v\n want %+v", got, want)
+			}
+			again, err := MarshalBinary(got)
+			if err != nil || !bytes.Equal(again, data) {
+				t.Fatalf("re-encoding differs (err %v)", err)
+			}
+		})
+	}
+}
+
+func TestCodecRejects(t *testing.T) {
+	p := fieldModulus
+	pMinus1 := new(big.Int).Sub(p, big.NewInt(1))
+
+	note := testNote(1)
+	good, err := MarshalBinary(note)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remplace le Cm encodé (derniers fieldSize octets) par p.
+	unreduced := append([]byte(nil), good...)
+	p.FillBytes(unreduced[len(unreduced)-fieldSize:])
+	otherVersion := append([]byte(nil), good...)
+	otherVersion[0] = CodecVersion - 1
+
+	encodeTests := []struct {
+		name string
+		in   interface{}
+	}{
+		{"coins equal to p", zg.Gamma{Coins: new(big.Int).Set(p)}},
+		{"negative energy", zg.Gamma{Energy: big.NewInt(-1)}},
+		{"cm equal to p", zg.Note{Cm: p.Bytes()}},
+		{"rho above p", zg.Note{Rho: new(big.Int).Add(p, big.NewInt(5)).Bytes()}},
+		{"pk over 48 bytes", zg.Note{PkOwner: append([]byte{1}, fe(1)...)}},
+		{"sn equal to p", zg.TxResultDefaultOneCoin{SnOld: p.Bytes()}},
+		{"untyped payload", TxRegister{TxIn: TxEncapsulated{Kind: 0, Payload: "tx"}}},
+		{"unknown type", 42},
+	}
+	for _, tt := range encodeTests {
+		t.Run("encode "+tt.name, func(t *testing.T) {
+			if _, err := MarshalBinary(tt.in); err == nil {
+				t.Fatal("MarshalBinary accepted the value")
+			}
+		})
+	}
+	if _, err := MarshalBinary(zg.Note{Cm: pMinus1.Bytes()}); err != nil {
+		t.Fatalf("p-1 rejected: %v", err)
+	}
+
+	decodeTests := []struct {
+		name string
+		data []byte
+		out  interface{}
+		want error
+	}{
+		{"unreduced cm", unreduced, new(zg.Note), ErrCodecField},
+		{"other version", otherVersion, new(zg.Note), ErrCodecVersion},
+		{"other tag", good, new(zg.Gamma), ErrCodecTag},
+		{"truncated", good[:len(good)-1], new(zg.Note), ErrCodecShort},
+		{"trailing bytes", append(append([]byte(nil), good...), 0), new(zg.Note), nil},
+		{"empty", nil, new(zg.Note), ErrCodecShort},
+	}
+	for _, tt := range decodeTests {
+		t.Run("decode "+tt.name, func(t *testing.T) {
+			err := UnmarshalBinary(tt.data, tt.out)
+			if err == nil {
+				t.Fatal("UnmarshalBinary accepted the encoding")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Les messages "register" passent par le codec : gob refuse d'envoyer un Cm
+// non réduit et le pair reçoit la forme canonique.
+func TestMessageUsesCodec(t *testing.T) {
+	reg := testRegister()
+	reg.CmIn = big.NewInt(9).Bytes()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(PackMessage("register", reg)); err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	if err := gob.NewDecoder(&buf).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := msg.Payload.(TxRegister)
+	if !ok {
+		t.Fatalf("payload is %T", msg.Payload)
+	}
+	if !reflect.DeepEqual(got, testRegister()) {
+		t.Fatalf("register payload changed in transit:\n got  %+v\n want %+v", got, testRegister())
+	}
+
+	reg.CmIn = fieldModulus.Bytes()
+	err := gob.NewEncoder(new(bytes.Buffer)).Encode(PackMessage("register", reg))
+	if !errors.Is(err, ErrCodecField) {
+		t.Fatalf("unreduced Cm sent: %v", err)
+	}
+}
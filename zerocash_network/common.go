@@ -2,8 +2,14 @@
 package zerocash_network
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"math/big"
 	"net"
+	"time"
 	zg "zerocash_gnark/zerocash_gnark"
 
 	bls12377 "github.com/consensys/gnark-crypto/ecc/bls12-377"
@@ -13,6 +19,9 @@ import (
 type Message struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
+	// ID corrèle une requête et sa réponse (cf. rpc.go) ; 0 pour un message
+	// qui n'attend pas de réponse.
+	ID uint64 `json:"id,omitempty"`
 }
 
 type Point struct {
@@ -23,10 +32,55 @@ type Point struct {
 // -------------------------------
 // Type DHPayload pour l'échange Diffie–Hellman
 // -------------------------------
+// Chaque valeur éphémère est signée par la clé d'identité de son émetteur
+// (cf. Sign et Check) : "DH_G_r" engage l'émetteur, le destinataire et A ;
+// "DH_G_b" engage en plus la valeur A à laquelle il répond (Partner), ce qui
+// lie la réponse à l'échange et empêche de substituer G^r ou G^b. Les deux
+// engagent le round d'enchères de la session (0 : session permanente).
 type DHPayload struct {
-	ID      int               `json:"id"`      // Identifiant de l'émetteur
-	SubType string            `json:"subtype"` // "DH_G_r" ou "DH_G_b"
-	Value   bls12377.G1Affine `json:"value"`   // La valeur éphémère (G^r ou G^b)
+	ID        int               `json:"id"`                  // Identifiant de l'émetteur
+	Peer      int               `json:"peer"`                // Identifiant du destinataire
+	Round     int               `json:"round"`               // Round de la session, 0 hors enchères
+	SubType   string            `json:"subtype"`             // "DH_G_r" ou "DH_G_b"
+	Value     bls12377.G1Affine `json:"value"`               // La valeur éphémère (G^r ou G^b)
+	Partner   bls12377.G1Affine `json:"partner"`             // Pour "DH_G_b" : le G^r reçu
+	Signature []byte            `json:"signature,omitempty"` // Signature ed25519 de SignBytes par ID
+}
+
+// SignBytes renvoie le message signé par l'émetteur de p.
+func (p DHPayload) SignBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("dh:" + p.SubType)
+	binary.Write(&buf, binary.BigEndian, int64(p.ID))
+	binary.Write(&buf, binary.BigEndian, int64(p.Peer))
+	binary.Write(&buf, binary.BigEndian, int64(p.Round))
+	value, partner := p.Value.Bytes(), p.Partner.Bytes()
+	buf.Write(value[:])
+	buf.Write(partner[:])
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// Sign renseigne l'émetteur de p et le signe avec id.
+func (p *DHPayload) Sign(id *Identity) {
+	p.ID = id.ID
+	p.Signature = id.Sign(p.SignBytes())
+}
+
+// Check vérifie que p est signé par le nœud from, qu'il est adressé au nœud
+// d'identité id et que sa valeur éphémère est un point valide du sous-groupe
+// (non nul) de G1.
+func (p DHPayload) Check(id *Identity, from int) error {
+	if p.ID != from {
+		return fmt.Errorf("%w: DH payload from node %d claims node %d", ErrBadAuth, from, p.ID)
+	}
+	if p.Peer != id.ID {
+		return fmt.Errorf("%w: DH payload addressed to node %d", ErrBadAuth, p.Peer)
+	}
+	if p.Value.IsInfinity() || !p.Value.IsOnCurve() || !p.Value.IsInSubGroup() {
+		return fmt.Errorf("%w: invalid DH ephemeral point", ErrBadAuth)
+	}
+	return id.Verify(from, p.SignBytes(), p.Signature)
 }
 
 // -------------------------------
@@ -61,7 +115,6 @@ type AuxList struct {
 
 type InfoBid struct {
 	Gamma zg.Gamma
-	Bid   *big.Int
 	Kind  bool
 }
 
@@ -83,10 +136,11 @@ type TxRegister struct {
 	CmIn      []byte
 	PiReg     []byte
 	PubW      []byte
-	Ip        zg.InputProverRegister
+	Ip        zg.InputProverRegister // énoncé public de PiReg (cf. zg.InputProverRegister.Public)
 	AuxCipher [5][]byte
 	EncVal    []bls12377_fp.Element
 	Kind      bool
+	RoundID   int
 }
 
 // type TxRegister struct {
@@ -134,6 +188,67 @@ type AuctionResultN struct {
 	RhoNew   []*big.Int
 	RandNew  []*big.Int
 	//N        int
+	TxSettle TxSettlePayload // obligatoire : un résultat sans règlement est refusé
+	RoundID  int
+}
+
+// TxSellerRegister est l'enregistrement côté vendeur : la note d'énergie mise
+// en vente (CmIn) est verrouillée et le prix de réserve n'apparaît que chiffré
+// dans AuxCipher[2]. Contrairement à TxRegister, aucun input du prouveur n'est
+// transmis : le validateur ne reçoit que les valeurs publiques.
+type TxSellerRegister struct {
+	TxIn      TxEncapsulated
+	CmIn      []byte
+	PiReg     []byte
+	AuxCipher [5][]byte
+	EncVal    []bls12377_fp.Element
+	GammaIn   zg.Gamma // énergie offerte (publique)
+	Expiry    uint64   // dernière hauteur de bloc pouvant inclure l'enregistrement (entrée publique de PiReg)
+	ID        int      // vendeur
+	TargetID  int      // nœud avec lequel la clé de chiffrement est partagée
+	RoundID   int
+}
+
+// TxSettlePayload transporte la preuve de règlement du round et ses entrées
+// publiques. Openings contient, pour chaque note de sortie, son ouverture
+// chiffrée pour son propriétaire (cf. zg.BuildDecMimc) : bidder i en 2i
+// (énergie livrée) et 2i+1 (monnaie rendue), puis le vendeur (paiement, invendu).
+// Decryptions[i] contient les déchiffrements partiels du comité dont la
+// combinaison donne Ip.Bidders[i].EncKey.
+type TxSettlePayload struct {
+	Proof       []byte
+	Ip          zg.InputProverSettle // uniquement les champs publics (cf. Public())
+	Openings    [][6]bls12377_fp.Element
+	Decryptions [][]zg.PartialDecryption
+}
+
+// TxRefund rend au bidder (ou au vendeur) la note verrouillée lors de
+// l'enregistrement quand le round expire sans règlement. TxIn est une
+// transaction one coin dépensant la note CmIn enregistrée dans le round
+// RoundID, dont la nouvelle note est chiffrée sous la clé DH partagée avec le
+// validateur.
+type TxRefund struct {
+	TxIn    TxDefaultOneCoinPayload
+	RoundID int
+}
+
+// TxDrawPayload transporte un retrait (CircuitWithdraw) : Ip ne contient que
+// les champs publics (cf. zg.InputTxDraw.Public).
+// TxDrawPayload est le retrait de l'allocation attribuée à la note Ip.CmIn
+// par le règlement du round RoundID.
+type TxDrawPayload struct {
+	Proof   []byte
+	Ip      zg.InputTxDraw
+	ID      int
+	RoundID int
+}
+
+// DrawReceipt est la réponse du validateur à un TxDrawPayload : Accepted
+// indique si la note CmOut a été ajoutée au ledger, Reason pourquoi sinon.
+type DrawReceipt struct {
+	CmOut    []byte
+	Accepted bool
+	Reason   string
 }
 
 type TxF1Payload struct {
@@ -146,6 +261,7 @@ type TxFNPayload struct {
 
 type DHRequestPayload struct {
 	SenderID int // ID de l'émetteur de la transaction
+	RoundID  int // round de la session demandée, 0 hors enchères
 }
 type DHResponsePayload struct {
 	DestPartnerPublic   bls12377.G1Affine // La clé publique éphémère du destinataire (B)
@@ -158,22 +274,211 @@ type RegisterPayload struct {
 	PiReg     []byte                  // The registration proof π_reg
 }
 
-type Handler interface {
-	HandleMessage(msg Message, conn net.Conn)
+// RoundState est l'état d'un round d'enchères sur le validateur.
+type RoundState int
+
+const (
+	RoundOpen     RoundState = iota // enregistrements acceptés jusqu'à Deadline
+	RoundClosed                     // deadline passée, en attente du règlement
+	RoundSettled                    // règlement accepté, état final
+	RoundExpired                    // aucun règlement avant SettleBy : remboursements ouverts
+	RoundDisputed                   // règlement annulé sur contestation : remboursements ouverts
+)
+
+func (s RoundState) String() string {
+	switch s {
+	case RoundOpen:
+		return "open"
+	case RoundClosed:
+		return "closed"
+	case RoundSettled:
+		return "settled"
+	case RoundExpired:
+		return "expired"
+	case RoundDisputed:
+		return "disputed"
+	}
+	return "unknown"
 }
 
-var handlers = make(map[string]Handler)
+// RoundOpenPayload demande au validateur d'ouvrir un round dont les
+// enregistrements sont acceptés jusqu'à Deadline. Sans règlement accepté avant
+// SettleBy, le round expire et les notes verrouillées peuvent être remboursées.
+// Un règlement peut être contesté pendant ChallengeWindow après son acceptation.
+type RoundOpenPayload struct {
+	Deadline        time.Time
+	SettleBy        time.Time
+	ChallengeWindow time.Duration
+}
 
-func RegisterHandler(messageType string, handler Handler) {
-	handlers[messageType] = handler
+// RoundRequestPayload demande l'état d'un round.
+type RoundRequestPayload struct {
+	ID int
 }
 
-func RouteMessage(msg Message, conn net.Conn) {
-	if handler, ok := handlers[msg.Type]; ok {
-		handler.HandleMessage(msg, conn)
-	} else {
-		println("Aucun handler pour le type :", msg.Type)
+// RoundInfo est la réponse du validateur à "round_open" et "round_get" : l'état
+// du round et les enregistrements acceptés.
+type RoundInfo struct {
+	ID          int
+	State       RoundState
+	Deadline    time.Time
+	SettleBy    time.Time
+	ChallengeBy time.Time     // zéro tant que le round n'est pas réglé
+	Bids        []Transaction // TxRegister
+	Aux         []AuxList
+	InfoBid     []InfoBid
+	Sellers     []Transaction // TxSellerRegister
+}
+
+// TxChallenge conteste le règlement du round RoundID : le bidder révèle
+// l'ouverture (Coins, Energy, Bid) de son enregistrement CmIn et prouve, avec
+// CircuitTxRegister, que le ciphertext enregistré la chiffre sous la clé du
+// comité. Le validateur confronte ce bid au règlement publié.
+type TxChallenge struct {
+	RoundID int
+	ID      int
+	CmIn    []byte
+	Coins   *big.Int
+	Energy  *big.Int
+	Bid     *big.Int
+	Proof   []byte
+	Expiry  uint64 // dernière hauteur de bloc pouvant inclure la contestation (entrée publique de Proof)
+}
+
+// CommitteeConfig décrit le comité de déchiffrement des bids : Members[k] (à
+// l'adresse Addresses[k]) a l'indice DKG k+1, et Threshold parts suffisent à
+// déchiffrer. Les membres ne déchiffrent que les rounds fermés du validateur
+// Validator, et seulement à la demande des nœuds Requesters (l'auctioneer et
+// le validateur). Les observateurs, aux adresses Observers, suivent la DKG
+// sans recevoir de part.
+type CommitteeConfig struct {
+	Members    []int
+	Addresses  []string
+	Threshold  int
+	Validator  string
+	Requesters []int
+	Observers  []string
+}
+
+// DKGDealPayload est la distribution du membre Dealer : les engagements de
+// Feldman de son polynôme et, pour un membre, sa part f(indice). Share est vide
+// pour un observateur (validateur, auctioneer), qui n'a besoin que des
+// engagements pour calculer la clé du comité et les clés de vérification.
+// Signature est la signature par Dealer de DKGCommitmentHash.
+type DKGDealPayload struct {
+	Dealer      int
+	Config      CommitteeConfig
+	Commitments []bls12377.G1Affine
+	Share       []byte
+	Signature   []byte
+}
+
+// DKGCommitmentHash renvoie l'empreinte des engagements du dealer, qu'il signe
+// dans sa distribution et que les membres se renvoient en écho.
+func DKGCommitmentHash(dealer int, commitments []bls12377.G1Affine) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("dkg:")
+	binary.Write(&buf, binary.BigEndian, int64(dealer))
+	for _, c := range commitments {
+		b := c.Bytes()
+		buf.Write(b[:])
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// DKGEchoPayload est diffusé par le membre Echoer à tout le comité, membres
+// et observateurs, pour chaque distribution reçue : Hash est l'empreinte des
+// engagements que Dealer lui a envoyés et Signature la signature du dealer
+// reçue avec eux. Deux échos signés différents prouvent que le dealer a envoyé
+// des engagements différents selon le destinataire, sans qu'un membre puisse
+// l'en accuser à tort. Complaint signale que la part reçue ne vérifie pas ces
+// engagements.
+type DKGEchoPayload struct {
+	Echoer    int
+	Dealer    int
+	Hash      []byte
+	Signature []byte
+	Complaint bool
+}
+
+// DKGJustifyPayload est la réponse publique du dealer Dealer à la plainte de
+// Accuser : la part de celui-ci, que chacun vérifie contre les engagements du
+// dealer.
+type DKGJustifyPayload struct {
+	Dealer  int
+	Accuser int
+	Share   []byte
+}
+
+// DecryptRequestPayload demande à un membre du comité ses déchiffrements
+// partiels pour les bids du round RoundID.
+type DecryptRequestPayload struct {
+	RoundID int
+}
+
+// DecryptSharePayload répond à DecryptRequestPayload : Parts[i] est la part du
+// membre pour le bid RoundInfo.Bids[i].
+type DecryptSharePayload struct {
+	Member int
+	Parts  []zg.PartialDecryption
+}
+
+// PeerInfo décrit un nœud du réseau : son identifiant, l'adresse où il écoute,
+// son rôle ("validator", "auctioneer" ou "participant") et sa clé publique
+// d'identité ed25519 (cf. Identity). L'entrée est signée par le nœud qu'elle
+// décrit : un pair qui la relaie ne peut affirmer ni la clé ni le rôle d'un
+// autre nœud.
+type PeerInfo struct {
+	ID        int
+	Address   string
+	Role      string
+	PublicKey []byte
+	Signature []byte // Signature ed25519 de SignBytes par PublicKey
+}
+
+// SignBytes renvoie le message signé par le nœud décrit par p.
+func (p PeerInfo) SignBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("peer:")
+	binary.Write(&buf, binary.BigEndian, int64(p.ID))
+	for _, field := range [][]byte{[]byte(p.Address), []byte(p.Role), p.PublicKey} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.Write(field)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// Sign renseigne l'identifiant et la clé de p puis le signe avec id, qui doit
+// être l'identité du nœud décrit.
+func (p *PeerInfo) Sign(id *Identity) {
+	p.ID = id.ID
+	p.PublicKey = id.Public()
+	p.Signature = id.Sign(p.SignBytes())
+}
+
+// Check vérifie que p est signé par la clé qu'il annonce, c'est-à-dire par le
+// nœud qu'il décrit.
+func (p PeerInfo) Check() error {
+	if len(p.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: peer %d entry has an invalid identity key", ErrBadAuth, p.ID)
+	}
+	if !ed25519.Verify(p.PublicKey, p.SignBytes(), p.Signature) {
+		return fmt.Errorf("%w: peer %d entry is not signed by its key", ErrBadAuth, p.ID)
 	}
+	return nil
+}
+
+// PeersPayload est l'échange de pairs : la requête "peers_get" porte les pairs
+// connus de l'émetteur, lui compris, et la réponse "peers" ceux du destinataire.
+type PeersPayload struct {
+	Peers []PeerInfo
+}
+
+// Handler traite les messages d'un type donné (cf. Router).
+type Handler interface {
+	HandleMessage(msg Message, conn net.Conn)
 }
 
 func PackMessage(meta string, payload interface{}) Message {
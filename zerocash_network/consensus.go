@@ -0,0 +1,92 @@
+// consensus.go
+package zerocash_network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// ValidatorInfo décrit un validateur du consensus : son identifiant de nœud,
+// son adresse et la clé publique qui signe ses propositions et ses votes.
+type ValidatorInfo struct {
+	ID      int
+	Address string
+	PubKey  ed25519.PublicKey
+}
+
+type VoteKind int
+
+const (
+	Prevote VoteKind = iota
+	Precommit
+)
+
+func (k VoteKind) String() string {
+	if k == Prevote {
+		return "prevote"
+	}
+	return "precommit"
+}
+
+// Proposal est le bloc proposé par Proposer pour la hauteur Height au tour
+// Round. Entries sont les entrées encodées du bloc, dont Header.TxRoot engage
+// les identifiants, et FeeProof la preuve de la note de frais du bloc.
+// ValidRound est le dernier tour où le bloc a réuni un quorum de prevotes (-1
+// sinon).
+type Proposal struct {
+	Height     uint64
+	Round      int
+	ValidRound int
+	Header     BlockHeader
+	Entries    [][]byte
+	FeeProof   []byte
+	Proposer   int
+	Signature  []byte
+}
+
+// Vote est le prevote ou le precommit de Validator pour le bloc de condensé
+// BlockHash (vide : vote nil) à la hauteur Height, au tour Round.
+type Vote struct {
+	Kind      VoteKind
+	Height    uint64
+	Round     int
+	BlockHash []byte
+	Validator int
+	Signature []byte
+}
+
+// EntryPayload relaie aux autres validateurs une entrée admise par un
+// validateur, encodée telle qu'elle est journalisée.
+type EntryPayload struct {
+	Entry []byte
+}
+
+// SignBytes renvoie le message signé par le proposeur.
+func (p Proposal) SignBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("proposal")
+	writeRound(&buf, p.Height, p.Round)
+	binary.Write(&buf, binary.BigEndian, int64(p.ValidRound))
+	binary.Write(&buf, binary.BigEndian, int64(p.Proposer))
+	writeBytes(&buf, p.Header.Hash())
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// SignBytes renvoie le message signé par le votant.
+func (v Vote) SignBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(v.Kind.String())
+	writeRound(&buf, v.Height, v.Round)
+	binary.Write(&buf, binary.BigEndian, int64(v.Validator))
+	writeBytes(&buf, v.BlockHash)
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+func writeRound(buf *bytes.Buffer, height uint64, round int) {
+	binary.Write(buf, binary.BigEndian, height)
+	binary.Write(buf, binary.BigEndian, int64(round))
+}
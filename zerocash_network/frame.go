@@ -0,0 +1,232 @@
+// frame.go
+package zerocash_network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Format d'une trame sur le fil (entiers en big-endian) :
+//
+//	magic   [4]byte  "ZCSH"
+//	version uint8    version du protocole négociée sur la connexion
+//	typeLen uint8    longueur du type de message
+//	type    [typeLen]byte
+//	bodyLen uint32   longueur du corps, au plus MaxFrameSize
+//	body    [bodyLen]byte
+//
+// Le corps d'une trame Message est un flux gob complet (encodeur neuf par
+// trame) : chaque trame se décode seule, sans état partagé entre appels, et
+// la lecture n'avance jamais au-delà de la trame courante.
+const (
+	// ProtocolVersion est la version la plus récente parlée par ce nœud.
+	ProtocolVersion uint8 = 1
+	// MinProtocolVersion est la plus ancienne version encore acceptée.
+	MinProtocolVersion uint8 = 1
+	// MaxFrameSize borne le corps d'une trame ; au-delà, la connexion est rejetée
+	// avant toute allocation.
+	MaxFrameSize = 32 << 20
+	// HandshakeTimeout borne la durée de la négociation de version.
+	HandshakeTimeout = 5 * time.Second
+
+	headerSize = 4 + 1 + 1
+)
+
+var frameMagic = [4]byte{'Z', 'C', 'S', 'H'}
+
+// Types réservés à la négociation de version.
+const (
+	helloFrame    = "hello"
+	helloAckFrame = "hello_ack"
+)
+
+var (
+	ErrBadMagic        = errors.New("zerocash_network: bad frame magic")
+	ErrFrameTooLarge   = errors.New("zerocash_network: frame exceeds MaxFrameSize")
+	ErrVersionMismatch = errors.New("zerocash_network: no common protocol version")
+)
+
+// Conn est une connexion dont la version du protocole a été négociée.
+// Dial et ServerHandshake la produisent ; SendMessage et ReceiveMessage l'utilisent pour
+// estampiller et contrôler la version de chaque trame. Après Identity.Dial ou
+// Identity.Accept, le trafic est chiffré et Peer est l'identifiant authentifié
+// du pair (cf. secure.go).
+type Conn struct {
+	net.Conn
+	Version       uint8
+	Peer          int
+	Authenticated bool
+}
+
+// frameVersion renvoie la version à écrire dans les trames émises sur conn.
+func frameVersion(conn net.Conn) uint8 {
+	if c, ok := conn.(*Conn); ok {
+		return c.Version
+	}
+	return ProtocolVersion
+}
+
+// WriteFrame écrit une trame en un seul Write, pour que des goroutines
+// partageant la connexion ne puissent pas entrelacer leurs trames.
+func WriteFrame(conn net.Conn, version uint8, msgType string, body []byte) error {
+	if len(msgType) > 255 {
+		return fmt.Errorf("zerocash_network: message type %q too long", msgType)
+	}
+	if len(body) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	buf := make([]byte, 0, headerSize+len(msgType)+4+len(body))
+	buf = append(buf, frameMagic[:]...)
+	buf = append(buf, version, uint8(len(msgType)))
+	buf = append(buf, msgType...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+	buf = append(buf, body...)
+	_, err := conn.Write(buf)
+	return err
+}
+
+// ReadFrame lit exactement une trame. Une connexion fermée entre deux trames
+// renvoie io.EOF ; une trame tronquée renvoie io.ErrUnexpectedEOF.
+func ReadFrame(conn net.Conn) (version uint8, msgType string, body []byte, err error) {
+	var hdr [headerSize]byte
+	if _, err = io.ReadFull(conn, hdr[:]); err != nil {
+		return 0, "", nil, err
+	}
+	if !bytes.Equal(hdr[:4], frameMagic[:]) {
+		return 0, "", nil, ErrBadMagic
+	}
+	version = hdr[4]
+	typ := make([]byte, hdr[5])
+	if _, err = io.ReadFull(conn, typ); err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+	var n [4]byte
+	if _, err = io.ReadFull(conn, n[:]); err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > MaxFrameSize {
+		return 0, "", nil, ErrFrameTooLarge
+	}
+	body = make([]byte, size)
+	if _, err = io.ReadFull(conn, body); err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+	return version, string(typ), body, nil
+}
+
+// unexpected signale qu'une trame a été coupée après son en-tête.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encodeFrame sérialise data en gob et déduit le type de la trame.
+func encodeFrame(data interface{}) (string, []byte, error) {
+	var msgType string
+	switch m := data.(type) {
+	case Message:
+		msgType = m.Type
+	case *Message:
+		msgType = m.Type
+	}
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(data); err != nil {
+		return "", nil, err
+	}
+	return msgType, body.Bytes(), nil
+}
+
+// decodeFrame décode le corps d'une trame reçue sur conn dans out et vérifie
+// que sa version est celle de la connexion et que le type annoncé par l'en-tête
+// est celui du Message transporté.
+func decodeFrame(conn net.Conn, version uint8, msgType string, body []byte, out interface{}) error {
+	if want := frameVersion(conn); version != want {
+		return fmt.Errorf("%w: frame version %d on a version %d connection", ErrVersionMismatch, version, want)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(out); err != nil {
+		return err
+	}
+	if m, ok := out.(*Message); ok && m.Type != msgType {
+		return fmt.Errorf("zerocash_network: frame type %q carries message %q", msgType, m.Type)
+	}
+	return nil
+}
+
+// Dial ouvre une connexion TCP vers address et négocie la version du protocole.
+func Dial(address string) (*Conn, error) {
+	return DialTimeout(address, 0)
+}
+
+// DialTimeout est Dial avec un délai de connexion (0 : pas de délai).
+func DialTimeout(address string, timeout time.Duration) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ClientHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ClientHandshake annonce l'intervalle de versions supportées et attend la
+// version retenue par le pair.
+func ClientHandshake(conn net.Conn) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := []byte{MinProtocolVersion, ProtocolVersion}
+	if err := WriteFrame(conn, ProtocolVersion, helloFrame, hello); err != nil {
+		return nil, err
+	}
+	_, typ, body, err := ReadFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if typ != helloAckFrame || len(body) != 1 {
+		return nil, fmt.Errorf("zerocash_network: unexpected %q frame during handshake", typ)
+	}
+	v := body[0]
+	if v == 0 {
+		return nil, ErrVersionMismatch
+	}
+	if v < MinProtocolVersion || v > ProtocolVersion {
+		return nil, fmt.Errorf("%w: peer chose version %d", ErrVersionMismatch, v)
+	}
+	return &Conn{Conn: conn, Version: v}, nil
+}
+
+// ServerHandshake lit l'annonce du client et répond par la plus haute version
+// commune, ou par 0 avant de renvoyer ErrVersionMismatch s'il n'y en a pas.
+func ServerHandshake(conn net.Conn) (*Conn, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	_, typ, body, err := ReadFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if typ != helloFrame || len(body) != 2 {
+		return nil, fmt.Errorf("zerocash_network: unexpected %q frame during handshake", typ)
+	}
+	lo, hi := max(body[0], MinProtocolVersion), min(body[1], ProtocolVersion)
+	if lo > hi {
+		WriteFrame(conn, ProtocolVersion, helloAckFrame, []byte{0})
+		return nil, fmt.Errorf("%w: peer speaks %d..%d", ErrVersionMismatch, body[0], body[1])
+	}
+	if err := WriteFrame(conn, hi, helloAckFrame, []byte{hi}); err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, Version: hi}, nil
+}
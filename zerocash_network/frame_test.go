@@ -0,0 +1,71 @@
+package zerocash_network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// bufConn est une connexion qui écrit dans, et lit depuis, un même tampon.
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Write(p []byte) (int, error) { return c.buf.Write(p) }
+func (c *bufConn) Read(p []byte) (int, error)  { return c.buf.Read(p) }
+
+func TestFrameRoundTrip(t *testing.T) {
+	conn := &bufConn{}
+	if err := WriteFrame(conn, ProtocolVersion, "ping", []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	version, typ, body, err := ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != ProtocolVersion || typ != "ping" || string(body) != "body" {
+		t.Fatalf("read (%d, %q, %q), want (%d, \"ping\", \"body\")", version, typ, body, ProtocolVersion)
+	}
+	if _, _, _, err := ReadFrame(conn); err != io.EOF {
+		t.Fatalf("read past the last frame: err = %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameRejects(t *testing.T) {
+	frame := func(size uint32) []byte {
+		b := append(frameMagic[:], ProtocolVersion, 4)
+		b = append(b, "ping"...)
+		return binary.BigEndian.AppendUint32(b, size)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"bad magic", append([]byte("HTTP"), frame(0)[4:]...), ErrBadMagic},
+		{"oversized", frame(MaxFrameSize + 1), ErrFrameTooLarge},
+		{"truncated type", frame(0)[:headerSize+2], io.ErrUnexpectedEOF},
+		{"truncated body", append(frame(8), "half"...), io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		conn := &bufConn{}
+		conn.buf.Write(tt.data)
+		if _, _, _, err := ReadFrame(conn); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWriteFrameRejectsOversized(t *testing.T) {
+	conn := &bufConn{}
+	if err := WriteFrame(conn, ProtocolVersion, "big", make([]byte, MaxFrameSize+1)); err != ErrFrameTooLarge {
+		t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+	}
+	if conn.buf.Len() != 0 {
+		t.Fatalf("%d bytes written for a rejected frame", conn.buf.Len())
+	}
+}
@@ -8,20 +8,50 @@ import (
 )
 
 const (
-	DiffieHellmanMsg = "DiffieHellman"
-	TxMsg            = "tx"
-	DHRequestMsg     = "dh_request"
-	RegisterMsg      = "register" // NEW: Registration message type
+	DiffieHellmanMsg  = "DiffieHellman"
+	TxMsg             = "tx"
+	DHRequestMsg      = "dh_request"
+	RegisterMsg       = "register" // NEW: Registration message type
+	SellerRegisterMsg = "register_seller"
+	RoundOpenMsg      = "round_open"
+	RoundGetMsg       = "round_get"
+	RefundMsg         = "refund"
+	DKGDealMsg        = "dkg_deal"
+	DKGEchoMsg        = "dkg_echo"
+	DKGJustifyMsg     = "dkg_justify"
+	DecryptRequestMsg = "decrypt_request"
+	DecryptShareMsg   = "decrypt_share"
+	ChallengeMsg      = "challenge"
+	TxDrawMsg         = "tx_draw_one_coin"
+	DrawReceiptMsg    = "draw_receipt"
+	BlockGetMsg       = "block_get"
+	BlocksMsg         = "blocks"
+	BFTProposalMsg    = "bft_proposal"
+	BFTVoteMsg        = "bft_vote"
+	BFTEntryMsg       = "bft_entry"
+	PeersGetMsg       = "peers_get"
+	PeersMsg          = "peers"
+	ErrorMsg          = "error"
 )
 
+// SendMessage envoie data dans une trame (cf. frame.go), estampillée de la
+// version négociée sur conn.
 func SendMessage(conn net.Conn, data interface{}) error {
-	encoder := gob.NewEncoder(conn)
-	return encoder.Encode(data)
+	msgType, body, err := encodeFrame(data)
+	if err != nil {
+		return err
+	}
+	return WriteFrame(conn, frameVersion(conn), msgType, body)
 }
 
+// ReceiveMessage lit exactement une trame et la décode dans out. io.EOF signale
+// une fermeture propre du pair entre deux trames.
 func ReceiveMessage(conn net.Conn, out interface{}) error {
-	decoder := gob.NewDecoder(conn)
-	return decoder.Decode(out)
+	version, msgType, body, err := ReadFrame(conn)
+	if err != nil {
+		return err
+	}
+	return decodeFrame(conn, version, msgType, body, out)
 }
 
 // Enregistrez ici, si nécessaire, vos types personnalisés afin que gob sache les encoder.
@@ -44,5 +74,26 @@ func init() {
 	gob.Register(TxF1Payload{})
 	gob.Register(AuctionResultN{})
 	gob.Register(TxFNPayload{})
+	gob.Register(TxSellerRegister{})
+	gob.Register(TxSettlePayload{})
+	gob.Register(RoundOpenPayload{})
+	gob.Register(RoundRequestPayload{})
+	gob.Register(RoundInfo{})
+	gob.Register(TxRefund{})
+	gob.Register(DKGDealPayload{})
+	gob.Register(DKGEchoPayload{})
+	gob.Register(DKGJustifyPayload{})
+	gob.Register(DecryptRequestPayload{})
+	gob.Register(DecryptSharePayload{})
+	gob.Register(TxChallenge{})
+	gob.Register(TxDrawPayload{})
+	gob.Register(DrawReceipt{})
+	gob.Register(BlockRequestPayload{})
+	gob.Register(BlockListPayload{})
+	gob.Register(Proposal{})
+	gob.Register(Vote{})
+	gob.Register(EntryPayload{})
+	gob.Register(PeersPayload{})
+	gob.Register(RPCError{})
 	// Vous pouvez enregistrer d'autres types personnalisés ici si nécessaire.
 }
@@ -0,0 +1,233 @@
+// router.go
+package zerocash_network
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HandlerFunc permet d'utiliser une fonction comme Handler.
+type HandlerFunc func(msg Message, conn net.Conn)
+
+func (f HandlerFunc) HandleMessage(msg Message, conn net.Conn) { f(msg, conn) }
+
+// Middleware enveloppe un Handler, par exemple pour journaliser, filtrer ou
+// protéger le traitement de chaque message.
+type Middleware func(next Handler) Handler
+
+// Router associe chaque type de message à son Handler. Les middlewares
+// enregistrés par Use s'appliquent à tous les messages, le premier enregistré
+// étant le plus externe. Un Router est lui-même un Handler et peut être
+// modifié pendant qu'il sert des messages.
+type Router struct {
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	middleware []Middleware
+	notFound   Handler
+	chain      Handler // middlewares puis dispatch, reconstruit par Use
+}
+
+// NewRouter renvoie un routeur sans route ni middleware.
+func NewRouter() *Router {
+	r := &Router{handlers: make(map[string]Handler)}
+	r.chain = HandlerFunc(r.dispatch)
+	return r
+}
+
+// Handle enregistre h pour les messages de type msgType, en remplaçant
+// l'éventuel handler précédent.
+func (r *Router) Handle(msgType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = h
+}
+
+// HandleFunc est Handle pour une fonction.
+func (r *Router) HandleFunc(msgType string, f func(msg Message, conn net.Conn)) {
+	r.Handle(msgType, HandlerFunc(f))
+}
+
+// NotFound enregistre le handler des messages sans route ; par défaut, ils
+// reçoivent une erreur CodeBadRequest.
+func (r *Router) NotFound(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notFound = h
+}
+
+// Use ajoute des middlewares, appliqués dans l'ordre après ceux déjà présents.
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+	var h Handler = HandlerFunc(r.dispatch)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	r.chain = h
+}
+
+// Types renvoie, triés, les types de message routés.
+func (r *Router) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.handlers))
+	for t := range r.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// HandleMessage fait passer msg par les middlewares puis le remet au handler
+// de son type.
+func (r *Router) HandleMessage(msg Message, conn net.Conn) {
+	r.mu.RLock()
+	h := r.chain
+	r.mu.RUnlock()
+	h.HandleMessage(msg, conn)
+}
+
+func (r *Router) dispatch(msg Message, conn net.Conn) {
+	r.mu.RLock()
+	h, ok := r.handlers[msg.Type]
+	if !ok {
+		h = r.notFound
+	}
+	r.mu.RUnlock()
+	if h == nil {
+		ReplyError(conn, msg, CodeBadRequest, "no handler for %q", msg.Type)
+		return
+	}
+	h.HandleMessage(msg, conn)
+}
+
+// Logging appelle log pour chaque message, avant de le transmettre.
+func Logging(log func(msg Message, conn net.Conn)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(msg Message, conn net.Conn) {
+			log(msg, conn)
+			next.HandleMessage(msg, conn)
+		})
+	}
+}
+
+// Recover intercepte la panique d'un handler et la signale à report : le
+// nœud, et la connexion, continuent de servir les messages suivants.
+func Recover(report func(msg Message, conn net.Conn, v interface{})) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(msg Message, conn net.Conn) {
+			defer func() {
+				if v := recover(); v != nil {
+					report(msg, conn, v)
+				}
+			}()
+			next.HandleMessage(msg, conn)
+		})
+	}
+}
+
+// Authorize ne transmet que les messages acceptés par check, appelé avec
+// l'identifiant authentifié du pair (cf. PeerID, -1 pour une connexion non
+// authentifiée) ; reject reçoit les autres avec la raison du refus.
+func Authorize(check func(peer int, msg Message) error, reject func(msg Message, conn net.Conn, err error)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(msg Message, conn net.Conn) {
+			peer, ok := PeerID(conn)
+			if !ok {
+				peer = -1
+			}
+			if err := check(peer, msg); err != nil {
+				reject(msg, conn, err)
+				return
+			}
+			next.HandleMessage(msg, conn)
+		})
+	}
+}
+
+// RateLimit limite chaque pair à rate messages par seconde en moyenne, par
+// rafales d'au plus burst messages (seau à jetons). Les pairs sont distingués
+// par leur identifiant authentifié, sinon par leur adresse IP. reject reçoit les
+// messages en excès, qui ne sont pas traités.
+func RateLimit(rate float64, burst int, reject func(msg Message, conn net.Conn)) Middleware {
+	l := newRateLimiter(rate, burst)
+	return func(next Handler) Handler {
+		return HandlerFunc(func(msg Message, conn net.Conn) {
+			if !l.allow(rateKey(conn), time.Now()) {
+				reject(msg, conn)
+				return
+			}
+			next.HandleMessage(msg, conn)
+		})
+	}
+}
+
+// rateLimiter tient un seau à jetons par émetteur. Un seau resté inactif le
+// temps de se remplir (idle) équivaut à un seau neuf : il est supprimé, pour
+// que des émetteurs de passage, par exemple des adresses IP non
+// authentifiées, ne fassent pas grossir la table indéfiniment.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	idle   time.Duration
+	mu     sync.Mutex
+	pruned time.Time
+	// buckets associe à chaque émetteur ses jetons et la date de leur
+	// dernier calcul.
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		idle:    time.Duration(float64(burst) / rate * float64(time.Second)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow consomme un jeton du seau de key à la date now, s'il en reste.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) >= l.idle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) >= l.idle {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateKey identifie l'émetteur d'un message pour RateLimit.
+func rateKey(conn net.Conn) string {
+	if peer, ok := PeerID(conn); ok {
+		return "node:" + strconv.Itoa(peer)
+	}
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+		return "ip:" + host
+	}
+	return "addr:" + conn.RemoteAddr().String()
+}
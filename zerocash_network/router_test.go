@@ -0,0 +1,157 @@
+package zerocash_network
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// reply lit la réponse écrite par un handler sur conn.
+func reply(t *testing.T, conn *bufConn) Message {
+	t.Helper()
+	var msg Message
+	if err := ReceiveMessage(conn, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := NewRouter()
+	conn := &bufConn{}
+	r.HandleMessage(Message{Type: "unknown", ID: 1}, conn)
+	resp := reply(t, conn)
+	if e, ok := resp.Payload.(RPCError); resp.Type != ErrorMsg || !ok || e.Code != CodeBadRequest || resp.ID != 1 {
+		t.Fatalf("reply %+v, want a %s error to request 1", resp, CodeBadRequest)
+	}
+
+	// Un message sans ID n'attend pas de réponse.
+	r.HandleMessage(Message{Type: "unknown"}, conn)
+	if conn.buf.Len() != 0 {
+		t.Fatal("reply to a message without ID")
+	}
+}
+
+func TestRouterMiddlewareOrder(t *testing.T) {
+	r := NewRouter()
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(msg Message, conn net.Conn) {
+				calls = append(calls, name)
+				next.HandleMessage(msg, conn)
+			})
+		}
+	}
+	r.HandleFunc("ping", func(msg Message, conn net.Conn) { calls = append(calls, "handler") })
+	r.Use(trace("first"))
+	r.Use(trace("second"))
+	r.HandleMessage(Message{Type: "ping"}, &bufConn{})
+	if want := []string{"first", "second", "handler"}; !slices.Equal(calls, want) {
+		t.Fatalf("calls %v, want %v", calls, want)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	r := NewRouter()
+	var reported interface{}
+	r.Use(Recover(func(msg Message, conn net.Conn, v interface{}) { reported = v }))
+	handled := 0
+	r.HandleFunc("panic", func(msg Message, conn net.Conn) { panic("boom") })
+	r.HandleFunc("ping", func(msg Message, conn net.Conn) { handled++ })
+
+	r.HandleMessage(Message{Type: "panic"}, &bufConn{})
+	if reported != "boom" {
+		t.Fatalf("reported %v, want the panic value", reported)
+	}
+	r.HandleMessage(Message{Type: "ping"}, &bufConn{})
+	if handled != 1 {
+		t.Fatal("message after a panic not handled")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	errDenied := errors.New("denied")
+	// Seul le nœud 3 peut envoyer des messages "admin".
+	check := func(peer int, msg Message) error {
+		if msg.Type == "admin" && peer != 3 {
+			return errDenied
+		}
+		return nil
+	}
+	authenticated := func(peer int) net.Conn {
+		return &Conn{Conn: &bufConn{}, Peer: peer, Authenticated: true}
+	}
+	tests := []struct {
+		name string
+		conn net.Conn
+		msg  string
+		ok   bool
+	}{
+		{"allowed peer", authenticated(3), "admin", true},
+		{"other peer", authenticated(4), "admin", false},
+		{"unauthenticated", &bufConn{}, "admin", false},
+		{"unrestricted message", authenticated(4), "ping", true},
+	}
+	for _, tt := range tests {
+		r := NewRouter()
+		var rejected error
+		r.Use(Authorize(check, func(msg Message, conn net.Conn, err error) { rejected = err }))
+		handled := false
+		r.HandleFunc(tt.msg, func(msg Message, conn net.Conn) { handled = true })
+		r.HandleMessage(Message{Type: tt.msg}, tt.conn)
+		if handled != tt.ok || (rejected == nil) != tt.ok {
+			t.Errorf("%s: handled %v, rejected with %v, want allowed %v", tt.name, handled, rejected, tt.ok)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// 1 message par seconde, par rafales de 2.
+	l := newRateLimiter(1, 2)
+	t0 := time.Unix(1000, 0)
+	tests := []struct {
+		name string
+		key  string
+		at   time.Duration
+		ok   bool
+	}{
+		{"burst", "a", 0, true},
+		{"burst", "a", 0, true},
+		{"burst exhausted", "a", 0, false},
+		{"other sender", "b", 0, true},
+		{"half a token", "a", 500 * time.Millisecond, false},
+		{"token refilled", "a", 1500 * time.Millisecond, true},
+		{"empty again", "a", 1500 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		if got := l.allow(tt.key, t0.Add(tt.at)); got != tt.ok {
+			t.Errorf("%s: %s at %v allowed %v, want %v", tt.name, tt.key, tt.at, got, tt.ok)
+		}
+	}
+
+	// Au-delà du temps de remplissage, les seaux inactifs sont supprimés.
+	if len(l.buckets) != 2 {
+		t.Fatalf("%d buckets, want 2", len(l.buckets))
+	}
+	l.allow("c", t0.Add(10*time.Second))
+	if len(l.buckets) != 1 {
+		t.Fatalf("%d buckets after the idle ones expired, want 1", len(l.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	r := NewRouter()
+	rejected := 0
+	r.Use(RateLimit(1, 1, func(msg Message, conn net.Conn) { rejected++ }))
+	handled := 0
+	r.HandleFunc("ping", func(msg Message, conn net.Conn) { handled++ })
+	peer := &Conn{Conn: &bufConn{}, Peer: 5, Authenticated: true}
+	r.HandleMessage(Message{Type: "ping"}, peer)
+	r.HandleMessage(Message{Type: "ping"}, peer)
+	if handled != 1 || rejected != 1 {
+		t.Fatalf("handled %d, rejected %d, want 1 and 1", handled, rejected)
+	}
+}
@@ -0,0 +1,349 @@
+// rpc.go
+package zerocash_network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Appels requête/réponse entre nœuds. Un Client garde une connexion
+// authentifiée par adresse, partagée par tous les appels vers elle : chaque
+// requête porte un ID neuf, que le serveur recopie dans sa réponse (cf. Reply),
+// et une goroutine de lecture remet chaque réponse à l'appel qui l'attend. Un
+// appel abandonné (contexte expiré ou annulé) ne bloque donc aucun autre
+// appel ; sa réponse, si elle arrive, est ignorée.
+
+// DefaultCallTimeout borne les appels dont le contexte n'a pas d'échéance,
+// sauf si Client.Timeout en fixe une autre.
+const DefaultCallTimeout = 30 * time.Second
+
+// Codes des erreurs renvoyées par un serveur (cf. ReplyError).
+const (
+	CodeBadRequest   = "bad_request"  // requête malformée, ou type de message sans handler
+	CodeNotFound     = "not_found"    // objet inconnu
+	CodeUnauthorized = "unauthorized" // émetteur non autorisé à envoyer ce message
+	CodeRateLimited  = "rate_limited" // débit de l'émetteur dépassé
+	CodeRefused      = "refused"      // requête valide que le serveur refuse de servir en l'état
+	CodeInternal     = "internal"     // erreur du serveur
+)
+
+// ErrClientClosed est renvoyée par les appels d'un Client fermé.
+var ErrClientClosed = errors.New("zerocash_network: client closed")
+
+// RPCError est une réponse d'erreur structurée : la charge utile d'un message
+// ErrorMsg. Client.Call la renvoie comme erreur, de type *RPCError.
+type RPCError struct {
+	Code    string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("zerocash_network: %s: %s", e.Code, e.Message)
+}
+
+// Reply répond à la requête req, reçue sur conn, par un message msgType
+// portant payload.
+func Reply(conn net.Conn, req Message, msgType string, payload interface{}) error {
+	resp := PackMessage(msgType, payload)
+	resp.ID = req.ID
+	return SendMessage(conn, resp)
+}
+
+// ReplyError répond à la requête req par une erreur structurée. Un message
+// sans ID n'attend pas de réponse : rien n'est alors envoyé.
+func ReplyError(conn net.Conn, req Message, code, format string, args ...interface{}) error {
+	if req.ID == 0 {
+		return nil
+	}
+	return Reply(conn, req, ErrorMsg, RPCError{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Client appelle d'autres nœuds au nom d'une identité. Un Client est sûr pour
+// un usage concurrent.
+type Client struct {
+	identity *Identity
+	// Timeout borne les appels dont le contexte n'a pas d'échéance
+	// (0 : DefaultCallTimeout).
+	Timeout time.Duration
+
+	nextID atomic.Uint64
+	mu     sync.Mutex
+	conns  map[string]*rpcConn
+	closed bool
+}
+
+// NewClient renvoie un client sans connexion ouverte ; elles le sont au
+// premier appel vers chaque adresse.
+func NewClient(id *Identity) *Client {
+	return &Client{identity: id, conns: make(map[string]*rpcConn)}
+}
+
+// rpcConn est une connexion du Client et les appels qui y attendent une
+// réponse.
+type rpcConn struct {
+	conn    *Conn
+	mu      sync.Mutex
+	pending map[uint64]chan Message
+	err     error // non nil une fois la connexion hors d'usage
+}
+
+// Call envoie msg à address et attend la réponse portant le même ID, au plus
+// jusqu'à l'échéance de ctx. Une réponse ErrorMsg est renvoyée comme
+// *RPCError.
+func (c *Client) Call(ctx context.Context, address string, msg Message) (Message, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		rc, reused, err := c.conn(ctx, address)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.ID = c.nextID.Add(1)
+		msgType, body, err := encodeFrame(msg)
+		if err != nil {
+			return Message{}, err
+		}
+		ch, err := rc.expect(msg.ID)
+		written := 0
+		if err == nil {
+			written, err = rc.send(msgType, body)
+		}
+		if err != nil {
+			c.drop(address, rc, err)
+			// Le pair a pu fermer une connexion réutilisée depuis son dernier
+			// usage : la requête est renvoyée une fois sur une connexion neuve,
+			// mais seulement si rien n'en a été écrit. Sinon le pair a pu la
+			// recevoir et la traiter, et la renvoyer la rejouerait.
+			if reused && attempt == 0 && written == 0 {
+				continue
+			}
+			return Message{}, err
+		}
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				return Message{}, rc.failure()
+			}
+			if e, isErr := resp.Payload.(RPCError); isErr && resp.Type == ErrorMsg {
+				return resp, &e
+			}
+			return resp, nil
+		case <-ctx.Done():
+			rc.forget(msg.ID)
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// Call envoie à address une requête msgType portant req et renvoie la charge
+// utile de la réponse, qui doit être de type T.
+func Call[T any](ctx context.Context, c *Client, address, msgType string, req interface{}) (T, error) {
+	var zero T
+	resp, err := c.Call(ctx, address, PackMessage(msgType, req))
+	if err != nil {
+		return zero, err
+	}
+	payload, ok := resp.Payload.(T)
+	if !ok {
+		return zero, fmt.Errorf("zerocash_network: unexpected %q response to %q", resp.Type, msgType)
+	}
+	return payload, nil
+}
+
+// Peer renvoie l'identifiant authentifié du nœud à address, en ouvrant au
+// besoin la connexion qu'utiliseront les appels suivants.
+func (c *Client) Peer(ctx context.Context, address string) (int, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	rc, _, err := c.conn(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	return rc.conn.Peer, nil
+}
+
+// Close ferme les connexions du client ; les appels en cours échouent.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conns := c.conns
+	c.conns = make(map[string]*rpcConn)
+	c.mu.Unlock()
+	for _, rc := range conns {
+		rc.close(ErrClientClosed)
+	}
+	return nil
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultCallTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// conn renvoie la connexion vers address, ouverte au besoin ; reused indique
+// qu'elle existait déjà.
+func (c *Client) conn(ctx context.Context, address string) (rc *rpcConn, reused bool, err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, false, ErrClientClosed
+	}
+	if rc, ok := c.conns[address]; ok {
+		c.mu.Unlock()
+		return rc, true, nil
+	}
+	c.mu.Unlock()
+
+	conn, err := c.dial(ctx, address)
+	if err != nil {
+		return nil, false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		conn.Close()
+		return nil, false, ErrClientClosed
+	}
+	// Un appel concurrent a pu ouvrir la connexion entre-temps.
+	if rc, ok := c.conns[address]; ok {
+		conn.Close()
+		return rc, true, nil
+	}
+	rc = &rpcConn{conn: conn, pending: make(map[uint64]chan Message)}
+	c.conns[address] = rc
+	go c.read(address, rc)
+	return rc, false, nil
+}
+
+// dial ouvre une connexion authentifiée vers address, abandonnée si ctx
+// expire ou est annulé avant la fin de la poignée de main.
+func (c *Client) dial(ctx context.Context, address string) (*Conn, error) {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := c.identity.DialTimeout(address, timeout)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// read remet les réponses reçues sur rc aux appels qui les attendent, jusqu'à
+// ce que la connexion tombe.
+func (c *Client) read(address string, rc *rpcConn) {
+	for {
+		var msg Message
+		if err := ReceiveMessage(rc.conn, &msg); err != nil {
+			c.drop(address, rc, fmt.Errorf("zerocash_network: connection to %s lost: %w", address, err))
+			return
+		}
+		rc.deliver(msg)
+	}
+}
+
+// drop retire rc du client et le ferme : ses appels en attente échouent avec err.
+func (c *Client) drop(address string, rc *rpcConn, err error) {
+	c.mu.Lock()
+	if c.conns[address] == rc {
+		delete(c.conns, address)
+	}
+	c.mu.Unlock()
+	rc.close(err)
+}
+
+// send écrit une trame msgType portant body sur rc et renvoie le nombre
+// d'octets de la trame acceptés par la connexion.
+func (rc *rpcConn) send(msgType string, body []byte) (int, error) {
+	w := &countingConn{Conn: rc.conn}
+	err := WriteFrame(w, rc.conn.Version, msgType, body)
+	return w.n, err
+}
+
+// countingConn compte les octets écrits avec succès sur la connexion.
+type countingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.n += n
+	return n, err
+}
+
+// expect enregistre l'appel id et renvoie le canal de sa réponse, fermé si la
+// connexion tombe avant.
+func (rc *rpcConn) expect(id uint64) (chan Message, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.err != nil {
+		return nil, rc.err
+	}
+	ch := make(chan Message, 1)
+	rc.pending[id] = ch
+	return ch, nil
+}
+
+func (rc *rpcConn) forget(id uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.pending, id)
+}
+
+func (rc *rpcConn) deliver(msg Message) {
+	rc.mu.Lock()
+	ch, ok := rc.pending[msg.ID]
+	delete(rc.pending, msg.ID)
+	rc.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+func (rc *rpcConn) failure() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.err
+}
+
+func (rc *rpcConn) close(err error) {
+	rc.mu.Lock()
+	if rc.err == nil {
+		rc.err = err
+		for _, ch := range rc.pending {
+			close(ch)
+		}
+		rc.pending = nil
+	}
+	rc.mu.Unlock()
+	rc.conn.Close()
+}
@@ -0,0 +1,165 @@
+package zerocash_network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve accepte sur une adresse locale les connexions authentifiées par id et
+// appelle handle, dans sa propre goroutine, pour chaque message reçu.
+func serve(t *testing.T, id *Identity, handle func(conn net.Conn, msg Message)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			raw, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, err := id.Accept(raw)
+				if err != nil {
+					raw.Close()
+					return
+				}
+				defer conn.Close()
+				for {
+					var msg Message
+					if err := ReceiveMessage(conn, &msg); err != nil {
+						return
+					}
+					go handle(conn, msg)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// newTestClient renvoie un client du nœud 1 et l'identité du nœud 2, qui se
+// font mutuellement confiance.
+func newTestClient(t *testing.T) (*Client, *Identity) {
+	t.Helper()
+	a, b := newTestIdentity(t, 1), newTestIdentity(t, 2)
+	a.Trust(b.ID, b.Public())
+	b.Trust(a.ID, a.Public())
+	c := NewClient(a)
+	t.Cleanup(func() { c.Close() })
+	return c, b
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	c, server := newTestClient(t)
+	const calls = 20
+	// Le serveur répond d'autant plus tard que la requête est ancienne : les
+	// réponses arrivent dans le désordre sur la connexion partagée.
+	addr := serve(t, server, func(conn net.Conn, msg Message) {
+		req := msg.Payload.(RoundRequestPayload)
+		time.Sleep(time.Duration(calls-req.ID) * time.Millisecond)
+		Reply(conn, msg, "round", req)
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := Call[RoundRequestPayload](context.Background(), c, addr, "round_get", RoundRequestPayload{ID: i})
+			if err == nil && resp.ID != i {
+				err = errors.New("reply to another call")
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	c, server := newTestClient(t)
+	addr := serve(t, server, func(conn net.Conn, msg Message) {
+		if msg.Type == "slow" {
+			time.Sleep(time.Second)
+		}
+		ReplyError(conn, msg, CodeRefused, "no")
+	})
+
+	var rpcErr *RPCError
+	if _, err := c.Call(context.Background(), addr, PackMessage("ping", nil)); !errors.As(err, &rpcErr) || rpcErr.Code != CodeRefused {
+		t.Fatalf("err = %v, want a %s RPCError", err, CodeRefused)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, addr, PackMessage("slow", nil)); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	// La réponse tardive est ignorée et la connexion reste utilisable.
+	if _, err := c.Call(context.Background(), addr, PackMessage("ping", nil)); !errors.As(err, &rpcErr) {
+		t.Fatalf("call after a timeout: err = %v", err)
+	}
+}
+
+// brokenConn est une connexion fermée par le pair pendant qu'elle attendait
+// dans le pool : son premier Write accepte written octets puis échoue.
+type brokenConn struct {
+	net.Conn
+	written int
+}
+
+func (c *brokenConn) Write(p []byte) (int, error) {
+	return min(c.written, len(p)), errors.New("broken pipe")
+}
+func (c *brokenConn) Close() error { return nil }
+
+func TestCallRetriesOnlyUnwrittenRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		written int // octets de la requête acceptés par la connexion cassée
+		retried bool
+	}{
+		{"nothing written", 0, true},
+		{"partly written", 3, false},
+	}
+	for _, tt := range tests {
+		c, server := newTestClient(t)
+		var served atomic.Int32
+		addr := serve(t, server, func(conn net.Conn, msg Message) {
+			served.Add(1)
+			Reply(conn, msg, "pong", nil)
+		})
+		c.conns[addr] = &rpcConn{
+			conn:    &Conn{Conn: &brokenConn{written: tt.written}, Version: ProtocolVersion},
+			pending: make(map[uint64]chan Message),
+		}
+
+		_, err := c.Call(context.Background(), addr, PackMessage("ping", nil))
+		if tt.retried && err != nil {
+			t.Errorf("%s: err = %v, want the request retried", tt.name, err)
+		}
+		if !tt.retried && err == nil {
+			t.Errorf("%s: request retried after part of it was sent", tt.name)
+		}
+		want := int32(0)
+		if tt.retried {
+			want = 1
+		}
+		if served.Load() != want {
+			t.Errorf("%s: served %d times, want %d", tt.name, served.Load(), want)
+		}
+	}
+}
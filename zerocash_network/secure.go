@@ -0,0 +1,398 @@
+// secure.go
+package zerocash_network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport authentifié et chiffré entre nœuds.
+//
+// Après la négociation de version (cf. frame.go), les deux pairs s'authentifient
+// par leurs clés d'identité ed25519 longue durée, à la manière de SIGMA :
+//
+//	I -> R  auth_hello   eI                    (clé X25519 éphémère)
+//	R -> I  auth_reply   eR | idR | sig_R(th("responder", idR))
+//	I -> R  auth_finish  idI | sig_I(th("initiator", idI, idR))
+//
+// où th hache la version et les deux clés éphémères, que chaque signature lie
+// donc à la session. Chaque pair vérifie la signature de l'autre avec la clé
+// épinglée pour l'identifiant annoncé (Identity.Trust) : un pair inconnu est
+// rejeté. Les clés de session (une par sens) sont dérivées par HKDF-SHA256 du
+// secret X25519 ; les octets échangés ensuite sont découpés en records
+// AES-256-GCM (longueur uint32 puis texte chiffré, nonce = compteur du sens).
+const (
+	authHelloFrame  = "auth_hello"
+	authReplyFrame  = "auth_reply"
+	authFinishFrame = "auth_finish"
+
+	// maxRecord borne le texte clair d'un record.
+	maxRecord = 64 << 10
+)
+
+var (
+	ErrUnknownPeer = errors.New("zerocash_network: unknown peer identity")
+	ErrBadAuth     = errors.New("zerocash_network: peer authentication failed")
+)
+
+// Identity est l'identité longue durée d'un nœud : son identifiant, sa clé de
+// signature ed25519 et les clés publiques épinglées de ses pairs.
+type Identity struct {
+	ID  int
+	key ed25519.PrivateKey
+
+	mu    sync.RWMutex
+	peers map[int]ed25519.PublicKey
+}
+
+// NewIdentity génère une identité pour le nœud id.
+func NewIdentity(id int) (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{ID: id, key: key, peers: make(map[int]ed25519.PublicKey)}, nil
+}
+
+// LoadIdentity lit l'identité du nœud id dans path (graine ed25519 de 32
+// octets, en hexadécimal). Si le fichier n'existe pas, une identité est générée
+// et sa graine y est écrite, lisible par son seul propriétaire.
+func LoadIdentity(id int, path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		ident, err := NewIdentity(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		seed := hex.EncodeToString(ident.key.Seed())
+		return ident, os.WriteFile(path, []byte(seed+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: not a hex-encoded ed25519 seed", path)
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	return &Identity{ID: id, key: key, peers: make(map[int]ed25519.PublicKey)}, nil
+}
+
+// Public renvoie la clé publique d'identité, à épingler chez les pairs.
+func (id *Identity) Public() ed25519.PublicKey {
+	return id.key.Public().(ed25519.PublicKey)
+}
+
+// Trust épingle pub comme clé d'identité du nœud peer.
+func (id *Identity) Trust(peer int, pub ed25519.PublicKey) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.peers[peer] = pub
+}
+
+// PeerKey renvoie la clé épinglée du nœud peer.
+func (id *Identity) PeerKey(peer int) (ed25519.PublicKey, bool) {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	pub, ok := id.peers[peer]
+	return pub, ok
+}
+
+// Sign signe msg avec la clé d'identité du nœud.
+func (id *Identity) Sign(msg []byte) []byte {
+	return ed25519.Sign(id.key, msg)
+}
+
+// Verify vérifie que sig est une signature de msg par le nœud peer, sous sa
+// clé épinglée ; un pair sans clé épinglée renvoie ErrUnknownPeer.
+func (id *Identity) Verify(peer int, msg, sig []byte) error {
+	return id.verifyPeer(peer, msg, sig)
+}
+
+// Dial ouvre une connexion vers address, négocie la version puis authentifie
+// le pair ; Conn.Peer donne son identifiant.
+func (id *Identity) Dial(address string) (*Conn, error) {
+	return id.DialTimeout(address, 0)
+}
+
+// DialTimeout est Dial avec un délai de connexion (0 : pas de délai).
+func (id *Identity) DialTimeout(address string, timeout time.Duration) (*Conn, error) {
+	c, err := DialTimeout(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := id.initiate(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Accept est le pendant serveur de Dial pour une connexion acceptée.
+func (id *Identity) Accept(raw net.Conn) (*Conn, error) {
+	c, err := ServerHandshake(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := id.respond(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// PeerID renvoie l'identifiant authentifié du pair de conn, si conn provient
+// de Identity.Dial ou Identity.Accept.
+func PeerID(conn net.Conn) (int, bool) {
+	if c, ok := conn.(*Conn); ok && c.Authenticated {
+		return c.Peer, true
+	}
+	return 0, false
+}
+
+func (id *Identity) initiate(c *Conn) error {
+	c.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	ePub := eph.PublicKey().Bytes()
+	if err := WriteFrame(c.Conn, c.Version, authHelloFrame, ePub); err != nil {
+		return err
+	}
+	reply, err := readAuthFrame(c, authReplyFrame, 32+8+ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+	peerEph, peer, sig := reply[:32], int(int64(binary.BigEndian.Uint64(reply[32:40]))), reply[40:]
+	th := transcript(c.Version, ePub, peerEph)
+	if err := id.verifyPeer(peer, authSignBytes(th, "responder", peer), sig); err != nil {
+		return err
+	}
+	finish := binary.BigEndian.AppendUint64(nil, uint64(int64(id.ID)))
+	finish = append(finish, ed25519.Sign(id.key, authSignBytes(th, "initiator", id.ID, peer))...)
+	if err := WriteFrame(c.Conn, c.Version, authFinishFrame, finish); err != nil {
+		return err
+	}
+	return c.secure(eph, peerEph, th, peer, true)
+}
+
+func (id *Identity) respond(c *Conn) error {
+	c.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	peerEph, err := readAuthFrame(c, authHelloFrame, 32)
+	if err != nil {
+		return err
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	ePub := eph.PublicKey().Bytes()
+	th := transcript(c.Version, peerEph, ePub)
+	reply := append(append([]byte{}, ePub...), binary.BigEndian.AppendUint64(nil, uint64(int64(id.ID)))...)
+	reply = append(reply, ed25519.Sign(id.key, authSignBytes(th, "responder", id.ID))...)
+	if err := WriteFrame(c.Conn, c.Version, authReplyFrame, reply); err != nil {
+		return err
+	}
+	finish, err := readAuthFrame(c, authFinishFrame, 8+ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+	peer := int(int64(binary.BigEndian.Uint64(finish[:8])))
+	if err := id.verifyPeer(peer, authSignBytes(th, "initiator", peer, id.ID), finish[8:]); err != nil {
+		return err
+	}
+	return c.secure(eph, peerEph, th, peer, false)
+}
+
+// verifyPeer vérifie sig avec la clé épinglée de peer.
+func (id *Identity) verifyPeer(peer int, msg, sig []byte) error {
+	pub, ok := id.PeerKey(peer)
+	if !ok {
+		return fmt.Errorf("%w %d", ErrUnknownPeer, peer)
+	}
+	if !ed25519.Verify(pub, msg, sig) {
+		return fmt.Errorf("%w: bad signature from node %d", ErrBadAuth, peer)
+	}
+	return nil
+}
+
+// readAuthFrame lit la trame de handshake typ, de taille size.
+func readAuthFrame(c *Conn, typ string, size int) ([]byte, error) {
+	v, t, body, err := ReadFrame(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	if v != c.Version || t != typ || len(body) != size {
+		return nil, fmt.Errorf("%w: unexpected %q frame (%d bytes)", ErrBadAuth, t, len(body))
+	}
+	return body, nil
+}
+
+// transcript hache la version et les clés éphémères de l'initiateur et du répondeur.
+func transcript(version uint8, initEph, respEph []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("zcsh-auth-v1"))
+	h.Write([]byte{version})
+	h.Write(initEph)
+	h.Write(respEph)
+	return h.Sum(nil)
+}
+
+// authSignBytes renvoie le message signé par un pair : le transcript, son rôle
+// et les identifiants engagés.
+func authSignBytes(th []byte, role string, ids ...int) []byte {
+	var buf bytes.Buffer
+	buf.Write(th)
+	buf.WriteString(role)
+	for _, id := range ids {
+		binary.Write(&buf, binary.BigEndian, int64(id))
+	}
+	return buf.Bytes()
+}
+
+// secure dérive les clés de session et remplace la connexion sous-jacente de c
+// par le canal chiffré.
+func (c *Conn) secure(eph *ecdh.PrivateKey, peerEph, th []byte, peer int, initiator bool) error {
+	pub, err := ecdh.X25519().NewPublicKey(peerEph)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadAuth, err)
+	}
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadAuth, err)
+	}
+	prk := hkdfExtract(th, shared)
+	i2r, err := newGCM(hkdfExpand(prk, "initiator to responder"))
+	if err != nil {
+		return err
+	}
+	r2i, err := newGCM(hkdfExpand(prk, "responder to initiator"))
+	if err != nil {
+		return err
+	}
+	sc := &secureConn{Conn: c.Conn, send: i2r, recv: r2i}
+	if !initiator {
+		sc.send, sc.recv = r2i, i2r
+	}
+	c.Conn = sc
+	c.Peer = peer
+	c.Authenticated = true
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfExtract et hkdfExpand implémentent HKDF-SHA256 (RFC 5869) pour une
+// sortie de 32 octets.
+func hkdfExtract(salt, secret []byte) []byte {
+	m := hmac.New(sha256.New, salt)
+	m.Write(secret)
+	return m.Sum(nil)
+}
+
+func hkdfExpand(prk []byte, info string) []byte {
+	m := hmac.New(sha256.New, prk)
+	m.Write([]byte(info))
+	m.Write([]byte{1})
+	return m.Sum(nil)
+}
+
+// secureConn chiffre chaque Write en records AES-GCM et déchiffre les records
+// lus. Les nonces sont les compteurs de records de chaque sens : un record
+// rejoué, supprimé ou réordonné fait échouer le déchiffrement.
+type secureConn struct {
+	net.Conn
+	send, recv cipher.AEAD
+
+	wmu     sync.Mutex
+	sendSeq uint64
+
+	rmu     sync.Mutex
+	recvSeq uint64
+	pending []byte
+}
+
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+func (s *secureConn) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxRecord {
+			chunk = chunk[:maxRecord]
+		}
+		hdr := binary.BigEndian.AppendUint32(nil, uint32(len(chunk)+s.send.Overhead()))
+		record := s.send.Seal(hdr, nonce(s.send, s.sendSeq), chunk, hdr)
+		s.sendSeq++
+		if _, err := s.Conn.Write(record); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (s *secureConn) Read(p []byte) (int, error) {
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	if len(s.pending) == 0 {
+		var hdr [4]byte
+		if _, err := io.ReadFull(s.Conn, hdr[:]); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(hdr[:])
+		if size < uint32(s.recv.Overhead()) || size > maxRecord+uint32(s.recv.Overhead()) {
+			return 0, fmt.Errorf("%w: record of %d bytes", ErrBadAuth, size)
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(s.Conn, record); err != nil {
+			return 0, unexpected(err)
+		}
+		plain, err := s.recv.Open(record[:0], nonce(s.recv, s.recvSeq), record, hdr[:])
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrBadAuth, err)
+		}
+		s.recvSeq++
+		s.pending = plain
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
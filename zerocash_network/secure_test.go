@@ -0,0 +1,196 @@
+package zerocash_network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// handshake authentifie a (initiateur) auprès de b (répondeur) sur une
+// connexion en mémoire. Le côté qui échoue ferme sa connexion, ce qui
+// débloque l'autre.
+func handshake(a, b *Identity) (ca, cb *Conn, errA, errB error) {
+	ra, rb := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if cb, errB = b.Accept(rb); errB != nil {
+			rb.Close()
+		}
+	}()
+	if ca, errA = ClientHandshake(ra); errA == nil {
+		errA = a.initiate(ca)
+	}
+	if errA != nil {
+		ra.Close()
+	}
+	<-done
+	return ca, cb, errA, errB
+}
+
+func newTestIdentity(t *testing.T, id int) *Identity {
+	t.Helper()
+	ident, err := NewIdentity(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ident
+}
+
+func TestHandshake(t *testing.T) {
+	impostor := newTestIdentity(t, 9)
+	tests := []struct {
+		name string
+		pin  func(a, b *Identity)
+		// rejectedBy est le côté qui doit refuser le pair (vide si la
+		// poignée de main aboutit), avec l'erreur want.
+		rejectedBy string
+		want       error
+	}{
+		{"pinned", func(a, b *Identity) {
+			a.Trust(b.ID, b.Public())
+			b.Trust(a.ID, a.Public())
+		}, "", nil},
+		{"responder unknown", func(a, b *Identity) {
+			b.Trust(a.ID, a.Public())
+		}, "initiator", ErrUnknownPeer},
+		{"initiator unknown", func(a, b *Identity) {
+			a.Trust(b.ID, b.Public())
+		}, "responder", ErrUnknownPeer},
+		{"wrong responder key", func(a, b *Identity) {
+			a.Trust(b.ID, impostor.Public())
+			b.Trust(a.ID, a.Public())
+		}, "initiator", ErrBadAuth},
+		{"wrong initiator key", func(a, b *Identity) {
+			a.Trust(b.ID, b.Public())
+			b.Trust(a.ID, impostor.Public())
+		}, "responder", ErrBadAuth},
+	}
+	for _, tt := range tests {
+		a, b := newTestIdentity(t, 1), newTestIdentity(t, 2)
+		tt.pin(a, b)
+		ca, cb, errA, errB := handshake(a, b)
+		switch tt.rejectedBy {
+		case "":
+			if errA != nil || errB != nil {
+				t.Errorf("%s: handshake failed: %v, %v", tt.name, errA, errB)
+				break
+			}
+			if peer, ok := PeerID(ca); !ok || peer != b.ID {
+				t.Errorf("%s: initiator sees peer (%d, %v), want %d", tt.name, peer, ok, b.ID)
+			}
+			if peer, ok := PeerID(cb); !ok || peer != a.ID {
+				t.Errorf("%s: responder sees peer (%d, %v), want %d", tt.name, peer, ok, a.ID)
+			}
+		case "initiator":
+			if !errors.Is(errA, tt.want) || errB == nil {
+				t.Errorf("%s: errors %v, %v, want %v from the initiator", tt.name, errA, errB, tt.want)
+			}
+		case "responder":
+			if !errors.Is(errB, tt.want) {
+				t.Errorf("%s: errors %v, %v, want %v from the responder", tt.name, errA, errB, tt.want)
+			}
+		}
+		for _, c := range []*Conn{ca, cb} {
+			if c != nil {
+				c.Close()
+			}
+		}
+	}
+}
+
+func TestSecureConnExchange(t *testing.T) {
+	a, b := newTestIdentity(t, 1), newTestIdentity(t, 2)
+	a.Trust(b.ID, b.Public())
+	b.Trust(a.ID, a.Public())
+	ca, cb, errA, errB := handshake(a, b)
+	if errA != nil || errB != nil {
+		t.Fatalf("handshake: %v, %v", errA, errB)
+	}
+	defer ca.Close()
+	defer cb.Close()
+
+	// Un message plus long qu'un record est découpé puis recomposé.
+	want := bytes.Repeat([]byte("zerocash"), maxRecord/4)
+	go SendMessage(ca, PackMessage("blob", RPCError{Message: string(want)}))
+	var msg Message
+	if err := ReceiveMessage(cb, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Payload.(RPCError).Message; got != string(want) {
+		t.Fatalf("received %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// records chiffre chaque message dans un record distinct et renvoie les
+// records, ainsi que la clé partagée par l'émetteur et le récepteur.
+func records(t *testing.T, msgs ...string) (key []byte, recs [][]byte) {
+	t.Helper()
+	key = bytes.Repeat([]byte{7}, 32)
+	aead, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &bufConn{}
+	w := &secureConn{Conn: conn, send: aead}
+	for _, m := range msgs {
+		if _, err := w.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, append([]byte(nil), conn.buf.Bytes()...))
+		conn.buf.Reset()
+	}
+	return key, recs
+}
+
+func TestSecureConnRejectsRecords(t *testing.T) {
+	key, recs := records(t, "first", "second")
+	tampered := append([]byte(nil), recs[0]...)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name   string
+		stream [][]byte
+		want   []string // messages lus avant l'échec
+	}{
+		{"in order", [][]byte{recs[0], recs[1]}, []string{"first", "second"}},
+		{"tampered", [][]byte{tampered, recs[1]}, nil},
+		{"reordered", [][]byte{recs[1], recs[0]}, nil},
+		{"replayed", [][]byte{recs[0], recs[0]}, []string{"first"}},
+		{"dropped", [][]byte{recs[1]}, nil},
+	}
+	for _, tt := range tests {
+		aead, err := newGCM(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := &bufConn{}
+		conn.buf.Write(bytes.Join(tt.stream, nil))
+		r := &secureConn{Conn: conn, recv: aead}
+		var got []string
+		for {
+			buf := make([]byte, maxRecord)
+			n, err := r.Read(buf)
+			if err == io.EOF && len(got) == len(tt.stream) {
+				break
+			}
+			if err != nil {
+				if !errors.Is(err, ErrBadAuth) {
+					t.Errorf("%s: err = %v, want ErrBadAuth", tt.name, err)
+				}
+				break
+			}
+			got = append(got, string(buf[:n]))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: read %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: read %q, want %q", tt.name, got, tt.want)
+			}
+		}
+	}
+}
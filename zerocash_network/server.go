@@ -63,8 +63,13 @@ func preview(data []byte, n int) []byte {
 	return data[:n]
 }
 
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
+func handleConnection(raw net.Conn) {
+	defer raw.Close()
+	conn, err := ServerHandshake(raw)
+	if err != nil {
+		fmt.Printf("[ERREUR] Négociation avec %s : %v\n", raw.RemoteAddr(), err)
+		return
+	}
 	manager.AddClient(conn)
 	defer manager.RemoveClient(conn)
 